@@ -13,6 +13,7 @@ It manages the client and conversation history for generating optimization sugge
 */
 type Conn struct {
 	client  *openai.Client
+	model   openai.ChatModel
 	history []openai.ChatCompletionMessageParamUnion
 }
 
@@ -21,7 +22,14 @@ NewConn creates a new OpenAI API connection.
 It initializes the OpenAI client for making API requests.
 */
 func NewConn() *Conn {
-	return &Conn{client: openai.NewClient()}
+	return &Conn{client: openai.NewClient(), model: openai.ChatModelGPT4o}
+}
+
+/*
+Model returns the name of the model used to generate suggestions.
+*/
+func (conn *Conn) Model() string {
+	return string(conn.model)
 }
 
 /*
@@ -49,7 +57,7 @@ func (conn *Conn) Generate(
 				JSONSchema: openai.F(schemaParam),
 			},
 		),
-		Model: openai.F(openai.ChatModelGPT4o),
+		Model: openai.F(conn.model),
 	})
 
 	if err != nil {
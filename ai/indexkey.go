@@ -0,0 +1,241 @@
+package ai
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Index types of the fields of special indexes
+const (
+	IndexTypeHashed   = "hashed"
+	IndexTypeText     = "text"
+	IndexTypeSphere2D = "2dsphere"
+	IndexTypePlane2D  = "2d"
+)
+
+/*
+IndexKeyField is one field of an index key specification: an ascending (1) or
+descending (-1) field, or a field of a special index type. Wildcard indexes use the
+field "$**", or "path.$**" for the fields under a path, with a direction.
+*/
+type IndexKeyField struct {
+	Field     string `json:"field" jsonschema_description:"The field path, '$**' or 'path.$**' for a wildcard index"`
+	Direction int    `json:"direction,omitempty" jsonschema:"enum=1,enum=-1" jsonschema_description:"1 for ascending, -1 for descending. Omit when type is set."`
+	Type      string `json:"type,omitempty" jsonschema:"enum=hashed,enum=text,enum=2dsphere,enum=2d" jsonschema_description:"Optional: special index type of the field, instead of a direction"`
+}
+
+/*
+Value returns the value of the field in an index key document: its type, or its
+direction.
+*/
+func (f IndexKeyField) Value() any {
+	if f.Type != "" {
+		return f.Type
+	}
+	return f.Direction
+}
+
+/*
+IndexKey is an index key specification. The order of the fields of a compound index
+decides which queries it can serve, so the key is a list of fields in index order. It
+is encoded as a JSON list of fields, and decodes from the object form of the shell too,
+e.g. {"status": 1, "createdAt": -1}, keeping the order of its fields.
+*/
+type IndexKey []IndexKeyField
+
+/*
+Fields returns the field names of the key, in index order.
+*/
+func (k IndexKey) Fields() []string {
+	fields := make([]string, len(k))
+	for i, field := range k {
+		fields[i] = field.Field
+	}
+	return fields
+}
+
+/*
+Has reports whether the key includes a field.
+*/
+func (k IndexKey) Has(field string) bool {
+	for _, f := range k {
+		if f.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Special reports whether the key has a field of a special index type, or is a wildcard
+key. Such keys follow their own ordering rules.
+*/
+func (k IndexKey) Special() bool {
+	for _, field := range k {
+		if field.Type != "" || IsWildcard(field.Field) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+D returns the key as an ordered BSON document, as expected by createIndexes.
+*/
+func (k IndexKey) D() bson.D {
+	doc := make(bson.D, len(k))
+	for i, field := range k {
+		doc[i] = bson.E{Key: field.Field, Value: field.Value()}
+	}
+	return doc
+}
+
+/*
+Name returns the name MongoDB gives an index with this key when none is set, the
+fields and their directions or types joined by underscores, e.g. status_1_createdAt_-1.
+*/
+func (k IndexKey) Name() string {
+	parts := make([]string, 0, 2*len(k))
+	for _, field := range k {
+		parts = append(parts, field.Field, fmt.Sprint(field.Value()))
+	}
+	return strings.Join(parts, "_")
+}
+
+/*
+String returns the key as it is written in the shell, e.g. {status: 1, loc: "2dsphere"}.
+*/
+func (k IndexKey) String() string {
+	parts := make([]string, len(k))
+	for i, field := range k {
+		if field.Type != "" {
+			parts[i] = fmt.Sprintf("%s: %q", field.Field, field.Type)
+		} else {
+			parts[i] = fmt.Sprintf("%s: %d", field.Field, field.Direction)
+		}
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+/*
+Validate checks that the key can be created: every field has a name, appears once, and
+has either a direction of 1 or -1 or a known index type, and at most one field is hashed.
+*/
+func (k IndexKey) Validate() error {
+	if len(k) == 0 {
+		return fmt.Errorf("index key has no fields")
+	}
+
+	seen := make(map[string]bool)
+	hashed := 0
+	for _, field := range k {
+		if field.Field == "" {
+			return fmt.Errorf("index key has a field without a name")
+		}
+		if seen[field.Field] {
+			return fmt.Errorf("index key field %s appears more than once", field.Field)
+		}
+		seen[field.Field] = true
+
+		switch field.Type {
+		case "":
+			if field.Direction != 1 && field.Direction != -1 {
+				return fmt.Errorf("index key field %s needs a direction of 1 or -1, got %d", field.Field, field.Direction)
+			}
+		case IndexTypeHashed, IndexTypeText, IndexTypeSphere2D, IndexTypePlane2D:
+			if field.Direction != 0 {
+				return fmt.Errorf("index key field %s has both a direction and type %s", field.Field, field.Type)
+			}
+			// A wildcard text index, {"$**": "text"}, indexes every string field
+			if IsWildcard(field.Field) && (field.Field != "$**" || field.Type != IndexTypeText) {
+				return fmt.Errorf("wildcard index key field %s cannot have type %s", field.Field, field.Type)
+			}
+			if field.Type == IndexTypeHashed {
+				hashed++
+			}
+		default:
+			return fmt.Errorf("index key field %s has unknown type %s", field.Field, field.Type)
+		}
+	}
+
+	if hashed > 1 {
+		return fmt.Errorf("index key can have only one hashed field")
+	}
+
+	return nil
+}
+
+/*
+IsWildcard reports whether a field of an index key is a wildcard.
+*/
+func IsWildcard(field string) bool {
+	return field == "$**" || strings.HasSuffix(field, ".$**")
+}
+
+/*
+UnmarshalJSON decodes a list of fields, or an object of field directions and types,
+keeping the order of its fields.
+*/
+func (k *IndexKey) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+
+	switch token {
+	case nil:
+		*k = nil
+		return nil
+
+	case json.Delim('['):
+		var fields []IndexKeyField
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return err
+		}
+		*k = fields
+		return nil
+
+	case json.Delim('{'):
+		key := IndexKey{}
+		for decoder.More() {
+			token, err := decoder.Token()
+			if err != nil {
+				return err
+			}
+			name := token.(string) // Object keys are always strings
+
+			value, err := decoder.Token()
+			if err != nil {
+				return err
+			}
+
+			field := IndexKeyField{Field: name}
+			switch v := value.(type) {
+			case json.Number:
+				direction, err := strconv.ParseFloat(v.String(), 64)
+				if err != nil {
+					return fmt.Errorf("invalid direction for index key field %s: %w", name, err)
+				}
+				field.Direction = int(direction)
+			case string:
+				field.Type = v
+			default:
+				return fmt.Errorf("invalid value for index key field %s: %v", name, value)
+			}
+			key = append(key, field)
+		}
+
+		*k = key
+		return nil
+	}
+
+	return fmt.Errorf("index key must be a list or an object, got %v", token)
+}
@@ -0,0 +1,93 @@
+package ai
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestIndexKeyJSON(t *testing.T) {
+	// The object form of the shell, as written by earlier records
+	data := []byte(`{"status": 1, "createdAt": -1, "location": "2dsphere"}`)
+
+	var key IndexKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+
+	// Field order must survive decoding, it is lost with a map
+	if got := key.String(); got != `{status: 1, createdAt: -1, location: "2dsphere"}` {
+		t.Errorf("Unmarshal() = %s, want fields in document order", got)
+	}
+
+	encoded, err := json.Marshal(key)
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	want := `[{"field":"status","direction":1},{"field":"createdAt","direction":-1},{"field":"location","type":"2dsphere"}]`
+	if string(encoded) != want {
+		t.Errorf("Marshal() = %s, want %s", encoded, want)
+	}
+
+	var decoded IndexKey
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("Unmarshal() of a list failed: %v", err)
+	}
+	if decoded.String() != key.String() {
+		t.Errorf("Unmarshal() of a list = %s, want %s", decoded, key)
+	}
+
+	doc := key.D()
+	if len(doc) != 3 || doc[1].Key != "createdAt" || doc[1].Value != -1 || doc[2].Value != "2dsphere" {
+		t.Errorf("D() = %v, want an ordered document", doc)
+	}
+
+	if err := json.Unmarshal([]byte(`"status"`), &key); err == nil {
+		t.Error("Unmarshal() of a string should fail")
+	}
+}
+
+func TestIndexKeyValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     IndexKey
+		wantErr bool
+	}{
+		{"compound", IndexKey{{Field: "a", Direction: 1}, {Field: "b", Direction: -1}}, false},
+		{"hashed", IndexKey{{Field: "a", Type: IndexTypeHashed}}, false},
+		{"text", IndexKey{{Field: "title", Type: IndexTypeText}, {Field: "body", Type: IndexTypeText}}, false},
+		{"wildcard", IndexKey{{Field: "attributes.$**", Direction: 1}}, false},
+		{"empty", IndexKey{}, true},
+		{"no direction", IndexKey{{Field: "a"}}, true},
+		{"duplicate field", IndexKey{{Field: "a", Direction: 1}, {Field: "a", Direction: -1}}, true},
+		{"unknown type", IndexKey{{Field: "a", Type: "geoHaystack"}}, true},
+		{"direction and type", IndexKey{{Field: "a", Direction: 1, Type: IndexTypeHashed}}, true},
+		{"two hashed fields", IndexKey{{Field: "a", Type: IndexTypeHashed}, {Field: "b", Type: IndexTypeHashed}}, true},
+		{"wildcard text", IndexKey{{Field: "$**", Type: IndexTypeText}}, false},
+		{"hashed wildcard", IndexKey{{Field: "attributes.$**", Type: IndexTypeHashed}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.key.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIndexKeyName(t *testing.T) {
+	tests := []struct {
+		key  IndexKey
+		want string
+	}{
+		{IndexKey{{Field: "status", Direction: 1}, {Field: "createdAt", Direction: -1}}, "status_1_createdAt_-1"},
+		{IndexKey{{Field: "location", Type: IndexTypeSphere2D}}, "location_2dsphere"},
+		{IndexKey{{Field: "$**", Direction: 1}}, "$**_1"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.key.Name(); got != tt.want {
+			t.Errorf("Name() of %s = %s, want %s", tt.key, got, tt.want)
+		}
+	}
+}
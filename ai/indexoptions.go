@@ -0,0 +1,204 @@
+package ai
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+/*
+IndexOptions represents optional parameters for index creation.
+*/
+type IndexOptions struct {
+	Name                    string       `json:"name,omitempty" jsonschema_description:"Optional: Custom name for the index. Auto-generated if omitted."`
+	Unique                  bool         `json:"unique,omitempty" jsonschema_description:"Optional: If true, creates a unique index."`
+	Sparse                  bool         `json:"sparse,omitempty" jsonschema_description:"Optional: If true, creates a sparse index."`
+	ExpireAfterSeconds      *int         `json:"expireAfterSeconds,omitempty" jsonschema_description:"Optional: TTL index expiration time in seconds."`
+	PartialFilterExpression Document     `json:"partialFilterExpression,omitempty" jsonschema_description:"Optional: Only index the documents matching this filter, as Extended JSON (e.g., '{\"status\": \"active\"}'). Cannot be combined with sparse."`
+	Collation               *Collation   `json:"collation,omitempty" jsonschema_description:"Optional: Collation of the string keys. Only queries with the same collation can use the index."`
+	Hidden                  bool         `json:"hidden,omitempty" jsonschema_description:"Optional: If true, creates the index hidden from the query planner."`
+	WildcardProjection      Document     `json:"wildcardProjection,omitempty" jsonschema_description:"Optional: Fields included or excluded by a '$**' wildcard index, as Extended JSON (e.g., '{\"attributes\": 1}')."`
+	Weights                 []TextWeight `json:"weights,omitempty" jsonschema_description:"Optional: Weights of the fields of a text index."`
+	DefaultLanguage         string       `json:"default_language,omitempty" jsonschema_description:"Optional: Default language of a text index (e.g., 'english', 'none')."`
+	StorageEngine           Document     `json:"storageEngine,omitempty" jsonschema_description:"Optional: Storage engine options, as Extended JSON (e.g., '{\"wiredTiger\": {\"configString\": \"block_compressor=zstd\"}}')."`
+}
+
+/*
+Collation are the language rules of string comparisons in an index.
+*/
+type Collation struct {
+	Locale          string `json:"locale" bson:"locale" jsonschema_description:"ICU locale (e.g., 'en', 'fr', 'simple')"`
+	CaseLevel       bool   `json:"caseLevel,omitempty" bson:"caseLevel,omitempty" jsonschema_description:"Optional: Compare case at strength 1 or 2"`
+	CaseFirst       string `json:"caseFirst,omitempty" bson:"caseFirst,omitempty" jsonschema:"enum=upper,enum=lower,enum=off" jsonschema_description:"Optional: Sort order of case differences"`
+	Strength        int    `json:"strength,omitempty" bson:"strength,omitempty" jsonschema:"enum=1,enum=2,enum=3,enum=4,enum=5" jsonschema_description:"Optional: Comparison level, 1 for base characters only, 2 to include diacritics, 3 (default) to include case"`
+	NumericOrdering bool   `json:"numericOrdering,omitempty" bson:"numericOrdering,omitempty" jsonschema_description:"Optional: Compare numeric strings as numbers"`
+	Alternate       string `json:"alternate,omitempty" bson:"alternate,omitempty" jsonschema:"enum=non-ignorable,enum=shifted" jsonschema_description:"Optional: Whether whitespace and punctuation are considered base characters"`
+	MaxVariable     string `json:"maxVariable,omitempty" bson:"maxVariable,omitempty" jsonschema:"enum=punct,enum=space" jsonschema_description:"Optional: Characters ignored with alternate 'shifted'"`
+	Backwards       bool   `json:"backwards,omitempty" bson:"backwards,omitempty" jsonschema_description:"Optional: Compare strings with diacritics from the back"`
+	Normalization   bool   `json:"normalization,omitempty" bson:"normalization,omitempty" jsonschema_description:"Optional: Check whether text requires normalization"`
+}
+
+/*
+TextWeight is the weight of a field of a text index, relative to the other fields.
+*/
+type TextWeight struct {
+	Field  string `json:"field" jsonschema_description:"The field path"`
+	Weight int    `json:"weight" jsonschema_description:"Weight of the field, from 1 to 99999"`
+}
+
+/*
+Document is a MongoDB document written as Extended JSON, such as a partial filter
+expression. Structured outputs cannot describe free-form objects, so it is encoded as
+a string; a JSON object decodes into one as well.
+*/
+type Document string
+
+/*
+UnmarshalJSON decodes a string, or keeps a JSON object as its text.
+*/
+func (d *Document) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '{' {
+		*d = Document(data)
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("document must be a string or an object: %w", err)
+	}
+	*d = Document(s)
+	return nil
+}
+
+/*
+D parses the document, keeping the order of its fields. An empty document is nil.
+*/
+func (d Document) D() (bson.D, error) {
+	if d == "" {
+		return nil, nil
+	}
+
+	var doc bson.D
+	if err := bson.UnmarshalExtJSON([]byte(d), false, &doc); err != nil {
+		return nil, fmt.Errorf("invalid document %s: %w", d, err)
+	}
+	return doc, nil
+}
+
+/*
+NewDocument encodes a document as canonical Extended JSON, so the types of its numbers
+survive decoding.
+*/
+func NewDocument(doc any) (Document, error) {
+	data, err := bson.MarshalExtJSON(doc, true, false)
+	if err != nil {
+		return "", err
+	}
+	return Document(data), nil
+}
+
+/*
+Validate checks that the options can be used with an index key: documents parse,
+sparse is not combined with a partial filter, wildcard projections only go with a
+"$**" key, text options only with a text index, and the _id index is never hidden.
+*/
+func (o IndexOptions) Validate(keys IndexKey) error {
+	for _, option := range o.documents() {
+		if _, err := option.doc.D(); err != nil {
+			return fmt.Errorf("invalid %s: %w", option.name, err)
+		}
+	}
+
+	if o.Sparse && o.PartialFilterExpression != "" {
+		return fmt.Errorf("an index cannot be both sparse and partial")
+	}
+
+	if o.WildcardProjection != "" && !keys.Has("$**") {
+		return fmt.Errorf("wildcardProjection requires a '$**' index key")
+	}
+
+	text := false
+	for _, field := range keys {
+		text = text || field.Type == IndexTypeText
+	}
+	if (len(o.Weights) > 0 || o.DefaultLanguage != "") && !text {
+		return fmt.Errorf("weights and default_language require a text index")
+	}
+	for _, weight := range o.Weights {
+		if weight.Field == "" || weight.Weight < 1 || weight.Weight > 99999 {
+			return fmt.Errorf("invalid weight %d for text index field %q", weight.Weight, weight.Field)
+		}
+	}
+
+	if o.Collation != nil && o.Collation.Locale == "" {
+		return fmt.Errorf("collation requires a locale")
+	}
+
+	if o.Hidden && len(keys) == 1 && keys[0].Field == "_id" {
+		return fmt.Errorf("the _id index cannot be hidden")
+	}
+
+	return nil
+}
+
+/*
+D returns the options as the fields of a createIndexes index specification, in a
+stable order. The name is left to the caller.
+*/
+func (o IndexOptions) D() (bson.D, error) {
+	var doc bson.D
+
+	if o.Unique {
+		doc = append(doc, bson.E{Key: "unique", Value: true})
+	}
+	if o.Sparse {
+		doc = append(doc, bson.E{Key: "sparse", Value: true})
+	}
+	if o.ExpireAfterSeconds != nil {
+		doc = append(doc, bson.E{Key: "expireAfterSeconds", Value: *o.ExpireAfterSeconds})
+	}
+	if o.Hidden {
+		doc = append(doc, bson.E{Key: "hidden", Value: true})
+	}
+	if o.Collation != nil {
+		doc = append(doc, bson.E{Key: "collation", Value: o.Collation})
+	}
+	if len(o.Weights) > 0 {
+		weights := make(bson.D, len(o.Weights))
+		for i, weight := range o.Weights {
+			weights[i] = bson.E{Key: weight.Field, Value: weight.Weight}
+		}
+		doc = append(doc, bson.E{Key: "weights", Value: weights})
+	}
+	if o.DefaultLanguage != "" {
+		doc = append(doc, bson.E{Key: "default_language", Value: o.DefaultLanguage})
+	}
+
+	for _, option := range o.documents() {
+		value, err := option.doc.D()
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", option.name, err)
+		}
+		if value != nil {
+			doc = append(doc, bson.E{Key: option.name, Value: value})
+		}
+	}
+
+	return doc, nil
+}
+
+// documentOption is an index option holding a document
+type documentOption struct {
+	name string
+	doc  Document
+}
+
+// documents returns the options holding a document, in a stable order
+func (o IndexOptions) documents() []documentOption {
+	return []documentOption{
+		{"partialFilterExpression", o.PartialFilterExpression},
+		{"wildcardProjection", o.WildcardProjection},
+		{"storageEngine", o.StorageEngine},
+	}
+}
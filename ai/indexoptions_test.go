@@ -0,0 +1,72 @@
+package ai
+
+import (
+	"encoding/json"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestIndexOptionsD(t *testing.T) {
+	data := []byte(`{
+		"name": "status_partial",
+		"unique": true,
+		"hidden": true,
+		"partialFilterExpression": {"status": {"$in": ["active", "pending"]}},
+		"collation": {"locale": "en", "strength": 2},
+		"storageEngine": "{\"wiredTiger\": {\"configString\": \"block_compressor=zstd\"}}"
+	}`)
+
+	var options IndexOptions
+	if err := json.Unmarshal(data, &options); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+
+	doc, err := options.D()
+	if err != nil {
+		t.Fatalf("D() failed: %v", err)
+	}
+
+	got, err := bson.MarshalExtJSON(doc, false, false)
+	if err != nil {
+		t.Fatalf("MarshalExtJSON() failed: %v", err)
+	}
+	want := `{"unique":true,"hidden":true,"collation":{"locale":"en","strength":2},` +
+		`"partialFilterExpression":{"status":{"$in":["active","pending"]}},` +
+		`"storageEngine":{"wiredTiger":{"configString":"block_compressor=zstd"}}}`
+	if string(got) != want {
+		t.Errorf("D() = %s, want %s", got, want)
+	}
+}
+
+func TestIndexOptionsValidate(t *testing.T) {
+	text := IndexKey{{Field: "title", Type: IndexTypeText}}
+	wildcard := IndexKey{{Field: "$**", Direction: 1}}
+	ascending := IndexKey{{Field: "status", Direction: 1}}
+
+	tests := []struct {
+		name    string
+		options IndexOptions
+		keys    IndexKey
+		wantErr bool
+	}{
+		{"partial", IndexOptions{PartialFilterExpression: `{"status": "active"}`}, ascending, false},
+		{"text weights", IndexOptions{Weights: []TextWeight{{Field: "title", Weight: 10}}, DefaultLanguage: "none"}, text, false},
+		{"wildcard projection", IndexOptions{WildcardProjection: `{"attributes": 1}`}, wildcard, false},
+		{"invalid partial filter", IndexOptions{PartialFilterExpression: `{status`}, ascending, true},
+		{"sparse and partial", IndexOptions{Sparse: true, PartialFilterExpression: `{"a": 1}`}, ascending, true},
+		{"projection without wildcard", IndexOptions{WildcardProjection: `{"a": 1}`}, ascending, true},
+		{"weights without text", IndexOptions{Weights: []TextWeight{{Field: "status", Weight: 1}}}, ascending, true},
+		{"weight out of range", IndexOptions{Weights: []TextWeight{{Field: "title", Weight: 0}}}, text, true},
+		{"collation without locale", IndexOptions{Collation: &Collation{Strength: 2}}, ascending, true},
+		{"hidden _id", IndexOptions{Hidden: true}, IndexKey{{Field: "_id", Direction: 1}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.options.Validate(tt.keys); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
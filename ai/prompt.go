@@ -2,6 +2,9 @@ package ai
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"sync"
 	"text/template"
@@ -20,9 +23,17 @@ var defaultTemplates = map[string]string{
 	- Analyze the provided MongoDB metrics and suggest optimizations.
 	- Focus on ONE specific optimization category (index, query, etc.) per suggestion.
 	- For the 'solution.operations' array, provide the specific parameters needed to perform the action.
-	  - For 'createIndex', specify 'collection', 'keys', and optionally 'options.name' or other options.
+	  - For 'createIndex', specify 'collection', 'keys', and optionally 'options.name' or other options. 'keys' lists the fields in index order, each with a 'direction' of 1 or -1, or a 'type' of hashed, text, 2dsphere or 2d; wildcard indexes use the field '$**' or 'path.$**'. Prefer a 'partialFilterExpression' when the query shapes only ever read a subset of the documents; documents in options are written as Extended JSON strings.
 	  - For 'dropIndex', specify 'collection' and 'name'.
 	- DO NOT provide raw MongoDB commands or shell syntax.
+	- Only suggest dropping an index as unused if its 'observedFor' window is long enough to cover the workload's cycles; 'useCount' is counted across all replica set members since 'since'. Never suggest dropping an index with 'missingHosts', members whose usage could not be read.
+	- Treat a WiredTiger cache 'fillRatio' above 0.95, a 'dirtyRatio' above 0.2, pages evicted by application threads or exhausted read/write tickets as signs of cache or concurrency pressure.
+	- Treat queued writers, growing lock waits per second or flow control throttling as write contention; if an index build in progress has waiting operations, it is the likely cause, so do not suggest another index build on that collection until it finishes.
+	- Index builds are replicated: when a secondary shows replication lag or the oplog window is short, say so, and prefer fewer index changes.
+	- On a sharded collection, prefer indexes prefixed by the shard key so queries can be routed to a single shard, include the shard key in unique indexes, and never drop the only index supporting the shard key; report jumbo chunks and imbalanced collections as problems of the shard key, not of indexes.
+	- Only use index key fields that appear in the collection's sampled 'schemas', unless the index is sparse or partial on a field too rare to be sampled; favor fields with a high 'presence' and 'cardinality', and remember that an index on a field with 'isArray' is multikey.
+	- Order the keys of a compound index by the ESR rule for the query shape it serves: the fields its 'predicates' compare for equality first, the lowest 'selectivity' first, then its sort fields in sort order and direction, then its range fields.
+	- An index with 'hidden' set is being dropped in stages and is observed before its drop; never suggest dropping or recreating it.
 	- Provide a detailed explanation for the problem and the reasoning behind your suggested solution.
 	- Ensure the entire output is a single JSON object matching the schema.
 	`,
@@ -139,6 +150,23 @@ func (p *Prompt) getTemplate(name, text string) (*template.Template, error) {
 	return tmpl, nil
 }
 
+/*
+Hash returns the SHA-256 of the prompt as it is sent to the model, covering the
+user message and the response schema, so a suggestion can be traced back to it.
+*/
+func (p *Prompt) Hash() string {
+	hash := sha256.New()
+	hash.Write([]byte(p.user))
+
+	if p.schema != nil {
+		if schema, err := json.Marshal(p.schema); err == nil {
+			hash.Write(schema)
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
 // WithReport adds a metrics report to the prompt and renders the user prompt template.
 func WithReport(name string, report *metrics.Report) PromptOption {
 	return func(p *Prompt) error {
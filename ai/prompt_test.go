@@ -1,6 +1,7 @@
 package ai
 
 import (
+	"context"
 	"strings"
 	"sync"
 	"testing"
@@ -12,14 +13,16 @@ import (
 // mockMonitor implements metrics.Monitor interface for testing
 type mockMonitor struct{}
 
-func (m *mockMonitor) GetServerStats(ctx any) (*metrics.ServerStats, error) { return nil, nil }
-func (m *mockMonitor) GetDatabaseStats(ctx any, dbName string) (*metrics.DatabaseStats, error) {
+func (m *mockMonitor) GetServerStats(ctx context.Context) (*metrics.ServerStats, error) {
 	return nil, nil
 }
-func (m *mockMonitor) GetCollectionStats(ctx any, dbName, collName string) (*metrics.CollectionStats, error) {
+func (m *mockMonitor) GetDatabaseStats(ctx context.Context, dbName string) (*metrics.DatabaseStats, error) {
 	return nil, nil
 }
-func (m *mockMonitor) GetIndexStats(ctx any, dbName, collName string) ([]metrics.IndexStats, error) {
+func (m *mockMonitor) GetCollectionStats(ctx context.Context, dbName, collName string) (*metrics.CollectionStats, error) {
+	return nil, nil
+}
+func (m *mockMonitor) GetIndexStats(ctx context.Context, dbName, collName string) ([]metrics.IndexStats, error) {
 	return nil, nil
 }
 
@@ -137,7 +140,7 @@ func TestWithSchemaAndHistory(t *testing.T) {
 				{
 					Action:     "createIndex",
 					Collection: "testCollection",
-					Keys:       IndexKey{"field": 1},
+					Keys:       IndexKey{{Field: "field", Direction: 1}},
 					Name:       "testIndexName",
 				},
 			},
@@ -29,26 +29,24 @@ type Problem struct {
 	Severity    string   `json:"severity" jsonschema:"enum=critical,enum=high,enum=medium,enum=low" jsonschema_description:"How severe the problem is"`
 }
 
-// IndexKey represents a field and its order in an index key specification.
-// We use map[string]int for flexibility (e.g., {"fieldName": 1, "otherField": -1}).
-type IndexKey map[string]int
-
-// IndexOptions represents optional parameters for index creation.
-type IndexOptions struct {
-	Name               string `json:"name,omitempty" jsonschema_description:"Optional: Custom name for the index. Auto-generated if omitted."`
-	Unique             bool   `json:"unique,omitempty" jsonschema_description:"Optional: If true, creates a unique index."`
-	Sparse             bool   `json:"sparse,omitempty" jsonschema_description:"Optional: If true, creates a sparse index."`
-	ExpireAfterSeconds *int   `json:"expireAfterSeconds,omitempty" jsonschema_description:"Optional: TTL index expiration time in seconds."`
-	// Add other relevant options like background, partialFilterExpression, etc. as needed
-}
-
 // IndexOperation defines parameters for creating or dropping an index.
 type IndexOperation struct {
 	Action     string       `json:"action" jsonschema:"enum=createIndex,enum=dropIndex" jsonschema_description:"Action to perform: createIndex or dropIndex"`
 	Collection string       `json:"collection" jsonschema_description:"The target collection name"`
-	Keys       IndexKey     `json:"keys,omitempty" jsonschema_description:"Required for createIndex: The index key specification (e.g., {'fieldName': 1})"`
+	Keys       IndexKey     `json:"keys,omitempty" jsonschema_description:"Required for createIndex: The index key fields in index order (e.g., [{'field': 'status', 'direction': 1}, {'field': 'location', 'type': '2dsphere'}])"`
 	Name       string       `json:"name,omitempty" jsonschema_description:"Required for dropIndex, optional for createIndex (if omitted, uses auto-generated name or options.name)"`
 	Options    IndexOptions `json:"options,omitempty" jsonschema_description:"Optional parameters for createIndex"`
+	Snapshot   Document     `json:"snapshot,omitempty" jsonschema:"-"` // The index spec from listIndexes, taken before a drop
+	Stage      string       `json:"stage,omitempty" jsonschema:"-"`    // How far a staged drop has gone
+	Applied    *bool        `json:"applied,omitempty" jsonschema:"-"`  // Whether the operation ran, unknown in older records
+}
+
+/*
+WasApplied reports whether the operation may have changed the database. Operations
+recorded before this was tracked are assumed to have run.
+*/
+func (op IndexOperation) WasApplied() bool {
+	return op.Applied == nil || *op.Applied
 }
 
 /*
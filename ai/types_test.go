@@ -0,0 +1,28 @@
+package ai
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestIndexOperationWasApplied(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		data string
+		want bool
+	}{
+		{"applied", `{"action":"dropIndex","collection":"orders","name":"status_1","applied":true}`, true},
+		{"not applied", `{"action":"dropIndex","collection":"orders","name":"status_1","applied":false}`, false},
+		{"recorded before it was tracked", `{"action":"dropIndex","collection":"orders","name":"status_1"}`, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var op IndexOperation
+			if err := json.Unmarshal([]byte(tt.data), &op); err != nil {
+				t.Fatalf("Unmarshal() failed: %v", err)
+			}
+			if got := op.WasApplied(); got != tt.want {
+				t.Errorf("WasApplied() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
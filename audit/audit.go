@@ -0,0 +1,242 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+)
+
+// Event describes what an audit entry records about a command
+type Event string
+
+const (
+	// EventIssued is recorded before a command is sent to MongoDB
+	EventIssued Event = "issued"
+	// EventSucceeded is recorded after a command completed successfully
+	EventSucceeded Event = "succeeded"
+	// EventFailed is recorded after a command returned an error
+	EventFailed Event = "failed"
+)
+
+// maxAppendAttempts bounds the retries when other runs append to the log concurrently
+const maxAppendAttempts = 10
+
+// ErrEntryExists is returned by a Sink when an entry with the same sequence number is already stored
+var ErrEntryExists = errors.New("audit entry already exists")
+
+/*
+Provenance describes where a mutating command came from: who or what triggered
+the run, and the AI model and prompt that produced the suggestion.
+*/
+type Provenance struct {
+	Trigger    string `json:"trigger"`
+	Model      string `json:"model,omitempty"`
+	PromptHash string `json:"prompt_hash,omitempty"`
+}
+
+/*
+Entry is a single record in the audit log.
+Each entry includes the hash of the previous entry, so changing, removing or
+reordering any entry breaks the chain and is detected by Verify.
+*/
+type Entry struct {
+	Sequence  uint64    `json:"sequence"`
+	Timestamp time.Time `json:"timestamp"`
+	Event     Event     `json:"event"`
+	Database  string    `json:"database"`
+	Action    string    `json:"action"`          // apply, rollback, stagedDrop, abort or whatIf
+	Operation string    `json:"operation"`       // the command name, e.g. createIndexes
+	Command   string    `json:"command"`         // the exact command as canonical extended JSON
+	Error     string    `json:"error,omitempty"` // the command error for failed events
+	Provenance
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+/*
+ComputeHash returns the hash of the entry's content, including the previous hash
+but excluding its own Hash field.
+*/
+func (e *Entry) ComputeHash() (string, error) {
+	content := *e
+	content.Hash = ""
+
+	data, err := json.Marshal(&content)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+/*
+Sink stores audit entries.
+Implementations must never overwrite an entry: appending an entry whose sequence
+number already exists must fail with ErrEntryExists.
+*/
+type Sink interface {
+	// AppendAuditEntry stores a new entry
+	AppendAuditEntry(ctx context.Context, entry *Entry) error
+
+	// ListAuditEntries returns all entries ordered by sequence number
+	ListAuditEntries(ctx context.Context) ([]*Entry, error)
+}
+
+/*
+Log is an append-only, hash-chained audit log on top of a Sink.
+It is safe for concurrent use, and several processes may append to the same sink:
+a conflicting sequence number causes the entry to be chained onto the new head.
+*/
+type Log struct {
+	sink Sink
+	head *Entry
+	mu   sync.Mutex
+}
+
+/*
+NewLog creates an audit log that stores its entries in the given sink.
+*/
+func NewLog(sink Sink) *Log {
+	return &Log{sink: sink}
+}
+
+/*
+Append chains the entry onto the log and stores it.
+The sequence number, previous hash and hash are set by Append.
+*/
+func (l *Log) Append(ctx context.Context, entry *Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now().UTC()
+	}
+
+	for attempt := 0; attempt < maxAppendAttempts; attempt++ {
+		if l.head == nil || attempt > 0 {
+			if err := l.loadHead(ctx); err != nil {
+				return err
+			}
+		}
+
+		entry.Sequence = 1
+		entry.PrevHash = ""
+		if l.head != nil {
+			entry.Sequence = l.head.Sequence + 1
+			entry.PrevHash = l.head.Hash
+		}
+
+		hash, err := entry.ComputeHash()
+		if err != nil {
+			return err
+		}
+		entry.Hash = hash
+
+		err = l.sink.AppendAuditEntry(ctx, entry)
+		if errors.Is(err, ErrEntryExists) {
+			// Another run appended first, chain onto its entry instead
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to store audit entry: %w", err)
+		}
+
+		stored := *entry
+		l.head = &stored
+		return nil
+	}
+
+	return fmt.Errorf("failed to append audit entry after %d attempts: %w", maxAppendAttempts, ErrEntryExists)
+}
+
+// loadHead reads the last entry of the log from the sink
+func (l *Log) loadHead(ctx context.Context) error {
+	entries, err := l.sink.ListAuditEntries(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	l.head = nil
+	if len(entries) > 0 {
+		l.head = entries[len(entries)-1]
+	}
+
+	return nil
+}
+
+/*
+VerificationError describes the first entry at which the audit chain is broken.
+*/
+type VerificationError struct {
+	Sequence uint64
+	Reason   string
+}
+
+// Error returns the error message
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("audit log verification failed at entry %d: %s", e.Sequence, e.Reason)
+}
+
+/*
+Verify checks that the entries form an unbroken hash chain starting at sequence 1.
+It detects modified, removed, inserted and reordered entries.
+*/
+func Verify(entries []*Entry) error {
+	var prev *Entry
+
+	for i, entry := range entries {
+		expected := uint64(i + 1)
+		if entry.Sequence != expected {
+			return &VerificationError{
+				Sequence: expected,
+				Reason:   fmt.Sprintf("expected sequence %d, found %d", expected, entry.Sequence),
+			}
+		}
+
+		prevHash := ""
+		if prev != nil {
+			prevHash = prev.Hash
+		}
+		if entry.PrevHash != prevHash {
+			return &VerificationError{Sequence: entry.Sequence, Reason: "previous hash does not match"}
+		}
+
+		hash, err := entry.ComputeHash()
+		if err != nil {
+			return err
+		}
+		if entry.Hash != hash {
+			return &VerificationError{Sequence: entry.Sequence, Reason: "entry hash does not match its content"}
+		}
+
+		prev = entry
+	}
+
+	return nil
+}
+
+/*
+DefaultTrigger describes the current invocation as "<command> by <user>@<host>",
+used when no explicit trigger is configured.
+*/
+func DefaultTrigger(command string) string {
+	name := "unknown"
+	if current, err := user.Current(); err == nil {
+		name = current.Username
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	return fmt.Sprintf("%s by %s@%s", command, name, host)
+}
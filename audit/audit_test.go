@@ -0,0 +1,102 @@
+package audit
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// memorySink is an in-memory Sink for testing
+type memorySink struct {
+	mu      sync.Mutex
+	entries []*Entry
+}
+
+func (m *memorySink) AppendAuditEntry(ctx context.Context, entry *Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.entries {
+		if existing.Sequence == entry.Sequence {
+			return ErrEntryExists
+		}
+	}
+
+	stored := *entry
+	m.entries = append(m.entries, &stored)
+	return nil
+}
+
+func (m *memorySink) ListAuditEntries(ctx context.Context) ([]*Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries := make([]*Entry, len(m.entries))
+	for i, entry := range m.entries {
+		copied := *entry
+		entries[i] = &copied
+	}
+	return entries, nil
+}
+
+func TestLog(t *testing.T) {
+	Convey("Given an audit log", t, func() {
+		ctx := context.Background()
+		sink := &memorySink{}
+		log := NewLog(sink)
+
+		provenance := Provenance{Trigger: "test", Model: "gpt-4o", PromptHash: "abc"}
+
+		Convey("When appending entries", func() {
+			So(log.Append(ctx, &Entry{Event: EventIssued, Database: "db", Command: `{"createIndexes":"users"}`, Provenance: provenance}), ShouldBeNil)
+			So(log.Append(ctx, &Entry{Event: EventSucceeded, Database: "db", Command: `{"createIndexes":"users"}`, Provenance: provenance}), ShouldBeNil)
+
+			entries, err := sink.ListAuditEntries(ctx)
+			So(err, ShouldBeNil)
+
+			Convey("Then they should form a verifiable chain", func() {
+				So(entries, ShouldHaveLength, 2)
+				So(entries[0].Sequence, ShouldEqual, 1)
+				So(entries[0].PrevHash, ShouldBeEmpty)
+				So(entries[1].PrevHash, ShouldEqual, entries[0].Hash)
+				So(Verify(entries), ShouldBeNil)
+			})
+
+			Convey("Then modifying an entry should be detected", func() {
+				entries[0].Command = `{"dropIndexes":"users"}`
+				err := Verify(entries)
+				So(err, ShouldNotBeNil)
+				So(err.(*VerificationError).Sequence, ShouldEqual, 1)
+			})
+
+			Convey("Then re-hashing a modified entry should break the link to the next one", func() {
+				entries[0].Trigger = "someone else"
+				entries[0].Hash, _ = entries[0].ComputeHash()
+				err := Verify(entries)
+				So(err, ShouldNotBeNil)
+				So(err.(*VerificationError).Sequence, ShouldEqual, 2)
+			})
+
+			Convey("Then removing an entry should be detected", func() {
+				So(Verify(entries[1:]), ShouldNotBeNil)
+			})
+		})
+
+		Convey("When another log appends to the same sink", func() {
+			other := NewLog(sink)
+
+			So(log.Append(ctx, &Entry{Event: EventIssued}), ShouldBeNil)
+			So(other.Append(ctx, &Entry{Event: EventIssued}), ShouldBeNil)
+			So(log.Append(ctx, &Entry{Event: EventSucceeded}), ShouldBeNil)
+
+			Convey("Then the entries should be chained onto the latest head", func() {
+				entries, err := sink.ListAuditEntries(ctx)
+				So(err, ShouldBeNil)
+				So(entries, ShouldHaveLength, 3)
+				So(Verify(entries), ShouldBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/theapemachine/lookatthatmongo/ai"
+	"github.com/theapemachine/lookatthatmongo/logger"
+	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
+)
+
+var (
+	logDatabase    string
+	slowOpLimit    int
+	logReportOnly  bool
+	logPatternsTop int
+)
+
+/*
+analyzeLogsCmd builds a report from exported mongod log files and asks the AI for
+optimization suggestions, without connecting to MongoDB.
+*/
+var analyzeLogsCmd = &cobra.Command{
+	Use:   "analyze-logs <path>...",
+	Short: "Analyze slow queries in mongod log files",
+	Long: `Parse the "Slow query" entries of structured (MongoDB 4.4+) mongod log files into
+slow operations and query shapes, and generate optimization suggestions from them.
+Each path may be a log file or a directory of log files; .gz files are decompressed.
+No connection to MongoDB is needed, and nothing is applied.`,
+	Args: cobra.MinimumNArgs(1),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		cfg.ApplyLogging()
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		reader := metrics.NewLogReader(
+			metrics.WithLogDatabase(logDatabase),
+			metrics.WithSlowOperationLimit(slowOpLimit),
+		)
+
+		for _, path := range args {
+			logger.Info("Reading log files", "path", path)
+			if err := reader.ReadPath(path); err != nil {
+				return err
+			}
+		}
+
+		lines, skipped := reader.Stats()
+		report := reader.Report(logPatternsTop)
+		logger.Info("Analyzed log files",
+			"lines", lines,
+			"slow_operations", len(report.SlowOperations),
+			"query_shapes", len(report.QueryPatterns),
+			"unparsed", skipped)
+
+		if len(report.SlowOperations) == 0 {
+			logger.Warn("No slow query entries found, only structured (4.4+) logs are supported")
+			return nil
+		}
+
+		if logReportOnly {
+			return printJSON(cmd, report)
+		}
+
+		logger.Info("Generating optimization suggestions")
+		aiconn := ai.NewConn()
+		prompt, err := ai.NewPrompt(
+			ai.WithReport("before", report),
+			ai.WithSchema(ai.OptimizationSuggestionSchema),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create prompt: %w", err)
+		}
+
+		suggestion, err := aiconn.Generate(cmd.Context(), prompt)
+		if err != nil {
+			return fmt.Errorf("failed to generate optimization suggestions: %w", err)
+		}
+
+		return printJSON(cmd, suggestion)
+	},
+}
+
+// printJSON writes a value as indented JSON to the command output
+func printJSON(cmd *cobra.Command, value any) error {
+	encoder := json.NewEncoder(cmd.OutOrStdout())
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(analyzeLogsCmd)
+
+	analyzeLogsCmd.Flags().StringVar(&logDatabase, "db", "", "Only analyze operations on this database (default: all databases)")
+	analyzeLogsCmd.Flags().IntVar(&slowOpLimit, "slow-operations", metrics.DefaultSlowOperationLimit, "Number of slowest operations included in the report")
+	analyzeLogsCmd.Flags().IntVar(&logPatternsTop, "top-query-patterns", cfg.TopQueryPatterns, "Number of query shapes included in the report")
+	analyzeLogsCmd.Flags().BoolVar(&logReportOnly, "report-only", false, "Print the report instead of generating suggestions")
+}
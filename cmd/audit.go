@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/theapemachine/lookatthatmongo/ai"
+	"github.com/theapemachine/lookatthatmongo/audit"
+	"github.com/theapemachine/lookatthatmongo/logger"
+	"github.com/theapemachine/lookatthatmongo/storage"
+)
+
+var (
+	auditOutput string
+	auditFormat string
+)
+
+/*
+auditCmd groups commands for reviewing the audit log of mutating commands.
+*/
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Review the audit log of commands sent to MongoDB",
+}
+
+/*
+auditExportCmd writes the audit log to a file or stdout for compliance reviews.
+*/
+var auditExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the audit log",
+	Long: `Export every audit log entry, in sequence order, as JSON Lines (the default)
+or as a single JSON array. The chain is verified first, and the export is refused
+if it has been tampered with, unless --skip-verify is given.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		cfg.ApplyLogging()
+		return cfg.Validate()
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		entries, err := loadAuditEntries(cmd)
+		if err != nil {
+			return err
+		}
+
+		if skip, _ := cmd.Flags().GetBool("skip-verify"); !skip {
+			if err := audit.Verify(entries); err != nil {
+				return err
+			}
+		}
+
+		var out io.Writer = cmd.OutOrStdout()
+		if auditOutput != "" {
+			file, err := os.Create(auditOutput)
+			if err != nil {
+				return fmt.Errorf("failed to create output file: %w", err)
+			}
+			defer file.Close()
+			out = file
+		}
+
+		switch auditFormat {
+		case "jsonl":
+			encoder := json.NewEncoder(out)
+			for _, entry := range entries {
+				if err := encoder.Encode(entry); err != nil {
+					return fmt.Errorf("failed to write audit entry: %w", err)
+				}
+			}
+		case "json":
+			encoder := json.NewEncoder(out)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(entries); err != nil {
+				return fmt.Errorf("failed to write audit log: %w", err)
+			}
+		default:
+			return fmt.Errorf("invalid format: %s (valid values: jsonl, json)", auditFormat)
+		}
+
+		logger.Info("Exported audit log", "entries", len(entries))
+		return nil
+	},
+}
+
+/*
+auditVerifyCmd checks that the audit log hash chain is intact.
+*/
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify that the audit log has not been tampered with",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		cfg.ApplyLogging()
+		return cfg.Validate()
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		entries, err := loadAuditEntries(cmd)
+		if err != nil {
+			return err
+		}
+
+		if err := audit.Verify(entries); err != nil {
+			return err
+		}
+
+		logger.Info("Audit log verified", "entries", len(entries))
+		return nil
+	},
+}
+
+// loadAuditEntries reads all entries from the audit log of the configured storage
+func loadAuditEntries(cmd *cobra.Command) ([]*audit.Entry, error) {
+	store, err := newStorage(cmd.Context())
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize storage: %w", err)
+	}
+
+	sink, ok := store.(audit.Sink)
+	if !ok {
+		return nil, fmt.Errorf("storage backend %s does not support an audit log", cfg.StorageType)
+	}
+
+	entries, err := sink.ListAuditEntries(cmd.Context())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	return entries, nil
+}
+
+/*
+newAuditLog returns the audit log kept in the storage backend,
+or nil when auditing is disabled.
+*/
+func newAuditLog(store storage.Storage) (*audit.Log, error) {
+	if !cfg.AuditEnabled {
+		logger.Warn("Audit log is disabled")
+		return nil, nil
+	}
+
+	sink, ok := store.(audit.Sink)
+	if !ok {
+		return nil, fmt.Errorf("storage backend %s does not support an audit log", cfg.StorageType)
+	}
+
+	return audit.NewLog(sink), nil
+}
+
+/*
+provenance describes the current run for the audit log.
+*/
+func provenance(command string, aiconn *ai.Conn, prompt *ai.Prompt) audit.Provenance {
+	trigger := cfg.AuditTrigger
+	if trigger == "" {
+		trigger = audit.DefaultTrigger(command)
+	}
+
+	return audit.Provenance{
+		Trigger:    trigger,
+		Model:      aiconn.Model(),
+		PromptHash: prompt.Hash(),
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+	auditCmd.AddCommand(auditExportCmd)
+	auditCmd.AddCommand(auditVerifyCmd)
+
+	auditExportCmd.Flags().StringVarP(&auditOutput, "output", "o", "", "File to write the export to (default: stdout)")
+	auditExportCmd.Flags().StringVar(&auditFormat, "format", "jsonl", "Export format (jsonl, json)")
+	auditExportCmd.Flags().Bool("skip-verify", false, "Export even if the audit chain fails verification")
+}
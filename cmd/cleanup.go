@@ -39,17 +39,8 @@ This command is particularly useful for S3 storage to control storage costs.`,
 			"retention_days", retentionDays,
 			"storage_type", cfg.StorageType)
 
-		// Create storage client based on configuration
-		var store storage.Storage
-		var err error
-
 		if cfg.StorageType == config.S3Storage {
-			logger.Info("Using S3 storage", "bucket", cfg.S3Bucket, "region", cfg.S3Region)
-			store, err = storage.NewS3Storage(cmd.Context(),
-				storage.WithBucket(cfg.S3Bucket),
-				storage.WithRegion(cfg.S3Region),
-				storage.WithPrefix(cfg.S3Prefix),
-			)
+			store, err := newStorage(cmd.Context())
 			if err != nil {
 				return fmt.Errorf("failed to initialize S3 storage: %w", err)
 			}
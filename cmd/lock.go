@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/theapemachine/lookatthatmongo/config"
+	"github.com/theapemachine/lookatthatmongo/logger"
+	"github.com/theapemachine/lookatthatmongo/mongodb"
+	"github.com/theapemachine/lookatthatmongo/storage"
+)
+
+/*
+newLocker returns the run locker selected by the configuration.
+It returns nil when run locking is disabled.
+*/
+func newLocker(store storage.Storage, conn *mongodb.Conn) (storage.Locker, error) {
+	switch cfg.LockBackend {
+	case config.NoLock:
+		logger.Warn("Run locking is disabled")
+		return nil, nil
+	case config.MongoLock:
+		return storage.NewMongoLocker(
+			conn.Client.Database(cfg.LockDatabase).Collection(storage.DefaultLockCollection),
+		), nil
+	default:
+		locker, ok := store.(storage.Locker)
+		if !ok {
+			return nil, fmt.Errorf("storage backend %s does not support run locks", cfg.StorageType)
+		}
+		return locker, nil
+	}
+}
+
+/*
+acquireRunLock takes the run lock for a database on the configured cluster and
+renews it in the background until the returned release function is called.
+The returned context is cancelled when the lease is lost, so that the run stops
+changing the database once another run may hold the lock.
+With a nil locker it does nothing.
+*/
+func acquireRunLock(ctx context.Context, locker storage.Locker, dbName string) (context.Context, func(), error) {
+	if locker == nil {
+		return ctx, func() {}, nil
+	}
+
+	ttl := cfg.LockTTL
+	lease, err := locker.AcquireLock(ctx, storage.LockKey(clusterID(cfg.MongoURI), dbName), ttl)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to acquire run lock for %s: %w", dbName, err)
+	}
+
+	logger.Info("Acquired run lock",
+		"database", dbName,
+		"key", lease.Key,
+		"expires_at", lease.ExpiresAt)
+
+	lockCtx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	// Renew the lease well before it expires, for as long as the run holds it
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := locker.RenewLock(ctx, lease, ttl); err != nil {
+					logger.Error("Failed to renew run lock", "database", dbName, "error", err)
+					// A lease that could not be renewed before it expired may have been taken over
+					if errors.Is(err, storage.ErrLockLost) || lease.Expired(time.Now()) {
+						cancel(fmt.Errorf("run lock for %s: %w", dbName, storage.ErrLockLost))
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	return lockCtx, func() {
+		close(done)
+		<-stopped
+		defer cancel(nil)
+
+		// Release even if the run was cancelled, so the next run does not wait for expiry
+		releaseCtx, cancelRelease := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancelRelease()
+
+		if err := locker.ReleaseLock(releaseCtx, lease); err != nil {
+			logger.Warn("Failed to release run lock", "database", dbName, "error", err)
+			return
+		}
+		logger.Info("Released run lock", "database", dbName)
+	}, nil
+}
+
+/*
+clusterID identifies the cluster a connection string points at by its sorted host list,
+without credentials, so that different URIs for the same cluster share locks.
+*/
+func clusterID(uri string) string {
+	hosts := uri
+	if i := strings.Index(hosts, "://"); i >= 0 {
+		hosts = hosts[i+3:]
+	}
+	if i := strings.IndexAny(hosts, "/?"); i >= 0 {
+		hosts = hosts[:i]
+	}
+	if i := strings.LastIndex(hosts, "@"); i >= 0 {
+		hosts = hosts[i+1:]
+	}
+
+	list := strings.Split(strings.ToLower(hosts), ",")
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
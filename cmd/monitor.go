@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"github.com/theapemachine/lookatthatmongo/mongodb"
+	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
+)
+
+/*
+newMonitor returns a monitor for the connection, reading the database profiler,
+explaining the top query shapes and sampling schemas when enabled in the configuration.
+*/
+func newMonitor(conn *mongodb.Conn) *mongodb.Monitor {
+	opts := []mongodb.MonitorOptionFn{mongodb.WithConn(conn)}
+
+	if cfg.ProfilerEnabled {
+		profilerOpts := []metrics.ProfilerOptionFn{
+			metrics.WithTopQueryPatterns(cfg.TopQueryPatterns),
+		}
+		if cfg.ProfileWindow > 0 {
+			profilerOpts = append(profilerOpts, metrics.WithProfilingWindow(cfg.ProfileSlowMS, cfg.ProfileWindow))
+		}
+		opts = append(opts, mongodb.WithProfiling(profilerOpts...))
+
+		if cfg.ExplainTop > 0 {
+			opts = append(opts, mongodb.WithExplain(metrics.WithExplainTop(cfg.ExplainTop)))
+		}
+	}
+
+	if cfg.SchemaSampling {
+		opts = append(opts, mongodb.WithSchemaSampling(metrics.WithSchemaSampleSize(cfg.SchemaSampleSize)))
+	}
+
+	return mongodb.NewMonitor(opts...)
+}
+
+/*
+newReport returns an empty report collected through the monitor, with the collection
+concurrency and timeout from the configuration.
+*/
+func newReport(monitor metrics.Monitor) *metrics.Report {
+	return metrics.NewReport(monitor,
+		metrics.WithConcurrency(cfg.ReportConcurrency),
+		metrics.WithCollectionTimeout(cfg.CollectionTimeout),
+	)
+}
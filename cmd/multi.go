@@ -12,7 +12,6 @@ import (
 	"github.com/theapemachine/lookatthatmongo/ai"
 	"github.com/theapemachine/lookatthatmongo/logger"
 	"github.com/theapemachine/lookatthatmongo/mongodb"
-	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
 	"github.com/theapemachine/lookatthatmongo/mongodb/optimizer"
 	"github.com/theapemachine/lookatthatmongo/mongodb/tracker"
 	"github.com/theapemachine/lookatthatmongo/storage"
@@ -73,19 +72,7 @@ and provides a comparative analysis of the results.`,
 			"compare_only", compareOnly)
 
 		// Create storage for optimization history
-		var store storage.Storage
-		var err error
-
-		// Initialize storage based on configuration
-		if cfg.StorageType == "s3" {
-			store, err = storage.NewS3Storage(cmd.Context(),
-				storage.WithBucket(cfg.S3Bucket),
-				storage.WithRegion(cfg.S3Region),
-				storage.WithPrefix(cfg.S3Prefix),
-			)
-		} else {
-			store, err = storage.NewFileStorage(cfg.StoragePath)
-		}
+		store, err := newStorage(cmd.Context())
 		if err != nil {
 			return fmt.Errorf("failed to initialize storage: %w", err)
 		}
@@ -103,6 +90,12 @@ and provides a comparative analysis of the results.`,
 		}
 		defer conn.Close(cmd.Context())
 
+		// Runs against the same database are serialized with a run lock
+		locker, err := newLocker(store, conn)
+		if err != nil {
+			return fmt.Errorf("failed to initialize run lock: %w", err)
+		}
+
 		// Start a goroutine for each database
 		for _, dbName := range databases {
 			wg.Add(1)
@@ -119,7 +112,7 @@ and provides a comparative analysis of the results.`,
 				}
 
 				// Process the database
-				err := processDatabase(ctx, conn, store, dbName, compareOnly)
+				err := processDatabase(ctx, conn, store, locker, dbName, compareOnly)
 				duration := time.Since(start)
 
 				if err != nil {
@@ -159,17 +152,17 @@ and provides a comparative analysis of the results.`,
 }
 
 // processDatabase processes a single database
-func processDatabase(ctx context.Context, conn *mongodb.Conn, store storage.Storage, dbName string, compareOnly bool) error {
+func processDatabase(ctx context.Context, conn *mongodb.Conn, store storage.Storage, locker storage.Locker, dbName string, compareOnly bool) error {
 	logger.Info("Processing database", "database", dbName)
 
 	// Set up monitoring
-	monitor := mongodb.NewMonitor(mongodb.WithConn(conn))
-	beforeReport := metrics.NewReport(monitor)
+	monitor := newMonitor(conn)
+	beforeReport := newReport(monitor)
 
 	// Collect metrics before optimization
 	logger.Info("Collecting metrics", "database", dbName)
 	err := beforeReport.Collect(ctx, dbName, func() ([]string, error) {
-		return conn.Database(dbName).ListCollectionNames(ctx, struct{}{})
+		return conn.ListCollections(ctx, dbName)
 	})
 	if err != nil {
 		return fmt.Errorf("failed to collect metrics: %w", err)
@@ -225,17 +218,47 @@ func processDatabase(ctx context.Context, conn *mongodb.Conn, store storage.Stor
 	}
 	history.AddOptimization(typedSuggestion)
 
+	// Make sure no other run is optimizing this database at the same time
+	// From here on, the run stops when the lock is lost
+	ctx, release, err := acquireRunLock(ctx, locker, dbName)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	// Apply optimizations
 	logger.Info("Applying optimizations",
 		"database", dbName,
 		"category", typedSuggestion.Category,
 		"impact", typedSuggestion.Impact)
 
+	// Every mutating command is recorded in the audit log
+	auditLog, err := newAuditLog(store)
+	if err != nil {
+		return fmt.Errorf("failed to initialize audit log: %w", err)
+	}
+
 	opt := optimizer.NewOptimizer(
 		optimizer.WithConnection(conn),
 		optimizer.WithMonitor(monitor),
+		optimizer.WithAuditLog(auditLog),
+		optimizer.WithProvenance(provenance("lookatthatmongo multi", aiconn, prompt)),
+		optimizer.WithReplicationGuard(cfg.MaxReplicationLag, cfg.MinOplogWindow),
+		optimizer.WithBuildMonitor(cfg.BuildPollInterval, cfg.MaxBuildDuration),
+		loadGuardOption(),
+		whatIfOption(beforeReport),
+		schemaOption(beforeReport),
+		optimizer.WithIndexUsage(beforeReport.Indexes),
+		keyOrderOption(beforeReport),
+		optimizer.WithStagedDrop(cfg.StagedDrop),
 	)
 
+	// Indexes hidden by earlier runs are dropped or unhidden before anything else changes
+	stagedDrops := newStagedDrops(store, opt)
+	if err := stagedDrops.Process(ctx, dbName, beforeReport); err != nil {
+		logger.Warn("Failed to process staged index drops", "database", dbName, "error", err)
+	}
+
 	if err := opt.Apply(ctx, dbName, typedSuggestion); err != nil { // Pass dbName
 		// Attempt rollback on failure if enabled
 		if cfg.EnableRollback {
@@ -250,11 +273,19 @@ func processDatabase(ctx context.Context, conn *mongodb.Conn, store storage.Stor
 		return fmt.Errorf("optimization failed: %v", err)
 	}
 
+	// The what-if estimates are stored with the records of the run
+	history.SetEstimates(opt.Estimates())
+
+	// Indexes that were hidden instead of dropped are tracked until a later run drops them
+	if err := stagedDrops.Record(ctx, dbName, typedSuggestion, beforeReport); err != nil {
+		return fmt.Errorf("failed to record staged index drops: %w", err)
+	}
+
 	// Collect metrics after optimization
 	logger.Info("Collecting metrics after optimization", "database", dbName)
-	afterReport := metrics.NewReport(monitor)
+	afterReport := newReport(monitor)
 	err = afterReport.Collect(ctx, dbName, func() ([]string, error) {
-		return conn.Database(dbName).ListCollectionNames(ctx, struct{}{})
+		return conn.ListCollections(ctx, dbName)
 	})
 	if err != nil {
 		return fmt.Errorf("failed to collect metrics after optimization: %w", err)
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
+	"github.com/theapemachine/lookatthatmongo/mongodb/optimizer"
+	"github.com/theapemachine/lookatthatmongo/mongodb/tracker"
+	"github.com/theapemachine/lookatthatmongo/storage"
+)
+
+/*
+whatIfOption enables the what-if analysis of new indexes when configured, measuring
+them against the query shapes of the report the suggestion was made from.
+*/
+func whatIfOption(report *metrics.Report) optimizer.OptimizerOptionFn {
+	return func(o *optimizer.MongoOptimizer) {
+		if !cfg.WhatIfEnabled {
+			return
+		}
+
+		optimizer.WithWhatIf(report.QueryPatterns, cfg.WhatIfSampleSize, cfg.WhatIfGate)(o)
+		optimizer.WithWhatIfDatabase(cfg.WhatIfDatabase)(o)
+	}
+}
+
+/*
+schemaOption checks new index keys against the schemas sampled in the report the
+suggestion was made from, when the schema gate is enabled.
+*/
+func schemaOption(report *metrics.Report) optimizer.OptimizerOptionFn {
+	return func(o *optimizer.MongoOptimizer) {
+		if !cfg.SchemaGate {
+			return
+		}
+
+		optimizer.WithSchemas(report.Schemas)(o)
+	}
+}
+
+/*
+keyOrderOption checks or rewrites the order of compound index keys by the ESR rule,
+using the query shapes and schemas of the report the suggestion was made from.
+*/
+func keyOrderOption(report *metrics.Report) optimizer.OptimizerOptionFn {
+	return optimizer.WithKeyOrdering(cfg.KeyOrder, report.QueryPatterns, report.Schemas)
+}
+
+/*
+newStagedDrops tracks the indexes hidden instead of dropped, and drops or unhides them
+through the optimizer on later runs.
+*/
+func newStagedDrops(store storage.Storage, opt *optimizer.MongoOptimizer) *tracker.StagedDrops {
+	return tracker.NewStagedDrops(
+		tracker.WithStagedDropStorage(store),
+		tracker.WithIndexDropper(opt),
+		tracker.WithObservation(cfg.DropObservation),
+		tracker.WithRegressionThreshold(cfg.DropThreshold),
+	)
+}
+
+/*
+loadGuardOption pauses, defers or aborts changes while the server is under load, when
+the load guard is enabled. The replication lag limit is shared with the replication guard.
+*/
+func loadGuardOption() optimizer.OptimizerOptionFn {
+	return func(o *optimizer.MongoOptimizer) {
+		if !cfg.LoadGuard {
+			return
+		}
+
+		optimizer.WithLoadGuard(optimizer.LoadThresholds{
+			MaxCPUPercent:     cfg.LoadMaxCPU,
+			MaxDirtyPercent:   cfg.LoadMaxDirty,
+			MaxReplicationLag: cfg.MaxReplicationLag,
+			MaxQueued:         cfg.LoadMaxQueued,
+			MaxConnections:    cfg.LoadMaxConnections,
+		}, cfg.LoadPause, cfg.LoadMaxWait)(o)
+	}
+}
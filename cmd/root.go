@@ -12,10 +12,8 @@ import (
 	"github.com/theapemachine/lookatthatmongo/config"
 	"github.com/theapemachine/lookatthatmongo/logger"
 	"github.com/theapemachine/lookatthatmongo/mongodb"
-	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
 	"github.com/theapemachine/lookatthatmongo/mongodb/optimizer"
 	"github.com/theapemachine/lookatthatmongo/mongodb/tracker"
-	"github.com/theapemachine/lookatthatmongo/storage"
 )
 
 var (
@@ -45,22 +43,7 @@ var rootCmd = &cobra.Command{
 		logger.Info("Starting MongoDB optimization", "database", cfg.DatabaseName)
 
 		// Create storage for optimization history based on configuration
-		var store storage.Storage
-		var err error
-
-		if cfg.StorageType == config.S3Storage {
-			logger.Info("Using S3 storage", "bucket", cfg.S3Bucket, "region", cfg.S3Region)
-			store, err = storage.NewS3Storage(cmd.Context(),
-				storage.WithBucket(cfg.S3Bucket),
-				storage.WithRegion(cfg.S3Region),
-				storage.WithPrefix(cfg.S3Prefix),
-			)
-		} else {
-			// Default to file storage
-			logger.Info("Using file storage", "path", cfg.StoragePath)
-			store, err = storage.NewFileStorage(cfg.StoragePath)
-		}
-
+		store, err := newStorage(cmd.Context())
 		if err != nil {
 			return fmt.Errorf("failed to initialize storage: %w", err)
 		}
@@ -74,13 +57,13 @@ var rootCmd = &cobra.Command{
 		logger.Info("Connected to MongoDB", "database", cfg.DatabaseName)
 
 		// Set up monitoring
-		monitor := mongodb.NewMonitor(mongodb.WithConn(conn))
-		beforeReport := metrics.NewReport(monitor)
+		monitor := newMonitor(conn)
+		beforeReport := newReport(monitor)
 
 		// Collect metrics before optimization
 		logger.Info("Collecting metrics before optimization")
 		err = beforeReport.Collect(cmd.Context(), cfg.DatabaseName, func() ([]string, error) {
-			return conn.Database(cfg.DatabaseName).ListCollectionNames(cmd.Context(), struct{}{})
+			return conn.ListCollections(cmd.Context(), cfg.DatabaseName)
 		})
 		if err != nil {
 			return fmt.Errorf("failed to collect metrics: %w", err)
@@ -120,20 +103,54 @@ var rootCmd = &cobra.Command{
 		// Now use typedSuggestion for subsequent operations
 		history.AddOptimization(typedSuggestion)
 
+		// Make sure no other run is optimizing this database at the same time
+		locker, err := newLocker(store, conn)
+		if err != nil {
+			return fmt.Errorf("failed to initialize run lock: %w", err)
+		}
+		// From here on, the run stops when the lock is lost
+		ctx, release, err := acquireRunLock(cmd.Context(), locker, cfg.DatabaseName)
+		if err != nil {
+			return err
+		}
+		defer release()
+
 		// Apply optimizations
 		logger.Info("Applying optimizations",
 			"category", typedSuggestion.Category,
 			"impact", typedSuggestion.Impact)
+		// Every mutating command is recorded in the audit log
+		auditLog, err := newAuditLog(store)
+		if err != nil {
+			return fmt.Errorf("failed to initialize audit log: %w", err)
+		}
+
 		opt := optimizer.NewOptimizer(
 			optimizer.WithConnection(conn),
 			optimizer.WithMonitor(monitor),
+			optimizer.WithAuditLog(auditLog),
+			optimizer.WithProvenance(provenance("lookatthatmongo", aiconn, prompt)),
+			optimizer.WithReplicationGuard(cfg.MaxReplicationLag, cfg.MinOplogWindow),
+			optimizer.WithBuildMonitor(cfg.BuildPollInterval, cfg.MaxBuildDuration),
+			loadGuardOption(),
+			whatIfOption(beforeReport),
+			schemaOption(beforeReport),
+			optimizer.WithIndexUsage(beforeReport.Indexes),
+			keyOrderOption(beforeReport),
+			optimizer.WithStagedDrop(cfg.StagedDrop),
 		)
 
-		if err := opt.Apply(cmd.Context(), cfg.DatabaseName, typedSuggestion); err != nil {
+		// Indexes hidden by earlier runs are dropped or unhidden before anything else changes
+		stagedDrops := newStagedDrops(store, opt)
+		if err := stagedDrops.Process(ctx, cfg.DatabaseName, beforeReport); err != nil {
+			logger.Warn("Failed to process staged index drops", "error", err)
+		}
+
+		if err := opt.Apply(ctx, cfg.DatabaseName, typedSuggestion); err != nil {
 			// Attempt rollback on failure if enabled
 			if cfg.EnableRollback {
 				logger.Error("Optimization failed, attempting rollback", "error", err)
-				if rbErr := opt.Rollback(cmd.Context(), cfg.DatabaseName, typedSuggestion); rbErr != nil {
+				if rbErr := opt.Rollback(ctx, cfg.DatabaseName, typedSuggestion); rbErr != nil {
 					return fmt.Errorf("optimization failed and rollback failed: %v (rollback: %v)", err, rbErr)
 				}
 				return fmt.Errorf("optimization failed but rolled back successfully: %v", err)
@@ -141,11 +158,19 @@ var rootCmd = &cobra.Command{
 			return fmt.Errorf("optimization failed: %v", err)
 		}
 
+		// The what-if estimates are stored with the records of the run
+		history.SetEstimates(opt.Estimates())
+
+		// Indexes that were hidden instead of dropped are tracked until a later run drops them
+		if err := stagedDrops.Record(ctx, cfg.DatabaseName, typedSuggestion, beforeReport); err != nil {
+			return fmt.Errorf("failed to record staged index drops: %w", err)
+		}
+
 		// Collect metrics after optimization
 		logger.Info("Collecting metrics after optimization")
-		afterReport := metrics.NewReport(monitor)
-		err = afterReport.Collect(cmd.Context(), cfg.DatabaseName, func() ([]string, error) {
-			return conn.Database(cfg.DatabaseName).ListCollectionNames(cmd.Context(), struct{}{})
+		afterReport := newReport(monitor)
+		err = afterReport.Collect(ctx, cfg.DatabaseName, func() ([]string, error) {
+			return conn.ListCollections(ctx, cfg.DatabaseName)
 		})
 		if err != nil {
 			return fmt.Errorf("failed to collect metrics after optimization: %w", err)
@@ -173,14 +198,14 @@ var rootCmd = &cobra.Command{
 
 		// Measure and take action
 		logger.Info("Measuring optimization impact")
-		_, err = measurement.MeasureAndStore(cmd.Context())
+		_, err = measurement.MeasureAndStore(ctx)
 		if err != nil {
 			return fmt.Errorf("measurement failed: %w", err)
 		}
 
 		// Validate the changes
 		logger.Info("Validating optimization")
-		result, err := opt.Validate(cmd.Context(), cfg.DatabaseName, typedSuggestion)
+		result, err := opt.Validate(ctx, cfg.DatabaseName, typedSuggestion)
 		if err != nil {
 			return fmt.Errorf("validation failed: %w", err)
 		}
@@ -219,6 +244,65 @@ func init() {
 	rootCmd.Flags().StringVar(&cfg.S3Prefix, "s3-prefix", cfg.S3Prefix, "Prefix for S3 objects (default: optimization-records/)")
 	rootCmd.Flags().IntVar(&cfg.S3RetentionDays, "s3-retention-days", cfg.S3RetentionDays, "Number of days to keep records in S3 before auto-deletion")
 	rootCmd.Flags().StringVar(&cfg.S3CredentialsFile, "s3-credentials", cfg.S3CredentialsFile, "Path to AWS credentials file")
+	rootCmd.Flags().StringVar(&cfg.S3Endpoint, "s3-endpoint", cfg.S3Endpoint, "Custom S3 endpoint URL (MinIO, Ceph, LocalStack)")
+	rootCmd.Flags().BoolVar(&cfg.S3PathStyle, "s3-path-style", cfg.S3PathStyle, "Use path-style S3 addressing")
+	rootCmd.Flags().BoolVar(&cfg.S3SSEKMS, "s3-sse-kms", cfg.S3SSEKMS, "Enable S3 server-side encryption with AWS KMS")
+	rootCmd.Flags().StringVar(&cfg.S3KMSKeyID, "s3-kms-key-id", cfg.S3KMSKeyID, "KMS key ID for S3 server-side encryption")
+
+	// Run locking flags
+	rootCmd.Flags().StringVar((*string)(&cfg.LockBackend), "lock-backend", string(cfg.LockBackend), "Where run locks are held (storage, mongo, none)")
+	rootCmd.Flags().DurationVar(&cfg.LockTTL, "lock-ttl", cfg.LockTTL, "Lease duration of a run lock, renewed while the run is active")
+
+	// Profiler flags
+	rootCmd.Flags().BoolVar(&cfg.ProfilerEnabled, "profiler", cfg.ProfilerEnabled, "Include query shapes from the database profiler in reports")
+	rootCmd.Flags().IntVar(&cfg.ProfileSlowMS, "profile-slowms", cfg.ProfileSlowMS, "Slow operation threshold while profiling is enabled by a run")
+	rootCmd.Flags().DurationVar(&cfg.ProfileWindow, "profile-window", cfg.ProfileWindow, "Enable profiling for this long before reading it (0 leaves profiling untouched)")
+	rootCmd.Flags().IntVar(&cfg.TopQueryPatterns, "top-query-patterns", cfg.TopQueryPatterns, "Number of query shapes included in a report")
+	rootCmd.Flags().IntVar(&cfg.ExplainTop, "explain-top", cfg.ExplainTop, "Number of top query shapes explained with executionStats (0 disables)")
+
+	// What-if analysis flags
+	rootCmd.Flags().BoolVar(&cfg.WhatIfEnabled, "what-if", cfg.WhatIfEnabled, "Estimate the benefit of new indexes on a sampled shadow collection before creating them")
+	rootCmd.Flags().BoolVar(&cfg.WhatIfGate, "what-if-gate", cfg.WhatIfGate, "Refuse to create indexes without a measured benefit")
+	rootCmd.Flags().Int64Var(&cfg.WhatIfSampleSize, "what-if-sample-size", cfg.WhatIfSampleSize, "Number of documents copied into a shadow collection")
+
+	// Report collection flags
+	rootCmd.Flags().IntVar(&cfg.ReportConcurrency, "report-concurrency", cfg.ReportConcurrency, "Number of collections collected at the same time")
+	rootCmd.Flags().DurationVar(&cfg.CollectionTimeout, "collection-timeout", cfg.CollectionTimeout, "Time allowed to collect a single collection (0 disables)")
+
+	// Schema sampling flags
+	rootCmd.Flags().BoolVar(&cfg.SchemaSampling, "schema-sampling", cfg.SchemaSampling, "Infer the schema of each collection from a sample of its documents")
+	rootCmd.Flags().Int64Var(&cfg.SchemaSampleSize, "schema-sample-size", cfg.SchemaSampleSize, "Number of documents sampled per collection")
+	rootCmd.Flags().BoolVar(&cfg.SchemaGate, "schema-gate", cfg.SchemaGate, "Refuse to create indexes on fields missing from the sampled documents")
+	rootCmd.Flags().StringVar(&cfg.KeyOrder, "key-order", cfg.KeyOrder, "ESR ordering of compound index keys (off, check, reorder)")
+
+	// Replication guard flags
+	rootCmd.Flags().DurationVar(&cfg.MaxReplicationLag, "max-replication-lag", cfg.MaxReplicationLag, "Refuse index builds while a secondary lags more than this (0 disables)")
+	rootCmd.Flags().DurationVar(&cfg.MinOplogWindow, "min-oplog-window", cfg.MinOplogWindow, "Refuse index builds while the oplog window is shorter than this (0 disables)")
+
+	// Staged drop flags
+	rootCmd.Flags().BoolVar(&cfg.StagedDrop, "staged-drop", cfg.StagedDrop, "Hide indexes instead of dropping them, and drop them on a later run")
+	rootCmd.Flags().DurationVar(&cfg.DropObservation, "drop-observation", cfg.DropObservation, "How long an index stays hidden before it is dropped")
+	rootCmd.Flags().Float64Var(&cfg.DropThreshold, "drop-regression-threshold", cfg.DropThreshold, "Increase in latency or documents examined (%) that unhides a hidden index")
+
+	// Index build flags
+	rootCmd.Flags().DurationVar(&cfg.BuildPollInterval, "build-poll-interval", cfg.BuildPollInterval, "How often the progress of an index build is read (0 disables)")
+	rootCmd.Flags().DurationVar(&cfg.MaxBuildDuration, "max-build-duration", cfg.MaxBuildDuration, "Abort index builds running longer than this (0 disables)")
+
+	// Load guard flags
+	rootCmd.Flags().BoolVar(&cfg.LoadGuard, "load-guard", cfg.LoadGuard, "Check the load of the server before and during changes")
+	rootCmd.Flags().Float64Var(&cfg.LoadMaxCPU, "load-max-cpu", cfg.LoadMaxCPU, "Maximum CPU usage of mongod in percent of all cores (0 disables)")
+	rootCmd.Flags().Float64Var(&cfg.LoadMaxDirty, "load-max-dirty", cfg.LoadMaxDirty, "Maximum WiredTiger cache dirty percentage (0 disables)")
+	rootCmd.Flags().Int64Var(&cfg.LoadMaxQueued, "load-max-queued", cfg.LoadMaxQueued, "Maximum readers and writers queued for the global lock (0 disables)")
+	rootCmd.Flags().Int64Var(&cfg.LoadMaxConnections, "load-max-connections", cfg.LoadMaxConnections, "Maximum current client connections (0 disables)")
+	rootCmd.Flags().DurationVar(&cfg.LoadPause, "load-pause", cfg.LoadPause, "Time between load checks while the server is under load")
+	rootCmd.Flags().DurationVar(&cfg.LoadMaxWait, "load-max-wait", cfg.LoadMaxWait, "How long the load may stay over a threshold before a change is deferred or aborted")
+
+	// Report storage flags
+	rootCmd.Flags().BoolVar(&cfg.DeduplicateReports, "deduplicate-reports", cfg.DeduplicateReports, "Store each report once and reference it from records")
+	rootCmd.Flags().StringVar(&cfg.ReportCompression, "compression", cfg.ReportCompression, "Compression for stored reports (none, gzip, zstd)")
+
+	// Encryption flags
+	rootCmd.Flags().StringVar(&cfg.EncryptionKeyFile, "encryption-key-file", cfg.EncryptionKeyFile, "Path to the key used to encrypt stored records")
 
 	// Logging flags
 	rootCmd.Flags().StringVar(&logLevel, "log-level", "info", "Log level (debug, info, warn, error)")
@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/theapemachine/lookatthatmongo/config"
+	"github.com/theapemachine/lookatthatmongo/logger"
+	"github.com/theapemachine/lookatthatmongo/storage"
+)
+
+var (
+	newKeyFile string
+	newKeyEnv  string
+)
+
+/*
+storageCmd groups maintenance commands for the optimization history storage.
+*/
+var storageCmd = &cobra.Command{
+	Use:   "storage",
+	Short: "Manage optimization history storage",
+}
+
+/*
+rekeyCmd re-encrypts all stored optimization records with a new master key.
+Records encrypted with the current key (or stored in plaintext) are read and
+written back sealed with the new key.
+*/
+var rekeyCmd = &cobra.Command{
+	Use:   "rekey",
+	Short: "Re-encrypt stored records with a new encryption key",
+	Long: `Rotate the storage encryption key by re-encrypting every stored record.
+The current key is taken from STORAGE_ENCRYPTION_KEY_FILE or STORAGE_ENCRYPTION_KEY,
+the new key from --new-key-file or the environment variable named by --new-key-env.
+Plaintext records are encrypted with the new key as well.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Apply logging configuration
+		cfg.ApplyLogging()
+
+		// Validate configuration
+		if err := cfg.Validate(); err != nil {
+			return err
+		}
+
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		newKey, err := storage.LoadKey(newKeyFile, newKeyEnv)
+		if err != nil {
+			return fmt.Errorf("failed to load new encryption key: %w", err)
+		}
+		if newKey == nil {
+			return fmt.Errorf("a new key is required (use --new-key-file or set %s)", newKeyEnv)
+		}
+
+		oldKey, err := storage.LoadKey(cfg.EncryptionKeyFile, config.EncryptionKeyEnv)
+		if err != nil {
+			return fmt.Errorf("failed to load current encryption key: %w", err)
+		}
+
+		var previous [][]byte
+		if oldKey != nil {
+			previous = append(previous, oldKey)
+		}
+
+		encryptor, err := storage.NewEncryptor(newKey, previous...)
+		if err != nil {
+			return fmt.Errorf("failed to initialize encryption: %w", err)
+		}
+
+		store, err := openStorage(cmd.Context(), encryptor)
+		if err != nil {
+			return fmt.Errorf("failed to initialize storage: %w", err)
+		}
+
+		// Every record has to be rewritten, so a record that cannot be read fails the rekey
+		lister, ok := store.(interface {
+			ListOptimizationRecordsStrict(ctx context.Context) ([]*storage.OptimizationRecord, error)
+		})
+		if !ok {
+			return fmt.Errorf("storage backend %s cannot list every record for a rekey", cfg.StorageType)
+		}
+
+		records, err := lister.ListOptimizationRecordsStrict(cmd.Context())
+		if err != nil {
+			return fmt.Errorf("failed to list optimization records: %w", err)
+		}
+
+		logger.Info("Re-encrypting optimization records",
+			"records", len(records),
+			"key_id", encryptor.PrimaryKeyID())
+
+		for _, record := range records {
+			if err := store.SaveOptimizationRecord(cmd.Context(), record); err != nil {
+				return fmt.Errorf("failed to re-encrypt record %s: %w", record.ID, err)
+			}
+		}
+
+		// Report blobs are shared between records, so they are rewritten separately
+		var blobs int
+		if reencrypter, ok := store.(interface {
+			ReencryptReports(ctx context.Context) (int, error)
+		}); ok {
+			if blobs, err = reencrypter.ReencryptReports(cmd.Context()); err != nil {
+				return fmt.Errorf("failed to re-encrypt reports: %w", err)
+			}
+		}
+
+		// Audit entries are never written again, so they are rewritten here as well
+		var entries int
+		if reencrypter, ok := store.(interface {
+			ReencryptAuditEntries(ctx context.Context) (int, error)
+		}); ok {
+			if entries, err = reencrypter.ReencryptAuditEntries(cmd.Context()); err != nil {
+				return fmt.Errorf("failed to re-encrypt audit entries: %w", err)
+			}
+		}
+
+		logger.Info("Rekey completed successfully", "records", len(records), "reports", blobs, "audit_entries", entries)
+		return nil
+	},
+}
+
+/*
+newStorage creates the storage backend described by the configuration,
+including encryption when a key is configured.
+*/
+func newStorage(ctx context.Context) (storage.Storage, error) {
+	key, err := storage.LoadKey(cfg.EncryptionKeyFile, config.EncryptionKeyEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	var encryptor *storage.Encryptor
+	if key != nil {
+		if encryptor, err = storage.NewEncryptor(key); err != nil {
+			return nil, err
+		}
+		logger.Info("Storage encryption enabled", "key_id", encryptor.PrimaryKeyID())
+	}
+
+	return openStorage(ctx, encryptor)
+}
+
+/*
+openStorage creates the configured storage backend with the given encryptor,
+which may be nil to disable encryption.
+*/
+func openStorage(ctx context.Context, encryptor *storage.Encryptor) (storage.Storage, error) {
+	compression, err := storage.ParseCompression(cfg.ReportCompression)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.StorageType == config.S3Storage {
+		logger.Info("Using S3 storage", "bucket", cfg.S3Bucket, "region", cfg.S3Region, "endpoint", cfg.S3Endpoint)
+		opts := []storage.S3StorageOption{
+			storage.WithBucket(cfg.S3Bucket),
+			storage.WithRegion(cfg.S3Region),
+			storage.WithPrefix(cfg.S3Prefix),
+			storage.WithPathStyle(cfg.S3PathStyle),
+			storage.WithAuditPrefix(cfg.S3AuditPrefix),
+		}
+		if cfg.S3Endpoint != "" {
+			opts = append(opts, storage.WithEndpoint(cfg.S3Endpoint))
+		}
+		if cfg.S3CredentialsFile != "" {
+			opts = append(opts, storage.WithCredentialsFile(cfg.S3CredentialsFile))
+		}
+		if cfg.S3AccessKeyID != "" {
+			opts = append(opts, storage.WithStaticCredentials(cfg.S3AccessKeyID, cfg.S3SecretAccessKey, ""))
+		}
+		if cfg.S3SSEKMS {
+			opts = append(opts, storage.WithSSEKMS(cfg.S3KMSKeyID))
+		}
+		if encryptor != nil {
+			opts = append(opts, storage.WithEncryptor(encryptor))
+		}
+		if cfg.DeduplicateReports {
+			opts = append(opts, storage.WithReportDeduplication(compression))
+		}
+		return storage.NewS3Storage(ctx, opts...)
+	}
+
+	// Default to file storage
+	logger.Info("Using file storage", "path", cfg.StoragePath)
+	var opts []storage.FileStorageOption
+	if encryptor != nil {
+		opts = append(opts, storage.WithFileEncryptor(encryptor))
+	}
+	if cfg.DeduplicateReports {
+		opts = append(opts, storage.WithFileReportDeduplication(compression))
+	}
+	return storage.NewFileStorage(cfg.StoragePath, opts...)
+}
+
+func init() {
+	rootCmd.AddCommand(storageCmd)
+	storageCmd.AddCommand(rekeyCmd)
+
+	rekeyCmd.Flags().StringVar(&newKeyFile, "new-key-file", "", "Path to the new encryption key")
+	rekeyCmd.Flags().StringVar(&newKeyEnv, "new-key-env", "STORAGE_ENCRYPTION_NEW_KEY", "Environment variable holding the new encryption key")
+}
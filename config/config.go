@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/log"
 	"github.com/theapemachine/lookatthatmongo/logger"
@@ -21,6 +22,21 @@ const (
 	S3Storage StorageType = "s3"
 )
 
+// LockBackend represents where run locks are held
+type LockBackend string
+
+const (
+	// StorageLock holds run locks in the configured storage backend
+	StorageLock LockBackend = "storage"
+	// MongoLock holds run locks as documents in the MongoDB cluster being optimized
+	MongoLock LockBackend = "mongo"
+	// NoLock disables run locking
+	NoLock LockBackend = "none"
+)
+
+// EncryptionKeyEnv is the environment variable that may hold the storage encryption key
+const EncryptionKeyEnv = "STORAGE_ENCRYPTION_KEY"
+
 /*
 Config holds the application configuration including MongoDB connection settings,
 storage settings, logging settings, and optimization parameters.
@@ -40,6 +56,74 @@ type Config struct {
 	S3Prefix          string
 	S3RetentionDays   int    // Number of days to keep records before auto-deletion
 	S3CredentialsFile string // Path to AWS credentials file
+	S3Endpoint        string // Custom endpoint URL for S3-compatible services
+	S3PathStyle       bool   // Use path-style addressing instead of virtual-hosted buckets
+	S3AccessKeyID     string // Static access key ID, overrides the default credential chain
+	S3SecretAccessKey string // Static secret access key
+	S3SSEKMS          bool   // Enable server-side encryption with AWS KMS
+	S3KMSKeyID        string // KMS key ID for server-side encryption (optional)
+	S3AuditPrefix     string // Prefix for audit log objects, kept apart from records
+
+	// Report storage settings
+	DeduplicateReports bool   // Store reports once, addressed by content hash
+	ReportCompression  string // Compression for stored reports (none, gzip, zstd)
+
+	// Run locking settings
+	LockBackend  LockBackend   // Where run locks are held (storage, mongo, none)
+	LockTTL      time.Duration // Lease duration of a run lock, renewed while the run is active
+	LockDatabase string        // Database holding lock documents for the mongo lock backend
+
+	// Audit settings
+	AuditEnabled bool   // Record every mutating command in the audit log
+	AuditTrigger string // Who or what triggered the run, recorded with each audit entry
+
+	// Profiler settings
+	ProfilerEnabled  bool          // Read query shapes from the database profiler into reports
+	ProfileSlowMS    int           // Slow operation threshold while profiling is enabled by a run
+	ProfileWindow    time.Duration // How long to enable profiling before reading it, 0 leaves profiling untouched
+	TopQueryPatterns int           // Number of query shapes included in a report
+	ExplainTop       int           // Number of top query shapes explained, 0 disables explain
+
+	// What-if analysis settings
+	WhatIfEnabled    bool   // Estimate the benefit of new indexes on a sampled shadow collection
+	WhatIfGate       bool   // Refuse to create indexes without a measured benefit
+	WhatIfSampleSize int64  // Number of documents copied into a shadow collection
+	WhatIfDatabase   string // Scratch database holding shadow collections
+
+	// Report collection settings
+	ReportConcurrency int           // Number of collections collected at the same time
+	CollectionTimeout time.Duration // Time allowed to collect a single collection, 0 disables
+
+	// Schema sampling settings
+	SchemaSampling   bool   // Infer the schema of each collection from a sample of its documents
+	SchemaSampleSize int64  // Number of documents sampled per collection
+	SchemaGate       bool   // Refuse to create indexes on fields missing from the sample
+	KeyOrder         string // ESR ordering of compound index keys (off, check, reorder)
+
+	// Replication guard settings
+	MaxReplicationLag time.Duration // Refuse index builds while a secondary lags more than this, 0 disables
+	MinOplogWindow    time.Duration // Refuse index builds while the oplog window is shorter than this, 0 disables
+
+	// Staged drop settings
+	StagedDrop      bool          // Hide indexes instead of dropping them, and drop them on a later run
+	DropObservation time.Duration // How long an index stays hidden before it is dropped
+	DropThreshold   float64       // Increase in latency or documents examined, in percent, that unhides an index
+
+	// Index build settings
+	BuildPollInterval time.Duration // How often the progress of an index build is read, 0 disables
+	MaxBuildDuration  time.Duration // Abort index builds running longer than this, 0 disables
+
+	// Load guard settings
+	LoadGuard          bool          // Check the load of the server before and during changes
+	LoadMaxCPU         float64       // CPU usage of mongod, in percent of all cores, 0 disables
+	LoadMaxDirty       float64       // WiredTiger cache dirty percentage, 0 disables
+	LoadMaxQueued      int64         // Readers and writers queued for the global lock, 0 disables
+	LoadMaxConnections int64         // Current client connections, 0 disables
+	LoadPause          time.Duration // Time between load checks while the server is under load
+	LoadMaxWait        time.Duration // How long the load may stay over a threshold before a change is deferred or aborted
+
+	// Encryption settings
+	EncryptionKeyFile string // Path to the master key used to encrypt stored records
 
 	// Logging settings
 	LogLevel log.Level
@@ -72,6 +156,50 @@ func New() *Config {
 		S3Prefix:             getEnvWithDefault("S3_PREFIX", "optimization-records/"),
 		S3RetentionDays:      parseInt(getEnvWithDefault("S3_RETENTION_DAYS", "90")),
 		S3CredentialsFile:    getEnvWithDefault("S3_CREDENTIALS_FILE", ""),
+		S3Endpoint:           getEnvWithDefault("S3_ENDPOINT", ""),
+		S3PathStyle:          parseBool(getEnvWithDefault("S3_FORCE_PATH_STYLE", "false")),
+		S3AccessKeyID:        getEnvWithDefault("S3_ACCESS_KEY_ID", ""),
+		S3SecretAccessKey:    getEnvWithDefault("S3_SECRET_ACCESS_KEY", ""),
+		S3SSEKMS:             parseBool(getEnvWithDefault("S3_SSE_KMS", "false")),
+		S3KMSKeyID:           getEnvWithDefault("S3_KMS_KEY_ID", ""),
+		S3AuditPrefix:        getEnvWithDefault("S3_AUDIT_PREFIX", "audit-log/"),
+		DeduplicateReports:   parseBool(getEnvWithDefault("STORAGE_DEDUPLICATE_REPORTS", "true")),
+		ReportCompression:    getEnvWithDefault("STORAGE_COMPRESSION", "gzip"),
+		EncryptionKeyFile:    getEnvWithDefault("STORAGE_ENCRYPTION_KEY_FILE", ""),
+		AuditEnabled:         parseBool(getEnvWithDefault("AUDIT_ENABLED", "true")),
+		AuditTrigger:         getEnvWithDefault("AUDIT_TRIGGER", ""),
+		LockBackend:          LockBackend(getEnvWithDefault("LOCK_BACKEND", string(StorageLock))),
+		LockTTL:              parseDuration(getEnvWithDefault("LOCK_TTL", "10m")),
+		LockDatabase:         getEnvWithDefault("LOCK_DATABASE", "lookatthatmongo"),
+		ProfilerEnabled:      parseBool(getEnvWithDefault("PROFILER_ENABLED", "true")),
+		ProfileSlowMS:        parseInt(getEnvWithDefault("PROFILE_SLOWMS", "100")),
+		ProfileWindow:        parseDuration(getEnvWithDefault("PROFILE_WINDOW", "0s")),
+		TopQueryPatterns:     parseInt(getEnvWithDefault("TOP_QUERY_PATTERNS", "20")),
+		ExplainTop:           parseInt(getEnvWithDefault("EXPLAIN_TOP", "5")),
+		WhatIfEnabled:        parseBool(getEnvWithDefault("WHATIF_ENABLED", "false")),
+		WhatIfGate:           parseBool(getEnvWithDefault("WHATIF_GATE", "false")),
+		WhatIfSampleSize:     int64(parseInt(getEnvWithDefault("WHATIF_SAMPLE_SIZE", "10000"))),
+		WhatIfDatabase:       getEnvWithDefault("WHATIF_DATABASE", "lookatthatmongo_whatif"),
+		ReportConcurrency:    parseInt(getEnvWithDefault("REPORT_CONCURRENCY", "8")),
+		CollectionTimeout:    parseDuration(getEnvWithDefault("COLLECTION_TIMEOUT", "30s")),
+		SchemaSampling:       parseBool(getEnvWithDefault("SCHEMA_SAMPLING", "true")),
+		SchemaSampleSize:     int64(parseInt(getEnvWithDefault("SCHEMA_SAMPLE_SIZE", "1000"))),
+		SchemaGate:           parseBool(getEnvWithDefault("SCHEMA_GATE", "true")),
+		KeyOrder:             getEnvWithDefault("KEY_ORDER", "check"),
+		MaxReplicationLag:    parseDuration(getEnvWithDefault("MAX_REPLICATION_LAG", "30s")),
+		MinOplogWindow:       parseDuration(getEnvWithDefault("MIN_OPLOG_WINDOW", "24h")),
+		StagedDrop:           parseBool(getEnvWithDefault("STAGED_DROP", "true")),
+		DropObservation:      parseDuration(getEnvWithDefault("DROP_OBSERVATION", "24h")),
+		DropThreshold:        parseFloat(getEnvWithDefault("DROP_REGRESSION_THRESHOLD", "20")),
+		BuildPollInterval:    parseDuration(getEnvWithDefault("BUILD_POLL_INTERVAL", "10s")),
+		MaxBuildDuration:     parseDuration(getEnvWithDefault("MAX_BUILD_DURATION", "4h")),
+		LoadGuard:            parseBool(getEnvWithDefault("LOAD_GUARD", "true")),
+		LoadMaxCPU:           parseFloat(getEnvWithDefault("LOAD_MAX_CPU", "80")),
+		LoadMaxDirty:         parseFloat(getEnvWithDefault("LOAD_MAX_DIRTY", "10")),
+		LoadMaxQueued:        int64(parseInt(getEnvWithDefault("LOAD_MAX_QUEUED", "50"))),
+		LoadMaxConnections:   int64(parseInt(getEnvWithDefault("LOAD_MAX_CONNECTIONS", "0"))),
+		LoadPause:            parseDuration(getEnvWithDefault("LOAD_PAUSE", "30s")),
+		LoadMaxWait:          parseDuration(getEnvWithDefault("LOAD_MAX_WAIT", "10m")),
 		LogLevel:             parseLogLevel(getEnvWithDefault("LOG_LEVEL", "debug")),
 		ImprovementThreshold: parseFloat(getEnvWithDefault("IMPROVEMENT_THRESHOLD", "5.0")),
 		EnableRollback:       parseBool(getEnvWithDefault("ENABLE_ROLLBACK", "true")),
@@ -97,12 +225,85 @@ func (c *Config) Validate() error {
 		if c.S3Bucket == "" {
 			return fmt.Errorf("S3_BUCKET environment variable is required when STORAGE_TYPE=s3")
 		}
+		if (c.S3AccessKeyID == "") != (c.S3SecretAccessKey == "") {
+			return fmt.Errorf("S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY must be set together")
+		}
+		if c.AuditEnabled && (c.S3AuditPrefix == "" || strings.HasPrefix(c.S3Prefix, c.S3AuditPrefix)) {
+			return fmt.Errorf("S3_AUDIT_PREFIX must be set and must not contain the records prefix")
+		}
 	} else if c.StorageType == FileStorage {
 		// For file storage, no additional validation needed
 	} else {
 		return fmt.Errorf("invalid storage type: %s (valid values: file, s3)", c.StorageType)
 	}
 
+	switch strings.ToLower(c.ReportCompression) {
+	case "", "none", "gzip", "zstd":
+	default:
+		return fmt.Errorf("invalid report compression: %s (valid values: none, gzip, zstd)", c.ReportCompression)
+	}
+
+	switch c.LockBackend {
+	case "", StorageLock, MongoLock, NoLock:
+	default:
+		return fmt.Errorf("invalid lock backend: %s (valid values: storage, mongo, none)", c.LockBackend)
+	}
+
+	if c.LockBackend != NoLock && c.LockTTL <= 0 {
+		return fmt.Errorf("lock TTL must be positive")
+	}
+
+	if c.ProfileWindow < 0 {
+		return fmt.Errorf("profile window must not be negative")
+	}
+
+	if c.WhatIfEnabled && (c.WhatIfSampleSize <= 0 || c.WhatIfDatabase == "") {
+		return fmt.Errorf("what-if analysis requires a positive sample size and a scratch database")
+	}
+
+	if c.ReportConcurrency < 1 {
+		return fmt.Errorf("report concurrency must be at least 1")
+	}
+
+	if c.CollectionTimeout < 0 {
+		return fmt.Errorf("collection timeout must not be negative")
+	}
+
+	if c.SchemaSampling && c.SchemaSampleSize <= 0 {
+		return fmt.Errorf("schema sampling requires a positive sample size")
+	}
+
+	switch c.KeyOrder {
+	case "off", "check", "reorder":
+	default:
+		return fmt.Errorf("invalid key order: %s (valid values: off, check, reorder)", c.KeyOrder)
+	}
+
+	if c.MaxReplicationLag < 0 || c.MinOplogWindow < 0 {
+		return fmt.Errorf("replication lag and oplog window thresholds must not be negative")
+	}
+
+	if c.DropObservation < 0 || c.DropThreshold < 0 {
+		return fmt.Errorf("drop observation and regression threshold must not be negative")
+	}
+
+	if c.BuildPollInterval < 0 || c.MaxBuildDuration < 0 {
+		return fmt.Errorf("build poll interval and maximum build duration must not be negative")
+	}
+
+	if c.LoadGuard {
+		if c.LoadMaxCPU < 0 || c.LoadMaxDirty < 0 || c.LoadMaxQueued < 0 || c.LoadMaxConnections < 0 {
+			return fmt.Errorf("load thresholds must not be negative")
+		}
+		if c.LoadPause <= 0 || c.LoadMaxWait < 0 {
+			return fmt.Errorf("load guard requires a positive pause and a maximum wait that is not negative")
+		}
+	}
+
+	if c.ProfileWindow > 0 && c.ProfileSlowMS < 0 {
+		return fmt.Errorf("profile slowms must not be negative")
+	}
+
 	return nil
 }
 
@@ -213,3 +414,14 @@ func parseBool(value string) bool {
 	}
 	return b
 }
+
+/*
+parseDuration converts a string to a time.Duration value.
+*/
+func parseDuration(value string) time.Duration {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 10 * time.Minute // Default value
+	}
+	return d
+}
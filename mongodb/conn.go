@@ -2,9 +2,14 @@ package mongodb
 
 import (
 	"context"
+	"fmt"
+	"sync"
 
+	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
 /*
@@ -14,6 +19,9 @@ It wraps the MongoDB client and provides convenience methods for database operat
 type Conn struct {
 	Client   *mongo.Client
 	database string
+	opts     *options.ClientOptions
+	members  map[string]*mongo.Client
+	mu       sync.Mutex
 }
 
 /*
@@ -40,6 +48,7 @@ func NewConn(
 	return &Conn{
 		Client:   client,
 		database: database,
+		opts:     clientOpts,
 	}, nil
 }
 
@@ -59,9 +68,205 @@ Close disconnects from MongoDB.
 It should be called when the connection is no longer needed.
 */
 func (conn *Conn) Close(ctx context.Context) error {
+	conn.mu.Lock()
+	for host, member := range conn.members {
+		member.Disconnect(ctx)
+		delete(conn.members, host)
+	}
+	conn.mu.Unlock()
+
 	return conn.Client.Disconnect(ctx)
 }
 
+/*
+Members returns the data-bearing members of the replica set the connection belongs
+to, or nil when it is not connected to a replica set. The members are read from the
+replica set config, since hello leaves out hidden members, such as analytics nodes.
+*/
+func (conn *Conn) Members(ctx context.Context) ([]string, error) {
+	var hello struct {
+		SetName string `bson:"setName"`
+	}
+
+	if err := conn.Client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return nil, fmt.Errorf("failed to get replica set members: %w", err)
+	}
+
+	if hello.SetName == "" {
+		return nil, nil
+	}
+
+	raw, err := conn.Client.Database("admin").RunCommand(ctx, bson.D{{Key: "replSetGetConfig", Value: 1}}).Raw()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get replica set config: %w", err)
+	}
+
+	return configMembers(raw)
+}
+
+// configMembers returns the hosts of the members of a replica set config, without arbiters
+func configMembers(raw bson.Raw) ([]string, error) {
+	var result struct {
+		Config struct {
+			Members []struct {
+				Host        string `bson:"host"`
+				ArbiterOnly bool   `bson:"arbiterOnly"`
+			} `bson:"members"`
+		} `bson:"config"`
+	}
+
+	if err := bson.Unmarshal(raw, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode replica set config: %w", err)
+	}
+
+	hosts := make([]string, 0, len(result.Config.Members))
+	for _, member := range result.Config.Members {
+		if !member.ArbiterOnly {
+			hosts = append(hosts, member.Host)
+		}
+	}
+
+	return hosts, nil
+}
+
+/*
+IsSharded reports whether the connection goes through mongos to a sharded cluster.
+*/
+func (conn *Conn) IsSharded(ctx context.Context) (bool, error) {
+	var hello struct {
+		Msg string `bson:"msg"`
+	}
+
+	if err := conn.Client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return false, fmt.Errorf("failed to detect sharding: %w", err)
+	}
+
+	return hello.Msg == "isdbgrid", nil
+}
+
+/*
+ShardPrimaries returns a client connected directly to the primary of every shard, keyed
+by shard name, when the connection goes through mongos. Server metrics read through
+mongos describe the router, not the shards behind it.
+*/
+func (conn *Conn) ShardPrimaries(ctx context.Context) (map[string]*mongo.Client, error) {
+	shards, err := metrics.ListShards(ctx, conn.Client)
+	if err != nil {
+		return nil, err
+	}
+
+	primaries := make(map[string]*mongo.Client, len(shards))
+	for _, shard := range shards {
+		primary, err := conn.shardPrimary(ctx, shard)
+		if err != nil {
+			return nil, fmt.Errorf("failed to reach the primary of shard %s: %w", shard.ID, err)
+		}
+		primaries[shard.ID] = primary
+	}
+
+	return primaries, nil
+}
+
+// shardPrimary connects to the primary of a shard, asking its members which one it is
+func (conn *Conn) shardPrimary(ctx context.Context, shard metrics.ShardInfo) (*mongo.Client, error) {
+	err := fmt.Errorf("shard has no hosts")
+	for _, host := range shard.Hosts() {
+		var member *mongo.Client
+		if member, err = conn.Member(ctx, host); err != nil {
+			continue
+		}
+
+		var hello struct {
+			Primary string `bson:"primary"`
+		}
+		if err = member.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+			continue
+		}
+		if hello.Primary == "" {
+			err = fmt.Errorf("%s knows no primary", host)
+			continue
+		}
+
+		return conn.Member(ctx, hello.Primary)
+	}
+
+	return nil, err
+}
+
+/*
+Member returns a client connected directly to one member of the replica set, with the
+same credentials and TLS settings as the connection. Clients are kept until Close.
+*/
+func (conn *Conn) Member(ctx context.Context, host string) (*mongo.Client, error) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	if member, ok := conn.members[host]; ok {
+		return member, nil
+	}
+
+	member, err := mongo.Connect(ctx, memberOptions(conn.opts, host))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to member %s: %w", host, err)
+	}
+
+	if conn.members == nil {
+		conn.members = make(map[string]*mongo.Client)
+	}
+	conn.members[host] = member
+	return member, nil
+}
+
+/*
+memberOptions builds the options of a direct connection to one host from those of the
+connection. They are copied field by field rather than from the URI, because the driver
+refuses a direct connection from a mongodb+srv URI, the usual form on managed clusters.
+*/
+func memberOptions(base *options.ClientOptions, host string) *options.ClientOptions {
+	clientOpts := options.Client().
+		SetHosts([]string{host}).
+		SetDirect(true).
+		SetReadPreference(readpref.Nearest())
+
+	if base == nil {
+		return clientOpts
+	}
+
+	if base.Auth != nil {
+		clientOpts.SetAuth(*base.Auth)
+	}
+	if base.TLSConfig != nil {
+		clientOpts.SetTLSConfig(base.TLSConfig)
+	}
+	if base.AppName != nil {
+		clientOpts.SetAppName(*base.AppName)
+	}
+	if base.Compressors != nil {
+		clientOpts.SetCompressors(base.Compressors)
+	}
+	if base.ConnectTimeout != nil {
+		clientOpts.SetConnectTimeout(*base.ConnectTimeout)
+	}
+	if base.ServerSelectionTimeout != nil {
+		clientOpts.SetServerSelectionTimeout(*base.ServerSelectionTimeout)
+	}
+	if base.ServerAPIOptions != nil {
+		clientOpts.SetServerAPIOptions(base.ServerAPIOptions)
+	}
+
+	return clientOpts
+}
+
+/*
+ListCollections returns the names of the collections of a database that hold data.
+Views are left out, they have no stats or indexes of their own.
+*/
+func (conn *Conn) ListCollections(ctx context.Context, dbName string) ([]string, error) {
+	return conn.Database(dbName).ListCollectionNames(ctx, bson.D{
+		{Key: "type", Value: bson.D{{Key: "$in", Value: bson.A{"collection", "timeseries"}}}},
+	})
+}
+
 /*
 ListDatabases returns a list of database names available on the MongoDB server.
 */
@@ -0,0 +1,255 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/theapemachine/lookatthatmongo/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// DefaultExplainTop is the number of query shapes explained by default
+const DefaultExplainTop = 5
+
+// explainableCommands are the read commands that are explained, writes are never sent
+var explainableCommands = map[string]bool{
+	"find":      true,
+	"aggregate": true,
+	"count":     true,
+	"distinct":  true,
+}
+
+// sessionFields are command fields added by drivers that explain does not accept
+var sessionFields = map[string]bool{
+	"lsid":             true,
+	"txnNumber":        true,
+	"autocommit":       true,
+	"startTransaction": true,
+	"readConcern":      true,
+	"writeConcern":     true,
+}
+
+/*
+QueryPlan is the plan evidence for a query shape: the winning plan chosen by the
+query planner and the execution statistics of running it.
+*/
+type QueryPlan struct {
+	Namespace     string        `json:"namespace"`
+	Pattern       string        `json:"pattern"`
+	WinningStage  string        `json:"winningStage"`          // the root stage of the winning plan
+	Stages        []string      `json:"stages"`                // all stages of the winning plan, root first
+	IndexesUsed   []string      `json:"indexesUsed,omitempty"` // indexes scanned by the winning plan
+	KeysExamined  int64         `json:"keysExamined"`          // total keys examined
+	DocsExamined  int64         `json:"docsExamined"`          // total documents examined
+	DocsReturned  int64         `json:"docsReturned"`          // documents returned
+	HasSortStage  bool          `json:"hasSortStage"`          // whether the plan sorts in memory
+	ExecutionTime time.Duration `json:"executionTime"`         // execution time reported by explain
+	Error         string        `json:"error,omitempty"`       // why the shape could not be explained
+}
+
+/*
+ExplainerOptionFn is a function type for configuring an Explainer.
+*/
+type ExplainerOptionFn func(*Explainer)
+
+/*
+Explainer runs explain with executionStats verbosity on representative commands of
+query shapes, to give suggestions real plan evidence.
+*/
+type Explainer struct {
+	client *mongo.Client
+	top    int
+}
+
+/*
+NewExplainer creates an explainer running commands through the given client.
+*/
+func NewExplainer(client *mongo.Client, opts ...ExplainerOptionFn) *Explainer {
+	explainer := &Explainer{
+		client: client,
+		top:    DefaultExplainTop,
+	}
+
+	for _, opt := range opts {
+		opt(explainer)
+	}
+
+	return explainer
+}
+
+/*
+WithExplainTop sets how many of the top query shapes are explained.
+*/
+func WithExplainTop(n int) ExplainerOptionFn {
+	return func(e *Explainer) {
+		e.top = n
+	}
+}
+
+/*
+Explain explains the representative command of each of the top query shapes.
+Shapes without an explainable read command are skipped. A shape that fails to
+explain is returned with its error, so one bad sample does not hide the others.
+*/
+func (e *Explainer) Explain(ctx context.Context, patterns []QueryPatternStats) []QueryPlan {
+	var plans []QueryPlan
+
+	for _, pattern := range patterns {
+		if e.top > 0 && len(plans) >= e.top {
+			break
+		}
+
+		command, ok := ExplainableCommand(pattern.SampleCommand)
+		if !ok {
+			continue
+		}
+
+		dbName, _, _ := strings.Cut(pattern.Namespace, ".")
+		plan, err := e.ExplainCommand(ctx, dbName, command)
+		if err != nil {
+			logger.Warn("Failed to explain query shape", "namespace", pattern.Namespace, "error", err)
+			plan = &QueryPlan{Error: err.Error()}
+		}
+
+		plan.Namespace = pattern.Namespace
+		plan.Pattern = pattern.Pattern
+		plans = append(plans, *plan)
+	}
+
+	return plans
+}
+
+/*
+ExplainCommand explains a single read command against a database.
+*/
+func (e *Explainer) ExplainCommand(ctx context.Context, dbName string, command bson.D) (*QueryPlan, error) {
+	var result bson.M
+	if err := e.client.Database(dbName).RunCommand(ctx, bson.D{
+		{Key: "explain", Value: command},
+		{Key: "verbosity", Value: "executionStats"},
+	}).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to explain command: %w", err)
+	}
+
+	return ParseExplain(result), nil
+}
+
+/*
+ExplainableCommand returns a copy of a captured command that can be sent to explain.
+It returns false for writes, for commands truncated in the log, and for anything
+that is not a read command.
+*/
+func ExplainableCommand(command bson.D) (bson.D, bool) {
+	if len(command) == 0 || !explainableCommands[command[0].Key] {
+		return nil, false
+	}
+
+	cleaned := make(bson.D, 0, len(command))
+	for _, elem := range command {
+		// Truncated log entries do not hold the full command
+		if elem.Key == "$truncated" {
+			return nil, false
+		}
+
+		// Driver fields such as $db, $clusterTime and lsid are not part of the query
+		if strings.HasPrefix(elem.Key, "$") || sessionFields[elem.Key] {
+			continue
+		}
+
+		cleaned = append(cleaned, elem)
+	}
+
+	return cleaned, true
+}
+
+/*
+ParseExplain extracts the winning plan and execution statistics from explain output.
+Aggregations that are not fully pushed down to the query layer report them in
+their first stage.
+*/
+func ParseExplain(result bson.M) *QueryPlan {
+	plan := &QueryPlan{}
+
+	planner, _ := result["queryPlanner"].(bson.M)
+	execution, _ := result["executionStats"].(bson.M)
+
+	if planner == nil {
+		if stages, ok := result["stages"].(bson.A); ok && len(stages) > 0 {
+			if first, ok := stages[0].(bson.M); ok {
+				if cursor, ok := first["$cursor"].(bson.M); ok {
+					planner, _ = cursor["queryPlanner"].(bson.M)
+					execution, _ = cursor["executionStats"].(bson.M)
+				}
+			}
+		}
+	}
+
+	if planner != nil {
+		winning, _ := planner["winningPlan"].(bson.M)
+		// Plans executed by the slot-based engine nest the classic plan tree
+		if nested, ok := winning["queryPlan"].(bson.M); ok {
+			winning = nested
+		}
+
+		plan.walk(winning)
+		if len(plan.Stages) > 0 {
+			plan.WinningStage = plan.Stages[0]
+		}
+	}
+
+	if execution != nil {
+		plan.KeysExamined = toInt64(execution["totalKeysExamined"])
+		plan.DocsExamined = toInt64(execution["totalDocsExamined"])
+		plan.DocsReturned = toInt64(execution["nReturned"])
+		plan.ExecutionTime = time.Duration(toInt64(execution["executionTimeMillis"])) * time.Millisecond
+	}
+
+	return plan
+}
+
+// walk records the stages and indexes of a plan tree, depth first
+func (p *QueryPlan) walk(stage bson.M) {
+	if stage == nil {
+		return
+	}
+
+	if name, ok := stage["stage"].(string); ok {
+		p.Stages = append(p.Stages, name)
+		if name == "SORT" {
+			p.HasSortStage = true
+		}
+	}
+
+	if index, ok := stage["indexName"].(string); ok {
+		p.IndexesUsed = append(p.IndexesUsed, index)
+	}
+
+	if input, ok := stage["inputStage"].(bson.M); ok {
+		p.walk(input)
+	}
+
+	if inputs, ok := stage["inputStages"].(bson.A); ok {
+		for _, input := range inputs {
+			if child, ok := input.(bson.M); ok {
+				p.walk(child)
+			}
+		}
+	}
+}
+
+// toInt64 converts a numeric BSON value to int64
+func toInt64(value any) int64 {
+	switch v := value.(type) {
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	default:
+		return 0
+	}
+}
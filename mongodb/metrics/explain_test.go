@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestExplainableCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		command  bson.D
+		expected bson.D
+		ok       bool
+	}{
+		{
+			name: "find with driver fields",
+			command: bson.D{
+				{Key: "find", Value: "users"},
+				{Key: "filter", Value: bson.D{{Key: "age", Value: 30}}},
+				{Key: "lsid", Value: bson.D{{Key: "id", Value: "x"}}},
+				{Key: "$db", Value: "app"},
+				{Key: "$clusterTime", Value: bson.D{}},
+			},
+			expected: bson.D{
+				{Key: "find", Value: "users"},
+				{Key: "filter", Value: bson.D{{Key: "age", Value: 30}}},
+			},
+			ok: true,
+		},
+		{
+			name:    "update statement",
+			command: bson.D{{Key: "q", Value: bson.D{}}, {Key: "u", Value: bson.D{}}},
+			ok:      false,
+		},
+		{
+			name:    "write command",
+			command: bson.D{{Key: "delete", Value: "users"}},
+			ok:      false,
+		},
+		{
+			name:    "truncated command",
+			command: bson.D{{Key: "find", Value: "users"}, {Key: "$truncated", Value: "..."}},
+			ok:      false,
+		},
+		{
+			name: "empty command",
+			ok:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			command, ok := ExplainableCommand(tt.command)
+			assert.Equal(t, tt.ok, ok)
+			if tt.ok {
+				assert.Equal(t, tt.expected, command)
+			}
+		})
+	}
+}
+
+func TestParseExplain(t *testing.T) {
+	tests := []struct {
+		name     string
+		result   bson.M
+		expected QueryPlan
+	}{
+		{
+			name: "find with index and sort",
+			result: bson.M{
+				"queryPlanner": bson.M{
+					"winningPlan": bson.M{
+						"stage": "SORT",
+						"inputStage": bson.M{
+							"stage": "FETCH",
+							"inputStage": bson.M{
+								"stage":     "IXSCAN",
+								"indexName": "age_1",
+							},
+						},
+					},
+				},
+				"executionStats": bson.M{
+					"nReturned":           int32(10),
+					"totalKeysExamined":   int32(12),
+					"totalDocsExamined":   int64(12),
+					"executionTimeMillis": int32(3),
+				},
+			},
+			expected: QueryPlan{
+				WinningStage:  "SORT",
+				Stages:        []string{"SORT", "FETCH", "IXSCAN"},
+				IndexesUsed:   []string{"age_1"},
+				KeysExamined:  12,
+				DocsExamined:  12,
+				DocsReturned:  10,
+				HasSortStage:  true,
+				ExecutionTime: 3 * time.Millisecond,
+			},
+		},
+		{
+			name: "slot-based engine plan",
+			result: bson.M{
+				"queryPlanner": bson.M{
+					"winningPlan": bson.M{
+						"queryPlan": bson.M{"stage": "COLLSCAN"},
+					},
+				},
+				"executionStats": bson.M{"totalDocsExamined": int32(500)},
+			},
+			expected: QueryPlan{
+				WinningStage: "COLLSCAN",
+				Stages:       []string{"COLLSCAN"},
+				DocsExamined: 500,
+			},
+		},
+		{
+			name: "aggregation with cursor stage",
+			result: bson.M{
+				"stages": bson.A{
+					bson.M{"$cursor": bson.M{
+						"queryPlanner": bson.M{
+							"winningPlan": bson.M{
+								"stage": "OR",
+								"inputStages": bson.A{
+									bson.M{"stage": "IXSCAN", "indexName": "a_1"},
+									bson.M{"stage": "IXSCAN", "indexName": "b_1"},
+								},
+							},
+						},
+						"executionStats": bson.M{"totalKeysExamined": int32(40)},
+					}},
+					bson.M{"$group": bson.M{}},
+				},
+			},
+			expected: QueryPlan{
+				WinningStage: "OR",
+				Stages:       []string{"OR", "IXSCAN", "IXSCAN"},
+				IndexesUsed:  []string{"a_1", "b_1"},
+				KeysExamined: 40,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, *ParseExplain(tt.result))
+		})
+	}
+}
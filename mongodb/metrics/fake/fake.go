@@ -0,0 +1,243 @@
+/*
+Package fake provides metrics monitors that need no MongoDB server: an in-memory
+Monitor holding the metrics it serves, and fixtures that serve a recorded report.
+They let report collection, prompts and the tracker be tested without a database.
+*/
+package fake
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
+)
+
+/*
+Monitor is an in-memory metrics.Monitor. It implements every optional collector
+interface, serving whatever has been set on it. Errors can be injected per method,
+and optionally per collection.
+*/
+type Monitor struct {
+	Server        *metrics.ServerStats
+	Databases     map[string]*metrics.DatabaseStats
+	Collections   map[string]map[string]*metrics.CollectionStats // by database, then collection
+	Indexes       map[string]map[string][]metrics.IndexStats     // by database, then collection
+	QueryPatterns map[string][]metrics.QueryPatternStats         // by database
+	Plans         []metrics.QueryPlan
+	Performance   *metrics.PerformanceStats
+	Replication   *metrics.RepStats
+	Sharding      map[string]*metrics.ShardingStats               // by database
+	Schemas       map[string]map[string]*metrics.CollectionSchema // by database, then collection
+
+	mu     sync.Mutex
+	errors map[string]error
+	calls  []string
+}
+
+/*
+NewMonitor creates an empty in-memory monitor.
+*/
+func NewMonitor() *Monitor {
+	return &Monitor{
+		Server:        &metrics.ServerStats{},
+		Databases:     make(map[string]*metrics.DatabaseStats),
+		Collections:   make(map[string]map[string]*metrics.CollectionStats),
+		Indexes:       make(map[string]map[string][]metrics.IndexStats),
+		QueryPatterns: make(map[string][]metrics.QueryPatternStats),
+		Sharding:      make(map[string]*metrics.ShardingStats),
+		Schemas:       make(map[string]map[string]*metrics.CollectionSchema),
+		errors:        make(map[string]error),
+	}
+}
+
+/*
+AddCollection adds a collection and its indexes to a database, creating the database
+stats when missing.
+*/
+func (m *Monitor) AddCollection(dbName, collName string, stats *metrics.CollectionStats, indexes ...metrics.IndexStats) {
+	if _, ok := m.Databases[dbName]; !ok {
+		m.Databases[dbName] = &metrics.DatabaseStats{Name: dbName}
+	}
+	if m.Collections[dbName] == nil {
+		m.Collections[dbName] = make(map[string]*metrics.CollectionStats)
+		m.Indexes[dbName] = make(map[string][]metrics.IndexStats)
+	}
+
+	m.Collections[dbName][collName] = stats
+	m.Indexes[dbName][collName] = indexes
+}
+
+/*
+Fail makes a method return an error. With a collection name, only calls for that
+collection fail.
+*/
+func (m *Monitor) Fail(method, collName string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors[method+"/"+collName] = err
+}
+
+/*
+Calls returns the methods called so far, in order.
+*/
+func (m *Monitor) Calls() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.calls...)
+}
+
+/*
+ListCollections returns a function listing the collections of a database, as
+expected by Report.Collect.
+*/
+func (m *Monitor) ListCollections(dbName string) func() ([]string, error) {
+	return func() ([]string, error) {
+		names := make([]string, 0, len(m.Collections[dbName]))
+		for name := range m.Collections[dbName] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names, nil
+	}
+}
+
+// call records a call and returns the error to fail it with, if any
+func (m *Monitor) call(ctx context.Context, method, collName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.calls = append(m.calls, method)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err, ok := m.errors[method+"/"+collName]; ok {
+		return err
+	}
+	return m.errors[method+"/"]
+}
+
+/*
+GetServerStats returns a copy of the server stats.
+*/
+func (m *Monitor) GetServerStats(ctx context.Context) (*metrics.ServerStats, error) {
+	if err := m.call(ctx, "GetServerStats", ""); err != nil {
+		return nil, err
+	}
+
+	// Reports set the replication status on the stats they are given
+	server := *m.Server
+	return &server, nil
+}
+
+/*
+GetDatabaseStats returns the stats of a database added to the monitor.
+*/
+func (m *Monitor) GetDatabaseStats(ctx context.Context, dbName string) (*metrics.DatabaseStats, error) {
+	if err := m.call(ctx, "GetDatabaseStats", ""); err != nil {
+		return nil, err
+	}
+
+	stats, ok := m.Databases[dbName]
+	if !ok {
+		return nil, fmt.Errorf("no stats for database %s", dbName)
+	}
+	return stats, nil
+}
+
+/*
+GetCollectionStats returns the stats of a collection added to the monitor.
+*/
+func (m *Monitor) GetCollectionStats(ctx context.Context, dbName, collName string) (*metrics.CollectionStats, error) {
+	if err := m.call(ctx, "GetCollectionStats", collName); err != nil {
+		return nil, err
+	}
+
+	stats, ok := m.Collections[dbName][collName]
+	if !ok {
+		return nil, fmt.Errorf("no stats for collection %s.%s", dbName, collName)
+	}
+	return stats, nil
+}
+
+/*
+GetIndexStats returns a copy of the indexes of a collection added to the monitor.
+*/
+func (m *Monitor) GetIndexStats(ctx context.Context, dbName, collName string) ([]metrics.IndexStats, error) {
+	if err := m.call(ctx, "GetIndexStats", collName); err != nil {
+		return nil, err
+	}
+
+	indexes, ok := m.Indexes[dbName][collName]
+	if !ok {
+		return nil, fmt.Errorf("no indexes for collection %s.%s", dbName, collName)
+	}
+	return append([]metrics.IndexStats(nil), indexes...), nil
+}
+
+/*
+GetQueryPatterns returns the query shapes of a database.
+*/
+func (m *Monitor) GetQueryPatterns(ctx context.Context, dbName string) ([]metrics.QueryPatternStats, error) {
+	if err := m.call(ctx, "GetQueryPatterns", ""); err != nil {
+		return nil, err
+	}
+	return m.QueryPatterns[dbName], nil
+}
+
+/*
+ExplainQueryPatterns returns the plans set on the monitor, whatever the patterns.
+*/
+func (m *Monitor) ExplainQueryPatterns(ctx context.Context, patterns []metrics.QueryPatternStats) ([]metrics.QueryPlan, error) {
+	if err := m.call(ctx, "ExplainQueryPatterns", ""); err != nil {
+		return nil, err
+	}
+	return m.Plans, nil
+}
+
+/*
+GetPerformanceStats returns a copy of the performance stats set on the monitor.
+*/
+func (m *Monitor) GetPerformanceStats(ctx context.Context) (*metrics.PerformanceStats, error) {
+	if err := m.call(ctx, "GetPerformanceStats", ""); err != nil {
+		return nil, err
+	}
+	if m.Performance == nil {
+		return nil, nil
+	}
+	performance := *m.Performance
+	return &performance, nil
+}
+
+/*
+GetReplicationStatus returns the replication status set on the monitor, nil for a
+standalone server.
+*/
+func (m *Monitor) GetReplicationStatus(ctx context.Context) (*metrics.RepStats, error) {
+	if err := m.call(ctx, "GetReplicationStatus", ""); err != nil {
+		return nil, err
+	}
+	return m.Replication, nil
+}
+
+/*
+GetShardingStats returns the sharding stats of a database, nil when not sharded.
+*/
+func (m *Monitor) GetShardingStats(ctx context.Context, dbName string) (*metrics.ShardingStats, error) {
+	if err := m.call(ctx, "GetShardingStats", ""); err != nil {
+		return nil, err
+	}
+	return m.Sharding[dbName], nil
+}
+
+/*
+GetCollectionSchema returns the schema set for a collection, nil when none is.
+*/
+func (m *Monitor) GetCollectionSchema(ctx context.Context, dbName, collName string) (*metrics.CollectionSchema, error) {
+	if err := m.call(ctx, "GetCollectionSchema", collName); err != nil {
+		return nil, err
+	}
+	return m.Schemas[dbName][collName], nil
+}
@@ -0,0 +1,127 @@
+package fake
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
+)
+
+func TestMonitorCollect(t *testing.T) {
+	monitor := NewMonitor()
+	monitor.Server.Host = "fake:27017"
+	monitor.AddCollection("app", "users", &metrics.CollectionStats{Count: 10}, metrics.IndexStats{Name: "_id_"})
+	monitor.AddCollection("app", "orders", &metrics.CollectionStats{Count: 20}, metrics.IndexStats{Name: "_id_"})
+	monitor.Fail("GetIndexStats", "orders", errors.New("not authorized"))
+	monitor.Fail("GetPerformanceStats", "", errors.New("serverStatus not allowed"))
+
+	report := metrics.NewReport(monitor)
+	require.NoError(t, report.Collect(context.Background(), "app", monitor.ListCollections("app")))
+
+	assert.Equal(t, "fake:27017", report.ServerStats.Host)
+	assert.Equal(t, int64(10), report.Collections["users"][0].Count)
+	assert.Len(t, report.Indexes["users"], 1)
+	assert.NotContains(t, report.Indexes, "orders")
+
+	require.Len(t, report.Errors, 2)
+	assert.Equal(t, metrics.ReportError{Stage: "performance", Error: "serverStatus not allowed"}, report.Errors[0])
+	assert.Equal(t, metrics.ReportError{Collection: "orders", Stage: "indexStats", Error: "not authorized"}, report.Errors[1])
+
+	assert.Contains(t, monitor.Calls(), "GetReplicationStatus")
+}
+
+func TestMonitorIndexUtilization(t *testing.T) {
+	monitor := NewMonitor()
+	monitor.Performance = &metrics.PerformanceStats{}
+	monitor.AddCollection("app", "users", &metrics.CollectionStats{Count: 10},
+		metrics.IndexStats{Name: "_id_", UseCount: 5},
+		metrics.IndexStats{Name: "email_1", Unique: true, Hosts: []metrics.IndexHostUsage{
+			{Host: "a:27017", Ops: 3},
+			{Host: "b:27017", Ops: 4},
+		}},
+	)
+	monitor.AddCollection("app", "orders", &metrics.CollectionStats{Count: 20}, metrics.IndexStats{Name: "_id_"})
+	monitor.AddCollection("other", "events", &metrics.CollectionStats{Count: 30}, metrics.IndexStats{Name: "_id_"})
+	monitor.Fail("GetIndexStats", "orders", errors.New("not authorized"))
+
+	report := metrics.NewReport(monitor)
+	require.NoError(t, report.Collect(context.Background(), "app", monitor.ListCollections("app")))
+
+	require.NotNil(t, report.Performance)
+	assert.Equal(t, []metrics.IndexUtilizationStat{
+		{DatabaseName: "app", CollectionName: "users", IndexName: "_id_", UsageCount: 5},
+		{DatabaseName: "app", CollectionName: "users", IndexName: "email_1", Host: "a:27017", UsageCount: 3, IsUnique: true},
+		{DatabaseName: "app", CollectionName: "users", IndexName: "email_1", Host: "b:27017", UsageCount: 4, IsUnique: true},
+	}, report.Performance.IndexUtilization)
+	assert.Equal(t, []metrics.ReportError{{Collection: "orders", Stage: "indexStats", Error: "not authorized"}}, report.Errors)
+	assert.Empty(t, monitor.Performance.IndexUtilization)
+}
+
+func TestMonitorSchemas(t *testing.T) {
+	monitor := NewMonitor()
+	monitor.AddCollection("app", "users", &metrics.CollectionStats{Count: 10})
+	monitor.AddCollection("app", "orders", &metrics.CollectionStats{Count: 20})
+	monitor.Schemas["app"] = map[string]*metrics.CollectionSchema{
+		"users": {SampleSize: 10, Fields: []metrics.FieldSchema{{Path: "email", Presence: 1}}},
+	}
+	monitor.Fail("GetCollectionSchema", "orders", errors.New("$sample not allowed"))
+
+	report := metrics.NewReport(monitor)
+	require.NoError(t, report.Collect(context.Background(), "app", monitor.ListCollections("app")))
+
+	assert.Equal(t, "email", report.Schemas["users"].Fields[0].Path)
+	assert.NotContains(t, report.Schemas, "orders")
+	assert.Contains(t, report.Collections, "orders")
+	assert.Equal(t, []metrics.ReportError{{Collection: "orders", Stage: "schema", Error: "$sample not allowed"}}, report.Errors)
+}
+
+func TestMonitorCancelled(t *testing.T) {
+	monitor := NewMonitor()
+	monitor.AddCollection("app", "users", &metrics.CollectionStats{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := monitor.GetCollectionStats(ctx, "app", "users")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestLoadFixture(t *testing.T) {
+	monitor, err := LoadFixture("testdata/report.json")
+	require.NoError(t, err)
+
+	report := metrics.NewReport(monitor)
+	require.NoError(t, report.Collect(context.Background(), "shop", monitor.ListCollections("shop")))
+
+	assert.Equal(t, "7.0.12", report.ServerStats.Version)
+	assert.Equal(t, 2*time.Second, report.ServerStats.ReplicationStatus.MaxLag)
+	assert.Equal(t, float64(1048576), report.DatabaseStats["shop"].DataSize)
+	assert.Equal(t, int64(1500), report.Collections["orders"][0].Count)
+	assert.Equal(t, int64(0), report.Indexes["orders"][1].UseCount)
+	require.Len(t, report.QueryPatterns, 1)
+	assert.Equal(t, "shop.orders", report.QueryPatterns[0].Namespace)
+	assert.Empty(t, report.Errors)
+}
+
+func TestFixtureRoundTrip(t *testing.T) {
+	original := NewMonitor()
+	original.AddCollection("app", "users", &metrics.CollectionStats{Count: 3, Size: 300}, metrics.IndexStats{Name: "_id_", UseCount: 7})
+
+	recorded := metrics.NewReport(original)
+	require.NoError(t, recorded.Collect(context.Background(), "app", original.ListCollections("app")))
+
+	replayed, err := ReadFixture(strings.NewReader(recorded.String()))
+	require.NoError(t, err)
+
+	report := metrics.NewReport(replayed)
+	require.NoError(t, report.Collect(context.Background(), "app", replayed.ListCollections("app")))
+
+	assert.Equal(t, recorded.Collections, report.Collections)
+	assert.Equal(t, recorded.Indexes, report.Indexes)
+	assert.Equal(t, recorded.DatabaseStats, report.DatabaseStats)
+}
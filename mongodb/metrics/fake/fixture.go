@@ -0,0 +1,78 @@
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
+)
+
+/*
+LoadFixture reads a report recorded as JSON, such as the output of Report.String or
+a report stored with an optimization record, and returns a monitor serving it.
+*/
+func LoadFixture(path string) (*Monitor, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open fixture: %w", err)
+	}
+	defer file.Close()
+
+	return ReadFixture(file)
+}
+
+/*
+ReadFixture reads a report recorded as JSON and returns a monitor serving it.
+*/
+func ReadFixture(r io.Reader) (*Monitor, error) {
+	var report metrics.Report
+	if err := json.NewDecoder(r).Decode(&report); err != nil {
+		return nil, fmt.Errorf("failed to decode fixture: %w", err)
+	}
+
+	return FromReport(&report), nil
+}
+
+/*
+FromReport returns a monitor serving the metrics of a report, so collecting a new
+report from it reproduces the original. A report covers a single database, so its
+collections are served for every database in its database stats.
+*/
+func FromReport(report *metrics.Report) *Monitor {
+	m := NewMonitor()
+
+	if report.ServerStats != nil {
+		m.Server = report.ServerStats
+		if report.ServerStats.ReplicationStatus.IsReplicaSet {
+			replication := report.ServerStats.ReplicationStatus
+			m.Replication = &replication
+		}
+	}
+
+	m.Plans = report.Plans
+	m.Performance = report.Performance
+
+	for dbName, dbStats := range report.DatabaseStats {
+		m.Databases[dbName] = dbStats
+		m.QueryPatterns[dbName] = report.QueryPatterns
+		m.Sharding[dbName] = report.Sharding
+		m.Schemas[dbName] = report.Schemas
+
+		for collName, collStats := range report.Collections {
+			if len(collStats) == 0 {
+				continue
+			}
+
+			var indexes []metrics.IndexStats
+			for _, index := range report.Indexes[collName] {
+				indexes = append(indexes, *index)
+			}
+
+			m.AddCollection(dbName, collName, collStats[0], indexes...)
+		}
+	}
+
+	return m
+}
@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+IndexHostUsage is the usage of an index on one host, as reported by $indexStats.
+Counters are kept in memory by each mongod, so they start over at Since when the
+host restarts or the index is rebuilt.
+*/
+type IndexHostUsage struct {
+	Shard string    `json:"shard,omitempty" bson:"shard,omitempty"`
+	Host  string    `json:"host" bson:"host"`
+	Ops   int64     `json:"ops" bson:"ops"`
+	Since time.Time `json:"since" bson:"since"`
+}
+
+// indexStatsEntry is a document returned by the $indexStats stage
+type indexStatsEntry struct {
+	Name     string `bson:"name"`
+	Shard    string `bson:"shard"`
+	Host     string `bson:"host"`
+	Accesses struct {
+		Ops   int64     `bson:"ops"`
+		Since time.Time `bson:"since"`
+	} `bson:"accesses"`
+	Spec     bson.M `bson:"spec"`
+	Building bool   `bson:"building"`
+}
+
+/*
+ReadIndexUsage runs $indexStats for a collection on the host the client is connected
+to, and returns the usage of each index keyed by index name. Through mongos, there
+is an entry for one member of every shard owning the collection. Indexes that are
+still being built are left out.
+*/
+func ReadIndexUsage(ctx context.Context, client *mongo.Client, dbName, collName string) (map[string][]IndexHostUsage, error) {
+	cursor, err := client.Database(dbName).Collection(collName).Aggregate(ctx, bson.A{
+		bson.D{{Key: "$indexStats", Value: bson.D{}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index usage: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	usage := make(map[string][]IndexHostUsage)
+	for cursor.Next(ctx) {
+		var entry indexStatsEntry
+		if err := cursor.Decode(&entry); err != nil {
+			return nil, fmt.Errorf("failed to decode index usage: %w", err)
+		}
+
+		if entry.Building {
+			continue
+		}
+
+		usage[entry.Name] = append(usage[entry.Name], IndexHostUsage{
+			Shard: entry.Shard,
+			Host:  entry.Host,
+			Ops:   entry.Accesses.Ops,
+			Since: entry.Accesses.Since,
+		})
+	}
+
+	return usage, cursor.Err()
+}
+
+/*
+MergeIndexUsage sets the usage of an index from its usage on every host. The use
+count is the total across hosts, and the observation window starts at the most
+recent Since, because an index only counts as unused if no host has used it since.
+*/
+func MergeIndexUsage(stats *IndexStats, hosts []IndexHostUsage, now time.Time) {
+	sort.Slice(hosts, func(i, j int) bool {
+		if hosts[i].Shard != hosts[j].Shard {
+			return hosts[i].Shard < hosts[j].Shard
+		}
+		return hosts[i].Host < hosts[j].Host
+	})
+
+	stats.Hosts = hosts
+	stats.UseCount = 0
+	stats.Since = time.Time{}
+
+	for _, host := range hosts {
+		stats.UseCount += host.Ops
+		if host.Since.After(stats.Since) {
+			stats.Since = host.Since
+		}
+	}
+
+	if !stats.Since.IsZero() {
+		stats.ObservedFor = now.Sub(stats.Since)
+	}
+}
+
+/*
+IndexShards returns the shards on which an index exists, from $indexStats run
+through mongos, which reports the index once for every shard owning the collection.
+*/
+func IndexShards(ctx context.Context, client *mongo.Client, dbName, collName, indexName string) ([]string, error) {
+	usage, err := ReadIndexUsage(ctx, client, dbName, collName)
+	if err != nil {
+		return nil, err
+	}
+
+	var shards []string
+	for _, host := range usage[indexName] {
+		shards = append(shards, host.Shard)
+	}
+	sort.Strings(shards)
+
+	return shards, nil
+}
+
+/*
+IsMultiKey reports whether an index is multikey, i.e. indexes an array field, from
+the query plan of a scan hinted to use it. The query is only planned, not executed.
+*/
+func IsMultiKey(ctx context.Context, client *mongo.Client, dbName, collName, indexName string) (bool, error) {
+	var result bson.M
+	if err := client.Database(dbName).RunCommand(ctx, bson.D{
+		{Key: "explain", Value: bson.D{
+			{Key: "find", Value: collName},
+			{Key: "filter", Value: bson.D{}},
+			{Key: "hint", Value: indexName},
+			{Key: "limit", Value: 1},
+		}},
+		{Key: "verbosity", Value: "queryPlanner"},
+	}).Decode(&result); err != nil {
+		return false, fmt.Errorf("failed to explain index scan: %w", err)
+	}
+
+	planner, _ := result["queryPlanner"].(bson.M)
+	winning, _ := planner["winningPlan"].(bson.M)
+	if nested, ok := winning["queryPlan"].(bson.M); ok {
+		winning = nested
+	}
+
+	return findMultiKey(winning), nil
+}
+
+// findMultiKey looks for the isMultiKey flag of an index scan in a plan tree
+func findMultiKey(stage bson.M) bool {
+	for stage != nil {
+		if multiKey, ok := stage["isMultiKey"].(bool); ok {
+			return multiKey
+		}
+		stage, _ = stage["inputStage"].(bson.M)
+	}
+	return false
+}
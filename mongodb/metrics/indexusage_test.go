@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMergeIndexUsage(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	stats := &IndexStats{Name: "age_1"}
+	MergeIndexUsage(stats, []IndexHostUsage{
+		{Host: "db2:27017", Ops: 0, Since: now.Add(-2 * time.Hour)},
+		{Host: "db1:27017", Ops: 120, Since: now.Add(-30 * 24 * time.Hour)},
+	}, now)
+
+	assert.Equal(t, int64(120), stats.UseCount)
+	assert.Equal(t, now.Add(-2*time.Hour), stats.Since)
+	assert.Equal(t, 2*time.Hour, stats.ObservedFor)
+	assert.Equal(t, "db1:27017", stats.Hosts[0].Host)
+
+	sharded := &IndexStats{Name: "age_1"}
+	MergeIndexUsage(sharded, []IndexHostUsage{
+		{Shard: "shard1", Host: "a:27018", Ops: 3},
+		{Shard: "shard0", Host: "b:27018", Ops: 4},
+	}, now)
+	assert.Equal(t, int64(7), sharded.UseCount)
+	assert.Equal(t, "shard0", sharded.Hosts[0].Shard)
+
+	empty := &IndexStats{Name: "age_1", UseCount: 5}
+	MergeIndexUsage(empty, nil, now)
+	assert.Equal(t, int64(0), empty.UseCount)
+	assert.True(t, empty.Since.IsZero())
+	assert.Equal(t, time.Duration(0), empty.ObservedFor)
+}
+
+func TestFindMultiKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		plan     bson.M
+		expected bool
+	}{
+		{
+			name: "multikey index scan under fetch",
+			plan: bson.M{
+				"stage":      "LIMIT",
+				"inputStage": bson.M{"stage": "FETCH", "inputStage": bson.M{"stage": "IXSCAN", "isMultiKey": true}},
+			},
+			expected: true,
+		},
+		{
+			name:     "plain index scan",
+			plan:     bson.M{"stage": "IXSCAN", "isMultiKey": false},
+			expected: false,
+		},
+		{
+			name:     "no plan",
+			plan:     nil,
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, findMultiKey(tt.plan))
+		})
+	}
+}
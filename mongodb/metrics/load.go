@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+ServerLoad is a point-in-time sample of how busy a server is, read before and during
+changes so they do not land on top of a traffic peak. CPU usage is the share of the
+host's cores used by the mongod process since the previous sample, and is only known
+from the second sample on.
+*/
+type ServerLoad struct {
+	At             time.Time     `json:"at"`
+	CPUPercent     float64       `json:"cpuPercent"`
+	HasCPU         bool          `json:"hasCpu"`
+	CPUTimeMicros  int64         `json:"cpuTimeMicros"` // user and system time of the process
+	NumCores       int64         `json:"numCores"`
+	DirtyPercent   float64       `json:"dirtyPercent"` // WiredTiger dirty bytes / configured cache size
+	QueuedReaders  int64         `json:"queuedReaders"`
+	QueuedWriters  int64         `json:"queuedWriters"`
+	Connections    int64         `json:"connections"`
+	ReplicationLag time.Duration `json:"replicationLag"` // largest lag of a secondary, 0 outside a replica set
+}
+
+/*
+ReadServerLoad samples the load of the server from serverStatus and replSetGetStatus.
+The previous sample, if any, is used to compute CPU usage. Through mongos, the sample
+describes the router, so shards are sampled over direct connections to their members.
+*/
+func ReadServerLoad(ctx context.Context, client *mongo.Client, previous *ServerLoad) (*ServerLoad, error) {
+	var numCores int64
+	if previous != nil {
+		numCores = previous.NumCores
+	} else {
+		var hostInfo struct {
+			System struct {
+				NumCores int64 `bson:"numCores"`
+			} `bson:"system"`
+		}
+		// hostInfo needs a privilege monitoring roles do not always have, CPU usage is then unknown
+		if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hostInfo", Value: 1}}).Decode(&hostInfo); err == nil {
+			numCores = hostInfo.System.NumCores
+		}
+	}
+
+	raw, err := client.Database("admin").RunCommand(ctx, bson.D{
+		{Key: "serverStatus", Value: 1},
+	}).Raw()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get server status: %w", err)
+	}
+
+	load, err := DecodeServerLoad(raw, numCores, previous, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	replication, err := ReadReplicationStatus(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	if replication != nil {
+		load.ReplicationLag = replication.MaxLag
+	}
+
+	return load, nil
+}
+
+/*
+DecodeServerLoad computes a load sample from the output of serverStatus. CPU usage is
+the process time used since the previous sample over the wall time and cores available.
+*/
+func DecodeServerLoad(raw bson.Raw, numCores int64, previous *ServerLoad, now time.Time) (*ServerLoad, error) {
+	status, err := DecodeServerStatus(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var extra struct {
+		ExtraInfo struct {
+			UserTimeMicros   int64 `bson:"user_time_us"`
+			SystemTimeMicros int64 `bson:"system_time_us"`
+		} `bson:"extra_info"`
+	}
+	if err := bson.Unmarshal(raw, &extra); err != nil {
+		return nil, fmt.Errorf("failed to decode process times: %w", err)
+	}
+
+	load := &ServerLoad{
+		At:            now,
+		CPUTimeMicros: extra.ExtraInfo.UserTimeMicros + extra.ExtraInfo.SystemTimeMicros,
+		NumCores:      numCores,
+		Connections:   status.Connections.Current,
+	}
+
+	if status.WiredTiger != nil {
+		load.DirtyPercent = status.WiredTiger.Cache.DirtyRatio * 100
+	}
+
+	if status.GlobalLock != nil {
+		load.QueuedReaders = status.GlobalLock.CurrentQueue.Readers
+		load.QueuedWriters = status.GlobalLock.CurrentQueue.Writers
+	}
+
+	if previous != nil && numCores > 0 && load.CPUTimeMicros > 0 && now.After(previous.At) {
+		elapsed := now.Sub(previous.At).Microseconds()
+		used := load.CPUTimeMicros - previous.CPUTimeMicros
+		if elapsed > 0 && used >= 0 {
+			load.CPUPercent = float64(used) / float64(elapsed*numCores) * 100
+			load.HasCPU = true
+		}
+	}
+
+	return load, nil
+}
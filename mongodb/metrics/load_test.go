@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func serverStatusLoad(cpuTimeMicros int64) bson.D {
+	return bson.D{
+		{Key: "connections", Value: bson.D{{Key: "current", Value: int32(120)}}},
+		{Key: "globalLock", Value: bson.D{{Key: "currentQueue", Value: bson.D{
+			{Key: "readers", Value: int32(3)},
+			{Key: "writers", Value: int32(7)},
+		}}}},
+		{Key: "extra_info", Value: bson.D{
+			{Key: "user_time_us", Value: cpuTimeMicros * 3 / 4},
+			{Key: "system_time_us", Value: cpuTimeMicros / 4},
+		}},
+		{Key: "wiredTiger", Value: wiredTigerSection(false)},
+	}
+}
+
+func TestDecodeServerLoad(t *testing.T) {
+	now := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	raw, err := bson.Marshal(serverStatusLoad(8_000_000))
+	require.NoError(t, err)
+
+	first, err := DecodeServerLoad(raw, 4, nil, now)
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(120), first.Connections)
+	assert.Equal(t, int64(3), first.QueuedReaders)
+	assert.Equal(t, int64(7), first.QueuedWriters)
+	assert.InDelta(t, 10.0, first.DirtyPercent, 0.001)
+	assert.False(t, first.HasCPU, "CPU usage needs a previous sample")
+
+	// 2 seconds of process time over 1 second on 4 cores
+	raw, err = bson.Marshal(serverStatusLoad(10_000_000))
+	require.NoError(t, err)
+
+	second, err := DecodeServerLoad(raw, 4, first, now.Add(time.Second))
+	require.NoError(t, err)
+
+	assert.True(t, second.HasCPU)
+	assert.InDelta(t, 50.0, second.CPUPercent, 0.001)
+}
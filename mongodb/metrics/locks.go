@@ -0,0 +1,192 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// contendedResources are the lock resources whose waits are reported
+var contendedResources = []string{"Global", "Database", "Collection"}
+
+/*
+collectLockStats reads the lock, queue and flow control sections of serverStatus,
+computes wait rates since the previous collection, and looks for index builds that
+hold up other operations.
+*/
+func (pm *PerformanceMonitor) collectLockStats(ctx context.Context, stats *PerformanceStats) error {
+	raw, err := pm.client.Database("admin").RunCommand(ctx, bson.D{
+		{Key: "serverStatus", Value: 1},
+	}).Raw()
+	if err != nil {
+		return fmt.Errorf("failed to get lock stats: %w", err)
+	}
+
+	status, err := DecodeServerStatus(raw)
+	if err != nil {
+		return err
+	}
+
+	stats.Locks = pm.lockContention(status, time.Now())
+
+	var result bson.M
+	if err := pm.runCommand(ctx, "admin", bson.D{
+		{Key: "currentOp", Value: true},
+		{Key: "$all", Value: true},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "waitingForLock", Value: true}},
+			bson.D{{Key: "command.createIndexes", Value: bson.D{{Key: "$exists", Value: true}}}},
+			bson.D{{Key: "desc", Value: bson.D{{Key: "$regex", Value: "^IndexBuildsCoordinator"}}}},
+		}},
+	}, &result); err != nil {
+		return err
+	}
+
+	inprog, _ := result["inprog"].(bson.A)
+	ops := make([]bson.M, 0, len(inprog))
+	for _, op := range inprog {
+		if opMap, ok := op.(bson.M); ok {
+			ops = append(ops, opMap)
+		}
+	}
+	stats.Locks.IndexBuilds = DetectIndexBuilds(ops)
+
+	return nil
+}
+
+// lockContention computes the lock queues and wait rates from serverStatus
+func (pm *PerformanceMonitor) lockContention(status *ServerStats, now time.Time) LockContentionStats {
+	contention := LockContentionStats{
+		WaitsPerSecond:      make(map[string]float64),
+		WaitMicrosPerSecond: make(map[string]float64),
+	}
+
+	if status.GlobalLock != nil {
+		contention.QueuedReaders = status.GlobalLock.CurrentQueue.Readers
+		contention.QueuedWriters = status.GlobalLock.CurrentQueue.Writers
+		contention.ActiveReaders = status.GlobalLock.ActiveClients.Readers
+		contention.ActiveWriters = status.GlobalLock.ActiveClients.Writers
+	}
+
+	counts := make(map[string]int64)
+
+	for _, resource := range contendedResources {
+		lock, ok := status.Locks[resource]
+		if !ok {
+			continue
+		}
+
+		for mode, waits := range lock.AcquireWaitCount.byMode() {
+			counts["lockWaits."+resource+"."+mode] = waits
+		}
+		for mode, micros := range lock.TimeAcquiringMicros.byMode() {
+			counts["lockWaitMicros."+resource+"."+mode] = micros
+		}
+	}
+
+	if status.FlowControl != nil {
+		contention.FlowControlLagged = status.FlowControl.IsLagged
+		counts["flowControlMicros"] = status.FlowControl.TimeAcquiringMicros
+	}
+
+	for metric, count := range counts {
+		rate := pm.calculateRate(metric, count, now)
+		switch {
+		case strings.HasPrefix(metric, "lockWaits."):
+			contention.WaitsPerSecond[strings.TrimPrefix(metric, "lockWaits.")] = rate
+		case strings.HasPrefix(metric, "lockWaitMicros."):
+			contention.WaitMicrosPerSecond[strings.TrimPrefix(metric, "lockWaitMicros.")] = rate
+		default:
+			contention.FlowControlMicrosPerSecond = rate
+		}
+	}
+
+	// Store current values for next calculation
+	pm.prev.Lock()
+	for metric, count := range counts {
+		pm.prev.counts[metric] = count
+	}
+	pm.prev.Unlock()
+
+	return contention
+}
+
+// byMode returns the counters keyed by lock mode
+func (c LockModeCounts) byMode() map[string]int64 {
+	return map[string]int64{
+		"r": c.IntentShared,
+		"w": c.IntentExclusive,
+		"R": c.Shared,
+		"W": c.Exclusive,
+	}
+}
+
+/*
+DetectIndexBuilds finds the index builds among in-progress operations, and counts
+the operations waiting for a lock on the collection each of them is building on.
+Builds take an exclusive collection lock when they start and finish, and older
+servers hold it throughout a foreground build, so waiting writers show up here.
+*/
+func DetectIndexBuilds(ops []bson.M) []IndexBuildActivity {
+	var builds []IndexBuildActivity
+	waiting := make(map[string]int64)
+
+	for _, op := range ops {
+		namespace := opNamespace(op)
+
+		if locked, _ := op["waitingForLock"].(bool); locked && !isIndexBuild(op) {
+			waiting[namespace]++
+			continue
+		}
+
+		if isIndexBuild(op) {
+			msg, _ := op["msg"].(string)
+			builds = append(builds, IndexBuildActivity{
+				OpID:      fmt.Sprintf("%v", op["opid"]),
+				Namespace: namespace,
+				Message:   msg,
+			})
+		}
+	}
+
+	for i := range builds {
+		builds[i].WaitingOperations = waiting[builds[i].Namespace]
+	}
+
+	return builds
+}
+
+// isIndexBuild reports whether an operation is a createIndexes command or an index build thread
+func isIndexBuild(op bson.M) bool {
+	if command, ok := op["command"].(bson.M); ok {
+		if _, ok := command["createIndexes"]; ok {
+			return true
+		}
+	}
+
+	desc, _ := op["desc"].(string)
+	return strings.HasPrefix(desc, "IndexBuildsCoordinator")
+}
+
+// opNamespace returns the collection an operation works on, resolving commands run against $cmd
+func opNamespace(op bson.M) string {
+	namespace, _ := op["ns"].(string)
+
+	dbName, collName, _ := strings.Cut(namespace, ".")
+	if collName != "$cmd" {
+		return namespace
+	}
+
+	if command, ok := op["command"].(bson.M); ok && len(command) > 0 {
+		for _, key := range []string{"createIndexes", "insert", "update", "delete", "find", "aggregate"} {
+			if target, ok := command[key].(string); ok {
+				return dbName + "." + target
+			}
+		}
+	}
+
+	return namespace
+}
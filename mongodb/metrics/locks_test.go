@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestLockContention(t *testing.T) {
+	pm := NewPerformanceMonitor(nil)
+	start := time.Now()
+	pm.prev.timestamp = start
+
+	status := func(writeWaits, waitMicros, flowMicros int64) *ServerStats {
+		return &ServerStats{
+			GlobalLock: &GlobalLockStats{
+				CurrentQueue:  LockQueueStats{Total: 7, Readers: 2, Writers: 5},
+				ActiveClients: LockQueueStats{Total: 3, Readers: 1, Writers: 2},
+			},
+			Locks: map[string]LockStats{
+				"Collection": {
+					AcquireWaitCount:    LockModeCounts{Exclusive: writeWaits},
+					TimeAcquiringMicros: LockModeCounts{Exclusive: waitMicros},
+				},
+			},
+			FlowControl: &FlowControlStats{IsLagged: true, TimeAcquiringMicros: flowMicros},
+		}
+	}
+
+	first := pm.lockContention(status(10, 1000, 500), start)
+	assert.Equal(t, int64(5), first.QueuedWriters)
+	assert.Equal(t, int64(2), first.ActiveWriters)
+	assert.True(t, first.FlowControlLagged)
+	assert.Equal(t, 0.0, first.WaitsPerSecond["Collection.W"], "no rate on the first collection")
+
+	second := pm.lockContention(status(30, 51000, 1500), start.Add(10*time.Second))
+	assert.InDelta(t, 2.0, second.WaitsPerSecond["Collection.W"], 0.0001)
+	assert.InDelta(t, 5000.0, second.WaitMicrosPerSecond["Collection.W"], 0.0001)
+	assert.InDelta(t, 100.0, second.FlowControlMicrosPerSecond, 0.0001)
+	assert.Equal(t, 0.0, second.WaitsPerSecond["Collection.w"])
+	assert.NotContains(t, second.WaitsPerSecond, "Global.W")
+}
+
+func TestDetectIndexBuilds(t *testing.T) {
+	ops := []bson.M{
+		{
+			"opid":    int32(11),
+			"ns":      "app.$cmd",
+			"command": bson.M{"createIndexes": "orders", "indexes": bson.A{}},
+			"msg":     "Index Build: scanning collection 1200/5000 24%",
+		},
+		{"opid": int32(12), "ns": "app.orders", "waitingForLock": true, "command": bson.M{"insert": "orders"}},
+		{"opid": int32(13), "ns": "app.$cmd", "waitingForLock": true, "command": bson.M{"update": "orders"}},
+		{"opid": int32(14), "ns": "app.users", "waitingForLock": true},
+		{"opid": int32(15), "ns": "app.users", "desc": "IndexBuildsCoordinatorMongod-2"},
+	}
+
+	builds := DetectIndexBuilds(ops)
+
+	assert.Equal(t, []IndexBuildActivity{
+		{OpID: "11", Namespace: "app.orders", Message: "Index Build: scanning collection 1200/5000 24%", WaitingOperations: 2},
+		{OpID: "15", Namespace: "app.users", WaitingOperations: 1},
+	}, builds)
+	assert.Empty(t, DetectIndexBuilds([]bson.M{{"ns": "app.orders", "waitingForLock": true}}))
+}
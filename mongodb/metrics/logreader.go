@@ -0,0 +1,264 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// DefaultSlowOperationLimit is the number of slowest operations kept by a LogReader by default
+const DefaultSlowOperationLimit = 100
+
+// slowQueryMessage is the message of the structured log entries describing slow operations
+const slowQueryMessage = "Slow query"
+
+// maxLogLineSize bounds a single log line, slow query entries include the full command
+const maxLogLineSize = 16 * 1024 * 1024
+
+// logEntry is the subset of a structured (4.4+) mongod log line used for slow query analysis
+type logEntry struct {
+	Time    time.Time `bson:"t"`
+	Message string    `bson:"msg"`
+	Attr    struct {
+		Type           string `bson:"type"`
+		Namespace      string `bson:"ns"`
+		Command        bson.D `bson:"command"`
+		PlanSummary    string `bson:"planSummary"`
+		KeysExamined   int64  `bson:"keysExamined"`
+		DocsExamined   int64  `bson:"docsExamined"`
+		NReturned      int64  `bson:"nreturned"`
+		HasSortStage   bool   `bson:"hasSortStage"`
+		DurationMillis int64  `bson:"durationMillis"`
+		QueryHash      string `bson:"queryHash"`
+	} `bson:"attr"`
+}
+
+/*
+LogReaderOptionFn is a function type for configuring a LogReader.
+*/
+type LogReaderOptionFn func(*LogReader)
+
+/*
+LogReader parses the "Slow query" entries of structured mongod log files into slow
+operations and query shapes, so that clusters can be analyzed without a live connection.
+*/
+type LogReader struct {
+	database   string
+	slowLimit  int
+	aggregator *PatternAggregator
+	slowOps    []SlowOperation
+	lines      int
+	skipped    int
+}
+
+/*
+NewLogReader creates a log reader with no entries read yet.
+*/
+func NewLogReader(opts ...LogReaderOptionFn) *LogReader {
+	reader := &LogReader{
+		slowLimit:  DefaultSlowOperationLimit,
+		aggregator: NewPatternAggregator(),
+	}
+
+	for _, opt := range opts {
+		opt(reader)
+	}
+
+	return reader
+}
+
+/*
+WithLogDatabase only keeps operations on namespaces of the given database.
+*/
+func WithLogDatabase(dbName string) LogReaderOptionFn {
+	return func(r *LogReader) {
+		r.database = dbName
+	}
+}
+
+/*
+WithSlowOperationLimit sets how many of the slowest operations are kept.
+*/
+func WithSlowOperationLimit(limit int) LogReaderOptionFn {
+	return func(r *LogReader) {
+		r.slowLimit = limit
+	}
+}
+
+/*
+ReadPath reads a log file, or every file in a directory. Files ending in .gz are
+decompressed.
+*/
+func (r *LogReader) ReadPath(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to read log path: %w", err)
+	}
+
+	if !info.IsDir() {
+		return r.readFile(path)
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return fmt.Errorf("failed to read log directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if err := r.readFile(filepath.Join(path, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readFile reads a single, possibly gzip compressed, log file
+func (r *LogReader) readFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("failed to decompress log file %s: %w", path, err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	if err := r.Read(reader); err != nil {
+		return fmt.Errorf("failed to read log file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+/*
+Read parses structured log lines from the reader. Lines that are not slow query
+entries, including lines in the legacy text format, are skipped.
+*/
+func (r *LogReader) Read(reader io.Reader) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxLogLineSize)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		r.lines++
+
+		// Cheap check before decoding, most lines are not slow queries
+		if !bytes.Contains(line, []byte(slowQueryMessage)) {
+			continue
+		}
+
+		var entry logEntry
+		if err := bson.UnmarshalExtJSON(line, false, &entry); err != nil {
+			r.skipped++
+			continue
+		}
+
+		r.add(entry)
+	}
+
+	return scanner.Err()
+}
+
+// add records a slow query log entry
+func (r *LogReader) add(entry logEntry) {
+	if entry.Message != slowQueryMessage || entry.Attr.Namespace == "" {
+		return
+	}
+
+	if r.database != "" && !strings.HasPrefix(entry.Attr.Namespace, r.database+".") {
+		return
+	}
+
+	sample := OperationSample{
+		Namespace:    entry.Attr.Namespace,
+		Operation:    entry.Attr.Type,
+		Command:      entry.Attr.Command,
+		Duration:     time.Duration(entry.Attr.DurationMillis) * time.Millisecond,
+		DocsExamined: entry.Attr.DocsExamined,
+		KeysExamined: entry.Attr.KeysExamined,
+		DocsReturned: entry.Attr.NReturned,
+		PlanSummary:  entry.Attr.PlanSummary,
+		HasSortStage: entry.Attr.HasSortStage,
+		Timestamp:    entry.Time,
+	}
+	r.aggregator.Add(sample)
+
+	r.slowOps = append(r.slowOps, SlowOperation{
+		OpID:         entry.Attr.QueryHash,
+		Type:         sample.Operation,
+		Namespace:    sample.Namespace,
+		Duration:     sample.Duration,
+		QueryPattern: r.aggregator.Shape(sample.Command),
+		Plan:         sample.PlanSummary,
+		Timestamp:    sample.Timestamp,
+	})
+
+	// Only the slowest operations are kept, trimming in batches keeps this cheap
+	if r.slowLimit > 0 && len(r.slowOps) >= 2*r.slowLimit {
+		r.trimSlowOperations()
+	}
+}
+
+// trimSlowOperations sorts the slow operations by duration and drops all but the slowest
+func (r *LogReader) trimSlowOperations() {
+	sort.SliceStable(r.slowOps, func(i, j int) bool {
+		return r.slowOps[i].Duration > r.slowOps[j].Duration
+	})
+
+	if r.slowLimit > 0 && len(r.slowOps) > r.slowLimit {
+		r.slowOps = r.slowOps[:r.slowLimit]
+	}
+}
+
+/*
+SlowOperations returns the slowest operations read, slowest first.
+*/
+func (r *LogReader) SlowOperations() []SlowOperation {
+	r.trimSlowOperations()
+	return r.slowOps
+}
+
+/*
+QueryPatterns returns the n query shapes with the highest total execution time.
+*/
+func (r *LogReader) QueryPatterns(n int) []QueryPatternStats {
+	return r.aggregator.Top(n)
+}
+
+/*
+Stats returns the number of lines read and the number of slow query lines that could not be parsed.
+*/
+func (r *LogReader) Stats() (lines, skipped int) {
+	return r.lines, r.skipped
+}
+
+/*
+Report builds a report from the log entries read, holding the slow operations and
+the top n query shapes.
+*/
+func (r *LogReader) Report(n int) *Report {
+	report := NewReport(nil)
+	report.SlowOperations = r.SlowOperations()
+	report.QueryPatterns = r.QueryPatterns(n)
+	return report
+}
@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testLog = `{"t":{"$date":"2024-05-20T19:18:40.604+00:00"},"s":"I","c":"NETWORK","id":22943,"ctx":"listener","msg":"Connection accepted","attr":{"remote":"127.0.0.1:53000"}}
+{"t":{"$date":"2024-05-20T19:18:41.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn1","msg":"Slow query","attr":{"type":"command","ns":"shop.orders","command":{"find":"orders","filter":{"customer":"alice"},"sort":{"created":-1}},"planSummary":"COLLSCAN","keysExamined":0,"docsExamined":5000,"hasSortStage":true,"nreturned":10,"queryHash":"ABC","durationMillis":120}}
+{"t":{"$date":"2024-05-20T19:18:42.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn2","msg":"Slow query","attr":{"type":"command","ns":"shop.orders","command":{"find":"orders","filter":{"customer":"bob"},"sort":{"created":-1}},"planSummary":"COLLSCAN","keysExamined":0,"docsExamined":7000,"hasSortStage":true,"nreturned":4,"queryHash":"ABC","durationMillis":200}}
+{"t":{"$date":"2024-05-20T19:18:43.000+00:00"},"s":"I","c":"WRITE","id":51803,"ctx":"conn3","msg":"Slow query","attr":{"type":"update","ns":"shop.users","command":{"q":{"_id":{"$oid":"5f1a2b3c4d5e6f7a8b9c0d1e"}},"u":{"$set":{"seen":true}}},"planSummary":"IDHACK","keysExamined":1,"docsExamined":1,"nreturned":0,"durationMillis":150}}
+{"t":{"$date":"2024-05-20T19:18:44.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn4","msg":"Slow query","attr":{"type":"command","ns":"other.items","command":{"find":"items"},"planSummary":"COLLSCAN","durationMillis":300}}
+2024-05-20T19:18:45.000+0000 I COMMAND  [conn5] command shop.orders command: find { find: "orders" } 500ms
+{"t":{"$date":"2024-05-20T19:18:46.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn6","msg":"Slow query","attr":{"type":"command","ns":
+`
+
+func TestLogReaderRead(t *testing.T) {
+	reader := NewLogReader(WithLogDatabase("shop"))
+	require.NoError(t, reader.Read(strings.NewReader(testLog)))
+
+	lines, skipped := reader.Stats()
+	assert.Equal(t, 7, lines)
+	assert.Equal(t, 1, skipped) // the truncated last line
+
+	slowOps := reader.SlowOperations()
+	require.Len(t, slowOps, 3)
+	assert.Equal(t, 200*time.Millisecond, slowOps[0].Duration)
+	assert.Equal(t, "shop.orders", slowOps[0].Namespace)
+	assert.Equal(t, "COLLSCAN", slowOps[0].Plan)
+	assert.Equal(t, "ABC", slowOps[0].OpID)
+	assert.Equal(t, time.Date(2024, 5, 20, 19, 18, 42, 0, time.UTC), slowOps[0].Timestamp.UTC())
+	assert.Equal(t, "update", slowOps[1].Type)
+
+	patterns := reader.QueryPatterns(0)
+	require.Len(t, patterns, 2)
+
+	orders := patterns[0]
+	assert.Equal(t, "shop.orders", orders.Namespace)
+	assert.Equal(t, `find {"customer":<?>} sort:{"created":-1}`, orders.Pattern)
+	assert.Equal(t, int64(2), orders.ExecutionCount)
+	assert.Equal(t, 160*time.Millisecond, orders.AverageLatency)
+	assert.Equal(t, int64(6000), orders.AverageDocsScanned)
+	assert.Equal(t, int64(2), orders.CollectionScans)
+	assert.Equal(t, int64(2), orders.InMemorySort)
+
+	assert.Equal(t, `{"_id":<ObjectId>}`, patterns[1].Pattern)
+}
+
+func TestLogReaderSlowOperationLimit(t *testing.T) {
+	reader := NewLogReader(WithSlowOperationLimit(2))
+	require.NoError(t, reader.Read(strings.NewReader(testLog)))
+
+	slowOps := reader.SlowOperations()
+	require.Len(t, slowOps, 2)
+	assert.Equal(t, "other.items", slowOps[0].Namespace)
+	assert.Equal(t, 200*time.Millisecond, slowOps[1].Duration)
+}
+
+func TestLogReaderReadPath(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "mongod.log"), []byte(testLog), 0o600))
+
+	file, err := os.Create(filepath.Join(dir, "mongod.log.1.gz"))
+	require.NoError(t, err)
+	gz := gzip.NewWriter(file)
+	_, err = gz.Write([]byte(testLog))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	require.NoError(t, file.Close())
+
+	reader := NewLogReader(WithLogDatabase("shop"))
+	require.NoError(t, reader.ReadPath(dir))
+
+	report := reader.Report(1)
+	assert.Len(t, report.SlowOperations, 6)
+	require.Len(t, report.QueryPatterns, 1)
+	assert.Equal(t, int64(4), report.QueryPatterns[0].ExecutionCount)
+}
@@ -0,0 +1,214 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// DefaultTopQueryPatterns is the number of query shapes kept in a report by default
+const DefaultTopQueryPatterns = 20
+
+/*
+OperationSample is a single executed operation, as read from the profiler or from
+a slow query log line. Samples are grouped into query shapes by PatternAggregator.
+*/
+type OperationSample struct {
+	Namespace    string
+	Operation    string // query, update, remove, command, etc.
+	Command      bson.D // the original command as sent by the client
+	Duration     time.Duration
+	DocsExamined int64
+	KeysExamined int64
+	DocsReturned int64
+	PlanSummary  string
+	HasSortStage bool
+	Timestamp    time.Time
+}
+
+// patternAccumulator sums the samples of one query shape
+type patternAccumulator struct {
+	stats         QueryPatternStats
+	totalDuration time.Duration
+	docsExamined  int64
+	keysExamined  int64
+	docsReturned  int64
+	indexes       map[string]bool
+}
+
+/*
+PatternAggregator groups operation samples by namespace, operation and normalized
+query shape, and computes per-shape statistics.
+*/
+type PatternAggregator struct {
+	normalizer *PerformanceMonitor
+	patterns   map[string]*patternAccumulator
+}
+
+/*
+NewPatternAggregator creates an empty aggregator.
+*/
+func NewPatternAggregator() *PatternAggregator {
+	return &PatternAggregator{
+		normalizer: &PerformanceMonitor{},
+		patterns:   make(map[string]*patternAccumulator),
+	}
+}
+
+/*
+Add records a sample under its query shape.
+*/
+func (a *PatternAggregator) Add(sample OperationSample) {
+	pattern := a.Shape(sample.Command)
+	key := sample.Namespace + "|" + sample.Operation + "|" + pattern
+
+	acc, ok := a.patterns[key]
+	if !ok {
+		acc = &patternAccumulator{
+			stats: QueryPatternStats{
+				Pattern:       pattern,
+				Namespace:     sample.Namespace,
+				Operation:     sample.Operation,
+				Predicates:    ClassifyShape(sample.Command),
+				SampleCommand: sample.Command,
+			},
+			indexes: make(map[string]bool),
+		}
+		a.patterns[key] = acc
+	}
+
+	acc.stats.ExecutionCount++
+	acc.totalDuration += sample.Duration
+	acc.docsExamined += sample.DocsExamined
+	acc.keysExamined += sample.KeysExamined
+	acc.docsReturned += sample.DocsReturned
+
+	if sample.Duration > acc.stats.MaxLatency {
+		acc.stats.MaxLatency = sample.Duration
+	}
+	if sample.Timestamp.After(acc.stats.LastExecuted) {
+		acc.stats.LastExecuted = sample.Timestamp
+		// Keep the most recent command as the representative for explain
+		if sample.Command != nil {
+			acc.stats.SampleCommand = sample.Command
+		}
+	}
+	if sample.HasSortStage {
+		acc.stats.InMemorySort++
+	}
+
+	for _, stage := range strings.Split(sample.PlanSummary, ", ") {
+		switch {
+		case stage == "COLLSCAN":
+			acc.stats.CollectionScans++
+		case strings.HasPrefix(stage, "IXSCAN "):
+			acc.indexes[strings.TrimPrefix(stage, "IXSCAN ")] = true
+		}
+	}
+}
+
+/*
+Len returns the number of distinct query shapes seen.
+*/
+func (a *PatternAggregator) Len() int {
+	return len(a.patterns)
+}
+
+/*
+Top returns the statistics of the n shapes with the highest total execution time,
+or of all shapes if n is not positive.
+*/
+func (a *PatternAggregator) Top(n int) []QueryPatternStats {
+	accumulators := make([]*patternAccumulator, 0, len(a.patterns))
+	for _, acc := range a.patterns {
+		accumulators = append(accumulators, acc)
+	}
+
+	sort.Slice(accumulators, func(i, j int) bool {
+		if accumulators[i].totalDuration != accumulators[j].totalDuration {
+			return accumulators[i].totalDuration > accumulators[j].totalDuration
+		}
+		return accumulators[i].stats.Pattern < accumulators[j].stats.Pattern
+	})
+
+	if n > 0 && len(accumulators) > n {
+		accumulators = accumulators[:n]
+	}
+
+	result := make([]QueryPatternStats, 0, len(accumulators))
+	for _, acc := range accumulators {
+		stats := acc.stats
+		count := stats.ExecutionCount
+
+		stats.TotalLatency = acc.totalDuration
+		stats.AverageLatency = acc.totalDuration / time.Duration(count)
+		stats.AverageDocsScanned = acc.docsExamined / count
+		stats.AverageKeysExamined = acc.keysExamined / count
+		stats.AverageDocsReturned = acc.docsReturned / count
+
+		stats.IndexesUsed = make([]string, 0, len(acc.indexes))
+		for index := range acc.indexes {
+			stats.IndexesUsed = append(stats.IndexesUsed, index)
+		}
+		sort.Strings(stats.IndexesUsed)
+
+		result = append(result, stats)
+	}
+
+	return result
+}
+
+/*
+Shape returns the normalized query shape of a command: the command name and the filter
+(or pipeline) with values replaced by placeholders, followed by the sort and projection.
+*/
+func (a *PatternAggregator) Shape(command bson.D) string {
+	var parts []string
+
+	// Update and delete statements start with their filter rather than a command name
+	if len(command) > 0 && command[0].Key != "q" {
+		parts = append(parts, command[0].Key)
+	}
+
+	// Filter values are replaced by placeholders
+	for _, name := range []string{"filter", "q", "query"} {
+		if value, ok := lookup(command, name); ok {
+			parts = append(parts, a.normalizer.normalizeValue(value))
+			break
+		}
+	}
+
+	if value, ok := lookup(command, "pipeline"); ok {
+		if pipeline, ok := value.(bson.A); ok {
+			value = []any(pipeline)
+		}
+		parts = append(parts, "pipeline:"+a.normalizer.normalizeValue(value))
+	}
+
+	// Sort directions and projections are part of the shape, so they are kept as is
+	for _, name := range []string{"sort", "projection"} {
+		if value, ok := lookup(command, name); ok {
+			if data, err := bson.MarshalExtJSON(value, false, false); err == nil {
+				parts = append(parts, name+":"+string(data))
+			}
+		}
+	}
+
+	if len(parts) == 0 {
+		return "{}"
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// lookup returns the value of a top-level field of a document
+func lookup(doc bson.D, key string) (any, bool) {
+	for _, elem := range doc {
+		if elem.Key == key {
+			return elem.Value, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestShape(t *testing.T) {
+	tests := []struct {
+		name     string
+		command  bson.D
+		expected string
+	}{
+		{
+			name: "find with filter and sort",
+			command: bson.D{
+				{Key: "find", Value: "users"},
+				{Key: "filter", Value: bson.D{{Key: "age", Value: int32(30)}}},
+				{Key: "sort", Value: bson.D{{Key: "name", Value: int32(1)}}},
+			},
+			expected: `find {"age":<?>} sort:{"name":1}`,
+		},
+		{
+			name: "update statement",
+			command: bson.D{
+				{Key: "q", Value: bson.D{{Key: "status", Value: "active"}}},
+				{Key: "u", Value: bson.D{{Key: "$set", Value: bson.D{{Key: "seen", Value: true}}}}},
+			},
+			expected: `{"status":<?>}`,
+		},
+		{
+			name: "aggregate pipeline",
+			command: bson.D{
+				{Key: "aggregate", Value: "orders"},
+				{Key: "pipeline", Value: bson.A{
+					bson.D{{Key: "$match", Value: bson.D{{Key: "total", Value: 10.5}}}},
+				}},
+			},
+			expected: `aggregate pipeline:[{"$match":{"total":<?>}}]`,
+		},
+		{
+			name:     "empty command",
+			command:  nil,
+			expected: "{}",
+		},
+	}
+
+	aggregator := NewPatternAggregator()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, aggregator.Shape(tt.command))
+		})
+	}
+}
+
+func TestPatternAggregator(t *testing.T) {
+	now := time.Now()
+
+	find := func(name string) bson.D {
+		return bson.D{
+			{Key: "find", Value: "users"},
+			{Key: "filter", Value: bson.D{{Key: "name", Value: name}}},
+		}
+	}
+
+	aggregator := NewPatternAggregator()
+	aggregator.Add(OperationSample{
+		Namespace:    "app.users",
+		Operation:    "query",
+		Command:      find("alice"),
+		Duration:     10 * time.Millisecond,
+		DocsExamined: 100,
+		DocsReturned: 1,
+		PlanSummary:  "COLLSCAN",
+		Timestamp:    now.Add(-time.Minute),
+	})
+	aggregator.Add(OperationSample{
+		Namespace:    "app.users",
+		Operation:    "query",
+		Command:      find("bob"),
+		Duration:     30 * time.Millisecond,
+		DocsExamined: 200,
+		KeysExamined: 10,
+		DocsReturned: 3,
+		PlanSummary:  "IXSCAN { name: 1 }",
+		HasSortStage: true,
+		Timestamp:    now,
+	})
+	aggregator.Add(OperationSample{
+		Namespace: "app.orders",
+		Operation: "query",
+		Command:   bson.D{{Key: "find", Value: "orders"}},
+		Duration:  5 * time.Millisecond,
+		Timestamp: now,
+	})
+
+	assert.Equal(t, 2, aggregator.Len())
+
+	top := aggregator.Top(0)
+	assert.Len(t, top, 2)
+
+	users := top[0]
+	assert.Equal(t, "app.users", users.Namespace)
+	assert.Equal(t, int64(2), users.ExecutionCount)
+	assert.Equal(t, 40*time.Millisecond, users.TotalLatency)
+	assert.Equal(t, 20*time.Millisecond, users.AverageLatency)
+	assert.Equal(t, 30*time.Millisecond, users.MaxLatency)
+	assert.Equal(t, int64(150), users.AverageDocsScanned)
+	assert.Equal(t, int64(5), users.AverageKeysExamined)
+	assert.Equal(t, int64(2), users.AverageDocsReturned)
+	assert.Equal(t, int64(1), users.CollectionScans)
+	assert.Equal(t, int64(1), users.InMemorySort)
+	assert.Equal(t, []string{"{ name: 1 }"}, users.IndexesUsed)
+	assert.Equal(t, now, users.LastExecuted)
+	assert.Equal(t, find("bob"), users.SampleCommand)
+
+	limited := aggregator.Top(1)
+	assert.Len(t, limited, 1)
+	assert.Equal(t, "app.users", limited[0].Namespace)
+}
@@ -3,6 +3,7 @@ package metrics
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -48,10 +49,11 @@ func (pm *PerformanceMonitor) CollectStats(
 
 	for _, fn := range []func(ctx context.Context, stats *PerformanceStats) error{
 		pm.collectLatencyStats,
+		// Lock rates use the timestamp of the previous collection, which the throughput stats move forward
+		pm.collectLockStats,
 		pm.collectThroughputStats,
 		pm.collectResourceStats,
 		pm.collectSlowOperations,
-		pm.collectIndexUtilization,
 	} {
 		if err := fn(ctx, stats); err != nil {
 			return nil, fmt.Errorf("failed to collect stats: %w", err)
@@ -65,17 +67,12 @@ func (pm *PerformanceMonitor) collectLatencyStats(ctx context.Context, stats *Pe
 	var result bson.M
 	if err := pm.runCommand(ctx, "admin", bson.D{
 		{Key: "serverStatus", Value: 1},
-		{Key: "metrics", Value: 1},
+		{Key: "opLatencies", Value: bson.D{{Key: "histograms", Value: true}}},
 	}, &result); err != nil {
 		return err
 	}
 
-	metrics, ok := result["metrics"].(bson.M)
-	if !ok {
-		return fmt.Errorf("invalid metrics format in server status")
-	}
-
-	opLatencies, ok := metrics["operationLatencies"].(bson.M)
+	opLatencies, ok := result["opLatencies"].(bson.M)
 	if !ok {
 		return nil // Not an error, just no latency metrics available
 	}
@@ -149,10 +146,8 @@ func (pm *PerformanceMonitor) collectResourceStats(ctx context.Context, stats *P
 		return err
 	}
 
-	system, ok := result["system"].(bson.M)
-	if !ok {
-		return fmt.Errorf("invalid system metrics format")
-	}
+	// The system section is only reported by some deployments
+	system, _ := result["system"].(bson.M)
 
 	mem, ok := result["mem"].(bson.M)
 	if !ok {
@@ -186,7 +181,7 @@ func (pm *PerformanceMonitor) collectSlowOperations(ctx context.Context, stats *
 		return err
 	}
 
-	inprog, ok := result["inprog"].([]any)
+	inprog, ok := result["inprog"].(bson.A)
 	if !ok {
 		return nil // No slow operations
 	}
@@ -197,12 +192,18 @@ func (pm *PerformanceMonitor) collectSlowOperations(ctx context.Context, stats *
 			continue
 		}
 
+		// Servers since 3.6 report the running command instead of the query
+		query := opMap["query"]
+		if command, ok := opMap["command"].(bson.M); ok && query == nil {
+			query = command["filter"]
+		}
+
 		stats.SlowOperations = append(stats.SlowOperations, SlowOperation{
 			OpID:         fmt.Sprintf("%v", opMap["opid"]),
 			Type:         fmt.Sprintf("%v", opMap["op"]),
 			Namespace:    fmt.Sprintf("%v", opMap["ns"]),
 			Duration:     time.Duration(getMetric[int64](pm, opMap, "microsecs_running")) * time.Microsecond,
-			QueryPattern: pm.formatQueryPattern(opMap["query"]),
+			QueryPattern: pm.formatQueryPattern(query),
 			Plan:         getMetric[string](pm, opMap, "planSummary"),
 			Timestamp:    time.Now(),
 		})
@@ -211,40 +212,80 @@ func (pm *PerformanceMonitor) collectSlowOperations(ctx context.Context, stats *
 	return nil
 }
 
-func (pm *PerformanceMonitor) collectIndexUtilization(ctx context.Context, stats *PerformanceStats) error {
-	dbs, err := pm.listDatabases(ctx)
-	if err != nil {
-		return err
-	}
-
-	for _, dbName := range dbs {
-		if err := pm.collectDatabaseIndexStats(ctx, dbName, stats); err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
 // Helper methods
 
 func (pm *PerformanceMonitor) runCommand(ctx context.Context, db string, cmd any, result any) error {
 	return pm.client.Database(db).RunCommand(ctx, cmd).Decode(result)
 }
 
+/*
+parseLatency computes the latency of an operation type from the opLatencies section
+of serverStatus. The mean comes from the cumulative latency and operation count, and
+the percentiles and maximum from the histogram buckets, which hold lower bounds.
+*/
 func (pm *PerformanceMonitor) parseLatency(metrics bson.M, opType string) OperationLatency {
 	latency, ok := metrics[opType].(bson.M)
 	if !ok {
 		return OperationLatency{}
 	}
 
-	return OperationLatency{
-		P50:  getMetric[float64](pm, latency, "latency", "50"),
-		P95:  getMetric[float64](pm, latency, "latency", "95"),
-		P99:  getMetric[float64](pm, latency, "latency", "99"),
-		Max:  getMetric[float64](pm, latency, "latency", "max"),
-		Mean: getMetric[float64](pm, latency, "latency", "mean"),
+	result := OperationLatency{}
+	if ops := getMetric[int64](pm, latency, "ops"); ops > 0 {
+		result.Mean = float64(getMetric[int64](pm, latency, "latency")) / float64(ops)
+	}
+
+	histogram, _ := latency["histogram"].(bson.A)
+
+	type bucket struct {
+		micros float64
+		count  int64
+	}
+	buckets := make([]bucket, 0, len(histogram))
+
+	var total int64
+	for _, entry := range histogram {
+		doc, ok := entry.(bson.M)
+		if !ok {
+			continue
+		}
+		b := bucket{
+			micros: getMetric[float64](pm, doc, "micros"),
+			count:  getMetric[int64](pm, doc, "count"),
+		}
+		buckets = append(buckets, b)
+		total += b.count
+	}
+
+	if total == 0 {
+		return result
+	}
+
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].micros < buckets[j].micros })
+
+	percentile := func(p float64) float64 {
+		threshold := int64(float64(total)*p + 0.5)
+		var seen int64
+		for _, b := range buckets {
+			seen += b.count
+			if seen >= threshold {
+				return b.micros
+			}
+		}
+		return buckets[len(buckets)-1].micros
+	}
+
+	result.P50 = percentile(0.50)
+	result.P95 = percentile(0.95)
+	result.P99 = percentile(0.99)
+
+	for i := len(buckets) - 1; i >= 0; i-- {
+		if buckets[i].count > 0 {
+			result.Max = buckets[i].micros
+			break
+		}
 	}
+
+	return result
 }
 
 func (pm *PerformanceMonitor) calculateRate(metric string, currentCount int64, now time.Time) float64 {
@@ -283,11 +324,37 @@ func getMetric[T any](perfMon *PerformanceMonitor, m bson.M, keys ...string) T {
 		current = next
 	}
 
-	if num, ok := current[keys[len(keys)-1]].(T); ok {
+	value := current[keys[len(keys)-1]]
+	if num, ok := value.(T); ok {
 		return num
 	}
 
-	return *new(T)
+	// Numeric fields are int32, int64 or double depending on their magnitude
+	var converted any
+	switch any(*new(T)).(type) {
+	case int64:
+		converted = toInt64(value)
+	case float64:
+		converted = toFloat64(value)
+	default:
+		return *new(T)
+	}
+
+	return converted.(T)
+}
+
+// toFloat64 converts a numeric BSON value to float64
+func toFloat64(value any) float64 {
+	switch v := value.(type) {
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	default:
+		return 0
+	}
 }
 
 // formatQueryPattern normalizes a MongoDB query pattern by replacing specific values
@@ -387,74 +454,3 @@ func (pm *PerformanceMonitor) normalizeValue(val any) string {
 		return fmt.Sprintf("<?:%T>", v)
 	}
 }
-
-func (pm *PerformanceMonitor) listDatabases(ctx context.Context) ([]string, error) {
-	dbs, err := pm.client.ListDatabaseNames(ctx, bson.D{})
-	if err != nil {
-		return nil, err
-	}
-
-	// Filter out system databases
-	var filtered []string
-	for _, db := range dbs {
-		if db != "admin" && db != "local" && db != "config" {
-			filtered = append(filtered, db)
-		}
-	}
-	return filtered, nil
-}
-
-func (pm *PerformanceMonitor) collectDatabaseIndexStats(ctx context.Context, dbName string, stats *PerformanceStats) error {
-	collections, err := pm.client.Database(dbName).ListCollectionNames(ctx, bson.D{})
-	if err != nil {
-		return err
-	}
-
-	for _, collName := range collections {
-		if err := pm.collectCollectionIndexStats(ctx, dbName, collName, stats); err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-func (pm *PerformanceMonitor) collectCollectionIndexStats(ctx context.Context, dbName, collName string, stats *PerformanceStats) error {
-	var result bson.M
-	if err := pm.runCommand(ctx, dbName, bson.D{
-		{Key: "aggregate", Value: collName},
-		{Key: "pipeline", Value: bson.A{
-			bson.D{{Key: "$indexStats", Value: bson.D{}}},
-		}},
-		{Key: "cursor", Value: bson.D{}},
-	}, &result); err != nil {
-		return err
-	}
-
-	cursor, ok := result["cursor"].(bson.M)
-	if !ok {
-		return nil
-	}
-
-	batch, ok := cursor["firstBatch"].([]any)
-	if !ok {
-		return nil
-	}
-
-	for _, stat := range batch {
-		indexStat, ok := stat.(bson.M)
-		if !ok {
-			continue
-		}
-
-		stats.IndexUtilization = append(stats.IndexUtilization, IndexUtilizationStat{
-			DatabaseName:   dbName,
-			CollectionName: collName,
-			IndexName:      getMetric[string](pm, indexStat, "name"),
-			UsageCount:     getMetric[int64](pm, indexStat, "accesses", "ops"),
-			LastUsed:       time.Now(),
-		})
-	}
-
-	return nil
-}
@@ -300,3 +300,52 @@ func TestNormalizeEdgeCases(t *testing.T) {
 		})
 	}
 }
+
+func TestParseLatency(t *testing.T) {
+	pm := &PerformanceMonitor{}
+
+	opLatencies := bson.M{
+		"reads": bson.M{
+			"latency": int64(20000),
+			"ops":     int64(100),
+			"histogram": bson.A{
+				bson.M{"micros": int64(128), "count": int64(50)},
+				bson.M{"micros": int64(64), "count": int64(40)},
+				bson.M{"micros": int64(1024), "count": int64(9)},
+				bson.M{"micros": int64(8192), "count": int64(1)},
+			},
+		},
+		"writes": bson.M{
+			"latency": int64(0),
+			"ops":     int64(0),
+		},
+	}
+
+	reads := pm.parseLatency(opLatencies, "reads")
+	assert.Equal(t, 200.0, reads.Mean)
+	assert.Equal(t, 128.0, reads.P50)
+	assert.Equal(t, 1024.0, reads.P95)
+	assert.Equal(t, 1024.0, reads.P99)
+	assert.Equal(t, 8192.0, reads.Max)
+
+	assert.Equal(t, OperationLatency{}, pm.parseLatency(opLatencies, "writes"))
+	assert.Equal(t, OperationLatency{}, pm.parseLatency(opLatencies, "commands"))
+}
+
+func TestGetMetricConversion(t *testing.T) {
+	pm := &PerformanceMonitor{}
+
+	m := bson.M{
+		"connections": bson.M{
+			"current": int32(12),
+			"ratio":   int64(3),
+		},
+		"name": "mongod",
+	}
+
+	assert.Equal(t, int64(12), getMetric[int64](pm, m, "connections", "current"))
+	assert.Equal(t, 3.0, getMetric[float64](pm, m, "connections", "ratio"))
+	assert.Equal(t, "mongod", getMetric[string](pm, m, "name"))
+	assert.Equal(t, int64(0), getMetric[int64](pm, m, "name"))
+	assert.Equal(t, int64(0), getMetric[int64](pm, m, "missing", "current"))
+}
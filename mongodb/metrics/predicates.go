@@ -0,0 +1,195 @@
+package metrics
+
+import (
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+ShapePredicates are the fields a query shape filters and sorts on, split the way the
+ESR rule orders the keys of a compound index: equality, then sort, then range.
+*/
+type ShapePredicates struct {
+	Equality []string    `json:"equality,omitempty" bson:"equality,omitempty"`
+	Sort     []SortField `json:"sort,omitempty" bson:"sort,omitempty"`
+	Range    []string    `json:"range,omitempty" bson:"range,omitempty"`
+}
+
+/*
+SortField is a field of a sort specification with its direction.
+*/
+type SortField struct {
+	Field     string `json:"field" bson:"field"`
+	Direction int    `json:"direction" bson:"direction"`
+}
+
+// rangeOperators are the query operators that match a range of index keys
+var rangeOperators = map[string]bool{
+	"$gt": true, "$gte": true, "$lt": true, "$lte": true,
+	"$ne": true, "$nin": true, "$regex": true, "$exists": true, "$not": true, "$type": true,
+}
+
+/*
+Fields returns every field of the shape: equality, sort and range fields, in that order.
+*/
+func (p *ShapePredicates) Fields() []string {
+	fields := append([]string(nil), p.Equality...)
+	for _, sort := range p.Sort {
+		fields = append(fields, sort.Field)
+	}
+	return append(fields, p.Range...)
+}
+
+/*
+ClassifyShape returns the predicates of a command: the fields of its filter (or of the
+first $match of its pipeline) compared for equality or against a range, and the fields
+of its sort. Returns nil when the command neither filters nor sorts on a field. Fields
+under $or, $expr and other top-level operators are left out, an index cannot serve them
+in key order.
+*/
+func ClassifyShape(command bson.D) *ShapePredicates {
+	var filter, sortSpec bson.D
+
+	for _, name := range []string{"filter", "q", "query"} {
+		if value, ok := lookup(command, name); ok {
+			filter = toD(value)
+			break
+		}
+	}
+	if value, ok := lookup(command, "sort"); ok {
+		sortSpec = toD(value)
+	}
+
+	if value, ok := lookup(command, "pipeline"); ok {
+		if pipeline, ok := value.(bson.A); ok {
+			for _, stage := range pipeline {
+				stage := toD(stage)
+				if len(stage) == 0 {
+					continue
+				}
+				if stage[0].Key == "$match" && filter == nil {
+					filter = toD(stage[0].Value)
+				}
+				if stage[0].Key == "$sort" && sortSpec == nil {
+					sortSpec = toD(stage[0].Value)
+				}
+			}
+		}
+	}
+
+	predicates := &ShapePredicates{}
+	classifyFilter(filter, predicates)
+
+	for _, elem := range sortSpec {
+		if direction, ok := toDirection(elem.Value); ok {
+			predicates.Sort = append(predicates.Sort, SortField{Field: elem.Key, Direction: direction})
+		}
+	}
+
+	// A field compared for equality is served as such, whatever else the filter does with it
+	var ranges []string
+	for _, field := range predicates.Range {
+		if !contains(predicates.Equality, field) && !contains(ranges, field) {
+			ranges = append(ranges, field)
+		}
+	}
+	predicates.Range = ranges
+
+	if len(predicates.Equality) == 0 && len(predicates.Sort) == 0 && len(predicates.Range) == 0 {
+		return nil
+	}
+
+	return predicates
+}
+
+// classifyFilter adds the fields of a filter to the predicates
+func classifyFilter(filter bson.D, predicates *ShapePredicates) {
+	for _, elem := range filter {
+		if elem.Key == "$and" {
+			if clauses, ok := elem.Value.(bson.A); ok {
+				for _, clause := range clauses {
+					classifyFilter(toD(clause), predicates)
+				}
+			}
+			continue
+		}
+		if strings.HasPrefix(elem.Key, "$") {
+			continue
+		}
+
+		if isRange(elem.Value) {
+			predicates.Range = append(predicates.Range, elem.Key)
+		} else if !contains(predicates.Equality, elem.Key) {
+			predicates.Equality = append(predicates.Equality, elem.Key)
+		}
+	}
+}
+
+// isRange reports whether a filter value matches a range of keys rather than one value
+func isRange(value any) bool {
+	if _, ok := value.(primitive.Regex); ok {
+		return true
+	}
+
+	operators := toD(value)
+	if len(operators) == 0 || !strings.HasPrefix(operators[0].Key, "$") {
+		return false // A literal value or embedded document
+	}
+
+	for _, operator := range operators {
+		if rangeOperators[operator.Key] {
+			return true
+		}
+	}
+	return false
+}
+
+// toD returns a document value as a bson.D, or nil
+func toD(value any) bson.D {
+	switch v := value.(type) {
+	case bson.D:
+		return v
+	case bson.M:
+		doc := make(bson.D, 0, len(v))
+		for key, value := range v {
+			doc = append(doc, bson.E{Key: key, Value: value})
+		}
+		return doc
+	}
+	return nil
+}
+
+// toDirection returns the direction of a sort field, false for $meta sorts
+func toDirection(value any) (int, bool) {
+	switch v := value.(type) {
+	case int32:
+		return sign(float64(v)), true
+	case int64:
+		return sign(float64(v)), true
+	case int:
+		return sign(float64(v)), true
+	case float64:
+		return sign(v), true
+	}
+	return 0, false
+}
+
+// sign returns 1 for a positive value, -1 otherwise
+func sign(v float64) int {
+	if v < 0 {
+		return -1
+	}
+	return 1
+}
+
+// contains reports whether a slice holds a string
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestClassifyShape(t *testing.T) {
+	tests := []struct {
+		name     string
+		command  bson.D
+		expected *ShapePredicates
+	}{
+		{
+			name: "find with equality, sort and range",
+			command: bson.D{
+				{Key: "find", Value: "orders"},
+				{Key: "filter", Value: bson.D{
+					{Key: "status", Value: "shipped"},
+					{Key: "total", Value: bson.D{{Key: "$gte", Value: 100}}},
+					{Key: "region", Value: bson.D{{Key: "$in", Value: bson.A{"eu", "us"}}}},
+				}},
+				{Key: "sort", Value: bson.D{{Key: "createdAt", Value: int32(-1)}}},
+			},
+			expected: &ShapePredicates{
+				Equality: []string{"status", "region"},
+				Sort:     []SortField{{Field: "createdAt", Direction: -1}},
+				Range:    []string{"total"},
+			},
+		},
+		{
+			name: "aggregate with $match and $sort",
+			command: bson.D{
+				{Key: "aggregate", Value: "orders"},
+				{Key: "pipeline", Value: bson.A{
+					bson.D{{Key: "$match", Value: bson.D{
+						{Key: "$and", Value: bson.A{
+							bson.D{{Key: "customer", Value: "c1"}},
+							bson.D{{Key: "sku", Value: primitive.Regex{Pattern: "^A"}}},
+						}},
+					}}},
+					bson.D{{Key: "$sort", Value: bson.D{{Key: "total", Value: 1.0}}}},
+				}},
+			},
+			expected: &ShapePredicates{
+				Equality: []string{"customer"},
+				Sort:     []SortField{{Field: "total", Direction: 1}},
+				Range:    []string{"sku"},
+			},
+		},
+		{
+			name: "$or is left out",
+			command: bson.D{
+				{Key: "find", Value: "orders"},
+				{Key: "filter", Value: bson.D{{Key: "$or", Value: bson.A{bson.D{{Key: "a", Value: 1}}}}}},
+			},
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ClassifyShape(tt.command))
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/theapemachine/lookatthatmongo/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultProfileLimit is the maximum number of profiler entries read per collection
+const DefaultProfileLimit = 1000
+
+// profileEntry is the subset of a system.profile document used for aggregation
+type profileEntry struct {
+	Op           string    `bson:"op"`
+	Namespace    string    `bson:"ns"`
+	Command      bson.D    `bson:"command"`
+	Millis       int64     `bson:"millis"`
+	DocsExamined int64     `bson:"docsExamined"`
+	KeysExamined int64     `bson:"keysExamined"`
+	NReturned    int64     `bson:"nreturned"`
+	PlanSummary  string    `bson:"planSummary"`
+	HasSortStage bool      `bson:"hasSortStage"`
+	Timestamp    time.Time `bson:"ts"`
+}
+
+// sample converts a profiler entry into an operation sample
+func (e profileEntry) sample() OperationSample {
+	return OperationSample{
+		Namespace:    e.Namespace,
+		Operation:    e.Op,
+		Command:      e.Command,
+		Duration:     time.Duration(e.Millis) * time.Millisecond,
+		DocsExamined: e.DocsExamined,
+		KeysExamined: e.KeysExamined,
+		DocsReturned: e.NReturned,
+		PlanSummary:  e.PlanSummary,
+		HasSortStage: e.HasSortStage,
+		Timestamp:    e.Timestamp,
+	}
+}
+
+/*
+ProfilerOptionFn is a function type for configuring a ProfilerCollector.
+*/
+type ProfilerOptionFn func(*ProfilerCollector)
+
+/*
+ProfilerCollector reads the database profiler (system.profile) and groups the
+profiled operations into query shapes.
+*/
+type ProfilerCollector struct {
+	client   *mongo.Client
+	slowMS   int
+	window   time.Duration
+	limit    int64
+	top      int
+	mu       sync.Mutex
+	lastRead map[string]time.Time
+}
+
+/*
+NewProfilerCollector creates a collector reading the profiler through the given client.
+By default it only reads entries that are already present, leaving the profiling
+level of the database untouched.
+*/
+func NewProfilerCollector(client *mongo.Client, opts ...ProfilerOptionFn) *ProfilerCollector {
+	collector := &ProfilerCollector{
+		client:   client,
+		limit:    DefaultProfileLimit,
+		top:      DefaultTopQueryPatterns,
+		lastRead: make(map[string]time.Time),
+	}
+
+	for _, opt := range opts {
+		opt(collector)
+	}
+
+	return collector
+}
+
+/*
+WithProfilingWindow enables profiling of operations slower than slowMS for the
+duration of the window before the profiler is read. The previous profiling level
+and threshold are restored afterwards.
+*/
+func WithProfilingWindow(slowMS int, window time.Duration) ProfilerOptionFn {
+	return func(c *ProfilerCollector) {
+		c.slowMS = slowMS
+		c.window = window
+	}
+}
+
+/*
+WithProfileLimit sets the maximum number of profiler entries read per collection.
+*/
+func WithProfileLimit(limit int64) ProfilerOptionFn {
+	return func(c *ProfilerCollector) {
+		c.limit = limit
+	}
+}
+
+/*
+WithTopQueryPatterns sets how many query shapes are returned, ordered by total execution time.
+*/
+func WithTopQueryPatterns(n int) ProfilerOptionFn {
+	return func(c *ProfilerCollector) {
+		c.top = n
+	}
+}
+
+/*
+GetQueryPatterns returns the top query shapes of a database.
+Entries already read by an earlier call are skipped, so reports taken before and
+after an optimization only cover the operations in between.
+*/
+func (c *ProfilerCollector) GetQueryPatterns(ctx context.Context, dbName string) ([]QueryPatternStats, error) {
+	if c.window > 0 {
+		if err := c.profileWindow(ctx, dbName); err != nil {
+			return nil, err
+		}
+	}
+
+	c.mu.Lock()
+	since := c.lastRead[dbName]
+	c.mu.Unlock()
+
+	filter := bson.D{}
+	if !since.IsZero() {
+		filter = bson.D{{Key: "ts", Value: bson.D{{Key: "$gt", Value: since}}}}
+	}
+
+	cursor, err := c.client.Database(dbName).Collection("system.profile").Find(
+		ctx,
+		filter,
+		options.Find().SetSort(bson.D{{Key: "ts", Value: -1}}).SetLimit(c.limit),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profiler: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	aggregator := NewPatternAggregator()
+	latest := since
+
+	for cursor.Next(ctx) {
+		var entry profileEntry
+		if err := cursor.Decode(&entry); err != nil {
+			return nil, fmt.Errorf("failed to decode profiler entry: %w", err)
+		}
+
+		if entry.Timestamp.After(latest) {
+			latest = entry.Timestamp
+		}
+
+		aggregator.Add(entry.sample())
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read profiler: %w", err)
+	}
+
+	c.mu.Lock()
+	c.lastRead[dbName] = latest
+	c.mu.Unlock()
+
+	logger.Debug("Read profiler entries", "database", dbName, "shapes", aggregator.Len())
+	return aggregator.Top(c.top), nil
+}
+
+// profileWindow enables profiling for the configured window, then restores the previous settings
+func (c *ProfilerCollector) profileWindow(ctx context.Context, dbName string) error {
+	db := c.client.Database(dbName)
+
+	// Setting the level returns the previous level and threshold
+	var previous struct {
+		Was    int `bson:"was"`
+		SlowMS int `bson:"slowms"`
+	}
+	if err := db.RunCommand(ctx, bson.D{
+		{Key: "profile", Value: 1},
+		{Key: "slowms", Value: c.slowMS},
+	}).Decode(&previous); err != nil {
+		return fmt.Errorf("failed to enable profiling: %w", err)
+	}
+
+	// Also restore the settings when the wait is cancelled
+	defer func() {
+		restoreCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+
+		if err := db.RunCommand(restoreCtx, bson.D{
+			{Key: "profile", Value: previous.Was},
+			{Key: "slowms", Value: previous.SlowMS},
+		}).Err(); err != nil {
+			logger.Error("Failed to restore profiling level", "database", dbName, "error", err)
+		}
+	}()
+
+	logger.Info("Profiling operations", "database", dbName, "slowms", c.slowMS, "window", c.window)
+
+	timer := time.NewTimer(c.window)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,180 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// StatePrimary is the replica set member state of the primary
+	StatePrimary = 1
+	// StateSecondary is the replica set member state of a secondary
+	StateSecondary = 2
+
+	// errNoReplicationEnabled is returned by replSetGetStatus on a standalone server
+	errNoReplicationEnabled = 76
+	// errCommandNotFound is returned by replSetGetStatus on mongos
+	errCommandNotFound = 59
+)
+
+/*
+ReadReplicationStatus runs replSetGetStatus and computes how far each secondary is
+behind the primary. It returns nil when the server is not a replica set member.
+*/
+func ReadReplicationStatus(ctx context.Context, client *mongo.Client) (*RepStats, error) {
+	status := &RepStats{}
+
+	if err := client.Database("admin").RunCommand(ctx, bson.D{
+		{Key: "replSetGetStatus", Value: 1},
+	}).Decode(status); err != nil {
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && (cmdErr.Code == errNoReplicationEnabled || cmdErr.Code == errCommandNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get replication status: %w", err)
+	}
+
+	status.IsReplicaSet = true
+	status.IsMaster = status.MyState == StatePrimary
+	ComputeLag(status)
+
+	return status, nil
+}
+
+/*
+ComputeLag sets the lag of every secondary from the optime of the primary, and the
+largest of them. Without a primary, the most recent optime of any member is used.
+*/
+func ComputeLag(status *RepStats) {
+	var head time.Time
+	for _, member := range status.Members {
+		if member.State == StatePrimary {
+			head = member.OptimeDate
+			break
+		}
+		if member.OptimeDate.After(head) {
+			head = member.OptimeDate
+		}
+	}
+
+	status.MaxLag = 0
+	for i := range status.Members {
+		member := &status.Members[i]
+		if member.State != StateSecondary || member.OptimeDate.IsZero() {
+			continue
+		}
+
+		member.Lag = max(head.Sub(member.OptimeDate), 0)
+		status.MaxLag = max(status.MaxLag, member.Lag)
+	}
+}
+
+/*
+ReadOplogWindow reads the first and last entries of local.oplog.rs, and the size of
+the oplog. The window is how long a secondary, or an index build on one, can fall
+behind before it has to resync.
+*/
+func ReadOplogWindow(ctx context.Context, client *mongo.Client) (*OplogStats, error) {
+	local := client.Database("local")
+	oplog := local.Collection("oplog.rs")
+
+	var first, last struct {
+		Ts primitive.Timestamp `bson:"ts"`
+	}
+
+	projection := bson.D{{Key: "ts", Value: 1}}
+	if err := oplog.FindOne(ctx, bson.D{}, options.FindOne().
+		SetSort(bson.D{{Key: "$natural", Value: 1}}).
+		SetProjection(projection)).Decode(&first); err != nil {
+		return nil, fmt.Errorf("failed to read first oplog entry: %w", err)
+	}
+	if err := oplog.FindOne(ctx, bson.D{}, options.FindOne().
+		SetSort(bson.D{{Key: "$natural", Value: -1}}).
+		SetProjection(projection)).Decode(&last); err != nil {
+		return nil, fmt.Errorf("failed to read last oplog entry: %w", err)
+	}
+
+	stats := &OplogStats{
+		First: time.Unix(int64(first.Ts.T), 0).UTC(),
+		Last:  time.Unix(int64(last.Ts.T), 0).UTC(),
+	}
+	stats.WindowHours = stats.Last.Sub(stats.First).Hours()
+
+	var size bson.M
+	if err := local.RunCommand(ctx, bson.D{{Key: "collStats", Value: "oplog.rs"}}).Decode(&size); err != nil {
+		return nil, fmt.Errorf("failed to get oplog size: %w", err)
+	}
+	stats.SizeBytes = toInt64(size["size"])
+	stats.MaxSizeBytes = toInt64(size["maxSize"])
+
+	return stats, nil
+}
+
+/*
+ReplicationEvents compares two replica set status reads and returns the elections,
+health changes and state changes between them. Without a previous read, only
+unhealthy members are reported.
+*/
+func ReplicationEvents(previous, current *RepStats) []ReplicationEvent {
+	if current == nil {
+		return nil
+	}
+
+	before := make(map[string]ReplicaMember)
+	if previous != nil {
+		for _, member := range previous.Members {
+			before[member.Name] = member
+		}
+	}
+
+	var events []ReplicationEvent
+	for _, member := range current.Members {
+		prev, seen := before[member.Name]
+
+		if !member.Health && (!seen || prev.Health) {
+			events = append(events, ReplicationEvent{
+				Time:    current.Date,
+				Member:  member.Name,
+				Type:    "health",
+				Message: fmt.Sprintf("member is unreachable: %s", member.LastHeartbeatMessage),
+			})
+		}
+		if !seen {
+			continue
+		}
+
+		if member.Health && !prev.Health {
+			events = append(events, ReplicationEvent{
+				Time:    current.Date,
+				Member:  member.Name,
+				Type:    "health",
+				Message: "member is reachable again",
+			})
+		}
+
+		if member.State == StatePrimary && member.ElectionDate.After(prev.ElectionDate) {
+			events = append(events, ReplicationEvent{
+				Time:    member.ElectionDate,
+				Member:  member.Name,
+				Type:    "election",
+				Message: fmt.Sprintf("member was elected primary in term %d", current.Term),
+			})
+		} else if member.State != prev.State {
+			events = append(events, ReplicationEvent{
+				Time:    current.Date,
+				Member:  member.Name,
+				Type:    "state",
+				Message: fmt.Sprintf("member changed from %s to %s", prev.StateStr, member.StateStr),
+			})
+		}
+	}
+
+	return events
+}
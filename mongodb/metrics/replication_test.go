@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestComputeLag(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	raw, err := bson.Marshal(bson.D{
+		{Key: "set", Value: "rs0"},
+		{Key: "myState", Value: int32(1)},
+		{Key: "members", Value: bson.A{
+			bson.D{{Key: "name", Value: "a:27017"}, {Key: "health", Value: 1.0}, {Key: "state", Value: int32(1)}, {Key: "optimeDate", Value: now}},
+			bson.D{{Key: "name", Value: "b:27017"}, {Key: "health", Value: 1.0}, {Key: "state", Value: int32(2)}, {Key: "optimeDate", Value: now.Add(-45 * time.Second)}},
+			bson.D{{Key: "name", Value: "c:27017"}, {Key: "health", Value: 1.0}, {Key: "state", Value: int32(2)}, {Key: "optimeDate", Value: now.Add(-2 * time.Second)}},
+			bson.D{{Key: "name", Value: "d:27017"}, {Key: "health", Value: 0.0}, {Key: "state", Value: int32(7)}},
+		}},
+	})
+	require.NoError(t, err)
+
+	var status RepStats
+	require.NoError(t, bson.Unmarshal(raw, &status))
+	ComputeLag(&status)
+
+	assert.Equal(t, "rs0", status.SetName)
+	assert.True(t, status.Members[0].Health)
+	assert.False(t, status.Members[3].Health)
+	assert.Equal(t, 45*time.Second, status.Members[1].Lag)
+	assert.Equal(t, 2*time.Second, status.Members[2].Lag)
+	assert.Equal(t, time.Duration(0), status.Members[3].Lag)
+	assert.Equal(t, 45*time.Second, status.MaxLag)
+}
+
+func TestReplicationEvents(t *testing.T) {
+	elected := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	previous := &RepStats{Members: []ReplicaMember{
+		{Name: "a", Health: true, State: StatePrimary, StateStr: "PRIMARY", ElectionDate: elected},
+		{Name: "b", Health: true, State: StateSecondary, StateStr: "SECONDARY"},
+		{Name: "c", Health: false, State: 8, StateStr: "(not reachable/healthy)"},
+	}}
+
+	current := &RepStats{Term: 4, Members: []ReplicaMember{
+		{Name: "a", Health: true, State: StateSecondary, StateStr: "SECONDARY", ElectionDate: elected},
+		{Name: "b", Health: true, State: StatePrimary, StateStr: "PRIMARY", ElectionDate: elected.Add(time.Minute)},
+		{Name: "c", Health: true, State: StateSecondary, StateStr: "SECONDARY"},
+	}}
+
+	var types []string
+	for _, event := range ReplicationEvents(previous, current) {
+		types = append(types, event.Member+":"+event.Type)
+	}
+	assert.Equal(t, []string{"a:state", "b:election", "c:health", "c:state"}, types)
+
+	t.Run("first read", func(t *testing.T) {
+		events := ReplicationEvents(nil, previous)
+		require.Len(t, events, 1)
+		assert.Equal(t, "c", events[0].Member)
+		assert.Equal(t, "health", events[0].Type)
+	})
+}
@@ -1,49 +1,168 @@
 package metrics
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/theapemachine/lookatthatmongo/logger"
 )
 
 /*
 Report represents a comprehensive performance report
 */
 type Report struct {
-	Timestamp     time.Time                     `json:"timestamp"`
-	ServerStats   *ServerStats                  `json:"serverStats"`
-	DatabaseStats map[string]*DatabaseStats     `json:"databaseStats"`
-	Collections   map[string][]*CollectionStats `json:"collections"`
-	Indexes       map[string][]*IndexStats      `json:"indexes"`
-	monitor       Monitor
+	Timestamp      time.Time                     `json:"timestamp"`
+	ServerStats    *ServerStats                  `json:"serverStats"`
+	DatabaseStats  map[string]*DatabaseStats     `json:"databaseStats"`
+	Collections    map[string][]*CollectionStats `json:"collections"`
+	Indexes        map[string][]*IndexStats      `json:"indexes"`
+	QueryPatterns  []QueryPatternStats           `json:"queryPatterns,omitempty"`
+	SlowOperations []SlowOperation               `json:"slowOperations,omitempty"`
+	Plans          []QueryPlan                   `json:"plans,omitempty"`
+	Performance    *PerformanceStats             `json:"performance,omitempty"`
+	Sharding       *ShardingStats                `json:"sharding,omitempty"`
+	Schemas        map[string]*CollectionSchema  `json:"schemas,omitempty"`
+	Errors         []ReportError                 `json:"errors,omitempty"`
+	monitor        Monitor
+	concurrency    int
+	timeout        time.Duration
+}
+
+const (
+	// DefaultReportConcurrency is the number of collections collected at the same time
+	DefaultReportConcurrency = 8
+	// DefaultCollectionTimeout bounds the time spent collecting a single collection
+	DefaultCollectionTimeout = 30 * time.Second
+)
+
+/*
+ReportError is an error hit while collecting a report. The report is still returned
+without the metrics that failed, so one collection cannot fail a whole run.
+*/
+type ReportError struct {
+	Collection string `json:"collection,omitempty"`
+	Stage      string `json:"stage"`
+	Error      string `json:"error"`
+}
+
+/*
+ReportOptionFn is a function type for configuring a Report.
+*/
+type ReportOptionFn func(*Report)
+
+/*
+WithConcurrency sets how many collections are collected at the same time.
+*/
+func WithConcurrency(n int) ReportOptionFn {
+	return func(r *Report) {
+		r.concurrency = n
+	}
+}
+
+/*
+WithCollectionTimeout bounds the time spent collecting a single collection.
+Zero disables the timeout.
+*/
+func WithCollectionTimeout(timeout time.Duration) ReportOptionFn {
+	return func(r *Report) {
+		r.timeout = timeout
+	}
 }
 
 /*
 Monitor interface defines methods for collecting MongoDB metrics
 */
 type Monitor interface {
-	GetServerStats(ctx any) (*ServerStats, error)
-	GetDatabaseStats(ctx any, dbName string) (*DatabaseStats, error)
-	GetCollectionStats(ctx any, dbName, collName string) (*CollectionStats, error)
-	GetIndexStats(ctx any, dbName, collName string) ([]IndexStats, error)
+	GetServerStats(ctx context.Context) (*ServerStats, error)
+	GetDatabaseStats(ctx context.Context, dbName string) (*DatabaseStats, error)
+	GetCollectionStats(ctx context.Context, dbName, collName string) (*CollectionStats, error)
+	GetIndexStats(ctx context.Context, dbName, collName string) ([]IndexStats, error)
+}
+
+/*
+QueryPatternCollector is implemented by monitors that can report the query shapes
+executed against a database, e.g. from the database profiler.
+*/
+type QueryPatternCollector interface {
+	GetQueryPatterns(ctx context.Context, dbName string) ([]QueryPatternStats, error)
+}
+
+/*
+PlanExplainer is implemented by monitors that can explain the representative
+commands of query shapes.
+*/
+type PlanExplainer interface {
+	ExplainQueryPatterns(ctx context.Context, patterns []QueryPatternStats) ([]QueryPlan, error)
+}
+
+/*
+PerformanceCollector is implemented by monitors that can report server performance:
+latency percentiles, throughput, resource usage and slow operations. Throughput rates
+are computed against the previous call, so the same collector should be used for the
+reports before and after an optimization. The report adds the index utilization of
+the database it covers from its own index stats.
+*/
+type PerformanceCollector interface {
+	GetPerformanceStats(ctx context.Context) (*PerformanceStats, error)
+}
+
+/*
+ReplicationCollector is implemented by monitors that can report the replica set
+status: member lag, the oplog window, and elections and health changes.
+*/
+type ReplicationCollector interface {
+	GetReplicationStatus(ctx context.Context) (*RepStats, error)
+}
+
+/*
+ShardingCollector is implemented by monitors that can report the shards, balancer
+state and chunk distribution of a sharded cluster.
+*/
+type ShardingCollector interface {
+	GetShardingStats(ctx context.Context, dbName string) (*ShardingStats, error)
+}
+
+/*
+SchemaCollector is implemented by monitors that can infer the schema of a collection
+from a sample of its documents.
+*/
+type SchemaCollector interface {
+	GetCollectionSchema(ctx context.Context, dbName, collName string) (*CollectionSchema, error)
 }
 
 /*
 NewReport creates a new report instance
 */
-func NewReport(monitor Monitor) *Report {
-	return &Report{
+func NewReport(monitor Monitor, opts ...ReportOptionFn) *Report {
+	report := &Report{
 		Timestamp:     time.Now(),
 		DatabaseStats: make(map[string]*DatabaseStats),
 		Collections:   make(map[string][]*CollectionStats),
 		Indexes:       make(map[string][]*IndexStats),
+		Schemas:       make(map[string]*CollectionSchema),
 		monitor:       monitor,
+		concurrency:   DefaultReportConcurrency,
+		timeout:       DefaultCollectionTimeout,
 	}
+
+	for _, opt := range opts {
+		opt(report)
+	}
+
+	return report
 }
 
 /*
-Collect gathers all metrics for the specified database
+Collect gathers all metrics for the specified database. Collections are collected
+concurrently, each within its own timeout. A collection that fails is recorded in
+Errors and left out of the report, as are metrics the monitor only optionally provides.
 */
-func (r *Report) Collect(ctx any, dbName string, listCollections func() ([]string, error)) error {
+func (r *Report) Collect(ctx context.Context, dbName string, listCollections func() ([]string, error)) error {
 	var err error
 
 	// Get server stats
@@ -51,6 +170,28 @@ func (r *Report) Collect(ctx any, dbName string, listCollections func() ([]strin
 		return err
 	}
 
+	// Replication status is optional, standalone servers have none
+	if collector, ok := r.monitor.(ReplicationCollector); ok {
+		replication, err := collector.GetReplicationStatus(ctx)
+		if err != nil {
+			r.addError("", "replication", err)
+			logger.Warn("Failed to collect replication status", "database", dbName, "error", err)
+		} else if replication != nil {
+			r.ServerStats.ReplicationStatus = *replication
+		}
+	}
+
+	// Sharding stats are optional, only a connection through mongos has them
+	if collector, ok := r.monitor.(ShardingCollector); ok {
+		sharding, err := collector.GetShardingStats(ctx, dbName)
+		if err != nil {
+			r.addError("", "sharding", err)
+			logger.Warn("Failed to collect sharding stats", "database", dbName, "error", err)
+		} else {
+			r.Sharding = sharding
+		}
+	}
+
 	// Get database stats
 	dbStats, err := r.monitor.GetDatabaseStats(ctx, dbName)
 	if err != nil {
@@ -58,37 +199,193 @@ func (r *Report) Collect(ctx any, dbName string, listCollections func() ([]strin
 	}
 	r.DatabaseStats[dbName] = dbStats
 
+	// Performance stats are optional, the server may not allow all the commands they need
+	if collector, ok := r.monitor.(PerformanceCollector); ok {
+		performance, err := collector.GetPerformanceStats(ctx)
+		if err != nil {
+			r.addError("", "performance", err)
+			logger.Warn("Failed to collect performance stats", "database", dbName, "error", err)
+		} else {
+			r.Performance = performance
+		}
+	}
+
 	// Get collections
 	collections, err := listCollections()
 	if err != nil {
 		return err
 	}
 
-	// Collect metrics for each collection
+	if err := r.collectCollections(ctx, dbName, collections); err != nil {
+		return err
+	}
+
+	if r.Performance != nil {
+		r.Performance.IndexUtilization = r.indexUtilization(dbName)
+	}
+
+	// Query shapes are optional, a report without them is still useful
+	if collector, ok := r.monitor.(QueryPatternCollector); ok {
+		patterns, err := collector.GetQueryPatterns(ctx, dbName)
+		if err != nil {
+			r.addError("", "queryPatterns", err)
+			logger.Warn("Failed to collect query patterns", "database", dbName, "error", err)
+		} else {
+			r.QueryPatterns = patterns
+		}
+	}
+
+	// Plans give the top query shapes real evidence of how they are executed
+	if explainer, ok := r.monitor.(PlanExplainer); ok && len(r.QueryPatterns) > 0 {
+		plans, err := explainer.ExplainQueryPatterns(ctx, r.QueryPatterns)
+		if err != nil {
+			r.addError("", "plans", err)
+			logger.Warn("Failed to explain query patterns", "database", dbName, "error", err)
+		} else {
+			r.Plans = plans
+		}
+	}
+
+	return nil
+}
+
+/*
+collectCollections collects the metrics of every collection with a bounded number of
+workers. Only a cancelled context stops the collection, other errors are recorded.
+*/
+func (r *Report) collectCollections(ctx context.Context, dbName string, collections []string) error {
+	concurrency := max(r.concurrency, 1)
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
 	for _, collName := range collections {
-		if err := r.collectCollectionMetrics(ctx, dbName, collName); err != nil {
-			return err
+		if SkipCollection(collName) {
+			logger.Debug("Skipping collection", "database", dbName, "coll", collName)
+			continue
 		}
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		sem <- struct{}{} // Acquire semaphore
+		wg.Add(1)
+
+		go func(collName string) {
+			defer wg.Done()
+			defer func() { <-sem }() // Release semaphore
+
+			collCtx := ctx
+			if r.timeout > 0 {
+				var cancel context.CancelFunc
+				collCtx, cancel = context.WithTimeout(ctx, r.timeout)
+				defer cancel()
+			}
+
+			collStats, indexStats, stage, err := r.collectCollectionMetrics(collCtx, dbName, collName)
+
+			// The schema is optional, it is only sampled for collections that could be collected
+			var schema *CollectionSchema
+			var schemaErr error
+			if collector, ok := r.monitor.(SchemaCollector); ok && err == nil {
+				schema, schemaErr = collector.GetCollectionSchema(collCtx, dbName, collName)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if collStats != nil {
+				r.Collections[collName] = []*CollectionStats{collStats}
+			}
+			if indexStats != nil {
+				r.Indexes[collName] = indexStats
+			}
+			if err != nil {
+				r.addError(collName, stage, err)
+				logger.Warn("Failed to collect collection metrics", "database", dbName, "coll", collName, "stage", stage, "error", err)
+			}
+			if schema != nil {
+				r.Schemas[collName] = schema
+			}
+			if schemaErr != nil {
+				r.addError(collName, "schema", schemaErr)
+				logger.Warn("Failed to sample collection schema", "database", dbName, "coll", collName, "error", schemaErr)
+			}
+		}(collName)
+	}
+
+	wg.Wait()
+
+	// Workers finish in any order, keep the report stable
+	sort.SliceStable(r.Errors, func(i, j int) bool { return r.Errors[i].Collection < r.Errors[j].Collection })
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("collection of %s was cancelled: %w", dbName, err)
 	}
 
 	return nil
 }
 
 /*
-collectCollectionMetrics gathers metrics for a specific collection
+indexUtilization lists the usage of every index collected for the database, one entry
+per host that reported it. Collections whose indexes could not be collected are left
+out, and their errors are already in the report.
+*/
+func (r *Report) indexUtilization(dbName string) []IndexUtilizationStat {
+	collections := make([]string, 0, len(r.Indexes))
+	for collName := range r.Indexes {
+		collections = append(collections, collName)
+	}
+	sort.Strings(collections)
+
+	utilization := make([]IndexUtilizationStat, 0)
+	for _, collName := range collections {
+		for _, index := range r.Indexes[collName] {
+			stat := IndexUtilizationStat{
+				DatabaseName:   dbName,
+				CollectionName: collName,
+				IndexName:      index.Name,
+				UsageCount:     index.UseCount,
+				SizeBytes:      int64(index.Size),
+				Since:          index.Since,
+				IsSparse:       index.Sparse,
+				IsUnique:       index.Unique,
+				IsMultiKey:     index.IsMultiKey,
+				Incomplete:     len(index.MissingHosts) > 0,
+			}
+
+			if len(index.Hosts) == 0 {
+				utilization = append(utilization, stat)
+				continue
+			}
+			for _, host := range index.Hosts {
+				stat.Host = host.Host
+				stat.UsageCount = host.Ops
+				stat.Since = host.Since
+				utilization = append(utilization, stat)
+			}
+		}
+	}
+
+	return utilization
+}
+
+/*
+collectCollectionMetrics gathers metrics for a specific collection, and returns the
+stage that failed with the error.
 */
-func (r *Report) collectCollectionMetrics(ctx any, dbName, collName string) error {
+func (r *Report) collectCollectionMetrics(ctx context.Context, dbName, collName string) (*CollectionStats, []*IndexStats, string, error) {
 	// Get collection stats
 	collStats, err := r.monitor.GetCollectionStats(ctx, dbName, collName)
 	if err != nil {
-		return err
+		return nil, nil, "collectionStats", err
 	}
-	r.Collections[collName] = []*CollectionStats{collStats}
 
 	// Get index stats
 	indexStats, err := r.monitor.GetIndexStats(ctx, dbName, collName)
 	if err != nil {
-		return err
+		return collStats, nil, "indexStats", err
 	}
 
 	// Convert to pointers
@@ -96,9 +393,26 @@ func (r *Report) collectCollectionMetrics(ctx any, dbName, collName string) erro
 	for i := range indexStats {
 		indexPtrs[i] = &indexStats[i]
 	}
-	r.Indexes[collName] = indexPtrs
 
-	return nil
+	return collStats, indexPtrs, "", nil
+}
+
+// addError records an error hit while collecting the report
+func (r *Report) addError(collName, stage string, err error) {
+	r.Errors = append(r.Errors, ReportError{
+		Collection: collName,
+		Stage:      stage,
+		Error:      err.Error(),
+	})
+}
+
+/*
+SkipCollection reports whether a collection is left out of reports: the buckets of
+time-series collections, which are internal and reported through their time-series
+collection.
+*/
+func SkipCollection(collName string) bool {
+	return strings.HasPrefix(collName, "system.buckets.")
 }
 
 /*
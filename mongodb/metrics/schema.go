@@ -0,0 +1,336 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// DefaultSchemaSampleSize is the number of documents sampled to infer a schema
+	DefaultSchemaSampleSize = 1000
+	// DefaultSchemaMaxFields is the number of field paths kept in a schema
+	DefaultSchemaMaxFields = 100
+	// maxDistinctValues bounds the distinct values tracked per field path
+	maxDistinctValues = 1000
+)
+
+// typeAliases are the $type aliases of BSON types
+var typeAliases = map[bsontype.Type]string{
+	bsontype.Double:           "double",
+	bsontype.String:           "string",
+	bsontype.EmbeddedDocument: "object",
+	bsontype.Array:            "array",
+	bsontype.Binary:           "binData",
+	bsontype.ObjectID:         "objectId",
+	bsontype.Boolean:          "bool",
+	bsontype.DateTime:         "date",
+	bsontype.Null:             "null",
+	bsontype.Regex:            "regex",
+	bsontype.Int32:            "int",
+	bsontype.Timestamp:        "timestamp",
+	bsontype.Int64:            "long",
+	bsontype.Decimal128:       "decimal",
+}
+
+/*
+CollectionSchema is the shape of a collection inferred from a sample of its documents.
+*/
+type CollectionSchema struct {
+	SampleSize int64         `json:"sampleSize"`
+	Fields     []FieldSchema `json:"fields"`
+	Truncated  bool          `json:"truncated,omitempty"` // whether rarer field paths were left out
+}
+
+/*
+FieldSchema describes a field path in a sample. Paths inside arrays of documents are
+reported like MongoDB resolves them in queries and indexes, e.g. "items.sku".
+*/
+type FieldSchema struct {
+	Path        string           `json:"path"`
+	Types       map[string]int64 `json:"types"`       // occurrences by $type alias
+	Presence    float64          `json:"presence"`    // share of sampled documents with the field
+	NullRatio   float64          `json:"nullRatio"`   // share of occurrences that are null
+	IsArray     bool             `json:"isArray"`     // whether the field holds an array in any document
+	Cardinality int64            `json:"cardinality"` // distinct values in the sample, capped at 1000
+	Selectivity float64          `json:"selectivity"` // expected share of documents matched by an equality on the field
+	TopValue    float64          `json:"topValue"`    // share of values taken by the most common value
+}
+
+/*
+Field returns the schema of a field path, or nil when it never appears in the sample.
+*/
+func (s *CollectionSchema) Field(path string) *FieldSchema {
+	for i := range s.Fields {
+		if s.Fields[i].Path == path {
+			return &s.Fields[i]
+		}
+	}
+	return nil
+}
+
+/*
+SchemaOptionFn is a function type for configuring a SchemaSampler.
+*/
+type SchemaOptionFn func(*SchemaSampler)
+
+/*
+SchemaSampler infers the schema of collections from a random sample of documents.
+*/
+type SchemaSampler struct {
+	client     *mongo.Client
+	sampleSize int64
+	maxFields  int
+}
+
+/*
+NewSchemaSampler creates a schema sampler reading through the given client.
+*/
+func NewSchemaSampler(client *mongo.Client, opts ...SchemaOptionFn) *SchemaSampler {
+	sampler := &SchemaSampler{
+		client:     client,
+		sampleSize: DefaultSchemaSampleSize,
+		maxFields:  DefaultSchemaMaxFields,
+	}
+
+	for _, opt := range opts {
+		opt(sampler)
+	}
+
+	return sampler
+}
+
+/*
+WithSchemaSampleSize sets the number of documents sampled per collection.
+*/
+func WithSchemaSampleSize(n int64) SchemaOptionFn {
+	return func(s *SchemaSampler) {
+		s.sampleSize = n
+	}
+}
+
+/*
+WithSchemaMaxFields sets the number of field paths kept per collection. The paths
+present in the most documents are kept.
+*/
+func WithSchemaMaxFields(n int) SchemaOptionFn {
+	return func(s *SchemaSampler) {
+		s.maxFields = n
+	}
+}
+
+/*
+Sample infers the schema of a collection from up to the sample size of its documents,
+chosen with $sample.
+*/
+func (s *SchemaSampler) Sample(ctx context.Context, dbName, collName string) (*CollectionSchema, error) {
+	cursor, err := s.client.Database(dbName).Collection(collName).Aggregate(ctx, bson.A{
+		bson.D{{Key: "$sample", Value: bson.D{{Key: "size", Value: s.sampleSize}}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sample %s.%s: %w", dbName, collName, err)
+	}
+	defer cursor.Close(ctx)
+
+	builder := NewSchemaBuilder()
+	for cursor.Next(ctx) {
+		if err := builder.Add(cursor.Current); err != nil {
+			return nil, err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to sample %s.%s: %w", dbName, collName, err)
+	}
+
+	return builder.Schema(s.maxFields), nil
+}
+
+/*
+SchemaBuilder accumulates the field paths of documents into a schema.
+*/
+type SchemaBuilder struct {
+	documents int64
+	fields    map[string]*fieldAccumulator
+}
+
+// fieldAccumulator collects the occurrences of a field path
+type fieldAccumulator struct {
+	documents   int64 // documents containing the path
+	occurrences int64
+	nulls       int64
+	isArray     bool
+	types       map[string]int64
+	values      map[string]int64 // occurrences of each distinct value
+	untracked   int64            // values seen after the distinct values were capped
+	seenIn      int64            // the last document the path was counted in
+}
+
+/*
+NewSchemaBuilder creates an empty schema builder.
+*/
+func NewSchemaBuilder() *SchemaBuilder {
+	return &SchemaBuilder{fields: make(map[string]*fieldAccumulator)}
+}
+
+/*
+Add adds the field paths of a document to the schema.
+*/
+func (b *SchemaBuilder) Add(doc bson.Raw) error {
+	b.documents++
+	return b.addDocument("", doc)
+}
+
+// addDocument adds the elements of a document under a path prefix
+func (b *SchemaBuilder) addDocument(prefix string, doc bson.Raw) error {
+	elements, err := doc.Elements()
+	if err != nil {
+		return fmt.Errorf("failed to read sampled document: %w", err)
+	}
+
+	for _, element := range elements {
+		path := element.Key()
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+		if err := b.addValue(path, element.Value(), false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addValue records a value of a field path, descending into documents and arrays
+func (b *SchemaBuilder) addValue(path string, value bson.RawValue, inArray bool) error {
+	field, ok := b.fields[path]
+	if !ok {
+		field = &fieldAccumulator{types: make(map[string]int64), values: make(map[string]int64)}
+		b.fields[path] = field
+	}
+
+	if field.seenIn != b.documents {
+		field.seenIn = b.documents
+		field.documents++
+	}
+
+	switch value.Type {
+	case bsontype.Array:
+		field.isArray = true
+		field.types[typeAliases[value.Type]]++
+		field.occurrences++
+
+		values, err := value.Array().Values()
+		if err != nil {
+			return fmt.Errorf("failed to read sampled array: %w", err)
+		}
+		// Array elements are indexed under the path of the array itself
+		for _, elem := range values {
+			if err := b.addValue(path, elem, true); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case bsontype.EmbeddedDocument:
+		if !inArray {
+			field.types[typeAliases[value.Type]]++
+			field.occurrences++
+		}
+		return b.addDocument(path, value.Document())
+	}
+
+	if !inArray || value.Type == bsontype.Null {
+		field.occurrences++
+	}
+	field.types[typeAlias(value.Type)]++
+
+	if value.Type == bsontype.Null {
+		field.nulls++
+		return nil
+	}
+
+	valueKey := string(value.Type) + string(value.Value)
+	if _, ok := field.values[valueKey]; ok || len(field.values) < maxDistinctValues {
+		field.values[valueKey]++
+	} else {
+		field.untracked++
+	}
+
+	return nil
+}
+
+/*
+Schema returns the schema of the documents added so far, keeping at most maxFields
+paths, those present in the most documents first. Zero keeps every path.
+*/
+func (b *SchemaBuilder) Schema(maxFields int) *CollectionSchema {
+	schema := &CollectionSchema{SampleSize: b.documents}
+
+	for path, field := range b.fields {
+		fieldSchema := FieldSchema{
+			Path:        path,
+			Types:       field.types,
+			IsArray:     field.isArray,
+			Cardinality: int64(len(field.values)),
+		}
+		fieldSchema.Selectivity, fieldSchema.TopValue = field.selectivity(b.documents)
+		if b.documents > 0 {
+			fieldSchema.Presence = float64(field.documents) / float64(b.documents)
+		}
+		if field.occurrences > 0 {
+			fieldSchema.NullRatio = float64(field.nulls) / float64(field.occurrences)
+		}
+		schema.Fields = append(schema.Fields, fieldSchema)
+	}
+
+	sort.Slice(schema.Fields, func(i, j int) bool {
+		if schema.Fields[i].Presence != schema.Fields[j].Presence {
+			return schema.Fields[i].Presence > schema.Fields[j].Presence
+		}
+		return schema.Fields[i].Path < schema.Fields[j].Path
+	})
+
+	if maxFields > 0 && len(schema.Fields) > maxFields {
+		schema.Fields = schema.Fields[:maxFields]
+		schema.Truncated = true
+	}
+
+	return schema
+}
+
+/*
+selectivity estimates the share of sampled documents an equality on the field
+matches, for a value drawn from the sample: the sum of the squared occurrences of each
+value over the number of values and documents. A unique field scores 1/documents, a
+constant one 1. Values seen after the distinct values were capped count as unique.
+It also returns the share of values taken by the most common value.
+*/
+func (f *fieldAccumulator) selectivity(documents int64) (float64, float64) {
+	total := f.untracked
+	squares := float64(f.untracked)
+	var top int64
+	for _, count := range f.values {
+		total += count
+		squares += float64(count) * float64(count)
+		top = max(top, count)
+	}
+
+	if total == 0 || documents == 0 {
+		return 0, 0
+	}
+
+	return min(squares/(float64(total)*float64(documents)), 1), float64(top) / float64(total)
+}
+
+// typeAlias returns the $type alias of a BSON type
+func typeAlias(t bsontype.Type) string {
+	if alias, ok := typeAliases[t]; ok {
+		return alias
+	}
+	return strings.ToLower(t.String())
+}
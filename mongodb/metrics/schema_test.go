@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSchemaBuilder(t *testing.T) {
+	docs := []bson.M{
+		{"name": "a", "age": int32(30), "address": bson.M{"city": "Oslo"}, "tags": bson.A{"x", "y"}},
+		{"name": "b", "age": nil, "items": bson.A{bson.M{"sku": "s1"}, bson.M{"sku": "s2"}}},
+		{"name": "a", "age": int64(41)},
+		{"name": "c"},
+	}
+
+	builder := NewSchemaBuilder()
+	for _, doc := range docs {
+		raw, err := bson.Marshal(doc)
+		require.NoError(t, err)
+		require.NoError(t, builder.Add(raw))
+	}
+
+	schema := builder.Schema(0)
+	assert.Equal(t, int64(4), schema.SampleSize)
+	assert.False(t, schema.Truncated)
+
+	name := schema.Field("name")
+	require.NotNil(t, name)
+	assert.Equal(t, 1.0, name.Presence)
+	assert.Equal(t, int64(3), name.Cardinality)
+	assert.Equal(t, map[string]int64{"string": 4}, name.Types)
+
+	age := schema.Field("age")
+	require.NotNil(t, age)
+	assert.Equal(t, 0.75, age.Presence)
+	assert.InDelta(t, 1.0/3, age.NullRatio, 1e-9)
+	assert.Equal(t, map[string]int64{"int": 1, "long": 1, "null": 1}, age.Types)
+
+	city := schema.Field("address.city")
+	require.NotNil(t, city)
+	assert.Equal(t, 0.25, city.Presence)
+
+	tags := schema.Field("tags")
+	require.NotNil(t, tags)
+	assert.True(t, tags.IsArray)
+	assert.Equal(t, int64(2), tags.Cardinality)
+
+	sku := schema.Field("items.sku")
+	require.NotNil(t, sku)
+	assert.Equal(t, 0.25, sku.Presence)
+	assert.Equal(t, int64(2), sku.Cardinality)
+
+	// "name" is "a" twice and "b" and "c" once: (4+1+1)/(4*4)
+	assert.InDelta(t, 6.0/16, name.Selectivity, 1e-9)
+	assert.Equal(t, 0.5, name.TopValue)
+
+	assert.Nil(t, schema.Field("missing"))
+
+	// Fields are ordered by presence, and cut to the most common
+	assert.Equal(t, "name", schema.Fields[0].Path)
+	truncated := builder.Schema(2)
+	assert.Len(t, truncated.Fields, 2)
+	assert.True(t, truncated.Truncated)
+}
@@ -0,0 +1,285 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+ShardingStats describes a sharded cluster as seen from mongos: its shards, the
+balancer, and how the chunks of each sharded collection are distributed.
+*/
+type ShardingStats struct {
+	Shards      []ShardInfo         `json:"shards"`
+	Balancer    BalancerStats       `json:"balancer"`
+	Collections []ShardedCollection `json:"collections,omitempty"`
+}
+
+// ShardInfo is a shard of the cluster, from listShards
+type ShardInfo struct {
+	ID       string `json:"id" bson:"_id"`
+	Host     string `json:"host" bson:"host"`
+	State    int    `json:"state" bson:"state"`
+	Draining bool   `json:"draining,omitempty" bson:"draining,omitempty"`
+}
+
+/*
+Hosts returns the members of the shard from its connection string, which names the
+replica set of the shard before its hosts, e.g. "rs0/db0:27018,db1:27018".
+*/
+func (shard ShardInfo) Hosts() []string {
+	hosts := shard.Host
+	if i := strings.Index(hosts, "/"); i >= 0 {
+		hosts = hosts[i+1:]
+	}
+	if hosts == "" {
+		return nil
+	}
+	return strings.Split(hosts, ",")
+}
+
+// BalancerStats is the state of the balancer, from balancerStatus
+type BalancerStats struct {
+	Mode              string `json:"mode" bson:"mode"`
+	InBalancerRound   bool   `json:"inBalancerRound" bson:"inBalancerRound"`
+	NumBalancerRounds int64  `json:"numBalancerRounds" bson:"numBalancerRounds"`
+}
+
+/*
+ShardedCollection is a sharded collection with its shard key and the number of
+chunks, and jumbo chunks that cannot be split or moved, on each shard.
+*/
+type ShardedCollection struct {
+	Namespace   string           `json:"namespace"`
+	ShardKey    string           `json:"shardKey"`
+	Key         bson.D           `json:"-"`
+	Unique      bool             `json:"unique,omitempty"`
+	Chunks      map[string]int64 `json:"chunks"`
+	JumboChunks int64            `json:"jumboChunks"`
+	Imbalanced  bool             `json:"imbalanced"` // the chunk counts differ by more than the migration threshold
+}
+
+// shardedCollectionEntry is a document of config.collections
+type shardedCollectionEntry struct {
+	ID      string            `bson:"_id"`
+	Key     bson.D            `bson:"key"`
+	Unique  bool              `bson:"unique"`
+	UUID    *primitive.Binary `bson:"uuid"`
+	Dropped bool              `bson:"dropped"`
+}
+
+/*
+ReadShardingStats reads the shards, the balancer state and the chunk distribution of
+the sharded collections of a database. The client must be connected to mongos.
+*/
+func ReadShardingStats(ctx context.Context, client *mongo.Client, dbName string) (*ShardingStats, error) {
+	shards, err := ListShards(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	stats := &ShardingStats{Shards: shards}
+
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "balancerStatus", Value: 1}}).Decode(&stats.Balancer); err != nil {
+		return nil, fmt.Errorf("failed to get balancer status: %w", err)
+	}
+
+	cursor, err := client.Database("config").Collection("collections").Find(ctx, bson.D{
+		{Key: "_id", Value: primitive.Regex{Pattern: "^" + regexp.QuoteMeta(dbName+".")}},
+		{Key: "dropped", Value: bson.D{{Key: "$ne", Value: true}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sharded collections: %w", err)
+	}
+
+	var entries []shardedCollectionEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode sharded collections: %w", err)
+	}
+
+	shardIDs := make([]string, len(stats.Shards))
+	for i, shard := range stats.Shards {
+		shardIDs[i] = shard.ID
+	}
+
+	for _, entry := range entries {
+		collection, err := readChunkDistribution(ctx, client, entry)
+		if err != nil {
+			return nil, err
+		}
+		collection.Imbalanced = ChunkImbalance(collection.Chunks, shardIDs)
+		stats.Collections = append(stats.Collections, *collection)
+	}
+
+	return stats, nil
+}
+
+/*
+ListShards returns the shards of the cluster. The client must be connected to mongos.
+*/
+func ListShards(ctx context.Context, client *mongo.Client) ([]ShardInfo, error) {
+	var shards struct {
+		Shards []ShardInfo `bson:"shards"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "listShards", Value: 1}}).Decode(&shards); err != nil {
+		return nil, fmt.Errorf("failed to list shards: %w", err)
+	}
+	return shards.Shards, nil
+}
+
+/*
+ReadShardKey returns the shard key of a collection and whether it is unique, or a
+nil key when the collection is not sharded.
+*/
+func ReadShardKey(ctx context.Context, client *mongo.Client, namespace string) (bson.D, bool, error) {
+	var entry shardedCollectionEntry
+	err := client.Database("config").Collection("collections").FindOne(ctx, bson.D{
+		{Key: "_id", Value: namespace},
+		{Key: "dropped", Value: bson.D{{Key: "$ne", Value: true}}},
+	}).Decode(&entry)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read shard key: %w", err)
+	}
+
+	return entry.Key, entry.Unique, nil
+}
+
+/*
+ReadChunkShards returns the shards owning chunks of a sharded collection.
+*/
+func ReadChunkShards(ctx context.Context, client *mongo.Client, namespace string) ([]string, error) {
+	var entry shardedCollectionEntry
+	if err := client.Database("config").Collection("collections").FindOne(ctx, bson.D{
+		{Key: "_id", Value: namespace},
+	}).Decode(&entry); err != nil {
+		return nil, fmt.Errorf("failed to read sharded collection: %w", err)
+	}
+
+	collection, err := readChunkDistribution(ctx, client, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	shards := make([]string, 0, len(collection.Chunks))
+	for shard := range collection.Chunks {
+		shards = append(shards, shard)
+	}
+	sort.Strings(shards)
+
+	return shards, nil
+}
+
+// readChunkDistribution counts the chunks and jumbo chunks of a collection on each shard
+func readChunkDistribution(ctx context.Context, client *mongo.Client, entry shardedCollectionEntry) (*ShardedCollection, error) {
+	shardKey, err := bson.MarshalExtJSON(entry.Key, false, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode shard key: %w", err)
+	}
+
+	collection := &ShardedCollection{
+		Namespace: entry.ID,
+		ShardKey:  string(shardKey),
+		Key:       entry.Key,
+		Unique:    entry.Unique,
+		Chunks:    make(map[string]int64),
+	}
+
+	// Chunks refer to their collection by namespace before 5.0, and by UUID since
+	match := bson.A{bson.D{{Key: "ns", Value: entry.ID}}}
+	if entry.UUID != nil {
+		match = append(match, bson.D{{Key: "uuid", Value: *entry.UUID}})
+	}
+
+	cursor, err := client.Database("config").Collection("chunks").Aggregate(ctx, bson.A{
+		bson.D{{Key: "$match", Value: bson.D{{Key: "$or", Value: match}}}},
+		bson.D{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$shard"},
+			{Key: "chunks", Value: bson.D{{Key: "$sum", Value: 1}}},
+			{Key: "jumbo", Value: bson.D{{Key: "$sum", Value: bson.D{
+				{Key: "$cond", Value: bson.A{bson.D{{Key: "$eq", Value: bson.A{"$jumbo", true}}}, 1, 0}},
+			}}}},
+		}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk distribution of %s: %w", entry.ID, err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var group struct {
+			Shard  string `bson:"_id"`
+			Chunks int64  `bson:"chunks"`
+			Jumbo  int64  `bson:"jumbo"`
+		}
+		if err := cursor.Decode(&group); err != nil {
+			return nil, fmt.Errorf("failed to decode chunk distribution: %w", err)
+		}
+		collection.Chunks[group.Shard] = group.Chunks
+		collection.JumboChunks += group.Jumbo
+	}
+
+	return collection, cursor.Err()
+}
+
+/*
+ChunkImbalance reports whether the chunk counts of a collection across the given
+shards differ by more than the balancer's migration threshold: 2 chunks for fewer
+than 20 chunks, 4 for fewer than 80, and 8 otherwise. Shards without chunks count
+as holding none.
+*/
+func ChunkImbalance(chunks map[string]int64, shards []string) bool {
+	if len(shards) < 2 {
+		return false
+	}
+
+	var total, most int64
+	least := int64(-1)
+	for _, shard := range shards {
+		count := chunks[shard]
+		total += count
+		most = max(most, count)
+		if least < 0 || count < least {
+			least = count
+		}
+	}
+
+	threshold := int64(8)
+	switch {
+	case total < 20:
+		threshold = 2
+	case total < 80:
+		threshold = 4
+	}
+
+	return most-least > threshold
+}
+
+/*
+IsShardKeyPrefix reports whether the shard key is a prefix of an index key, which
+is required of unique indexes on a sharded collection, and makes an index usable
+to support the shard key.
+*/
+func IsShardKeyPrefix(shardKey, indexKey bson.D) bool {
+	if len(shardKey) == 0 || len(shardKey) > len(indexKey) {
+		return false
+	}
+
+	for i, elem := range shardKey {
+		if indexKey[i].Key != elem.Key {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestChunkImbalance(t *testing.T) {
+	shards := []string{"shard0", "shard1", "shard2"}
+
+	tests := []struct {
+		name     string
+		chunks   map[string]int64
+		shards   []string
+		expected bool
+	}{
+		{"balanced", map[string]int64{"shard0": 5, "shard1": 4, "shard2": 4}, shards, false},
+		{"few chunks over threshold", map[string]int64{"shard0": 6, "shard1": 3, "shard2": 3}, shards, true},
+		{"shard without chunks", map[string]int64{"shard0": 3, "shard1": 3}, shards, true},
+		{"many chunks within threshold", map[string]int64{"shard0": 40, "shard1": 34, "shard2": 33}, shards, false},
+		{"many chunks over threshold", map[string]int64{"shard0": 45, "shard1": 30, "shard2": 33}, shards, true},
+		{"single shard", map[string]int64{"shard0": 100}, shards[:1], false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ChunkImbalance(tt.chunks, tt.shards))
+		})
+	}
+}
+
+func TestIsShardKeyPrefix(t *testing.T) {
+	shardKey := bson.D{{Key: "tenant", Value: 1}, {Key: "region", Value: 1}}
+
+	assert.True(t, IsShardKeyPrefix(shardKey, bson.D{{Key: "tenant", Value: 1}, {Key: "region", Value: 1}, {Key: "createdAt", Value: -1}}))
+	assert.False(t, IsShardKeyPrefix(shardKey, bson.D{{Key: "region", Value: 1}, {Key: "tenant", Value: 1}}))
+	assert.False(t, IsShardKeyPrefix(shardKey, bson.D{{Key: "tenant", Value: 1}}))
+	assert.False(t, IsShardKeyPrefix(nil, bson.D{{Key: "tenant", Value: 1}}))
+}
+
+func TestShardedCollectionStats(t *testing.T) {
+	raw, err := bson.Marshal(bson.D{
+		{Key: "ns", Value: "app.orders"},
+		{Key: "sharded", Value: true},
+		{Key: "count", Value: int64(300)},
+		{Key: "shards", Value: bson.D{
+			{Key: "shard0", Value: bson.D{{Key: "ns", Value: "app.orders"}, {Key: "count", Value: int64(200)}}},
+			{Key: "shard1", Value: bson.D{{Key: "ns", Value: "app.orders"}, {Key: "count", Value: int64(100)}}},
+		}},
+	})
+	require.NoError(t, err)
+
+	var stats CollectionStats
+	require.NoError(t, bson.Unmarshal(raw, &stats))
+
+	assert.True(t, stats.Sharded)
+	require.Len(t, stats.Shards, 2)
+	assert.Equal(t, int64(200), stats.Shards["shard0"].Count)
+	assert.Equal(t, int64(100), stats.Shards["shard1"].Count)
+}
+
+func TestShardInfoHosts(t *testing.T) {
+	assert.Equal(t, []string{"db0:27018", "db1:27018"}, ShardInfo{ID: "shard0", Host: "rs0/db0:27018,db1:27018"}.Hosts())
+	assert.Equal(t, []string{"db2:27018"}, ShardInfo{ID: "shard1", Host: "db2:27018"}.Hosts())
+	assert.Empty(t, ShardInfo{ID: "shard2"}.Hosts())
+}
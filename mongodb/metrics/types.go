@@ -1,17 +1,123 @@
 package metrics
 
-import "time"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
 
 // ServerStats represents server-level statistics
 type ServerStats struct {
-	Host              string          `json:"host" bson:"host"`
-	Version           string          `json:"version" bson:"version"`
-	Uptime            float64         `json:"uptime" bson:"uptime"`
-	LocalTime         time.Time       `json:"localTime" bson:"localTime"`
-	Connections       ConnectionStats `json:"connections" bson:"connections"`
-	Memory            MemoryStats     `json:"memory" bson:"memory"`
-	OperationCounts   OpCountStats    `json:"opcounters" bson:"opcounters"`
-	ReplicationStatus RepStats        `json:"replicationStatus" bson:"replicationStatus"`
+	Host              string               `json:"host" bson:"host"`
+	Version           string               `json:"version" bson:"version"`
+	Uptime            float64              `json:"uptime" bson:"uptime"`
+	LocalTime         time.Time            `json:"localTime" bson:"localTime"`
+	Connections       ConnectionStats      `json:"connections" bson:"connections"`
+	Memory            MemoryStats          `json:"memory" bson:"memory"`
+	OperationCounts   OpCountStats         `json:"opcounters" bson:"opcounters"`
+	ReplicationStatus RepStats             `json:"replicationStatus" bson:"replicationStatus"`
+	WiredTiger        *WiredTigerStats     `json:"wiredTiger,omitempty" bson:"wiredTiger,omitempty"`
+	GlobalLock        *GlobalLockStats     `json:"globalLock,omitempty" bson:"globalLock,omitempty"`
+	Locks             map[string]LockStats `json:"locks,omitempty" bson:"locks,omitempty"`
+	Queues            *QueueStats          `json:"queues,omitempty" bson:"queues,omitempty"`
+	FlowControl       *FlowControlStats    `json:"flowControl,omitempty" bson:"flowControl,omitempty"`
+}
+
+/*
+WiredTigerStats holds the storage engine statistics that usually show a bottleneck
+first: cache pressure, eviction by application threads, checkpoint durations and
+the availability of read and write tickets.
+*/
+type WiredTigerStats struct {
+	Cache      WiredTigerCacheStats `json:"cache" bson:"cache"`
+	Checkpoint CheckpointStats      `json:"checkpoint" bson:"transaction"`
+	Tickets    TicketStats          `json:"tickets" bson:"concurrentTransactions"`
+}
+
+// WiredTigerCacheStats tracks the WiredTiger cache
+type WiredTigerCacheStats struct {
+	BytesInCache               int64   `json:"bytesInCache" bson:"bytes currently in the cache"`
+	MaxBytesConfigured         int64   `json:"maxBytesConfigured" bson:"maximum bytes configured"`
+	DirtyBytes                 int64   `json:"dirtyBytes" bson:"tracked dirty bytes in the cache"`
+	PagesReadIntoCache         int64   `json:"pagesReadIntoCache" bson:"pages read into cache"`
+	PagesWrittenFromCache      int64   `json:"pagesWrittenFromCache" bson:"pages written from cache"`
+	UnmodifiedPagesEvicted     int64   `json:"unmodifiedPagesEvicted" bson:"unmodified pages evicted"`
+	ModifiedPagesEvicted       int64   `json:"modifiedPagesEvicted" bson:"modified pages evicted"`
+	ApplicationThreadEvictions int64   `json:"applicationThreadEvictions" bson:"pages evicted by application threads"`
+	FillRatio                  float64 `json:"fillRatio" bson:"-"`  // bytes in cache / configured maximum
+	DirtyRatio                 float64 `json:"dirtyRatio" bson:"-"` // dirty bytes / configured maximum
+}
+
+// CheckpointStats tracks WiredTiger checkpoints
+type CheckpointStats struct {
+	Count            int64 `json:"count" bson:"transaction checkpoints"`
+	Running          int64 `json:"running" bson:"transaction checkpoint currently running"`
+	MostRecentMillis int64 `json:"mostRecentMillis" bson:"transaction checkpoint most recent time (msecs)"`
+	MaxMillis        int64 `json:"maxMillis" bson:"transaction checkpoint max time (msecs)"`
+	TotalMillis      int64 `json:"totalMillis" bson:"transaction checkpoint total time (msecs)"`
+}
+
+// TicketStats tracks the read and write tickets limiting concurrent storage engine transactions
+type TicketStats struct {
+	Read  TicketPool `json:"read" bson:"read"`
+	Write TicketPool `json:"write" bson:"write"`
+}
+
+// TicketPool tracks one kind of ticket
+type TicketPool struct {
+	Out          int64 `json:"out" bson:"out"`
+	Available    int64 `json:"available" bson:"available"`
+	TotalTickets int64 `json:"totalTickets" bson:"totalTickets"`
+}
+
+// GlobalLockStats tracks the operations queued for and holding the global lock
+type GlobalLockStats struct {
+	TotalTime     int64          `json:"totalTime" bson:"totalTime"` // microseconds since the global lock was created
+	CurrentQueue  LockQueueStats `json:"currentQueue" bson:"currentQueue"`
+	ActiveClients LockQueueStats `json:"activeClients" bson:"activeClients"`
+}
+
+// LockQueueStats counts operations by kind
+type LockQueueStats struct {
+	Total   int64 `json:"total" bson:"total"`
+	Readers int64 `json:"readers" bson:"readers"`
+	Writers int64 `json:"writers" bson:"writers"`
+}
+
+/*
+LockStats holds the cumulative counters of one lock resource (Global, Database,
+Collection, ...), by lock mode.
+*/
+type LockStats struct {
+	AcquireCount        LockModeCounts `json:"acquireCount" bson:"acquireCount"`
+	AcquireWaitCount    LockModeCounts `json:"acquireWaitCount" bson:"acquireWaitCount"`
+	TimeAcquiringMicros LockModeCounts `json:"timeAcquiringMicros" bson:"timeAcquiringMicros"`
+	DeadlockCount       LockModeCounts `json:"deadlockCount" bson:"deadlockCount"`
+}
+
+// LockModeCounts holds a counter for each lock mode
+type LockModeCounts struct {
+	IntentShared    int64 `json:"r" bson:"r"`
+	IntentExclusive int64 `json:"w" bson:"w"`
+	Shared          int64 `json:"R" bson:"R"`
+	Exclusive       int64 `json:"W" bson:"W"`
+}
+
+// QueueStats holds the admission queues reported by servers since 7.0
+type QueueStats struct {
+	Execution *TicketStats `json:"execution,omitempty" bson:"execution,omitempty"`
+}
+
+// FlowControlStats tracks flow control, which throttles writes on the primary when secondaries lag
+type FlowControlStats struct {
+	Enabled             bool    `json:"enabled" bson:"enabled"`
+	TargetRateLimit     int64   `json:"targetRateLimit" bson:"targetRateLimit"`
+	TimeAcquiringMicros int64   `json:"timeAcquiringMicros" bson:"timeAcquiringMicros"`
+	LocksPerKiloOp      float64 `json:"locksPerKiloOp" bson:"locksPerKiloOp"`
+	SustainerRate       int64   `json:"sustainerRate" bson:"sustainerRate"`
+	IsLagged            bool    `json:"isLagged" bson:"isLagged"`
+	IsLaggedCount       int64   `json:"isLaggedCount" bson:"isLaggedCount"`
+	IsLaggedTimeMicros  int64   `json:"isLaggedTimeMicros" bson:"isLaggedTimeMicros"`
 }
 
 // ConnectionStats tracks connection metrics
@@ -40,19 +146,48 @@ type OpCountStats struct {
 
 // RepStats tracks replication status
 type RepStats struct {
-	IsReplicaSet bool            `json:"isReplicaSet" bson:"isReplicaSet"`
-	IsMaster     bool            `json:"isMaster" bson:"isMaster"`
-	SetName      string          `json:"setName,omitempty" bson:"setName,omitempty"`
-	Members      []ReplicaMember `json:"members,omitempty" bson:"members,omitempty"`
+	IsReplicaSet bool               `json:"isReplicaSet" bson:"isReplicaSet"`
+	IsMaster     bool               `json:"isMaster" bson:"isMaster"`
+	SetName      string             `json:"setName,omitempty" bson:"set,omitempty"`
+	Date         time.Time          `json:"date" bson:"date"`
+	MyState      int                `json:"myState" bson:"myState"`
+	Term         int64              `json:"term" bson:"term"`
+	Members      []ReplicaMember    `json:"members,omitempty" bson:"members,omitempty"`
+	MaxLag       time.Duration      `json:"maxLag" bson:"-"` // largest lag of a secondary behind the primary
+	Oplog        *OplogStats        `json:"oplog,omitempty" bson:"-"`
+	Events       []ReplicationEvent `json:"events,omitempty" bson:"-"`
 }
 
 // ReplicaMember represents a member in a replica set
 type ReplicaMember struct {
-	Name          string    `json:"name" bson:"name"`
-	Health        bool      `json:"health" bson:"health"`
-	State         int       `json:"state" bson:"state"`
-	StateStr      string    `json:"stateStr" bson:"stateStr"`
-	LastHeartbeat time.Time `json:"lastHeartbeat,omitempty" bson:"lastHeartbeat,omitempty"`
+	Name                 string        `json:"name" bson:"name"`
+	Health               bool          `json:"health" bson:"health"`
+	State                int           `json:"state" bson:"state"`
+	StateStr             string        `json:"stateStr" bson:"stateStr"`
+	Self                 bool          `json:"self,omitempty" bson:"self,omitempty"`
+	OptimeDate           time.Time     `json:"optimeDate,omitempty" bson:"optimeDate,omitempty"`
+	Lag                  time.Duration `json:"lag" bson:"-"` // how far the member is behind the primary
+	ElectionDate         time.Time     `json:"electionDate,omitempty" bson:"electionDate,omitempty"`
+	SyncSourceHost       string        `json:"syncSourceHost,omitempty" bson:"syncSourceHost,omitempty"`
+	LastHeartbeat        time.Time     `json:"lastHeartbeat,omitempty" bson:"lastHeartbeat,omitempty"`
+	LastHeartbeatMessage string        `json:"lastHeartbeatMessage,omitempty" bson:"lastHeartbeatMessage,omitempty"`
+}
+
+// OplogStats describes the oplog: the operations it holds and how far back they go
+type OplogStats struct {
+	First        time.Time `json:"first"`
+	Last         time.Time `json:"last"`
+	WindowHours  float64   `json:"windowHours"` // time between the first and last entry
+	SizeBytes    int64     `json:"sizeBytes"`
+	MaxSizeBytes int64     `json:"maxSizeBytes"`
+}
+
+// ReplicationEvent is a change in the replica set seen between two status reads
+type ReplicationEvent struct {
+	Time    time.Time `json:"time"`
+	Member  string    `json:"member"`
+	Type    string    `json:"type"` // election, health or state
+	Message string    `json:"message"`
 }
 
 // DatabaseStats represents database-level statistics
@@ -68,25 +203,47 @@ type DatabaseStats struct {
 
 // CollectionStats represents collection-level statistics
 type CollectionStats struct {
-	Name         string                `json:"name" bson:"name"`
-	Size         float64               `json:"size" bson:"size"` // in bytes
-	Count        int64                 `json:"count" bson:"count"`
-	AvgObjSize   float64               `json:"avgObjSize" bson:"avgObjSize"`   // in bytes
-	StorageSize  float64               `json:"storageSize" bson:"storageSize"` // in bytes
-	Capped       bool                  `json:"capped" bson:"capped"`
-	MaxSize      float64               `json:"maxSize,omitempty" bson:"maxSize,omitempty"`
-	IndexSizes   map[string]float64    `json:"indexSizes" bson:"indexSizes"`
-	IndexDetails map[string]IndexStats `json:"indexDetails" bson:"indexDetails"`
+	Name         string                      `json:"name" bson:"name"`
+	Size         float64                     `json:"size" bson:"size"` // in bytes
+	Count        int64                       `json:"count" bson:"count"`
+	AvgObjSize   float64                     `json:"avgObjSize" bson:"avgObjSize"`   // in bytes
+	StorageSize  float64                     `json:"storageSize" bson:"storageSize"` // in bytes
+	Capped       bool                        `json:"capped" bson:"capped"`
+	MaxSize      float64                     `json:"maxSize,omitempty" bson:"maxSize,omitempty"`
+	IndexSizes   map[string]float64          `json:"indexSizes" bson:"indexSizes"`
+	IndexDetails map[string]IndexStats       `json:"indexDetails" bson:"indexDetails"`
+	WiredTiger   *CollectionWiredTigerStats  `json:"wiredTiger,omitempty" bson:"wiredTiger,omitempty"`
+	Sharded      bool                        `json:"sharded,omitempty" bson:"sharded,omitempty"`
+	Shards       map[string]*CollectionStats `json:"shards,omitempty" bson:"shards,omitempty"` // per-shard stats of a sharded collection, through mongos
+}
+
+// CollectionWiredTigerStats holds the WiredTiger statistics of a single collection
+type CollectionWiredTigerStats struct {
+	Cache CollectionCacheStats `json:"cache" bson:"cache"`
+}
+
+// CollectionCacheStats tracks the share of the WiredTiger cache used by a collection
+type CollectionCacheStats struct {
+	BytesInCache       int64 `json:"bytesInCache" bson:"bytes currently in the cache"`
+	DirtyBytes         int64 `json:"dirtyBytes" bson:"tracked dirty bytes in the cache"`
+	PagesReadIntoCache int64 `json:"pagesReadIntoCache" bson:"pages read into cache"`
+	PagesRequested     int64 `json:"pagesRequested" bson:"pages requested from the cache"`
 }
 
 // IndexStats represents index statistics
 type IndexStats struct {
-	Name       string  `json:"name" bson:"name"`
-	Size       float64 `json:"size" bson:"size"` // in bytes
-	KeyPattern string  `json:"keyPattern" bson:"keyPattern"`
-	Unique     bool    `json:"unique" bson:"unique"`
-	Sparse     bool    `json:"sparse" bson:"sparse"`
-	UseCount   int64   `json:"useCount" bson:"useCount"`
+	Name         string           `json:"name" bson:"name"`
+	Size         float64          `json:"size" bson:"size"` // in bytes
+	KeyPattern   string           `json:"keyPattern" bson:"keyPattern"`
+	Unique       bool             `json:"unique" bson:"unique"`
+	Sparse       bool             `json:"sparse" bson:"sparse"`
+	IsMultiKey   bool             `json:"isMultiKey" bson:"isMultiKey"`
+	Hidden       bool             `json:"hidden" bson:"hidden"`                                 // hidden from the query planner
+	UseCount     int64            `json:"useCount" bson:"useCount"`                             // total across hosts
+	Since        time.Time        `json:"since" bson:"since"`                                   // start of the usage observation window
+	ObservedFor  time.Duration    `json:"observedFor" bson:"observedFor"`                       // length of the usage observation window
+	Hosts        []IndexHostUsage `json:"hosts,omitempty" bson:"hosts,omitempty"`               // usage per replica set member
+	MissingHosts []string         `json:"missingHosts,omitempty" bson:"missingHosts,omitempty"` // members that could not be read, UseCount leaves out their operations
 }
 
 // PerformanceStats captures detailed performance metrics
@@ -96,6 +253,31 @@ type PerformanceStats struct {
 	ResourceUsage    ResourceUsageStats     `json:"resourceUsage" bson:"resourceUsage"`
 	SlowOperations   []SlowOperation        `json:"slowOperations" bson:"slowOperations"`
 	IndexUtilization []IndexUtilizationStat `json:"indexUtilization" bson:"indexUtilization"`
+	Locks            LockContentionStats    `json:"locks" bson:"locks"`
+}
+
+/*
+LockContentionStats tracks lock queues and waits. Rates are per second since the
+previous collection, and are zero on the first one.
+*/
+type LockContentionStats struct {
+	QueuedReaders              int64                `json:"queuedReaders" bson:"queuedReaders"`
+	QueuedWriters              int64                `json:"queuedWriters" bson:"queuedWriters"`
+	ActiveReaders              int64                `json:"activeReaders" bson:"activeReaders"`
+	ActiveWriters              int64                `json:"activeWriters" bson:"activeWriters"`
+	WaitsPerSecond             map[string]float64   `json:"waitsPerSecond" bson:"waitsPerSecond"`           // by resource and mode, e.g. "Collection.W"
+	WaitMicrosPerSecond        map[string]float64   `json:"waitMicrosPerSecond" bson:"waitMicrosPerSecond"` // by resource and mode
+	FlowControlLagged          bool                 `json:"flowControlLagged" bson:"flowControlLagged"`
+	FlowControlMicrosPerSecond float64              `json:"flowControlMicrosPerSecond" bson:"flowControlMicrosPerSecond"`
+	IndexBuilds                []IndexBuildActivity `json:"indexBuilds,omitempty" bson:"indexBuilds,omitempty"`
+}
+
+// IndexBuildActivity is an index build in progress and the operations waiting on a lock in its namespace
+type IndexBuildActivity struct {
+	OpID              string `json:"opId" bson:"opId"`
+	Namespace         string `json:"namespace" bson:"namespace"`
+	Message           string `json:"message,omitempty" bson:"message,omitempty"`
+	WaitingOperations int64  `json:"waitingOperations" bson:"waitingOperations"`
 }
 
 // LatencyStats tracks operation latency metrics
@@ -160,24 +342,32 @@ type IndexUtilizationStat struct {
 	DatabaseName   string    `json:"databaseName" bson:"databaseName"`
 	CollectionName string    `json:"collectionName" bson:"collectionName"`
 	IndexName      string    `json:"indexName" bson:"indexName"`
+	Host           string    `json:"host" bson:"host"`
 	UsageCount     int64     `json:"usageCount" bson:"usageCount"`
 	SizeBytes      int64     `json:"sizeBytes" bson:"sizeBytes"`
-	LastUsed       time.Time `json:"lastUsed" bson:"lastUsed"`
+	Since          time.Time `json:"since" bson:"since"` // usage is counted from this time
 	IsSparse       bool      `json:"isSparse" bson:"isSparse"`
 	IsUnique       bool      `json:"isUnique" bson:"isUnique"`
 	IsMultiKey     bool      `json:"isMultiKey" bson:"isMultiKey"`
+	Incomplete     bool      `json:"incomplete,omitempty" bson:"incomplete,omitempty"` // some members could not be read
 }
 
 // QueryPatternStats tracks query pattern performance
 type QueryPatternStats struct {
-	Pattern             string        `json:"pattern" bson:"pattern"`
-	ExecutionCount      int64         `json:"executionCount" bson:"executionCount"`
-	AverageLatency      time.Duration `json:"averageLatency" bson:"averageLatency"`
-	IndexesUsed         []string      `json:"indexesUsed" bson:"indexesUsed"`
-	CollectionScans     int64         `json:"collectionScans" bson:"collectionScans"`
-	InMemorySort        int64         `json:"inMemorySort" bson:"inMemorySort"`
-	AverageDocsScanned  int64         `json:"averageDocsScanned" bson:"averageDocsScanned"`
-	AverageKeysExamined int64         `json:"averageKeysExamined" bson:"averageKeysExamined"`
-	AverageDocsReturned int64         `json:"averageDocsReturned" bson:"averageDocsReturned"`
-	LastExecuted        time.Time     `json:"lastExecuted" bson:"lastExecuted"`
+	Pattern             string           `json:"pattern" bson:"pattern"`
+	Namespace           string           `json:"namespace" bson:"namespace"`
+	Operation           string           `json:"operation" bson:"operation"`
+	ExecutionCount      int64            `json:"executionCount" bson:"executionCount"`
+	AverageLatency      time.Duration    `json:"averageLatency" bson:"averageLatency"`
+	MaxLatency          time.Duration    `json:"maxLatency" bson:"maxLatency"`
+	TotalLatency        time.Duration    `json:"totalLatency" bson:"totalLatency"`
+	IndexesUsed         []string         `json:"indexesUsed" bson:"indexesUsed"`
+	CollectionScans     int64            `json:"collectionScans" bson:"collectionScans"`
+	InMemorySort        int64            `json:"inMemorySort" bson:"inMemorySort"`
+	AverageDocsScanned  int64            `json:"averageDocsScanned" bson:"averageDocsScanned"`
+	AverageKeysExamined int64            `json:"averageKeysExamined" bson:"averageKeysExamined"`
+	AverageDocsReturned int64            `json:"averageDocsReturned" bson:"averageDocsReturned"`
+	LastExecuted        time.Time        `json:"lastExecuted" bson:"lastExecuted"`
+	Predicates          *ShapePredicates `json:"predicates,omitempty" bson:"predicates,omitempty"`
+	SampleCommand       bson.D           `json:"-" bson:"-"` // A representative command, used to explain the shape
 }
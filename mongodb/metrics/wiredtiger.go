@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+/*
+DecodeServerStatus decodes the output of serverStatus into ServerStats, and computes
+the derived WiredTiger statistics.
+*/
+func DecodeServerStatus(raw bson.Raw) (*ServerStats, error) {
+	stats := &ServerStats{}
+	if err := bson.Unmarshal(raw, stats); err != nil {
+		return nil, fmt.Errorf("failed to decode server status: %w", err)
+	}
+
+	if stats.WiredTiger != nil {
+		var execution *TicketStats
+		if stats.Queues != nil {
+			execution = stats.Queues.Execution
+		}
+		stats.WiredTiger.finalize(execution)
+	}
+
+	return stats, nil
+}
+
+/*
+finalize computes the cache ratios. Servers since 7.0 report tickets under
+queues.execution instead of wiredTiger.concurrentTransactions, those are used
+when the storage engine section has none.
+*/
+func (w *WiredTigerStats) finalize(execution *TicketStats) {
+	if max := w.Cache.MaxBytesConfigured; max > 0 {
+		w.Cache.FillRatio = float64(w.Cache.BytesInCache) / float64(max)
+		w.Cache.DirtyRatio = float64(w.Cache.DirtyBytes) / float64(max)
+	}
+
+	if execution != nil && w.Tickets == (TicketStats{}) {
+		w.Tickets = *execution
+	}
+}
@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func wiredTigerSection(tickets bool) bson.D {
+	section := bson.D{
+		{Key: "cache", Value: bson.D{
+			{Key: "bytes currently in the cache", Value: int64(750)},
+			{Key: "maximum bytes configured", Value: int64(1000)},
+			{Key: "tracked dirty bytes in the cache", Value: int64(100)},
+			{Key: "pages read into cache", Value: int32(40)},
+			{Key: "pages evicted by application threads", Value: int64(3)},
+		}},
+		{Key: "transaction", Value: bson.D{
+			{Key: "transaction checkpoints", Value: int32(12)},
+			{Key: "transaction checkpoint most recent time (msecs)", Value: int32(250)},
+			{Key: "transaction checkpoint max time (msecs)", Value: int32(900)},
+		}},
+	}
+
+	if tickets {
+		section = append(section, bson.E{Key: "concurrentTransactions", Value: bson.D{
+			{Key: "read", Value: bson.D{{Key: "out", Value: 2}, {Key: "available", Value: 126}, {Key: "totalTickets", Value: 128}}},
+			{Key: "write", Value: bson.D{{Key: "out", Value: 128}, {Key: "available", Value: 0}, {Key: "totalTickets", Value: 128}}},
+		}})
+	}
+
+	return section
+}
+
+func TestDecodeServerStatus(t *testing.T) {
+	t.Run("storage engine tickets", func(t *testing.T) {
+		raw, err := bson.Marshal(bson.D{
+			{Key: "uptime", Value: 10.0},
+			{Key: "wiredTiger", Value: wiredTigerSection(true)},
+		})
+		require.NoError(t, err)
+
+		stats, err := DecodeServerStatus(raw)
+		require.NoError(t, err)
+		require.NotNil(t, stats.WiredTiger)
+
+		cache := stats.WiredTiger.Cache
+		assert.Equal(t, int64(750), cache.BytesInCache)
+		assert.Equal(t, int64(40), cache.PagesReadIntoCache)
+		assert.Equal(t, int64(3), cache.ApplicationThreadEvictions)
+		assert.InDelta(t, 0.75, cache.FillRatio, 0.0001)
+		assert.InDelta(t, 0.1, cache.DirtyRatio, 0.0001)
+
+		assert.Equal(t, int64(12), stats.WiredTiger.Checkpoint.Count)
+		assert.Equal(t, int64(900), stats.WiredTiger.Checkpoint.MaxMillis)
+		assert.Equal(t, int64(0), stats.WiredTiger.Tickets.Write.Available)
+		assert.Equal(t, int64(126), stats.WiredTiger.Tickets.Read.Available)
+	})
+
+	t.Run("execution queue tickets", func(t *testing.T) {
+		raw, err := bson.Marshal(bson.D{
+			{Key: "wiredTiger", Value: wiredTigerSection(false)},
+			{Key: "queues", Value: bson.D{{Key: "execution", Value: bson.D{
+				{Key: "read", Value: bson.D{{Key: "out", Value: 1}, {Key: "available", Value: 7}, {Key: "totalTickets", Value: 8}}},
+				{Key: "write", Value: bson.D{{Key: "out", Value: 0}, {Key: "available", Value: 8}, {Key: "totalTickets", Value: 8}}},
+			}}}},
+		})
+		require.NoError(t, err)
+
+		stats, err := DecodeServerStatus(raw)
+		require.NoError(t, err)
+		assert.Equal(t, TicketPool{Out: 1, Available: 7, TotalTickets: 8}, stats.WiredTiger.Tickets.Read)
+	})
+
+	t.Run("other storage engine", func(t *testing.T) {
+		raw, err := bson.Marshal(bson.D{{Key: "uptime", Value: 10.0}})
+		require.NoError(t, err)
+
+		stats, err := DecodeServerStatus(raw)
+		require.NoError(t, err)
+		assert.Nil(t, stats.WiredTiger)
+	})
+}
+
+func TestCollectionCacheStats(t *testing.T) {
+	raw, err := bson.Marshal(bson.D{
+		{Key: "ns", Value: "app.users"},
+		{Key: "wiredTiger", Value: bson.D{
+			{Key: "type", Value: "file"},
+			{Key: "cache", Value: bson.D{
+				{Key: "bytes currently in the cache", Value: int64(4096)},
+				{Key: "pages requested from the cache", Value: int64(20)},
+			}},
+		}},
+	})
+	require.NoError(t, err)
+
+	var stats CollectionStats
+	require.NoError(t, bson.Unmarshal(raw, &stats))
+	require.NotNil(t, stats.WiredTiger)
+	assert.Equal(t, int64(4096), stats.WiredTiger.Cache.BytesInCache)
+	assert.Equal(t, int64(20), stats.WiredTiger.Cache.PagesRequested)
+}
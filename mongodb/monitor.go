@@ -3,7 +3,10 @@ package mongodb
 import (
 	"context"
 	"fmt"
+	"sort"
+	"time"
 
+	"github.com/theapemachine/lookatthatmongo/logger"
 	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
 	"go.mongodb.org/mongo-driver/bson"
 )
@@ -16,6 +19,16 @@ performance data about the server, databases, collections, and indexes.
 type Monitor struct {
 	conn               *Conn
 	performanceMonitor *metrics.PerformanceMonitor
+	profiling          bool
+	profilerOpts       []metrics.ProfilerOptionFn
+	profiler           *metrics.ProfilerCollector
+	explaining         bool
+	explainerOpts      []metrics.ExplainerOptionFn
+	explainer          *metrics.Explainer
+	sampling           bool
+	samplerOpts        []metrics.SchemaOptionFn
+	sampler            *metrics.SchemaSampler
+	lastReplication    *metrics.RepStats
 }
 
 /*
@@ -37,6 +50,18 @@ func NewMonitor(opts ...MonitorOptionFn) *Monitor {
 
 	if monitor.conn != nil {
 		monitor.performanceMonitor = metrics.NewPerformanceMonitor(monitor.conn.Client)
+
+		if monitor.profiling {
+			monitor.profiler = metrics.NewProfilerCollector(monitor.conn.Client, monitor.profilerOpts...)
+		}
+
+		if monitor.explaining {
+			monitor.explainer = metrics.NewExplainer(monitor.conn.Client, monitor.explainerOpts...)
+		}
+
+		if monitor.sampling {
+			monitor.sampler = metrics.NewSchemaSampler(monitor.conn.Client, monitor.samplerOpts...)
+		}
 	}
 
 	return monitor
@@ -51,29 +76,62 @@ func WithConn(conn *Conn) MonitorOptionFn {
 	}
 }
 
+/*
+WithProfiling is an option function that makes the monitor read the database profiler
+and include the top query shapes in its reports.
+*/
+func WithProfiling(opts ...metrics.ProfilerOptionFn) MonitorOptionFn {
+	return func(m *Monitor) {
+		m.profiling = true
+		m.profilerOpts = opts
+	}
+}
+
+/*
+WithExplain is an option function that makes the monitor explain the top query shapes
+of its reports, with executionStats verbosity.
+*/
+func WithExplain(opts ...metrics.ExplainerOptionFn) MonitorOptionFn {
+	return func(m *Monitor) {
+		m.explaining = true
+		m.explainerOpts = opts
+	}
+}
+
+/*
+WithSchemaSampling is an option function that makes the monitor sample the documents
+of each collection to infer its schema.
+*/
+func WithSchemaSampling(opts ...metrics.SchemaOptionFn) MonitorOptionFn {
+	return func(m *Monitor) {
+		m.sampling = true
+		m.samplerOpts = opts
+	}
+}
+
 /*
 GetServerStats retrieves server-wide statistics from MongoDB.
 It implements the metrics.Monitor interface.
 */
-func (monitor *Monitor) GetServerStats(ctx any) (*metrics.ServerStats, error) {
+func (monitor *Monitor) GetServerStats(ctx context.Context) (*metrics.ServerStats, error) {
 	cmd := bson.D{{Key: "serverStatus", Value: 1}}
-	var result = &metrics.ServerStats{}
 
-	if err := monitor.conn.Database("admin").RunCommand(ctx.(context.Context), cmd).Decode(&result); err != nil {
+	raw, err := monitor.conn.Database("admin").RunCommand(ctx, cmd).Raw()
+	if err != nil {
 		return nil, fmt.Errorf("failed to get server stats: %w", err)
 	}
 
-	return result, nil
+	return metrics.DecodeServerStatus(raw)
 }
 
 /*
 GetDatabaseStats retrieves statistics for a specific database.
 */
-func (monitor *Monitor) GetDatabaseStats(ctx any, dbName string) (*metrics.DatabaseStats, error) {
+func (monitor *Monitor) GetDatabaseStats(ctx context.Context, dbName string) (*metrics.DatabaseStats, error) {
 	var result = &metrics.DatabaseStats{}
 
 	if err := monitor.conn.Database(dbName).RunCommand(
-		ctx.(context.Context),
+		ctx,
 		bson.D{{Key: "dbStats", Value: 1}},
 	).Decode(&result); err != nil {
 		return nil, fmt.Errorf("failed to get database stats: %w", err)
@@ -85,11 +143,11 @@ func (monitor *Monitor) GetDatabaseStats(ctx any, dbName string) (*metrics.Datab
 /*
 GetCollectionStats retrieves statistics for a specific collection.
 */
-func (monitor *Monitor) GetCollectionStats(ctx any, dbName, collName string) (*metrics.CollectionStats, error) {
+func (monitor *Monitor) GetCollectionStats(ctx context.Context, dbName, collName string) (*metrics.CollectionStats, error) {
 	var result = &metrics.CollectionStats{}
 
 	if err := monitor.conn.Database(dbName).RunCommand(
-		ctx.(context.Context),
+		ctx,
 		bson.D{{Key: "collStats", Value: collName}},
 	).Decode(&result); err != nil {
 		return nil, fmt.Errorf("failed to get collection stats: %w", err)
@@ -99,42 +157,207 @@ func (monitor *Monitor) GetCollectionStats(ctx any, dbName, collName string) (*m
 }
 
 /*
-GetIndexStats retrieves statistics for all indexes in a collection.
+GetIndexStats retrieves statistics for all indexes in a collection: the index spec,
+its size from collStats, and its usage from $indexStats on every member of the replica set.
 */
-func (monitor *Monitor) GetIndexStats(ctx any, dbName, collName string) ([]metrics.IndexStats, error) {
-	cursor, err := monitor.conn.Database(dbName).Collection(collName).Indexes().List(ctx.(context.Context))
+func (monitor *Monitor) GetIndexStats(ctx context.Context, dbName, collName string) ([]metrics.IndexStats, error) {
+	cursor, err := monitor.conn.Database(dbName).Collection(collName).Indexes().List(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list indexes: %w", err)
 	}
+	defer cursor.Close(ctx)
 
 	var indexes []metrics.IndexStats
-	for cursor.Next(ctx.(context.Context)) {
-		var idx bson.M
+	for cursor.Next(ctx) {
+		var idx struct {
+			Name   string `bson:"name"`
+			Key    bson.D `bson:"key"`
+			Unique bool   `bson:"unique"`
+			Sparse bool   `bson:"sparse"`
+			Hidden bool   `bson:"hidden"`
+		}
 		if err := cursor.Decode(&idx); err != nil {
 			return nil, fmt.Errorf("failed to decode index: %w", err)
 		}
 
-		indexStats := metrics.IndexStats{
-			Name:       idx["name"].(string),
-			KeyPattern: fmt.Sprintf("%v", idx["key"]),
+		keyPattern, err := bson.MarshalExtJSON(idx.Key, false, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode index key: %w", err)
 		}
 
-		if unique, ok := idx["unique"]; ok {
-			indexStats.Unique = unique.(bool)
+		indexes = append(indexes, metrics.IndexStats{
+			Name:       idx.Name,
+			KeyPattern: string(keyPattern),
+			Unique:     idx.Unique,
+			Sparse:     idx.Sparse,
+			Hidden:     idx.Hidden,
+		})
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list indexes: %w", err)
+	}
+
+	var sizes struct {
+		IndexSizes map[string]float64 `bson:"indexSizes"`
+	}
+	if err := monitor.conn.Database(dbName).RunCommand(
+		ctx,
+		bson.D{{Key: "collStats", Value: collName}},
+	).Decode(&sizes); err != nil {
+		return nil, fmt.Errorf("failed to get index sizes: %w", err)
+	}
+
+	usage, missing, err := monitor.indexUsage(ctx, dbName, collName)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for i := range indexes {
+		index := &indexes[i]
+		index.Size = sizes.IndexSizes[index.Name]
+		metrics.MergeIndexUsage(index, usage[index.Name], now)
+		index.MissingHosts = missing
+
+		// The _id index can never be multikey
+		if index.Name != "_id_" {
+			multiKey, err := metrics.IsMultiKey(ctx, monitor.conn.Client, dbName, collName, index.Name)
+			if err != nil {
+				logger.Debug("Could not determine if index is multikey", "coll", collName, "index", index.Name, "error", err)
+			}
+			index.IsMultiKey = multiKey
 		}
+	}
+
+	return indexes, nil
+}
 
-		if sparse, ok := idx["sparse"]; ok {
-			indexStats.Sparse = sparse.(bool)
+/*
+indexUsage reads $indexStats on every member of the replica set, since each member
+only counts the operations it served itself. When a member cannot be reached, the
+usage of the members that could is returned along with the members that were not read,
+whose operations are missing from the counts.
+*/
+func (monitor *Monitor) indexUsage(ctx context.Context, dbName, collName string) (map[string][]metrics.IndexHostUsage, []string, error) {
+	usage := make(map[string][]metrics.IndexHostUsage)
+
+	add := func(hostUsage map[string][]metrics.IndexHostUsage) {
+		for name, hosts := range hostUsage {
+			usage[name] = append(usage[name], hosts...)
 		}
+	}
 
-		indexes = append(indexes, indexStats)
+	members, err := monitor.conn.Members(ctx)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return indexes, nil
+	if len(members) > 0 {
+		read := 0
+		var missing []string
+		for _, host := range members {
+			client, err := monitor.conn.Member(ctx, host)
+			if err == nil {
+				var hostUsage map[string][]metrics.IndexHostUsage
+				if hostUsage, err = metrics.ReadIndexUsage(ctx, client, dbName, collName); err == nil {
+					add(hostUsage)
+					read++
+					continue
+				}
+			}
+			logger.Warn("Failed to read index usage from replica set member", "host", host, "error", err)
+			missing = append(missing, host)
+		}
+
+		if read > 0 {
+			sort.Strings(missing)
+			return usage, missing, nil
+		}
+	}
+
+	// Not a replica set, or no member could be reached directly. Through mongos, this
+	// reads one member of every shard.
+	hostUsage, err := metrics.ReadIndexUsage(ctx, monitor.conn.Client, dbName, collName)
+	if err != nil {
+		return nil, nil, err
+	}
+	add(hostUsage)
+
+	return usage, unreadMembers(members, usage), nil
+}
+
+// unreadMembers returns the members of a replica set that reported no index usage, sorted
+func unreadMembers(members []string, usage map[string][]metrics.IndexHostUsage) []string {
+	read := make(map[string]bool)
+	for _, hosts := range usage {
+		for _, host := range hosts {
+			read[host.Host] = true
+		}
+	}
+
+	var missing []string
+	for _, member := range members {
+		if !read[member] {
+			missing = append(missing, member)
+		}
+	}
+
+	sort.Strings(missing)
+	return missing
+}
+
+/*
+GetShardingStats retrieves the shards, the balancer state and the chunk distribution
+of the sharded collections of a database. It implements the metrics.ShardingCollector
+interface, and returns nil when the connection does not go through mongos.
+*/
+func (monitor *Monitor) GetShardingStats(ctx context.Context, dbName string) (*metrics.ShardingStats, error) {
+	sharded, err := monitor.conn.IsSharded(ctx)
+	if err != nil || !sharded {
+		return nil, err
+	}
+
+	return metrics.ReadShardingStats(ctx, monitor.conn.Client, dbName)
+}
+
+/*
+GetQueryPatterns retrieves the top query shapes of a database from the profiler.
+It implements the metrics.QueryPatternCollector interface.
+*/
+func (monitor *Monitor) GetQueryPatterns(ctx context.Context, dbName string) ([]metrics.QueryPatternStats, error) {
+	if monitor.profiler == nil {
+		return nil, nil
+	}
+	return monitor.profiler.GetQueryPatterns(ctx, dbName)
+}
+
+/*
+ExplainQueryPatterns explains the representative commands of the top query shapes.
+It implements the metrics.PlanExplainer interface.
+*/
+func (monitor *Monitor) ExplainQueryPatterns(ctx context.Context, patterns []metrics.QueryPatternStats) ([]metrics.QueryPlan, error) {
+	if monitor.explainer == nil {
+		return nil, nil
+	}
+	return monitor.explainer.Explain(ctx, patterns), nil
+}
+
+/*
+GetCollectionSchema infers the schema of a collection from a sample of its documents.
+It implements the metrics.SchemaCollector interface.
+*/
+func (monitor *Monitor) GetCollectionSchema(ctx context.Context, dbName, collName string) (*metrics.CollectionSchema, error) {
+	if monitor.sampler == nil {
+		return nil, nil
+	}
+	return monitor.sampler.Sample(ctx, dbName, collName)
 }
 
 /*
 GetPerformanceStats retrieves performance-related statistics.
+It implements the metrics.PerformanceCollector interface. The performance monitor is
+kept for the lifetime of the monitor, so throughput rates cover the time since the
+previous call.
 */
 func (monitor *Monitor) GetPerformanceStats(ctx context.Context) (*metrics.PerformanceStats, error) {
 	if monitor.performanceMonitor == nil {
@@ -144,17 +367,24 @@ func (monitor *Monitor) GetPerformanceStats(ctx context.Context) (*metrics.Perfo
 }
 
 /*
-GetReplicationStatus retrieves replication status information.
+GetReplicationStatus retrieves the replica set status, with the lag of each member,
+the oplog window, and the elections and health changes since the previous call.
+It implements the metrics.ReplicationCollector interface, and returns nil when the
+server is not a replica set member.
 */
 func (monitor *Monitor) GetReplicationStatus(ctx context.Context) (*metrics.RepStats, error) {
-	var result = &metrics.RepStats{}
+	status, err := metrics.ReadReplicationStatus(ctx, monitor.conn.Client)
+	if err != nil || status == nil {
+		return nil, err
+	}
 
-	if err := monitor.conn.Database("admin").RunCommand(
-		ctx,
-		bson.D{{Key: "replSetGetStatus", Value: 1}},
-	).Decode(&result); err != nil {
-		return nil, fmt.Errorf("failed to get replication status: %w", err)
+	// The oplog is only readable on data-bearing members with access to the local database
+	if status.Oplog, err = metrics.ReadOplogWindow(ctx, monitor.conn.Client); err != nil {
+		logger.Warn("Failed to read oplog window", "error", err)
 	}
 
-	return result, nil
+	status.Events = metrics.ReplicationEvents(monitor.lastReplication, status)
+	monitor.lastReplication = status
+
+	return status, nil
 }
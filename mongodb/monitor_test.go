@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
+	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
 )
 
 func TestNewMonitor(t *testing.T) {
@@ -37,3 +38,26 @@ func TestWithConn(t *testing.T) {
 		})
 	})
 }
+
+func TestUnreadMembers(t *testing.T) {
+	Convey("Given index usage read through a client reaching one member", t, func() {
+		usage := map[string][]metrics.IndexHostUsage{
+			"_id_":     {{Host: "db0:27017", Ops: 10}},
+			"status_1": {{Host: "db0:27017", Ops: 0}},
+		}
+
+		Convey("When the members of the replica set are compared with it", func() {
+			missing := unreadMembers([]string{"db2:27017", "db0:27017", "db1:27017"}, usage)
+
+			Convey("Then the other members should be reported as not read", func() {
+				So(missing, ShouldResemble, []string{"db1:27017", "db2:27017"})
+			})
+		})
+
+		Convey("When the connection is not to a replica set", func() {
+			Convey("Then no member should be missing", func() {
+				So(unreadMembers(nil, usage), ShouldBeEmpty)
+			})
+		})
+	})
+}
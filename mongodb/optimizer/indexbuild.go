@@ -0,0 +1,320 @@
+package optimizer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/theapemachine/lookatthatmongo/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// abortTimeout bounds the commands aborting an index build, which run even when the run was cancelled
+	abortTimeout = 30 * time.Second
+
+	// errIndexNotFound is returned when dropping an index that does not exist
+	errIndexNotFound = 27
+	// errNamespaceNotFound is returned when dropping a collection that does not exist
+	errNamespaceNotFound = 26
+)
+
+/*
+IndexBuildProgress is the progress of an index build, as reported by currentOp. The
+build goes through phases, such as scanning the collection and then inserting the
+keys, and Done and Total count the work of the current phase.
+*/
+type IndexBuildProgress struct {
+	OpID    interface{}   `json:"opId"`
+	Phase   string        `json:"phase"`
+	Done    int64         `json:"done"`
+	Total   int64         `json:"total"`
+	Elapsed time.Duration `json:"elapsed"`
+	At      time.Time     `json:"at"`
+}
+
+/*
+Percent returns how much of the current phase is done, from 0 to 100.
+*/
+func (p *IndexBuildProgress) Percent() float64 {
+	if p.Total <= 0 {
+		return 0
+	}
+	return float64(p.Done) / float64(p.Total) * 100
+}
+
+/*
+ETA estimates the time left in the current phase. The rate is measured since the
+previous poll when it saw the same phase, otherwise it is averaged over the whole
+build. Zero means there is no estimate yet.
+*/
+func (p *IndexBuildProgress) ETA(previous *IndexBuildProgress) time.Duration {
+	remaining := float64(p.Total - p.Done)
+	if remaining <= 0 {
+		return 0
+	}
+
+	if previous != nil && previous.Phase == p.Phase && p.Done > previous.Done && p.At.After(previous.At) {
+		rate := float64(p.Done-previous.Done) / float64(p.At.Sub(previous.At))
+		return time.Duration(remaining / rate)
+	}
+
+	if p.Done > 0 && p.Elapsed > 0 {
+		return time.Duration(float64(p.Elapsed) / float64(p.Done) * remaining)
+	}
+
+	return 0
+}
+
+/*
+WithBuildMonitor polls currentOp every pollInterval while an index is built, logging
+its progress and ETA, and aborts builds running longer than maxDuration. A build is
+also aborted when the run is cancelled, since the server keeps building an index
+after the client stops waiting for it. Zero disables polling or the time limit.
+*/
+func WithBuildMonitor(pollInterval, maxDuration time.Duration) OptimizerOptionFn {
+	return func(o *MongoOptimizer) {
+		o.buildPollInterval = pollInterval
+		o.maxBuildDuration = maxDuration
+	}
+}
+
+/*
+runIndexBuild sends a createIndexes command and watches the build until it returns,
+aborting it when it exceeds the maximum build duration, when the server stays under
+load for longer than the load guard allows, or when the context is cancelled.
+*/
+func (o *MongoOptimizer) runIndexBuild(ctx context.Context, databaseName, collName, indexName string, cmd bson.D) error {
+	guarded := o.loadThresholds.enabled()
+	if o.buildPollInterval <= 0 && o.maxBuildDuration <= 0 && !guarded {
+		return o.runCommand(ctx, databaseName, "apply", cmd)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- o.runCommand(ctx, databaseName, "apply", cmd)
+	}()
+
+	// The load guard checks the server as often as progress is read, or every pause without progress
+	interval := o.buildPollInterval
+	if interval <= 0 && guarded {
+		interval = max(o.loadPause, cpuSampleInterval)
+	}
+
+	var poll <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	var deadline <-chan time.Time
+	if o.maxBuildDuration > 0 {
+		timer := time.NewTimer(o.maxBuildDuration)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	var last *IndexBuildProgress
+	var overloadedSince time.Time
+	for {
+		select {
+		case err := <-done:
+			if ctx.Err() != nil {
+				// The command only stopped waiting, the build itself goes on
+				done <- err
+				return o.abortIndexBuild(ctx, databaseName, collName, indexName, last, done, ctx.Err())
+			}
+			return err
+
+		case <-poll:
+			if guarded {
+				reasons, err := o.checkLoad(ctx)
+				switch {
+				case err != nil:
+					logger.Debug("Could not check server load during index build", "db", databaseName, "coll", collName, "name", indexName, "error", err)
+				case len(reasons) == 0:
+					overloadedSince = time.Time{}
+				case overloadedSince.IsZero():
+					overloadedSince = time.Now()
+					logger.Warn("Server is under load during index build", "db", databaseName, "coll", collName, "name", indexName, "reasons", strings.Join(reasons, "; "))
+				case time.Since(overloadedSince) >= o.loadMaxWait:
+					return o.abortIndexBuild(ctx, databaseName, collName, indexName, last, done,
+						fmt.Errorf("server stayed under load for %s during the build: %s", o.loadMaxWait, strings.Join(reasons, "; ")))
+				}
+			}
+
+			if o.buildPollInterval <= 0 {
+				continue
+			}
+
+			progress, err := o.indexBuildProgress(ctx, databaseName, collName, indexName)
+			if err != nil {
+				logger.Debug("Could not read index build progress", "db", databaseName, "coll", collName, "name", indexName, "error", err)
+				continue
+			}
+			if progress == nil {
+				continue
+			}
+
+			logger.Info("Index build progress",
+				"db", databaseName,
+				"coll", collName,
+				"name", indexName,
+				"phase", progress.Phase,
+				"percent", fmt.Sprintf("%.1f", progress.Percent()),
+				"elapsed", progress.Elapsed.Round(time.Second),
+				"eta", progress.ETA(last).Round(time.Second))
+			last = progress
+
+		case <-deadline:
+			return o.abortIndexBuild(ctx, databaseName, collName, indexName, last, done,
+				fmt.Errorf("index build exceeded the maximum build duration of %s", o.maxBuildDuration))
+
+		case <-ctx.Done():
+			return o.abortIndexBuild(ctx, databaseName, collName, indexName, last, done, ctx.Err())
+		}
+	}
+}
+
+/*
+abortIndexBuild stops an index build in progress. Dropping the index by name aborts
+its build on MongoDB 4.4 and later; when that fails, the build operation is killed.
+It waits for the createIndexes command to return before reporting the abort.
+*/
+func (o *MongoOptimizer) abortIndexBuild(ctx context.Context, databaseName, collName, indexName string, last *IndexBuildProgress, done <-chan error, reason error) error {
+	logger.Warn("Aborting index build", "db", databaseName, "coll", collName, "name", indexName, "reason", reason)
+
+	// The run may have been cancelled, the abort has to go through regardless
+	abortCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), abortTimeout)
+	defer cancel()
+
+	dropErr := o.runCommand(abortCtx, databaseName, "abort", bson.D{
+		{Key: "dropIndexes", Value: collName},
+		{Key: "index", Value: indexName},
+	})
+	if dropErr != nil {
+		logger.Warn("Failed to abort index build by dropping it, killing the operation", "db", databaseName, "coll", collName, "name", indexName, "error", dropErr)
+
+		if last == nil {
+			if progress, err := o.indexBuildProgress(abortCtx, databaseName, collName, indexName); err == nil {
+				last = progress
+			}
+		}
+		if last == nil || last.OpID == nil {
+			return NewOptimizerError(ErrorTypeIndex, fmt.Sprintf("failed to abort index build of '%s'", indexName), dropErr).
+				WithDatabase(databaseName).
+				WithCollection(collName)
+		}
+
+		if err := o.runCommand(abortCtx, "admin", "abort", bson.D{
+			{Key: "killOp", Value: 1},
+			{Key: "op", Value: last.OpID},
+		}); err != nil {
+			return NewOptimizerError(ErrorTypeIndex, fmt.Sprintf("failed to abort index build of '%s'", indexName), err).
+				WithDatabase(databaseName).
+				WithCollection(collName)
+		}
+	}
+
+	select {
+	case <-done:
+	case <-abortCtx.Done():
+		logger.Warn("Timed out waiting for the aborted index build to return", "db", databaseName, "coll", collName, "name", indexName)
+	}
+
+	return NewOptimizerError(ErrorTypeIndex, fmt.Sprintf("index build of '%s' was aborted", indexName), reason).
+		WithDatabase(databaseName).
+		WithCollection(collName)
+}
+
+// indexBuildProgress reads the progress of an index build from currentOp, nil if it is not running
+func (o *MongoOptimizer) indexBuildProgress(ctx context.Context, databaseName, collName, indexName string) (*IndexBuildProgress, error) {
+	var result struct {
+		InProg []bson.M `bson:"inprog"`
+	}
+	if err := o.conn.Database("admin").RunCommand(ctx, bson.D{
+		{Key: "currentOp", Value: true},
+		{Key: "$all", Value: true},
+		{Key: "ns", Value: bson.D{{Key: "$regex", Value: "^" + regexp.QuoteMeta(databaseName+".")}}},
+		{Key: "command.createIndexes", Value: collName},
+		{Key: "command.indexes.name", Value: indexName},
+	}).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to read current operations: %w", err)
+	}
+
+	return parseIndexBuildProgress(result.InProg, time.Now()), nil
+}
+
+/*
+parseIndexBuildProgress picks the operation reporting the progress of a build among
+those building the index. The createIndexes command of the client and the thread
+building the index are both listed, and only the latter reports progress.
+*/
+func parseIndexBuildProgress(ops []bson.M, now time.Time) *IndexBuildProgress {
+	var found *IndexBuildProgress
+	for _, op := range ops {
+		msg, _ := op["msg"].(string)
+		progress, hasProgress := op["progress"].(bson.M)
+
+		if found != nil && (found.Total > 0 || !hasProgress) {
+			continue
+		}
+
+		build := &IndexBuildProgress{
+			OpID:    op["opid"],
+			Phase:   buildPhase(msg),
+			Elapsed: time.Duration(toInt64(op["microsecs_running"])) * time.Microsecond,
+			At:      now,
+		}
+		if hasProgress {
+			build.Done = toInt64(progress["done"])
+			build.Total = toInt64(progress["total"])
+		}
+		found = build
+	}
+
+	return found
+}
+
+// buildPhase returns the phase of an index build from its currentOp message, without the counts
+func buildPhase(msg string) string {
+	// e.g. "Index Build: scanning collection Index Build: scanning collection: 16448/1000000 1%"
+	phase := strings.TrimPrefix(msg, "Index Build: ")
+	if i := strings.Index(phase, " Index Build"); i >= 0 {
+		phase = phase[:i]
+	}
+	if i := strings.Index(phase, ":"); i >= 0 {
+		phase = phase[:i]
+	}
+	return strings.TrimSpace(phase)
+}
+
+// isIndexNotFound reports whether a command failed because the index does not exist
+func isIndexNotFound(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && cmdErr.Code == errIndexNotFound
+}
+
+// isNamespaceNotFound reports whether a command failed because the collection does not exist
+func isNamespaceNotFound(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && cmdErr.Code == errNamespaceNotFound
+}
+
+// toInt64 converts a numeric BSON value
+func toInt64(value interface{}) int64 {
+	switch v := value.(type) {
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	}
+	return 0
+}
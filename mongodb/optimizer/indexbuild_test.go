@@ -0,0 +1,93 @@
+package optimizer
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestParseIndexBuildProgress(t *testing.T) {
+	Convey("Given the operations building an index", t, func() {
+		now := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+		ops := []bson.M{
+			{
+				"opid":              int32(41),
+				"desc":              "conn12",
+				"command":           bson.M{"createIndexes": "orders"},
+				"microsecs_running": int64(60_000_000),
+			},
+			{
+				"opid":              int32(42),
+				"desc":              "IndexBuildsCoordinatorMongod-0",
+				"msg":               "Index Build: scanning collection Index Build: scanning collection: 250000/1000000 25%",
+				"progress":          bson.M{"done": int32(250000), "total": int64(1000000)},
+				"microsecs_running": int64(60_000_000),
+			},
+		}
+
+		Convey("When the progress is parsed", func() {
+			progress := parseIndexBuildProgress(ops, now)
+
+			Convey("Then it should come from the build thread", func() {
+				So(progress, ShouldNotBeNil)
+				So(progress.OpID, ShouldEqual, int32(42))
+				So(progress.Phase, ShouldEqual, "scanning collection")
+				So(progress.Done, ShouldEqual, 250000)
+				So(progress.Total, ShouldEqual, 1000000)
+				So(progress.Percent(), ShouldEqual, 25)
+				So(progress.Elapsed, ShouldEqual, time.Minute)
+			})
+
+			Convey("Then without an earlier poll the ETA should be averaged over the build", func() {
+				So(progress.ETA(nil), ShouldEqual, 3*time.Minute)
+			})
+
+			Convey("Then with an earlier poll of the same phase the ETA should use the recent rate", func() {
+				previous := *progress
+				previous.Done = 150000
+				previous.At = now.Add(-10 * time.Second)
+
+				So(progress.ETA(&previous), ShouldEqual, 75*time.Second)
+			})
+		})
+
+		Convey("When the build is not running", func() {
+			Convey("Then there should be no progress", func() {
+				So(parseIndexBuildProgress(nil, now), ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestWithBuildMonitor(t *testing.T) {
+	Convey("Given an optimizer with a build monitor", t, func() {
+		o := NewOptimizer(WithBuildMonitor(5*time.Second, time.Hour))
+
+		Convey("Then it should poll builds and limit their duration", func() {
+			So(o.buildPollInterval, ShouldEqual, 5*time.Second)
+			So(o.maxBuildDuration, ShouldEqual, time.Hour)
+		})
+	})
+}
+
+func TestIsIndexNotFound(t *testing.T) {
+	Convey("Given errors returned by dropIndexes", t, func() {
+		notFound := mongo.CommandError{Code: errIndexNotFound, Name: "IndexNotFound"}
+
+		Convey("Then a missing index should be recognised, also when wrapped", func() {
+			So(isIndexNotFound(notFound), ShouldBeTrue)
+			So(isIndexNotFound(fmt.Errorf("rollback failed: %w", notFound)), ShouldBeTrue)
+		})
+
+		Convey("Then other errors should not", func() {
+			So(isIndexNotFound(nil), ShouldBeFalse)
+			So(isIndexNotFound(mongo.CommandError{Code: 26, Name: "NamespaceNotFound"}), ShouldBeFalse)
+			So(isIndexNotFound(errors.New("index not found")), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,165 @@
+package optimizer
+
+import (
+	"sort"
+
+	"github.com/theapemachine/lookatthatmongo/ai"
+	"github.com/theapemachine/lookatthatmongo/logger"
+	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
+)
+
+const (
+	// KeyOrderOff leaves the keys of new indexes as suggested
+	KeyOrderOff = "off"
+	// KeyOrderCheck warns about compound keys that do not follow the ESR rule
+	KeyOrderCheck = "check"
+	// KeyOrderReorder rewrites compound keys to follow the ESR rule
+	KeyOrderReorder = "reorder"
+)
+
+/*
+WithKeyOrdering checks the field order of new compound indexes against the ESR rule
+(equality, sort, range) for the query shape they serve best, and with KeyOrderReorder
+rewrites it. Equality and range fields are ordered by the selectivity estimated from
+the sampled schemas, most selective first.
+*/
+func WithKeyOrdering(mode string, patterns []metrics.QueryPatternStats, schemas map[string]*metrics.CollectionSchema) OptimizerOptionFn {
+	return func(o *MongoOptimizer) {
+		o.keyOrder = mode
+		o.keyOrderPatterns = patterns
+		o.keyOrderSchemas = schemas
+	}
+}
+
+/*
+checkKeyOrder returns the keys a new index should be created with: the suggested keys,
+or with KeyOrderReorder their ESR order when it differs.
+*/
+func (o *MongoOptimizer) checkKeyOrder(databaseName string, op ai.IndexOperation) ai.IndexKey {
+	if o.keyOrder != KeyOrderCheck && o.keyOrder != KeyOrderReorder {
+		return op.Keys
+	}
+
+	// Hashed, text, geospatial and wildcard keys have ordering rules of their own
+	if op.Keys.Special() {
+		return op.Keys
+	}
+
+	shape := matchShape(op.Keys, databaseName+"."+op.Collection, o.keyOrderPatterns)
+	if shape == nil {
+		return op.Keys
+	}
+
+	ordered := esrOrder(op.Keys, shape, o.keyOrderSchemas[op.Collection])
+	if ordered.String() == op.Keys.String() {
+		logger.Debug("Index key order follows ESR", "db", databaseName, "coll", op.Collection, "keys", op.Keys.String())
+		return op.Keys
+	}
+
+	if o.keyOrder == KeyOrderCheck {
+		logger.Warn("Index key order does not follow ESR", "db", databaseName, "coll", op.Collection, "keys", op.Keys.String(), "esr", ordered.String())
+		return op.Keys
+	}
+
+	logger.Info("Reordering index keys to follow ESR", "db", databaseName, "coll", op.Collection, "keys", op.Keys.String(), "esr", ordered.String())
+	return ordered
+}
+
+/*
+matchShape returns the predicates of the query shape of a namespace sharing the most
+fields with a compound key, preferring the shapes that took the most time. Returns nil
+for a single field key, or when no shape shares at least two fields with the key.
+*/
+func matchShape(keys ai.IndexKey, namespace string, patterns []metrics.QueryPatternStats) *metrics.ShapePredicates {
+	if len(keys) < 2 {
+		return nil
+	}
+
+	var best *metrics.ShapePredicates
+	bestShared := 1
+	for _, pattern := range patterns {
+		if pattern.Namespace != namespace || pattern.Predicates == nil {
+			continue
+		}
+
+		shared := 0
+		for _, field := range pattern.Predicates.Fields() {
+			if keys.Has(field) {
+				shared++
+			}
+		}
+
+		// Patterns are ordered by total time, so ties go to the costliest shape
+		if shared > bestShared {
+			best, bestShared = pattern.Predicates, shared
+		}
+	}
+
+	return best
+}
+
+/*
+esrOrder orders the fields of a key by the ESR rule for a query shape: equality fields
+first, then sort fields in sort order and direction, then range fields. Equality and
+range fields are ordered by selectivity, fields without a sampled selectivity last.
+Key fields the shape does not use keep their order at the end.
+*/
+func esrOrder(keys ai.IndexKey, shape *metrics.ShapePredicates, schema *metrics.CollectionSchema) ai.IndexKey {
+	ordered := make(ai.IndexKey, 0, len(keys))
+	used := make(map[string]bool)
+
+	add := func(fields []string) {
+		var group ai.IndexKey
+		for _, field := range keys {
+			if !used[field.Field] && containsField(fields, field.Field) {
+				group = append(group, field)
+				used[field.Field] = true
+			}
+		}
+
+		sort.SliceStable(group, func(i, j int) bool {
+			return selectivity(schema, group[i].Field) < selectivity(schema, group[j].Field)
+		})
+		ordered = append(ordered, group...)
+	}
+
+	add(shape.Equality)
+
+	for _, sortField := range shape.Sort {
+		if keys.Has(sortField.Field) && !used[sortField.Field] {
+			ordered = append(ordered, ai.IndexKeyField{Field: sortField.Field, Direction: sortField.Direction})
+			used[sortField.Field] = true
+		}
+	}
+
+	add(shape.Range)
+
+	for _, field := range keys {
+		if !used[field.Field] {
+			ordered = append(ordered, field)
+		}
+	}
+
+	return ordered
+}
+
+// selectivity returns the sampled selectivity of a field, 1 when it is unknown
+func selectivity(schema *metrics.CollectionSchema, path string) float64 {
+	if schema == nil {
+		return 1
+	}
+	if field := schema.Field(path); field != nil && field.Selectivity > 0 {
+		return field.Selectivity
+	}
+	return 1
+}
+
+// containsField reports whether a list of fields holds a field
+func containsField(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package optimizer
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/theapemachine/lookatthatmongo/ai"
+	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
+)
+
+func TestKeyOrder(t *testing.T) {
+	Convey("Given a query shape and the sampled schema of its collection", t, func() {
+		shape := &metrics.ShapePredicates{
+			Equality: []string{"status", "customer"},
+			Sort:     []metrics.SortField{{Field: "createdAt", Direction: -1}},
+			Range:    []string{"total"},
+		}
+		schema := &metrics.CollectionSchema{
+			SampleSize: 1000,
+			Fields: []metrics.FieldSchema{
+				{Path: "status", Selectivity: 0.3},
+				{Path: "customer", Selectivity: 0.001},
+			},
+		}
+
+		Convey("When a compound key puts the range field first", func() {
+			keys := ai.IndexKey{
+				{Field: "total", Direction: 1},
+				{Field: "createdAt", Direction: 1},
+				{Field: "status", Direction: 1},
+				{Field: "customer", Direction: 1},
+				{Field: "note", Direction: 1},
+			}
+			ordered := esrOrder(keys, shape, schema)
+
+			Convey("Then equality fields should come first, most selective first, then sort and range fields", func() {
+				So(ordered.String(), ShouldEqual, "{customer: 1, status: 1, createdAt: -1, total: 1, note: 1}")
+			})
+		})
+
+		Convey("When a key already follows ESR", func() {
+			keys := ai.IndexKey{{Field: "customer", Direction: 1}, {Field: "createdAt", Direction: -1}}
+
+			Convey("Then it should be kept", func() {
+				So(esrOrder(keys, shape, schema), ShouldResemble, keys)
+			})
+		})
+	})
+
+	Convey("Given the query shapes of a database", t, func() {
+		patterns := []metrics.QueryPatternStats{
+			{Namespace: "app.orders", Predicates: &metrics.ShapePredicates{Equality: []string{"status"}, Range: []string{"total"}}},
+			{Namespace: "app.orders", Predicates: &metrics.ShapePredicates{Equality: []string{"customer", "status"}, Range: []string{"total"}}},
+			{Namespace: "app.users", Predicates: &metrics.ShapePredicates{Equality: []string{"customer", "status", "total"}}},
+		}
+
+		Convey("When matching a compound key", func() {
+			keys := ai.IndexKey{{Field: "total", Direction: 1}, {Field: "status", Direction: 1}, {Field: "customer", Direction: 1}}
+
+			Convey("Then the shape of the collection sharing the most fields should be used", func() {
+				So(matchShape(keys, "app.orders", patterns), ShouldEqual, patterns[1].Predicates)
+			})
+		})
+
+		Convey("When matching a single field key", func() {
+			keys := ai.IndexKey{{Field: "status", Direction: 1}}
+
+			Convey("Then no shape should be used", func() {
+				So(matchShape(keys, "app.orders", patterns), ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given an optimizer reordering keys", t, func() {
+		patterns := []metrics.QueryPatternStats{
+			{Namespace: "app.places", Predicates: &metrics.ShapePredicates{Equality: []string{"category"}, Range: []string{"location"}}},
+		}
+		o := NewOptimizer(WithKeyOrdering(KeyOrderReorder, patterns, nil))
+
+		Convey("When the key has a special index type", func() {
+			keys := ai.IndexKey{{Field: "location", Type: ai.IndexTypeSphere2D}, {Field: "category", Direction: 1}}
+			ordered := o.checkKeyOrder("app", ai.IndexOperation{Action: "createIndex", Collection: "places", Keys: keys})
+
+			Convey("Then it should be left as suggested", func() {
+				So(ordered, ShouldResemble, keys)
+			})
+		})
+
+		Convey("When the key is an ascending compound key", func() {
+			keys := ai.IndexKey{{Field: "location", Direction: 1}, {Field: "category", Direction: 1}}
+			ordered := o.checkKeyOrder("app", ai.IndexOperation{Action: "createIndex", Collection: "places", Keys: keys})
+
+			Convey("Then it should be reordered", func() {
+				So(ordered.Fields(), ShouldResemble, []string{"category", "location"})
+			})
+		})
+	})
+}
@@ -0,0 +1,187 @@
+package optimizer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/theapemachine/lookatthatmongo/logger"
+	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// DefaultLoadPause is how long the optimizer waits before checking the load again
+	DefaultLoadPause = 30 * time.Second
+	// DefaultLoadMaxWait is how long the load may stay over its thresholds
+	DefaultLoadMaxWait = 10 * time.Minute
+
+	// cpuSampleInterval separates the two samples the first CPU usage is computed from
+	cpuSampleInterval = time.Second
+)
+
+/*
+LoadThresholds are the limits of the load guard. A zero value disables a limit.
+*/
+type LoadThresholds struct {
+	MaxCPUPercent     float64       // CPU used by mongod, over all cores of the host
+	MaxDirtyPercent   float64       // WiredTiger dirty bytes over the configured cache size
+	MaxReplicationLag time.Duration // largest lag of a secondary
+	MaxQueued         int64         // readers and writers queued for the global lock
+	MaxConnections    int64         // current client connections
+}
+
+// enabled reports whether any limit is set
+func (t LoadThresholds) enabled() bool {
+	return t != LoadThresholds{}
+}
+
+// exceeded returns the limits a load sample is over
+func (t LoadThresholds) exceeded(load *metrics.ServerLoad) []string {
+	var reasons []string
+
+	if t.MaxCPUPercent > 0 && load.HasCPU && load.CPUPercent > t.MaxCPUPercent {
+		reasons = append(reasons, fmt.Sprintf("CPU at %.0f%% exceeds %.0f%%", load.CPUPercent, t.MaxCPUPercent))
+	}
+	if t.MaxDirtyPercent > 0 && load.DirtyPercent > t.MaxDirtyPercent {
+		reasons = append(reasons, fmt.Sprintf("WiredTiger cache %.1f%% dirty exceeds %.1f%%", load.DirtyPercent, t.MaxDirtyPercent))
+	}
+	if t.MaxReplicationLag > 0 && load.ReplicationLag > t.MaxReplicationLag {
+		reasons = append(reasons, fmt.Sprintf("replication lag of %s exceeds %s", load.ReplicationLag, t.MaxReplicationLag))
+	}
+	if queued := load.QueuedReaders + load.QueuedWriters; t.MaxQueued > 0 && queued > t.MaxQueued {
+		reasons = append(reasons, fmt.Sprintf("%d queued readers and writers exceed %d", queued, t.MaxQueued))
+	}
+	if t.MaxConnections > 0 && load.Connections > t.MaxConnections {
+		reasons = append(reasons, fmt.Sprintf("%d connections exceed %d", load.Connections, t.MaxConnections))
+	}
+
+	return reasons
+}
+
+/*
+WithLoadGuard checks the live load of the server before every operation and while
+indexes are built. When the load is over a threshold, the optimizer pauses, checking
+again every pause, for up to maxWait: an optimization whose first operation cannot
+start in that time is deferred, one whose next operation cannot start is stopped
+there, and an index build that stays over a threshold that long is aborted.
+*/
+func WithLoadGuard(thresholds LoadThresholds, pause, maxWait time.Duration) OptimizerOptionFn {
+	return func(o *MongoOptimizer) {
+		o.loadThresholds = thresholds
+		o.loadPause = pause
+		o.loadMaxWait = maxWait
+	}
+}
+
+/*
+waitForLoad blocks until the load of the server is under its thresholds, and returns
+an error when it stays over them for longer than the maximum wait.
+*/
+func (o *MongoOptimizer) waitForLoad(ctx context.Context, databaseName string, operation, operations int) error {
+	if !o.loadThresholds.enabled() {
+		return nil
+	}
+
+	start := time.Now()
+	for {
+		reasons, err := o.checkLoad(ctx)
+		if err != nil {
+			return NewOptimizerError(ErrorTypeValidation, "failed to check server load", err).
+				WithDatabase(databaseName)
+		}
+		if len(reasons) == 0 {
+			logger.Debug("Load guard passed", "db", databaseName, "operation", operation+1)
+			return nil
+		}
+
+		if time.Since(start) >= o.loadMaxWait {
+			message := fmt.Sprintf("deferring optimization, server is under load: %s", strings.Join(reasons, "; "))
+			if operation > 0 {
+				message = fmt.Sprintf("stopping after %d of %d operations, server is under load: %s", operation, operations, strings.Join(reasons, "; "))
+			}
+			return NewOptimizerError(ErrorTypeValidation, message, nil).
+				WithDatabase(databaseName)
+		}
+
+		logger.Warn("Server is under load, pausing before the next operation",
+			"db", databaseName,
+			"operation", operation+1,
+			"reasons", strings.Join(reasons, "; "),
+			"pause", o.loadPause)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(max(o.loadPause, cpuSampleInterval)):
+		}
+	}
+}
+
+/*
+checkLoad samples the load of the server and returns the thresholds it is over. Through
+mongos, serverStatus describes the router, which has no cache and no replication lag of
+its own, so the primary of every shard is sampled instead.
+*/
+func (o *MongoOptimizer) checkLoad(ctx context.Context) ([]string, error) {
+	sharded, err := o.conn.IsSharded(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !sharded {
+		load, err := o.sampleLoad(ctx, o.conn.Client, o.lastLoad)
+		if err != nil {
+			return nil, err
+		}
+
+		o.lastLoad = load
+		return o.loadThresholds.exceeded(load), nil
+	}
+
+	primaries, err := o.conn.ShardPrimaries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if o.lastShardLoads == nil {
+		o.lastShardLoads = make(map[string]*metrics.ServerLoad, len(primaries))
+	}
+
+	var reasons []string
+	for _, shard := range shardNames(primaries) {
+		load, err := o.sampleLoad(ctx, primaries[shard], o.lastShardLoads[shard])
+		if err != nil {
+			return nil, fmt.Errorf("failed to sample the load of shard %s: %w", shard, err)
+		}
+
+		o.lastShardLoads[shard] = load
+		for _, reason := range o.loadThresholds.exceeded(load) {
+			reasons = append(reasons, "shard "+shard+": "+reason)
+		}
+	}
+
+	return reasons, nil
+}
+
+// sampleLoad reads the load of the server a client is connected to
+func (o *MongoOptimizer) sampleLoad(ctx context.Context, client *mongo.Client, previous *metrics.ServerLoad) (*metrics.ServerLoad, error) {
+	load, err := metrics.ReadServerLoad(ctx, client, previous)
+	if err != nil {
+		return nil, err
+	}
+
+	// CPU usage needs two samples, take the second right away the first time
+	if !load.HasCPU && o.loadThresholds.MaxCPUPercent > 0 && load.NumCores > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(cpuSampleInterval):
+		}
+		if load, err = metrics.ReadServerLoad(ctx, client, load); err != nil {
+			return nil, err
+		}
+	}
+
+	return load, nil
+}
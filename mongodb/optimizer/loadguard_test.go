@@ -0,0 +1,63 @@
+package optimizer
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
+)
+
+func TestLoadThresholds(t *testing.T) {
+	Convey("Given load guard thresholds", t, func() {
+		thresholds := LoadThresholds{
+			MaxCPUPercent:     80,
+			MaxDirtyPercent:   10,
+			MaxReplicationLag: 30 * time.Second,
+			MaxQueued:         50,
+		}
+
+		Convey("When the server is quiet", func() {
+			load := &metrics.ServerLoad{CPUPercent: 35, HasCPU: true, DirtyPercent: 2, QueuedReaders: 1, Connections: 5000}
+
+			Convey("Then no threshold should be exceeded", func() {
+				So(thresholds.exceeded(load), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When the server is at a traffic peak", func() {
+			load := &metrics.ServerLoad{
+				CPUPercent:     95,
+				HasCPU:         true,
+				DirtyPercent:   12.5,
+				ReplicationLag: time.Minute,
+				QueuedReaders:  30,
+				QueuedWriters:  40,
+			}
+
+			Convey("Then every exceeded threshold should be reported", func() {
+				reasons := thresholds.exceeded(load)
+				So(reasons, ShouldHaveLength, 4)
+				So(reasons[0], ShouldEqual, "CPU at 95% exceeds 80%")
+				So(reasons[3], ShouldEqual, "70 queued readers and writers exceed 50")
+			})
+		})
+
+		Convey("When CPU usage is not known yet", func() {
+			load := &metrics.ServerLoad{CPUPercent: 0, HasCPU: false}
+
+			Convey("Then it should not count against the server", func() {
+				So(thresholds.exceeded(load), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When no threshold is set", func() {
+			o := NewOptimizer(WithLoadGuard(LoadThresholds{}, time.Second, time.Minute))
+
+			Convey("Then the load guard should be off", func() {
+				So(o.loadThresholds.enabled(), ShouldBeFalse)
+				So(thresholds.enabled(), ShouldBeTrue)
+			})
+		})
+	})
+}
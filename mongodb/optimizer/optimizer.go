@@ -3,8 +3,10 @@ package optimizer
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/theapemachine/lookatthatmongo/ai"
+	"github.com/theapemachine/lookatthatmongo/audit"
 	"github.com/theapemachine/lookatthatmongo/logger"
 	"github.com/theapemachine/lookatthatmongo/mongodb"
 	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
@@ -13,15 +15,59 @@ import (
 
 // MongoOptimizer implements the Optimizer interface for MongoDB
 type MongoOptimizer struct {
-	conn    *mongodb.Conn
-	monitor metrics.Monitor
+	conn       *mongodb.Conn
+	monitor    metrics.Monitor
+	auditLog   *audit.Log
+	provenance audit.Provenance
+
+	// What-if analysis of candidate indexes
+	whatIf           bool
+	whatIfGate       bool
+	whatIfDatabase   string
+	whatIfSampleSize int64
+	whatIfPatterns   []metrics.QueryPatternStats
+	estimates        []IndexEstimate
+
+	// Replication guard for index builds
+	maxReplicationLag time.Duration
+	minOplogWindow    time.Duration
+
+	// Sampled collection schemas new index keys are checked against
+	schemas map[string]*metrics.CollectionSchema
+
+	// Index usage of the report, dropped indexes are checked against
+	indexUsage map[string][]*metrics.IndexStats
+
+	// Drop indexes in stages, hiding them first
+	stagedDrop bool
+
+	// Progress monitoring and time limit of index builds
+	buildPollInterval time.Duration
+	maxBuildDuration  time.Duration
+
+	// Load guard for mutating operations
+	loadThresholds LoadThresholds
+	loadPause      time.Duration
+	loadMaxWait    time.Duration
+	lastLoad       *metrics.ServerLoad
+	lastShardLoads map[string]*metrics.ServerLoad
+
+	// ESR ordering of compound index keys
+	keyOrder         string
+	keyOrderPatterns []metrics.QueryPatternStats
+	keyOrderSchemas  map[string]*metrics.CollectionSchema
 }
 
 type OptimizerOptionFn func(*MongoOptimizer)
 
 // NewOptimizer creates a new MongoDB optimizer
 func NewOptimizer(opts ...OptimizerOptionFn) *MongoOptimizer {
-	opt := &MongoOptimizer{}
+	opt := &MongoOptimizer{
+		whatIfDatabase:   DefaultWhatIfDatabase,
+		whatIfSampleSize: DefaultWhatIfSampleSize,
+		loadPause:        DefaultLoadPause,
+		loadMaxWait:      DefaultLoadMaxWait,
+	}
 	for _, fn := range opts {
 		fn(opt)
 	}
@@ -40,6 +86,26 @@ func WithMonitor(monitor metrics.Monitor) OptimizerOptionFn {
 	}
 }
 
+/*
+WithAuditLog records every mutating command sent to MongoDB in the audit log.
+An entry is written before each command is sent, and another with its outcome.
+*/
+func WithAuditLog(log *audit.Log) OptimizerOptionFn {
+	return func(o *MongoOptimizer) {
+		o.auditLog = log
+	}
+}
+
+/*
+WithProvenance sets who or what triggered the run, and the model and prompt
+behind the suggestion, for the audit log.
+*/
+func WithProvenance(provenance audit.Provenance) OptimizerOptionFn {
+	return func(o *MongoOptimizer) {
+		o.provenance = provenance
+	}
+}
+
 // Apply implements the suggested optimizations
 func (o *MongoOptimizer) Apply(ctx context.Context, databaseName string, suggestion *ai.OptimizationSuggestion) error {
 	if o.conn == nil {
@@ -147,12 +213,18 @@ func (o *MongoOptimizer) Rollback(ctx context.Context, databaseName string, sugg
 
 	logger.Info("Executing rollback plan by reversing operations", "database", databaseName, "category", suggestion.Category)
 
-	// Iterate through operations in reverse order for rollback?
-	// For simplicity now, iterate forward. Order might matter for dependencies.
-	for _, op := range suggestion.Solution.Operations {
+	// Undo the operations that ran, the most recent first
+	operations := suggestion.Solution.Operations
+	for i := len(operations) - 1; i >= 0; i-- {
+		op := operations[i]
 		var rollbackCmd bson.D
 		var description string
 
+		if !op.WasApplied() {
+			logger.Debug("Skipping rollback of an operation that was not applied", "action", op.Action, "coll", op.Collection, "name", op.Name)
+			continue
+		}
+
 		switch op.Action {
 		case "createIndex":
 			// Rollback for createIndex is dropIndex
@@ -160,6 +232,10 @@ func (o *MongoOptimizer) Rollback(ctx context.Context, databaseName string, sugg
 			if op.Options.Name != "" {
 				indexNameToDrop = op.Options.Name
 			}
+			if indexNameToDrop == "" && len(op.Keys) > 0 {
+				// The index was created with the name the server derives from its keys
+				indexNameToDrop = op.Keys.Name()
+			}
 			if op.Collection == "" || indexNameToDrop == "" {
 				logger.Error("Cannot determine rollback for createIndex: missing collection or index name", "operation", op)
 				return NewOptimizerError(ErrorTypeRollback, "Cannot determine rollback for createIndex: missing collection or index name", nil)
@@ -171,32 +247,36 @@ func (o *MongoOptimizer) Rollback(ctx context.Context, databaseName string, sugg
 			description = fmt.Sprintf("dropIndex %s on %s", indexNameToDrop, op.Collection)
 
 		case "dropIndex":
-			// Rollback for dropIndex is createIndex
-			if op.Collection == "" || len(op.Keys) == 0 {
-				logger.Error("Cannot determine rollback for dropIndex: missing collection or keys", "operation", op)
-				return NewOptimizerError(ErrorTypeRollback, "Cannot determine rollback for dropIndex: missing collection or keys", nil)
-			}
-			indexDoc := bson.D{{Key: "key", Value: op.Keys}}
-			if op.Name != "" {
-				indexDoc = append(indexDoc, bson.E{Key: "name", Value: op.Name})
+			// Rollback for dropIndex is createIndex, from the spec captured before the drop
+			var indexDoc bson.D
+			var err error
+			switch {
+			case op.Collection == "":
+				err = fmt.Errorf("missing collection")
+			case op.Stage == StageHidden:
+				// A staged drop has only hidden the index so far, unhiding it is instant
+				rollbackCmd = hideIndexCommand(op.Collection, op.Name, false)
+				description = fmt.Sprintf("unhide index %s on %s", op.Name, op.Collection)
+			case op.Snapshot != "":
+				indexDoc, err = restoreIndexDocument(op.Snapshot)
+			case len(op.Keys) > 0:
+				// Records written before snapshots were taken only have what the suggestion included
+				indexDoc, err = buildIndexDocument(op.Name, op)
+			default:
+				err = fmt.Errorf("no index spec was captured and the operation has no keys")
 			}
-			// Re-apply options if they existed
-			if op.Options.Unique {
-				indexDoc = append(indexDoc, bson.E{Key: "unique", Value: true})
-			}
-			if op.Options.Sparse {
-				indexDoc = append(indexDoc, bson.E{Key: "sparse", Value: true})
-			}
-			if op.Options.ExpireAfterSeconds != nil {
-				indexDoc = append(indexDoc, bson.E{Key: "expireAfterSeconds", Value: *op.Options.ExpireAfterSeconds})
+			if err != nil {
+				logger.Error("Cannot determine rollback for dropIndex", "operation", op, "error", err)
+				return NewOptimizerError(ErrorTypeRollback, "Cannot determine rollback for dropIndex", err)
 			}
-			// Add other options if needed
 
-			rollbackCmd = bson.D{
-				{Key: "createIndexes", Value: op.Collection},
-				{Key: "indexes", Value: bson.A{indexDoc}},
+			if indexDoc != nil {
+				rollbackCmd = bson.D{
+					{Key: "createIndexes", Value: op.Collection},
+					{Key: "indexes", Value: bson.A{indexDoc}},
+				}
+				description = fmt.Sprintf("createIndex %s on %s", op.Name, op.Collection)
 			}
-			description = fmt.Sprintf("createIndex on %s with keys %v", op.Collection, op.Keys)
 
 		default:
 			logger.Warn("Skipping rollback for unsupported action type", "action", op.Action)
@@ -204,7 +284,13 @@ func (o *MongoOptimizer) Rollback(ctx context.Context, databaseName string, sugg
 		}
 
 		logger.Debug("Executing rollback command", "database", databaseName, "description", description, "command_bson", rollbackCmd)
-		if err := o.conn.Database(databaseName).RunCommand(ctx, rollbackCmd).Err(); err != nil {
+		err := o.runCommand(ctx, databaseName, "rollback", rollbackCmd)
+		if op.Action == "createIndex" && isIndexNotFound(err) {
+			// An aborted or failed build leaves no index behind, there is nothing to drop
+			logger.Info("Index to roll back does not exist", "database", databaseName, "description", description)
+			continue
+		}
+		if err != nil {
 			// Should we stop rollback on first error, or try to continue?
 			// For now, stop on first error.
 			logger.Error("Rollback command execution failed", "database", databaseName, "command", rollbackCmd, "error", err)
@@ -223,7 +309,12 @@ func (o *MongoOptimizer) applyIndexOptimization(ctx context.Context, databaseNam
 		return nil
 	}
 
-	for _, op := range suggestion.Solution.Operations {
+	// Rollback only undoes the operations that are marked as applied
+	for i := range suggestion.Solution.Operations {
+		suggestion.Solution.Operations[i].Applied = new(bool)
+	}
+
+	for i, op := range suggestion.Solution.Operations {
 		var cmd bson.D
 		var indexNameForCheck string // Name used for existence checks
 
@@ -242,10 +333,26 @@ func (o *MongoOptimizer) applyIndexOptimization(ctx context.Context, databaseNam
 
 		// Determine the index name to use for checks
 		if op.Action == "createIndex" {
+			if err := op.Keys.Validate(); err != nil {
+				return fmt.Errorf("pre-apply validation failed: invalid index key on collection '%s': %w", op.Collection, err)
+			}
+			if err := op.Options.Validate(op.Keys); err != nil {
+				return fmt.Errorf("pre-apply validation failed: invalid index options on collection '%s': %w", op.Collection, err)
+			}
+
+			// Order compound keys by the ESR rule before anything is derived from them,
+			// recording the keys actually used
+			op.Keys = o.checkKeyOrder(databaseName, op)
+			suggestion.Solution.Operations[i].Keys = op.Keys
+
 			indexNameForCheck = op.Name // Use name from operation if provided
 			if op.Options.Name != "" {
 				indexNameForCheck = op.Options.Name // Override with name from options
 			}
+			if indexNameForCheck == "" {
+				// Name the index as the server would, so its build can be found and aborted
+				indexNameForCheck = op.Keys.Name()
+			}
 		} else if op.Action == "dropIndex" {
 			indexNameForCheck = op.Name
 		}
@@ -268,6 +375,39 @@ func (o *MongoOptimizer) applyIndexOptimization(ctx context.Context, databaseNam
 			// If dropping index and no name provided (shouldn't happen based on struct tags, but check)
 			return fmt.Errorf("pre-apply validation failed: index name is required for dropIndex")
 		}
+
+		// 3. Only drop an index whose usage was read from every member
+		if op.Action == "dropIndex" {
+			if err := o.checkIndexUsage(databaseName, op); err != nil {
+				return err
+			}
+		}
+
+		// 4. Respect the shard key of a sharded collection
+		if err := o.checkShardKey(ctx, databaseName, indexNameForCheck, op); err != nil {
+			return err
+		}
+
+		// 5. Refuse index keys on fields the collection does not have
+		if op.Action == "createIndex" {
+			if err := o.checkSchema(databaseName, op); err != nil {
+				return err
+			}
+		}
+
+		// 6. Make sure secondaries can keep up with an index build
+		if op.Action == "createIndex" {
+			if err := o.checkReplication(ctx, databaseName, op.Collection); err != nil {
+				return err
+			}
+		}
+
+		// 7. Estimate the benefit of a new index on a shadow copy
+		if o.whatIf && op.Action == "createIndex" && len(op.Keys) > 0 {
+			if err := o.checkWhatIf(ctx, databaseName, indexNameForCheck, op); err != nil {
+				return err
+			}
+		}
 		// --- Pre-Apply Validation --- END ---
 
 		// --- Build Command --- START ---
@@ -278,31 +418,36 @@ func (o *MongoOptimizer) applyIndexOptimization(ctx context.Context, databaseNam
 			if op.Collection == "" || len(op.Keys) == 0 {
 				return fmt.Errorf("invalid createIndex operation parameters: missing collection or keys") // Should be caught by schema validation ideally
 			}
-			indexDoc := bson.D{{Key: "key", Value: op.Keys}}
-			if indexNameForCheck != "" {
-				indexDoc = append(indexDoc, bson.E{Key: "name", Value: indexNameForCheck})
-			}
-			// Add options...
-			if op.Options.Unique {
-				indexDoc = append(indexDoc, bson.E{Key: "unique", Value: true})
-			}
-			if op.Options.Sparse {
-				indexDoc = append(indexDoc, bson.E{Key: "sparse", Value: true})
-			}
-			if op.Options.ExpireAfterSeconds != nil {
-				indexDoc = append(indexDoc, bson.E{Key: "expireAfterSeconds", Value: *op.Options.ExpireAfterSeconds})
+			indexDoc, err := buildIndexDocument(indexNameForCheck, op)
+			if err != nil {
+				return fmt.Errorf("invalid createIndex operation options: %w", err)
 			}
 
 			cmd = bson.D{
 				{Key: "createIndexes", Value: op.Collection},
 				{Key: "indexes", Value: bson.A{indexDoc}},
 			}
-			logger.Info("Constructed createIndexes command", "db", databaseName, "coll", op.Collection, "keys", op.Keys, "name", indexNameForCheck)
+			logger.Info("Constructed createIndexes command", "db", databaseName, "coll", op.Collection, "keys", op.Keys.String(), "name", indexNameForCheck)
 
 		case "dropIndex":
 			if op.Collection == "" || indexNameForCheck == "" { // Name checked in validation block already
 				return fmt.Errorf("invalid dropIndex operation parameters: missing collection or index name")
 			}
+
+			// Capture the complete index spec first, so rollback can recreate it exactly
+			snapshot, err := o.snapshotIndex(ctx, databaseName, op.Collection, indexNameForCheck)
+			if err != nil {
+				return fmt.Errorf("refusing to drop index '%s' without capturing its spec: %w", indexNameForCheck, err)
+			}
+			suggestion.Solution.Operations[i].Snapshot = snapshot
+
+			if o.stagedDrop {
+				// The index is only hidden here, a later run drops it if no query regressed
+				cmd = hideIndexCommand(op.Collection, indexNameForCheck, true)
+				logger.Info("Constructed collMod command hiding index", "db", databaseName, "coll", op.Collection, "name", indexNameForCheck)
+				break
+			}
+
 			cmd = bson.D{
 				{Key: "dropIndexes", Value: op.Collection},
 				{Key: "index", Value: indexNameForCheck},
@@ -314,13 +459,31 @@ func (o *MongoOptimizer) applyIndexOptimization(ctx context.Context, databaseNam
 		}
 		// --- Build Command --- END ---
 
+		// Wait for the server to have room for the change
+		if err := o.waitForLoad(ctx, databaseName, i, len(suggestion.Solution.Operations)); err != nil {
+			return err
+		}
+
 		// Apply the constructed command
 		logger.Debug("Executing index command", "database", databaseName, "collection", op.Collection, "command_bson", cmd)
-		if err := o.conn.Database(databaseName).RunCommand(ctx, cmd).Err(); err != nil {
+		if op.Action == "createIndex" {
+			err = o.runIndexBuild(ctx, databaseName, op.Collection, indexNameForCheck, cmd)
+		} else {
+			err = o.runCommand(ctx, databaseName, "apply", cmd)
+		}
+		// A command that failed may still have changed the database, e.g. an index build
+		// that was aborted after the index was created, so it is rolled back as well
+		applied := true
+		suggestion.Solution.Operations[i].Applied = &applied
+		if err != nil {
 			logger.Error("Index command execution failed", "database", databaseName, "collection", op.Collection, "command_bson", cmd, "error", err)
 			return fmt.Errorf("failed to apply index optimization (%s): %w", op.Action, err)
 		}
 
+		if op.Action == "dropIndex" && o.stagedDrop {
+			suggestion.Solution.Operations[i].Stage = StageHidden
+		}
+
 		// Post-Apply Verification (simplified)
 		if verificationName != "" {
 			foundAfter, verifyErr := o.verifyIndexExists(ctx, databaseName, op.Collection, verificationName)
@@ -333,16 +496,87 @@ func (o *MongoOptimizer) applyIndexOptimization(ctx context.Context, databaseNam
 				logger.Error("Post-apply verification failed: index not found after createIndex", "db", databaseName, "coll", op.Collection, "name", verificationName)
 				return fmt.Errorf("index '%s' was not created successfully on %s.%s (verification failed)", verificationName, databaseName, op.Collection)
 			}
-			if op.Action == "dropIndex" && foundAfter {
+			if op.Action == "dropIndex" && foundAfter && !o.stagedDrop {
 				logger.Error("Post-apply verification failed: index still found after dropIndex", "db", databaseName, "coll", op.Collection, "name", verificationName)
 				return fmt.Errorf("index '%s' was not dropped successfully on %s.%s (verification failed)", verificationName, databaseName, op.Collection)
 			}
+			if op.Action == "createIndex" {
+				if err := o.verifyOnAllShards(ctx, databaseName, op.Collection, verificationName); err != nil {
+					return err
+				}
+			}
 			logger.Info("Index operation post-apply verified successfully", "action", op.Action, "db", databaseName, "coll", op.Collection, "name", verificationName, "found_status_after_op", foundAfter)
 		}
 	}
 	return nil
 }
 
+// buildIndexDocument builds the index specification of a createIndex operation
+func buildIndexDocument(name string, op ai.IndexOperation) (bson.D, error) {
+	indexDoc := bson.D{{Key: "key", Value: op.Keys.D()}}
+	if name != "" {
+		indexDoc = append(indexDoc, bson.E{Key: "name", Value: name})
+	}
+
+	options, err := op.Options.D()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(indexDoc, options...), nil
+}
+
+/*
+runCommand sends a mutating command to MongoDB, recording it in the audit log if one is configured.
+The command is not sent if it cannot be recorded first.
+*/
+func (o *MongoOptimizer) runCommand(ctx context.Context, databaseName, action string, cmd bson.D) error {
+	if err := o.audit(ctx, audit.EventIssued, databaseName, action, cmd, nil); err != nil {
+		return fmt.Errorf("failed to record command in audit log, command not sent: %w", err)
+	}
+
+	cmdErr := o.conn.Database(databaseName).RunCommand(ctx, cmd).Err()
+
+	event := audit.EventSucceeded
+	if cmdErr != nil {
+		event = audit.EventFailed
+	}
+	// The command was sent, so its outcome is recorded even when the run was cancelled
+	if err := o.audit(context.WithoutCancel(ctx), event, databaseName, action, cmd, cmdErr); err != nil {
+		logger.Error("Failed to record command outcome in audit log", "database", databaseName, "error", err)
+	}
+
+	return cmdErr
+}
+
+// audit appends an entry for a command to the audit log
+func (o *MongoOptimizer) audit(ctx context.Context, event audit.Event, databaseName, action string, cmd bson.D, cmdErr error) error {
+	if o.auditLog == nil {
+		return nil
+	}
+
+	command, err := bson.MarshalExtJSON(cmd, true, false)
+	if err != nil {
+		return fmt.Errorf("failed to encode command: %w", err)
+	}
+
+	entry := &audit.Entry{
+		Event:      event,
+		Database:   databaseName,
+		Action:     action,
+		Command:    string(command),
+		Provenance: o.provenance,
+	}
+	if len(cmd) > 0 {
+		entry.Operation = cmd[0].Key
+	}
+	if cmdErr != nil {
+		entry.Error = cmdErr.Error()
+	}
+
+	return o.auditLog.Append(ctx, entry)
+}
+
 // checkCollectionExists checks if a collection exists in a database.
 func (o *MongoOptimizer) checkCollectionExists(ctx context.Context, dbName, collName string) (bool, error) {
 	filter := bson.M{"name": collName}
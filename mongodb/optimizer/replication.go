@@ -0,0 +1,133 @@
+package optimizer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/theapemachine/lookatthatmongo/logger"
+	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+WithReplicationGuard refuses to start an index build while a secondary lags the
+primary by more than maxLag, or while the oplog window is shorter than minOplogWindow.
+Index builds are replicated, so a lagging secondary only falls further behind, and a
+secondary that falls out of the oplog window has to resync. Zero disables a check.
+*/
+func WithReplicationGuard(maxLag, minOplogWindow time.Duration) OptimizerOptionFn {
+	return func(o *MongoOptimizer) {
+		o.maxReplicationLag = maxLag
+		o.minOplogWindow = minOplogWindow
+	}
+}
+
+/*
+checkReplication applies the replication guard before an index build. Through mongos,
+the replica set of every shard is checked, since an index build runs on all of them.
+*/
+func (o *MongoOptimizer) checkReplication(ctx context.Context, databaseName, collName string) error {
+	if o.maxReplicationLag <= 0 && o.minOplogWindow <= 0 {
+		return nil
+	}
+
+	sharded, err := o.conn.IsSharded(ctx)
+	if err != nil {
+		return NewOptimizerError(ErrorTypeValidation, "failed to check replication before index build", err).
+			WithDatabase(databaseName).
+			WithCollection(collName)
+	}
+
+	if !sharded {
+		return o.checkReplicaSet(ctx, o.conn.Client, "", databaseName, collName)
+	}
+
+	shards, err := o.conn.ShardPrimaries(ctx)
+	if err != nil {
+		return NewOptimizerError(ErrorTypeValidation, "failed to check replication of the shards before index build", err).
+			WithDatabase(databaseName).
+			WithCollection(collName)
+	}
+
+	for _, name := range shardNames(shards) {
+		if err := o.checkReplicaSet(ctx, shards[name], name, databaseName, collName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkReplicaSet applies the replication guard to the replica set a client is connected to
+func (o *MongoOptimizer) checkReplicaSet(ctx context.Context, client *mongo.Client, shard, databaseName, collName string) error {
+	prefix := ""
+	if shard != "" {
+		prefix = "shard " + shard + ": "
+	}
+
+	status, err := metrics.ReadReplicationStatus(ctx, client)
+	if err != nil {
+		return NewOptimizerError(ErrorTypeValidation, prefix+"failed to check replication before index build", err).
+			WithDatabase(databaseName).
+			WithCollection(collName)
+	}
+	if status == nil {
+		logger.Info("Replication guard skipped, not a replica set", "db", databaseName, "coll", collName, "shard", shard)
+		return nil
+	}
+
+	var oplog *metrics.OplogStats
+	if o.minOplogWindow > 0 {
+		if oplog, err = metrics.ReadOplogWindow(ctx, client); err != nil {
+			return NewOptimizerError(ErrorTypeValidation, prefix+"failed to check oplog window before index build", err).
+				WithDatabase(databaseName).
+				WithCollection(collName)
+		}
+	}
+
+	if err := replicationGuard(status, oplog, o.maxReplicationLag, o.minOplogWindow); err != nil {
+		return NewOptimizerError(ErrorTypeValidation, prefix+err.Error(), nil).
+			WithDatabase(databaseName).
+			WithCollection(collName)
+	}
+
+	logger.Debug("Replication guard passed", "db", databaseName, "coll", collName, "shard", shard, "max_lag", status.MaxLag)
+	return nil
+}
+
+// replicationGuard returns why an index build should not start, or nil
+func replicationGuard(status *metrics.RepStats, oplog *metrics.OplogStats, maxLag, minOplogWindow time.Duration) error {
+	if maxLag > 0 && status.MaxLag > maxLag {
+		for _, member := range status.Members {
+			if member.Lag == status.MaxLag {
+				return fmt.Errorf("replication lag of %s on %s exceeds %s", member.Lag, member.Name, maxLag)
+			}
+		}
+	}
+
+	if minOplogWindow > 0 && oplog != nil {
+		if window, projected := oplogWindow(oplog); window < minOplogWindow {
+			if projected {
+				return fmt.Errorf("projected oplog window of %s is shorter than %s", window.Round(time.Minute), minOplogWindow)
+			}
+			return fmt.Errorf("oplog window of %s is shorter than %s", window.Round(time.Minute), minOplogWindow)
+		}
+	}
+
+	return nil
+}
+
+/*
+oplogWindow returns the time the oplog covers. Until the oplog has grown to its
+maximum size, the time between its first and last entry only says how old the replica
+set is, so the window is projected from the rate it has been filling at.
+*/
+func oplogWindow(oplog *metrics.OplogStats) (time.Duration, bool) {
+	window := time.Duration(oplog.WindowHours * float64(time.Hour))
+	if oplog.SizeBytes <= 0 || oplog.MaxSizeBytes <= 0 || oplog.SizeBytes >= oplog.MaxSizeBytes {
+		return window, false
+	}
+
+	return time.Duration(float64(window) * float64(oplog.MaxSizeBytes) / float64(oplog.SizeBytes)), true
+}
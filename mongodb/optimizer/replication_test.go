@@ -0,0 +1,65 @@
+package optimizer
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
+)
+
+func TestReplicationGuard(t *testing.T) {
+	Convey("Given the replication status of a replica set", t, func() {
+		status := &metrics.RepStats{
+			MaxLag: 45 * time.Second,
+			Members: []metrics.ReplicaMember{
+				{Name: "a:27017", State: metrics.StatePrimary},
+				{Name: "b:27017", State: metrics.StateSecondary, Lag: 45 * time.Second},
+			},
+		}
+		oplog := &metrics.OplogStats{WindowHours: 6, SizeBytes: 990 << 20, MaxSizeBytes: 990 << 20}
+
+		Convey("When a secondary lags more than allowed", func() {
+			err := replicationGuard(status, oplog, 30*time.Second, 0)
+
+			Convey("Then the index build should be refused", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "b:27017")
+			})
+		})
+
+		Convey("When the oplog window is too short", func() {
+			err := replicationGuard(status, oplog, time.Minute, 24*time.Hour)
+
+			Convey("Then the index build should be refused", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "oplog window of 6h0m0s")
+			})
+		})
+
+		Convey("When the oplog has not filled up yet", func() {
+			young := &metrics.OplogStats{WindowHours: 2, SizeBytes: 10 << 20, MaxSizeBytes: 990 << 20}
+
+			Convey("Then the window should be projected from how fast it fills", func() {
+				window, projected := oplogWindow(young)
+				So(projected, ShouldBeTrue)
+				So(window, ShouldEqual, 198*time.Hour)
+				So(replicationGuard(status, young, time.Minute, 24*time.Hour), ShouldBeNil)
+			})
+
+			Convey("Then a projected window that is too short should still be refused", func() {
+				busy := &metrics.OplogStats{WindowHours: 2, SizeBytes: 500 << 20, MaxSizeBytes: 990 << 20}
+				err := replicationGuard(status, busy, time.Minute, 24*time.Hour)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "projected oplog window")
+			})
+		})
+
+		Convey("When lag and oplog window are within limits", func() {
+			Convey("Then the index build should be allowed", func() {
+				So(replicationGuard(status, oplog, time.Minute, time.Hour), ShouldBeNil)
+				So(replicationGuard(status, oplog, 0, 0), ShouldBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,70 @@
+package optimizer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/theapemachine/lookatthatmongo/ai"
+	"github.com/theapemachine/lookatthatmongo/logger"
+	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
+)
+
+/*
+WithSchemas refuses to create indexes on fields that never appear in the sampled
+documents of their collection, using the schemas of the report the suggestion was
+made from. Collections without a schema are not checked, and neither are sparse and
+partial indexes, which are meant for fields too rare to show up in a sample.
+*/
+func WithSchemas(schemas map[string]*metrics.CollectionSchema) OptimizerOptionFn {
+	return func(o *MongoOptimizer) {
+		o.schemas = schemas
+	}
+}
+
+// checkSchema refuses a new index on fields missing from the sampled schema
+func (o *MongoOptimizer) checkSchema(databaseName string, op ai.IndexOperation) error {
+	schema, ok := o.schemas[op.Collection]
+	if !ok || schema == nil {
+		return nil
+	}
+
+	if op.Options.Sparse || op.Options.PartialFilterExpression != "" {
+		logger.Debug("Schema check skipped for a sparse or partial index", "db", databaseName, "coll", op.Collection)
+		return nil
+	}
+
+	missing := missingFields(schema, op.Keys)
+	if len(missing) > 0 {
+		return NewOptimizerError(ErrorTypeValidation,
+			fmt.Sprintf("index keys %s never appear in %d sampled documents", strings.Join(missing, ", "), schema.SampleSize),
+			nil).WithDatabase(databaseName).WithCollection(op.Collection)
+	}
+
+	logger.Debug("Schema check passed", "db", databaseName, "coll", op.Collection, "sample_size", schema.SampleSize)
+	return nil
+}
+
+/*
+missingFields returns the index key fields that never appear in a schema, sorted.
+Nothing is reported for an empty sample or a truncated schema, where a field can be
+missing without being absent from the collection. _id and wildcard keys always pass.
+*/
+func missingFields(schema *metrics.CollectionSchema, keys ai.IndexKey) []string {
+	if schema.SampleSize == 0 || schema.Truncated {
+		return nil
+	}
+
+	var missing []string
+	for _, field := range keys.Fields() {
+		if field == "_id" || ai.IsWildcard(field) {
+			continue
+		}
+		if schema.Field(field) == nil {
+			missing = append(missing, field)
+		}
+	}
+
+	sort.Strings(missing)
+	return missing
+}
@@ -0,0 +1,91 @@
+package optimizer
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/theapemachine/lookatthatmongo/ai"
+	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
+)
+
+func TestMissingFields(t *testing.T) {
+	Convey("Given the sampled schema of a collection", t, func() {
+		schema := &metrics.CollectionSchema{
+			SampleSize: 1000,
+			Fields: []metrics.FieldSchema{
+				{Path: "status", Presence: 1},
+				{Path: "items.sku", Presence: 0.4},
+			},
+		}
+
+		Convey("When the index keys are all in the sample", func() {
+			missing := missingFields(schema, ai.IndexKey{{Field: "status", Direction: 1}, {Field: "items.sku", Direction: -1}, {Field: "_id", Direction: 1}})
+
+			Convey("Then no field should be missing", func() {
+				So(missing, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When index keys never appear in the sample", func() {
+			missing := missingFields(schema, ai.IndexKey{{Field: "status", Direction: 1}, {Field: "stauts", Direction: 1}, {Field: "createdAt", Direction: -1}})
+
+			Convey("Then they should be reported", func() {
+				So(missing, ShouldResemble, []string{"createdAt", "stauts"})
+			})
+		})
+
+		Convey("When the schema was truncated", func() {
+			schema.Truncated = true
+			missing := missingFields(schema, ai.IndexKey{{Field: "createdAt", Direction: 1}})
+
+			Convey("Then nothing should be reported", func() {
+				So(missing, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When the index is a wildcard index", func() {
+			missing := missingFields(schema, ai.IndexKey{{Field: "attributes.$**", Direction: 1}})
+
+			Convey("Then nothing should be reported", func() {
+				So(missing, ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+func TestCheckSchema(t *testing.T) {
+	Convey("Given an optimizer checking index keys against sampled schemas", t, func() {
+		o := NewOptimizer(WithSchemas(map[string]*metrics.CollectionSchema{
+			"orders": {
+				SampleSize: 1000,
+				Fields:     []metrics.FieldSchema{{Path: "status", Presence: 1}},
+			},
+		}))
+		op := ai.IndexOperation{Action: "createIndex", Collection: "orders", Keys: ai.IndexKey{{Field: "refundedAt", Direction: 1}}}
+
+		Convey("When a regular index uses a field missing from the sample", func() {
+			err := o.checkSchema("shop", op)
+
+			Convey("Then the index should be refused", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "refundedAt")
+			})
+		})
+
+		Convey("When a sparse index uses a field missing from the sample", func() {
+			op.Options.Sparse = true
+
+			Convey("Then the index should be allowed", func() {
+				So(o.checkSchema("shop", op), ShouldBeNil)
+			})
+		})
+
+		Convey("When a partial index uses a field missing from the sample", func() {
+			op.Options.PartialFilterExpression = `{"refundedAt": {"$exists": true}}`
+
+			Convey("Then the index should be allowed", func() {
+				So(o.checkSchema("shop", op), ShouldBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,143 @@
+package optimizer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/theapemachine/lookatthatmongo/ai"
+	"github.com/theapemachine/lookatthatmongo/logger"
+	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+shardKey returns the shard key of a collection, or nil when the connection does not
+go through mongos or the collection is not sharded.
+*/
+func (o *MongoOptimizer) shardKey(ctx context.Context, databaseName, collName string) (bson.D, error) {
+	sharded, err := o.conn.IsSharded(ctx)
+	if err != nil || !sharded {
+		return nil, err
+	}
+
+	key, _, err := metrics.ReadShardKey(ctx, o.conn.Client, databaseName+"."+collName)
+	return key, err
+}
+
+/*
+checkShardKey refuses index operations a sharded collection does not allow: a unique
+index that is not prefixed by the shard key, and dropping the last index that supports
+the shard key.
+*/
+func (o *MongoOptimizer) checkShardKey(ctx context.Context, databaseName, indexName string, op ai.IndexOperation) error {
+	shardKey, err := o.shardKey(ctx, databaseName, op.Collection)
+	if err != nil {
+		return fmt.Errorf("failed during shard key check: %w", err)
+	}
+	if shardKey == nil {
+		return nil
+	}
+
+	switch op.Action {
+	case "createIndex":
+		if !op.Options.Unique {
+			return nil
+		}
+
+		if !metrics.IsShardKeyPrefix(shardKey, op.Keys.D()) {
+			return NewOptimizerError(ErrorTypeValidation,
+				fmt.Sprintf("unique index on sharded collection must be prefixed by the shard key %v", shardKey),
+				nil).WithDatabase(databaseName).WithCollection(op.Collection)
+		}
+
+	case "dropIndex":
+		specs, err := o.conn.Database(databaseName).Collection(op.Collection).Indexes().ListSpecifications(ctx)
+		if err != nil {
+			return fmt.Errorf("failed during shard key check: %w", err)
+		}
+
+		supporting := 0
+		dropsSupporting := false
+		for _, spec := range specs {
+			var key bson.D
+			if err := bson.Unmarshal(spec.KeysDocument, &key); err != nil {
+				return fmt.Errorf("failed to decode index key: %w", err)
+			}
+
+			if metrics.IsShardKeyPrefix(shardKey, key) {
+				supporting++
+				if spec.Name == indexName {
+					dropsSupporting = true
+				}
+			}
+		}
+
+		if dropsSupporting && supporting == 1 {
+			return NewOptimizerError(ErrorTypeValidation,
+				fmt.Sprintf("index '%s' is the only index supporting the shard key", indexName),
+				nil).WithDatabase(databaseName).WithCollection(op.Collection)
+		}
+	}
+
+	return nil
+}
+
+/*
+verifyOnAllShards checks that an index created through mongos exists on every shard
+owning chunks of the collection. A shard can fail to build an index while others
+succeed, leaving the collection with inconsistent indexes.
+*/
+func (o *MongoOptimizer) verifyOnAllShards(ctx context.Context, databaseName, collName, indexName string) error {
+	shardKey, err := o.shardKey(ctx, databaseName, collName)
+	if err != nil || shardKey == nil {
+		return err
+	}
+
+	owning, err := metrics.ReadChunkShards(ctx, o.conn.Client, databaseName+"."+collName)
+	if err != nil {
+		return err
+	}
+
+	built, err := metrics.IndexShards(ctx, o.conn.Client, databaseName, collName, indexName)
+	if err != nil {
+		return err
+	}
+
+	if missing := missingShards(owning, built); len(missing) > 0 {
+		return NewOptimizerError(ErrorTypeIndex,
+			fmt.Sprintf("index '%s' is missing on shards %s", indexName, strings.Join(missing, ", ")),
+			nil).WithDatabase(databaseName).WithCollection(collName)
+	}
+
+	logger.Info("Index verified on all shards", "db", databaseName, "coll", collName, "name", indexName, "shards", len(owning))
+	return nil
+}
+
+// missingShards returns the shards in owning that are not in built
+func missingShards(owning, built []string) []string {
+	have := make(map[string]bool, len(built))
+	for _, shard := range built {
+		have[shard] = true
+	}
+
+	var missing []string
+	for _, shard := range owning {
+		if !have[shard] {
+			missing = append(missing, shard)
+		}
+	}
+	return missing
+}
+
+// shardNames returns the names of the shards of a set of shard clients, sorted
+func shardNames(shards map[string]*mongo.Client) []string {
+	names := make([]string, 0, len(shards))
+	for name := range shards {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
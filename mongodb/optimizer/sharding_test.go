@@ -0,0 +1,36 @@
+package optimizer
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestMissingShards(t *testing.T) {
+	Convey("Given the shards owning a collection", t, func() {
+		owning := []string{"shard0", "shard1", "shard2"}
+
+		Convey("When the index was built on every shard", func() {
+			Convey("Then no shard should be missing", func() {
+				So(missingShards(owning, []string{"shard0", "shard1", "shard2"}), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When a shard failed to build the index", func() {
+			Convey("Then that shard should be reported", func() {
+				So(missingShards(owning, []string{"shard0", "shard2"}), ShouldResemble, []string{"shard1"})
+			})
+		})
+	})
+}
+
+func TestShardNames(t *testing.T) {
+	Convey("Given clients connected to the primary of every shard", t, func() {
+		shards := map[string]*mongo.Client{"shard2": nil, "shard0": nil, "shard1": nil}
+
+		Convey("Then the shards should be visited in a stable order", func() {
+			So(shardNames(shards), ShouldResemble, []string{"shard0", "shard1", "shard2"})
+		})
+	})
+}
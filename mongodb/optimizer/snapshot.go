@@ -0,0 +1,77 @@
+package optimizer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/theapemachine/lookatthatmongo/ai"
+	"github.com/theapemachine/lookatthatmongo/logger"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+/*
+snapshotIndex returns the complete specification of an index, as listed by
+listIndexes, to record with a drop operation. A dropped index is recreated from it
+on rollback, with identical options, whatever the suggestion included.
+*/
+func (o *MongoOptimizer) snapshotIndex(ctx context.Context, databaseName, collName, indexName string) (ai.Document, error) {
+	cursor, err := o.conn.Database(databaseName).Collection(collName).Indexes().List(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to list indexes: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var spec bson.D
+		if err := cursor.Decode(&spec); err != nil {
+			return "", fmt.Errorf("failed to decode index spec: %w", err)
+		}
+
+		if specName(spec) == indexName {
+			logger.Debug("Captured index spec before drop", "db", databaseName, "coll", collName, "name", indexName)
+			return ai.NewDocument(spec)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return "", fmt.Errorf("failed to list indexes: %w", err)
+	}
+
+	return "", fmt.Errorf("index '%s' not found on %s.%s", indexName, databaseName, collName)
+}
+
+/*
+restoreIndexDocument returns the createIndexes specification that recreates an index
+from its snapshot. Only the namespace, listed by servers before 4.4 and refused by
+createIndexes, is left out.
+*/
+func restoreIndexDocument(snapshot ai.Document) (bson.D, error) {
+	spec, err := snapshot.D()
+	if err != nil {
+		return nil, err
+	}
+
+	return specIndexDocument(spec), nil
+}
+
+// specIndexDocument turns an index spec listed by listIndexes into a createIndexes specification
+func specIndexDocument(spec bson.D) bson.D {
+	indexDoc := make(bson.D, 0, len(spec))
+	for _, elem := range spec {
+		if elem.Key != "ns" {
+			indexDoc = append(indexDoc, elem)
+		}
+	}
+
+	return indexDoc
+}
+
+// specName returns the name of an index from its spec
+func specName(spec bson.D) string {
+	for _, elem := range spec {
+		if elem.Key == "name" {
+			name, _ := elem.Value.(string)
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,61 @@
+package optimizer
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/theapemachine/lookatthatmongo/ai"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestRestoreIndexDocument(t *testing.T) {
+	Convey("Given the spec of an index listed before it was dropped", t, func() {
+		spec := bson.D{
+			{Key: "v", Value: int32(2)},
+			{Key: "key", Value: bson.D{{Key: "status", Value: int32(1)}, {Key: "createdAt", Value: int64(-1)}}},
+			{Key: "name", Value: "status_1_createdAt_-1"},
+			{Key: "ns", Value: "app.orders"},
+			{Key: "partialFilterExpression", Value: bson.D{{Key: "total", Value: bson.D{{Key: "$gt", Value: 100.5}}}}},
+			{Key: "collation", Value: bson.D{{Key: "locale", Value: "en"}, {Key: "strength", Value: int32(2)}}},
+			{Key: "hidden", Value: true},
+		}
+		snapshot, err := ai.NewDocument(spec)
+		So(err, ShouldBeNil)
+
+		Convey("When it is stored with the record and read back", func() {
+			op := ai.IndexOperation{Action: "dropIndex", Collection: "orders", Name: "status_1_createdAt_-1", Snapshot: snapshot}
+			data, err := json.Marshal(op)
+			So(err, ShouldBeNil)
+
+			var stored ai.IndexOperation
+			So(json.Unmarshal(data, &stored), ShouldBeNil)
+
+			indexDoc, err := restoreIndexDocument(stored.Snapshot)
+			So(err, ShouldBeNil)
+
+			Convey("Then the index should be recreated with the identical spec, without its namespace", func() {
+				expected := append(append(bson.D{}, spec[:3]...), spec[4:]...)
+				So(indexDoc, ShouldResemble, expected)
+			})
+		})
+	})
+}
+
+func TestSpecIndexDocument(t *testing.T) {
+	Convey("Given an index spec listed to copy it to a shadow collection", t, func() {
+		spec := bson.D{
+			{Key: "v", Value: int32(2)},
+			{Key: "key", Value: bson.D{{Key: "email", Value: int32(1)}}},
+			{Key: "name", Value: "email_1"},
+			{Key: "ns", Value: "app.users"},
+			{Key: "unique", Value: true},
+			{Key: "partialFilterExpression", Value: bson.D{{Key: "active", Value: true}}},
+		}
+
+		Convey("Then it should be named and copied with every option but its namespace", func() {
+			So(specName(spec), ShouldEqual, "email_1")
+			So(specIndexDocument(spec), ShouldResemble, append(append(bson.D{}, spec[:3]...), spec[4:]...))
+		})
+	})
+}
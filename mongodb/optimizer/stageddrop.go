@@ -0,0 +1,81 @@
+package optimizer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/theapemachine/lookatthatmongo/logger"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// StageHidden marks a dropIndex operation that has hidden the index, pending its drop
+const StageHidden = "hidden"
+
+/*
+WithStagedDrop makes dropIndex operations hide the index with collMod instead of
+dropping it. Hidden indexes are maintained but not used by the query planner, so the
+effect of a drop can be observed, and undone instantly by unhiding the index. The
+tracker drops the index once it has been hidden long enough without regressions.
+Hidden indexes need MongoDB 4.4 or later.
+*/
+func WithStagedDrop(enabled bool) OptimizerOptionFn {
+	return func(o *MongoOptimizer) {
+		o.stagedDrop = enabled
+	}
+}
+
+/*
+SetIndexHidden hides or unhides an index.
+*/
+func (o *MongoOptimizer) SetIndexHidden(ctx context.Context, databaseName, collName, indexName string, hidden bool) error {
+	if o.conn == nil {
+		return NewOptimizerError(ErrorTypeConnection, "MongoDB connection is nil", nil)
+	}
+
+	action := "apply"
+	if !hidden {
+		action = "rollback"
+	}
+
+	if err := o.runCommand(ctx, databaseName, action, hideIndexCommand(collName, indexName, hidden)); err != nil {
+		return NewOptimizerError(ErrorTypeIndex, fmt.Sprintf("failed to set index '%s' hidden to %t", indexName, hidden), err).
+			WithDatabase(databaseName).
+			WithCollection(collName)
+	}
+
+	logger.Info("Changed index visibility", "db", databaseName, "coll", collName, "name", indexName, "hidden", hidden)
+	return nil
+}
+
+/*
+DropIndex drops an index at the end of a staged drop.
+*/
+func (o *MongoOptimizer) DropIndex(ctx context.Context, databaseName, collName, indexName string) error {
+	if o.conn == nil {
+		return NewOptimizerError(ErrorTypeConnection, "MongoDB connection is nil", nil)
+	}
+
+	cmd := bson.D{
+		{Key: "dropIndexes", Value: collName},
+		{Key: "index", Value: indexName},
+	}
+	if err := o.runCommand(ctx, databaseName, "stagedDrop", cmd); err != nil {
+		return NewOptimizerError(ErrorTypeIndex, fmt.Sprintf("failed to drop hidden index '%s'", indexName), err).
+			WithDatabase(databaseName).
+			WithCollection(collName)
+	}
+
+	logger.Info("Dropped hidden index", "db", databaseName, "coll", collName, "name", indexName)
+	return nil
+}
+
+// hideIndexCommand returns the collMod command hiding or unhiding an index
+func hideIndexCommand(collName, indexName string, hidden bool) bson.D {
+	return bson.D{
+		{Key: "collMod", Value: collName},
+		{Key: "index", Value: bson.D{
+			{Key: "name", Value: indexName},
+			{Key: "hidden", Value: hidden},
+		}},
+	}
+}
@@ -0,0 +1,31 @@
+package optimizer
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestHideIndexCommand(t *testing.T) {
+	Convey("Given an index being dropped in stages", t, func() {
+		Convey("When it is hidden", func() {
+			cmd := hideIndexCommand("orders", "status_1", true)
+
+			Convey("Then collMod should hide it by name", func() {
+				So(cmd, ShouldResemble, bson.D{
+					{Key: "collMod", Value: "orders"},
+					{Key: "index", Value: bson.D{{Key: "name", Value: "status_1"}, {Key: "hidden", Value: true}}},
+				})
+			})
+		})
+
+		Convey("When the optimizer is configured for staged drops", func() {
+			o := NewOptimizer(WithStagedDrop(true))
+
+			Convey("Then drops should only hide indexes", func() {
+				So(o.stagedDrop, ShouldBeTrue)
+			})
+		})
+	})
+}
@@ -0,0 +1,42 @@
+package optimizer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/theapemachine/lookatthatmongo/ai"
+	"github.com/theapemachine/lookatthatmongo/logger"
+	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
+)
+
+/*
+WithIndexUsage refuses to drop indexes whose usage could not be read from every member
+of the replica set, using the indexes of the report the suggestion was made from. The
+use count of such an index leaves out the members that were not read, so an index that
+looks unused may well serve their queries.
+*/
+func WithIndexUsage(indexes map[string][]*metrics.IndexStats) OptimizerOptionFn {
+	return func(o *MongoOptimizer) {
+		o.indexUsage = indexes
+	}
+}
+
+// checkIndexUsage refuses to drop an index whose usage is incomplete
+func (o *MongoOptimizer) checkIndexUsage(databaseName string, op ai.IndexOperation) error {
+	for _, index := range o.indexUsage[op.Collection] {
+		if index == nil || index.Name != op.Name {
+			continue
+		}
+
+		if len(index.MissingHosts) > 0 {
+			return NewOptimizerError(ErrorTypeValidation,
+				fmt.Sprintf("refusing to drop index '%s', its usage could not be read from %s", op.Name, strings.Join(index.MissingHosts, ", ")),
+				nil).WithDatabase(databaseName).WithCollection(op.Collection)
+		}
+
+		logger.Debug("Index usage check passed", "db", databaseName, "coll", op.Collection, "name", op.Name, "use_count", index.UseCount)
+		return nil
+	}
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package optimizer
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/theapemachine/lookatthatmongo/ai"
+	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
+)
+
+func TestCheckIndexUsage(t *testing.T) {
+	Convey("Given the index usage of a report", t, func() {
+		o := NewOptimizer(WithIndexUsage(map[string][]*metrics.IndexStats{
+			"orders": {
+				{Name: "status_1", UseCount: 0},
+				{Name: "region_1", UseCount: 0, MissingHosts: []string{"analytics:27017"}},
+			},
+		}))
+
+		Convey("When the usage of the index was read from every member", func() {
+			err := o.checkIndexUsage("shop", ai.IndexOperation{Action: "dropIndex", Collection: "orders", Name: "status_1"})
+
+			Convey("Then the drop should be allowed", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When a member could not be read", func() {
+			err := o.checkIndexUsage("shop", ai.IndexOperation{Action: "dropIndex", Collection: "orders", Name: "region_1"})
+
+			Convey("Then the drop should be refused", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "analytics:27017")
+			})
+		})
+
+		Convey("When the index is not in the report", func() {
+			err := o.checkIndexUsage("shop", ai.IndexOperation{Action: "dropIndex", Collection: "users", Name: "email_1"})
+
+			Convey("Then the drop should be allowed", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,360 @@
+package optimizer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/theapemachine/lookatthatmongo/ai"
+	"github.com/theapemachine/lookatthatmongo/logger"
+	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	// DefaultWhatIfDatabase is the scratch database holding shadow collections
+	DefaultWhatIfDatabase = "lookatthatmongo_whatif"
+	// DefaultWhatIfSampleSize is the number of documents copied into a shadow collection
+	DefaultWhatIfSampleSize = 10000
+)
+
+/*
+ShapeEstimate is the effect of a candidate index on one query shape, measured by
+explaining the shape on a shadow collection before and after building the index.
+*/
+type ShapeEstimate struct {
+	Pattern           string            `json:"pattern"`
+	Before            metrics.QueryPlan `json:"before"`
+	After             metrics.QueryPlan `json:"after"`
+	KeysExaminedDelta int64             `json:"keysExaminedDelta"` // after minus before
+	DocsExaminedDelta int64             `json:"docsExaminedDelta"` // after minus before
+	UsesIndex         bool              `json:"usesIndex"`         // whether the winning plan uses the candidate index
+}
+
+/*
+IndexEstimate is the result of a what-if analysis of a candidate index.
+*/
+type IndexEstimate struct {
+	Collection string          `json:"collection"`
+	Index      string          `json:"index"`
+	Keys       ai.IndexKey     `json:"keys"`
+	SampleSize int64           `json:"sampleSize"`
+	Shapes     []ShapeEstimate `json:"shapes"`
+}
+
+/*
+Improves reports whether the candidate index reduces the keys or documents examined
+by at least one affected query shape, without increasing the work of the others.
+*/
+func (e *IndexEstimate) Improves() bool {
+	improved := false
+	for _, shape := range e.Shapes {
+		total := shape.KeysExaminedDelta + shape.DocsExaminedDelta
+		if total > 0 {
+			return false
+		}
+		if total < 0 {
+			improved = true
+		}
+	}
+	return improved
+}
+
+/*
+WithWhatIf estimates the benefit of every index before it is created, by building it
+on a sampled shadow copy of the collection in a scratch database and explaining the
+given query shapes there. With gate set, an index without a measured benefit is not created.
+*/
+func WithWhatIf(patterns []metrics.QueryPatternStats, sampleSize int64, gate bool) OptimizerOptionFn {
+	return func(o *MongoOptimizer) {
+		o.whatIf = true
+		o.whatIfPatterns = patterns
+		o.whatIfSampleSize = sampleSize
+		o.whatIfGate = gate
+	}
+}
+
+/*
+WithWhatIfDatabase sets the scratch database holding shadow collections.
+*/
+func WithWhatIfDatabase(name string) OptimizerOptionFn {
+	return func(o *MongoOptimizer) {
+		o.whatIfDatabase = name
+	}
+}
+
+/*
+Estimates returns the what-if results of the indexes considered by Apply. They are
+stored with the optimization records of the run.
+*/
+func (o *MongoOptimizer) Estimates() []IndexEstimate {
+	return o.estimates
+}
+
+// checkWhatIf runs the what-if analysis for a createIndex operation and applies the gate
+func (o *MongoOptimizer) checkWhatIf(ctx context.Context, databaseName, indexName string, op ai.IndexOperation) error {
+	estimate, err := o.estimateIndex(ctx, databaseName, indexName, op)
+	if err != nil {
+		if o.whatIfGate {
+			return NewOptimizerError(ErrorTypeValidation, "what-if analysis failed", err).
+				WithDatabase(databaseName).
+				WithCollection(op.Collection)
+		}
+		logger.Warn("What-if analysis failed, continuing without estimate", "database", databaseName, "coll", op.Collection, "error", err)
+		return nil
+	}
+
+	o.estimates = append(o.estimates, *estimate)
+
+	for _, shape := range estimate.Shapes {
+		logger.Info("What-if estimate",
+			"coll", op.Collection,
+			"index", estimate.Index,
+			"pattern", shape.Pattern,
+			"uses_index", shape.UsesIndex,
+			"keys_examined", fmt.Sprintf("%d -> %d", shape.Before.KeysExamined, shape.After.KeysExamined),
+			"docs_examined", fmt.Sprintf("%d -> %d", shape.Before.DocsExamined, shape.After.DocsExamined))
+	}
+
+	if o.whatIfGate && !estimate.Improves() {
+		return NewOptimizerError(ErrorTypeValidation,
+			fmt.Sprintf("what-if analysis found no benefit for index on %s (%d query shapes measured)", op.Collection, len(estimate.Shapes)),
+			nil).WithDatabase(databaseName).WithCollection(op.Collection)
+	}
+
+	return nil
+}
+
+/*
+estimateIndex copies a sample of the collection, with its existing indexes, into a
+shadow collection, and explains the affected query shapes there before and after
+building the candidate index. Both measurements run on the same sample, so they are
+directly comparable. The shadow collection is always dropped afterwards.
+*/
+func (o *MongoOptimizer) estimateIndex(ctx context.Context, databaseName, indexName string, op ai.IndexOperation) (*IndexEstimate, error) {
+	namespace := databaseName + "." + op.Collection
+
+	var patterns []metrics.QueryPatternStats
+	for _, pattern := range o.whatIfPatterns {
+		if pattern.Namespace != namespace {
+			continue
+		}
+		if _, ok := metrics.ExplainableCommand(pattern.SampleCommand); ok {
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	estimate := &IndexEstimate{
+		Collection: op.Collection,
+		Index:      indexName,
+		Keys:       op.Keys,
+		SampleSize: o.whatIfSampleSize,
+	}
+
+	if len(patterns) == 0 {
+		logger.Warn("No query shapes to measure the candidate index against", "database", databaseName, "coll", op.Collection)
+		return estimate, nil
+	}
+
+	shadow := shadowCollectionName(op.Collection)
+
+	defer func() {
+		// The shadow collection is dropped even if the analysis was cancelled
+		dropCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+
+		err := o.runCommand(dropCtx, o.whatIfDatabase, "whatIf", bson.D{{Key: "drop", Value: shadow}})
+		if err != nil && !isNamespaceNotFound(err) {
+			logger.Error("Failed to drop shadow collection", "database", o.whatIfDatabase, "coll", shadow, "error", err)
+		}
+	}()
+
+	logger.Info("Copying sample for what-if analysis",
+		"database", databaseName,
+		"coll", op.Collection,
+		"sample_size", o.whatIfSampleSize,
+		"shadow", o.whatIfDatabase+"."+shadow)
+
+	if err := o.copySample(ctx, databaseName, op.Collection, shadow); err != nil {
+		return nil, err
+	}
+
+	explainer := metrics.NewExplainer(o.conn.Client)
+
+	before := make([]*metrics.QueryPlan, len(patterns))
+	for i, pattern := range patterns {
+		plan, err := o.explainOnShadow(ctx, explainer, pattern, shadow)
+		if err != nil {
+			return nil, err
+		}
+		before[i] = plan
+	}
+
+	// A TTL candidate could expire sampled documents while they are being measured, and
+	// a hidden one would not be used by the plans measured
+	candidate := op
+	candidate.Options.ExpireAfterSeconds = nil
+	candidate.Options.Hidden = false
+
+	indexDoc, err := buildIndexDocument(indexName, candidate)
+	if err != nil {
+		return nil, err
+	}
+	if indexName == "" {
+		// The candidate needs a name to recognize it in the plans
+		indexDoc = append(indexDoc, bson.E{Key: "name", Value: "whatif_candidate"})
+		estimate.Index = "whatif_candidate"
+	}
+	if err := o.runCommand(ctx, o.whatIfDatabase, "whatIf", bson.D{
+		{Key: "createIndexes", Value: shadow},
+		{Key: "indexes", Value: bson.A{indexDoc}},
+	}); err != nil {
+		return nil, fmt.Errorf("failed to build candidate index on shadow collection: %w", err)
+	}
+
+	for i, pattern := range patterns {
+		after, err := o.explainOnShadow(ctx, explainer, pattern, shadow)
+		if err != nil {
+			return nil, err
+		}
+
+		shape := ShapeEstimate{
+			Pattern:           pattern.Pattern,
+			Before:            *before[i],
+			After:             *after,
+			KeysExaminedDelta: after.KeysExamined - before[i].KeysExamined,
+			DocsExaminedDelta: after.DocsExamined - before[i].DocsExamined,
+		}
+		for _, index := range after.IndexesUsed {
+			if index == estimate.Index {
+				shape.UsesIndex = true
+			}
+		}
+		estimate.Shapes = append(estimate.Shapes, shape)
+	}
+
+	return estimate, nil
+}
+
+/*
+copySample copies a random sample of a collection and its secondary indexes into the
+shadow collection. Indexes are copied with their full specification, so that partial,
+collation, hidden and other options shape the plans as they do on the collection; an
+index the shadow collection cannot reproduce is left out. TTL indexes lose their expiry,
+like the candidate, so that sampled documents cannot expire while they are measured.
+*/
+func (o *MongoOptimizer) copySample(ctx context.Context, databaseName, collName, shadow string) error {
+	source := o.conn.Client.Database(databaseName).Collection(collName)
+
+	// $out writes the scratch database, so the copy is audited like the other shadow commands
+	if err := o.runCommand(ctx, databaseName, "whatIf", bson.D{
+		{Key: "aggregate", Value: collName},
+		{Key: "pipeline", Value: bson.A{
+			bson.D{{Key: "$sample", Value: bson.D{{Key: "size", Value: o.whatIfSampleSize}}}},
+			bson.D{{Key: "$out", Value: bson.D{
+				{Key: "db", Value: o.whatIfDatabase},
+				{Key: "coll", Value: shadow},
+			}}},
+		}},
+		{Key: "cursor", Value: bson.D{}},
+	}); err != nil {
+		return fmt.Errorf("failed to copy sample into shadow collection: %w", err)
+	}
+
+	specs, err := source.Indexes().List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list indexes for shadow collection: %w", err)
+	}
+	defer specs.Close(ctx)
+
+	var indexes []bson.D
+	for specs.Next(ctx) {
+		var spec bson.D
+		if err := specs.Decode(&spec); err != nil {
+			return fmt.Errorf("failed to decode index spec for shadow collection: %w", err)
+		}
+
+		if specName(spec) == "_id_" {
+			continue
+		}
+		indexes = append(indexes, shadowIndexDocument(spec))
+	}
+	if err := specs.Err(); err != nil {
+		return fmt.Errorf("failed to list indexes for shadow collection: %w", err)
+	}
+
+	if len(indexes) == 0 {
+		return nil
+	}
+
+	all := make(bson.A, 0, len(indexes))
+	for _, indexDoc := range indexes {
+		all = append(all, indexDoc)
+	}
+
+	if err := o.runCommand(ctx, o.whatIfDatabase, "whatIf", bson.D{
+		{Key: "createIndexes", Value: shadow},
+		{Key: "indexes", Value: all},
+	}); err == nil {
+		return nil
+	}
+
+	// Build the indexes one by one to find those the shadow collection cannot have
+	for _, indexDoc := range indexes {
+		if err := o.runCommand(ctx, o.whatIfDatabase, "whatIf", bson.D{
+			{Key: "createIndexes", Value: shadow},
+			{Key: "indexes", Value: bson.A{indexDoc}},
+		}); err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("failed to copy indexes to shadow collection: %w", err)
+			}
+			logger.Warn("Leaving an index out of the shadow collection",
+				"db", databaseName,
+				"coll", collName,
+				"name", specName(indexDoc),
+				"error", err)
+		}
+	}
+
+	return nil
+}
+
+// shadowIndexDocument turns a listed index spec into one for the shadow collection, without its expiry
+func shadowIndexDocument(spec bson.D) bson.D {
+	indexDoc := specIndexDocument(spec)
+	for i, elem := range indexDoc {
+		if elem.Key == "expireAfterSeconds" {
+			return append(indexDoc[:i:i], indexDoc[i+1:]...)
+		}
+	}
+	return indexDoc
+}
+
+// explainOnShadow explains a query shape against the shadow collection
+func (o *MongoOptimizer) explainOnShadow(ctx context.Context, explainer *metrics.Explainer, pattern metrics.QueryPatternStats, shadow string) (*metrics.QueryPlan, error) {
+	command, _ := metrics.ExplainableCommand(pattern.SampleCommand)
+
+	// Point the command at the shadow collection instead of the original
+	retargeted := make(bson.D, len(command))
+	copy(retargeted, command)
+	retargeted[0].Value = shadow
+
+	plan, err := explainer.ExplainCommand(ctx, o.whatIfDatabase, retargeted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to explain %s on shadow collection: %w", pattern.Pattern, err)
+	}
+
+	return plan, nil
+}
+
+// shadowCollectionName returns a unique name for the shadow copy of a collection
+func shadowCollectionName(collName string) string {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return collName + "_whatif"
+	}
+	return strings.Join([]string{collName, "whatif", hex.EncodeToString(suffix)}, "_")
+}
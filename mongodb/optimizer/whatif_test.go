@@ -0,0 +1,78 @@
+package optimizer
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestIndexEstimateImproves(t *testing.T) {
+	Convey("Given what-if estimates of a candidate index", t, func() {
+		Convey("When one shape examines fewer documents and none examine more", func() {
+			estimate := &IndexEstimate{Shapes: []ShapeEstimate{
+				{KeysExaminedDelta: 100, DocsExaminedDelta: -5000},
+				{},
+			}}
+
+			Convey("Then the index should improve", func() {
+				So(estimate.Improves(), ShouldBeTrue)
+			})
+		})
+
+		Convey("When one shape examines more", func() {
+			estimate := &IndexEstimate{Shapes: []ShapeEstimate{
+				{DocsExaminedDelta: -5000},
+				{KeysExaminedDelta: 10},
+			}}
+
+			Convey("Then the index should not improve", func() {
+				So(estimate.Improves(), ShouldBeFalse)
+			})
+		})
+
+		Convey("When no shapes were measured", func() {
+			estimate := &IndexEstimate{}
+
+			Convey("Then the index should not improve", func() {
+				So(estimate.Improves(), ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestShadowCollectionName(t *testing.T) {
+	Convey("Given a collection name", t, func() {
+		Convey("When generating shadow collection names", func() {
+			first := shadowCollectionName("users")
+			second := shadowCollectionName("users")
+
+			Convey("Then they should be unique and derived from the collection", func() {
+				So(strings.HasPrefix(first, "users_whatif_"), ShouldBeTrue)
+				So(first, ShouldNotEqual, second)
+			})
+		})
+	})
+}
+
+func TestShadowIndexDocument(t *testing.T) {
+	Convey("Given the spec of a TTL index", t, func() {
+		spec := bson.D{
+			{Key: "v", Value: int32(2)},
+			{Key: "key", Value: bson.D{{Key: "createdAt", Value: int32(1)}}},
+			{Key: "name", Value: "createdAt_1"},
+			{Key: "expireAfterSeconds", Value: int32(3600)},
+			{Key: "partialFilterExpression", Value: bson.D{{Key: "temporary", Value: true}}},
+		}
+
+		Convey("When it is copied to a shadow collection", func() {
+			indexDoc := shadowIndexDocument(spec)
+
+			Convey("Then it should keep every option but its expiry", func() {
+				So(indexDoc, ShouldResemble, bson.D{spec[0], spec[1], spec[2], spec[4]})
+				So(spec[3].Key, ShouldEqual, "expireAfterSeconds")
+			})
+		})
+	})
+}
@@ -260,6 +260,7 @@ func (h *ActionHandler) ExecuteAction(ctx context.Context, actionType ActionType
 			ImprovementPct:   improvement,
 			RollbackRequired: actionType == ActionRollback,
 			RollbackSuccess:  actionType == ActionRollback && result.Success,
+			WhatIf:           h.history.GetEstimates(),
 		}
 
 		if err := h.storage.SaveOptimizationRecord(ctx, record); err != nil {
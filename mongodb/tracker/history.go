@@ -4,6 +4,7 @@ import (
 	"github.com/theapemachine/lookatthatmongo/ai"
 	"github.com/theapemachine/lookatthatmongo/logger"
 	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
+	"github.com/theapemachine/lookatthatmongo/mongodb/optimizer"
 )
 
 /*
@@ -15,6 +16,7 @@ type History struct {
 	afterReport   *metrics.Report
 	optimizations []*ai.OptimizationSuggestion
 	databaseName  string
+	estimates     []optimizer.IndexEstimate
 }
 
 /*
@@ -117,6 +119,20 @@ func (h *History) SetAfterReport(report *metrics.Report) {
 	h.afterReport = report
 }
 
+/*
+SetEstimates sets the what-if estimates of the indexes considered by the optimizer.
+*/
+func (h *History) SetEstimates(estimates []optimizer.IndexEstimate) {
+	h.estimates = estimates
+}
+
+/*
+GetEstimates returns the what-if estimates of the indexes considered by the optimizer.
+*/
+func (h *History) GetEstimates() []optimizer.IndexEstimate {
+	return h.estimates
+}
+
 /*
 GetDatabaseName returns the name of the database being optimized.
 */
@@ -6,6 +6,7 @@ import (
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/theapemachine/lookatthatmongo/ai"
 	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
+	"github.com/theapemachine/lookatthatmongo/mongodb/optimizer"
 )
 
 // historyMockReport is a simple mock for metrics.Report
@@ -261,3 +262,18 @@ func TestGetOptimizationCount(t *testing.T) {
 		})
 	})
 }
+
+func TestSetEstimates(t *testing.T) {
+	Convey("Given a history instance", t, func() {
+		history := NewHistory()
+		estimates := []optimizer.IndexEstimate{{Collection: "orders", Index: "status_1", SampleSize: 100}}
+
+		Convey("When setting the what-if estimates", func() {
+			history.SetEstimates(estimates)
+
+			Convey("Then they should be returned for the records of the run", func() {
+				So(history.GetEstimates(), ShouldResemble, estimates)
+			})
+		})
+	})
+}
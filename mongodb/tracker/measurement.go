@@ -127,6 +127,7 @@ func (m *Measurement) Measure(ctx context.Context) (*ai.OptimizationSuggestion,
 			Applied:        true,
 			Success:        true, // Assuming success at this point
 			ImprovementPct: 0,    // Will be calculated by the action handler
+			WhatIf:         m.history.GetEstimates(),
 		}
 
 		if err := m.storage.SaveOptimizationRecord(ctx, record); err != nil {
@@ -1,6 +1,9 @@
 package tracker
 
 import (
+	"context"
+	"errors"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -10,25 +13,25 @@ import (
 
 // mockMetricsMonitor is a mock implementation of the metrics.Monitor interface
 type mockMetricsMonitor struct {
-	serverStatsFunc     func(ctx any) (*metrics.ServerStats, error)
-	databaseStatsFunc   func(ctx any, dbName string) (*metrics.DatabaseStats, error)
-	collectionStatsFunc func(ctx any, dbName, collName string) (*metrics.CollectionStats, error)
-	indexStatsFunc      func(ctx any, dbName, collName string) ([]metrics.IndexStats, error)
+	serverStatsFunc     func(ctx context.Context) (*metrics.ServerStats, error)
+	databaseStatsFunc   func(ctx context.Context, dbName string) (*metrics.DatabaseStats, error)
+	collectionStatsFunc func(ctx context.Context, dbName, collName string) (*metrics.CollectionStats, error)
+	indexStatsFunc      func(ctx context.Context, dbName, collName string) ([]metrics.IndexStats, error)
 }
 
-func (m *mockMetricsMonitor) GetServerStats(ctx any) (*metrics.ServerStats, error) {
+func (m *mockMetricsMonitor) GetServerStats(ctx context.Context) (*metrics.ServerStats, error) {
 	return m.serverStatsFunc(ctx)
 }
 
-func (m *mockMetricsMonitor) GetDatabaseStats(ctx any, dbName string) (*metrics.DatabaseStats, error) {
+func (m *mockMetricsMonitor) GetDatabaseStats(ctx context.Context, dbName string) (*metrics.DatabaseStats, error) {
 	return m.databaseStatsFunc(ctx, dbName)
 }
 
-func (m *mockMetricsMonitor) GetCollectionStats(ctx any, dbName, collName string) (*metrics.CollectionStats, error) {
+func (m *mockMetricsMonitor) GetCollectionStats(ctx context.Context, dbName, collName string) (*metrics.CollectionStats, error) {
 	return m.collectionStatsFunc(ctx, dbName, collName)
 }
 
-func (m *mockMetricsMonitor) GetIndexStats(ctx any, dbName, collName string) ([]metrics.IndexStats, error) {
+func (m *mockMetricsMonitor) GetIndexStats(ctx context.Context, dbName, collName string) ([]metrics.IndexStats, error) {
 	return m.indexStatsFunc(ctx, dbName, collName)
 }
 
@@ -76,16 +79,16 @@ func TestReportCollect(t *testing.T) {
 		}
 
 		monitor := &mockMetricsMonitor{
-			serverStatsFunc: func(ctx any) (*metrics.ServerStats, error) {
+			serverStatsFunc: func(ctx context.Context) (*metrics.ServerStats, error) {
 				return serverStats, nil
 			},
-			databaseStatsFunc: func(ctx any, dbName string) (*metrics.DatabaseStats, error) {
+			databaseStatsFunc: func(ctx context.Context, dbName string) (*metrics.DatabaseStats, error) {
 				return dbStats, nil
 			},
-			collectionStatsFunc: func(ctx any, dbName, collName string) (*metrics.CollectionStats, error) {
+			collectionStatsFunc: func(ctx context.Context, dbName, collName string) (*metrics.CollectionStats, error) {
 				return collStats, nil
 			},
-			indexStatsFunc: func(ctx any, dbName, collName string) ([]metrics.IndexStats, error) {
+			indexStatsFunc: func(ctx context.Context, dbName, collName string) ([]metrics.IndexStats, error) {
 				return indexStats, nil
 			},
 		}
@@ -97,7 +100,7 @@ func TestReportCollect(t *testing.T) {
 				return []string{"testcoll"}, nil
 			}
 
-			err := report.Collect(nil, "testdb", listCollections)
+			err := report.Collect(context.Background(), "testdb", listCollections)
 
 			Convey("Then it should collect all metrics without error", func() {
 				So(err, ShouldBeNil)
@@ -112,6 +115,98 @@ func TestReportCollect(t *testing.T) {
 	})
 }
 
+func TestReportCollectPartial(t *testing.T) {
+	Convey("Given a monitor that fails on some collections", t, func() {
+		var running, maxRunning int32
+
+		monitor := &mockMetricsMonitor{
+			serverStatsFunc: func(ctx context.Context) (*metrics.ServerStats, error) {
+				return &metrics.ServerStats{}, nil
+			},
+			databaseStatsFunc: func(ctx context.Context, dbName string) (*metrics.DatabaseStats, error) {
+				return &metrics.DatabaseStats{Name: dbName}, nil
+			},
+			collectionStatsFunc: func(ctx context.Context, dbName, collName string) (*metrics.CollectionStats, error) {
+				current := atomic.AddInt32(&running, 1)
+				defer atomic.AddInt32(&running, -1)
+				for {
+					seen := atomic.LoadInt32(&maxRunning)
+					if current <= seen || atomic.CompareAndSwapInt32(&maxRunning, seen, current) {
+						break
+					}
+				}
+
+				switch collName {
+				case "denied":
+					return nil, errors.New("not authorized")
+				case "slow":
+					<-ctx.Done()
+					return nil, ctx.Err()
+				case "system.buckets.metrics":
+					panic("time-series buckets should be skipped")
+				}
+				time.Sleep(5 * time.Millisecond)
+				return &metrics.CollectionStats{Name: collName}, nil
+			},
+			indexStatsFunc: func(ctx context.Context, dbName, collName string) ([]metrics.IndexStats, error) {
+				if collName == "noindexes" {
+					return nil, errors.New("index stats failed")
+				}
+				return []metrics.IndexStats{{Name: "_id_"}}, nil
+			},
+		}
+
+		report := metrics.NewReport(monitor,
+			metrics.WithConcurrency(2),
+			metrics.WithCollectionTimeout(50*time.Millisecond),
+		)
+
+		Convey("When collecting metrics", func() {
+			err := report.Collect(context.Background(), "testdb", func() ([]string, error) {
+				return []string{"a", "denied", "b", "slow", "noindexes", "c", "system.buckets.metrics"}, nil
+			})
+
+			Convey("Then the report should hold the collections that succeeded", func() {
+				So(err, ShouldBeNil)
+				So(report.Collections, ShouldContainKey, "a")
+				So(report.Collections, ShouldContainKey, "b")
+				So(report.Collections, ShouldContainKey, "c")
+				So(report.Collections, ShouldContainKey, "noindexes")
+				So(report.Indexes, ShouldNotContainKey, "noindexes")
+				So(report.Collections, ShouldNotContainKey, "denied")
+				So(report.Collections, ShouldNotContainKey, "system.buckets.metrics")
+			})
+
+			Convey("Then the errors should be listed in the report", func() {
+				So(report.Errors, ShouldHaveLength, 3)
+				So(report.Errors[0].Collection, ShouldEqual, "denied")
+				So(report.Errors[0].Stage, ShouldEqual, "collectionStats")
+				So(report.Errors[1].Collection, ShouldEqual, "noindexes")
+				So(report.Errors[1].Stage, ShouldEqual, "indexStats")
+				So(report.Errors[2].Collection, ShouldEqual, "slow")
+			})
+
+			Convey("Then no more collections should run at once than allowed", func() {
+				So(atomic.LoadInt32(&maxRunning), ShouldBeLessThanOrEqualTo, 2)
+			})
+		})
+
+		Convey("When the context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			err := report.Collect(ctx, "testdb", func() ([]string, error) {
+				return []string{"a"}, nil
+			})
+
+			Convey("Then collection should fail", func() {
+				So(err, ShouldNotBeNil)
+				So(errors.Is(err, context.Canceled), ShouldBeTrue)
+			})
+		})
+	})
+}
+
 func TestReportString(t *testing.T) {
 	Convey("Given a report with data", t, func() {
 		serverStats := &metrics.ServerStats{
@@ -205,16 +300,16 @@ func TestGetReportMetrics(t *testing.T) {
 		}
 
 		monitor := &mockMetricsMonitor{
-			serverStatsFunc: func(ctx any) (*metrics.ServerStats, error) {
+			serverStatsFunc: func(ctx context.Context) (*metrics.ServerStats, error) {
 				return serverStats, nil
 			},
-			databaseStatsFunc: func(ctx any, dbName string) (*metrics.DatabaseStats, error) {
+			databaseStatsFunc: func(ctx context.Context, dbName string) (*metrics.DatabaseStats, error) {
 				return dbStats, nil
 			},
-			collectionStatsFunc: func(ctx any, dbName, collName string) (*metrics.CollectionStats, error) {
+			collectionStatsFunc: func(ctx context.Context, dbName, collName string) (*metrics.CollectionStats, error) {
 				return collStats, nil
 			},
-			indexStatsFunc: func(ctx any, dbName, collName string) ([]metrics.IndexStats, error) {
+			indexStatsFunc: func(ctx context.Context, dbName, collName string) ([]metrics.IndexStats, error) {
 				// Convert to the slice type expected by the interface
 				result := make([]metrics.IndexStats, len(indexStats))
 				for i, idx := range indexStats {
@@ -0,0 +1,356 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/theapemachine/lookatthatmongo/ai"
+	"github.com/theapemachine/lookatthatmongo/logger"
+	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
+	"github.com/theapemachine/lookatthatmongo/mongodb/optimizer"
+	"github.com/theapemachine/lookatthatmongo/storage"
+)
+
+const (
+	// DefaultDropObservation is how long an index stays hidden before it is dropped
+	DefaultDropObservation = 24 * time.Hour
+	// DefaultDropRegressionThreshold is the increase, in percent, that counts as a regression
+	DefaultDropRegressionThreshold = 20.0
+)
+
+/*
+IndexDropper hides, unhides and drops indexes. It is implemented by the MongoDB optimizer.
+*/
+type IndexDropper interface {
+	SetIndexHidden(ctx context.Context, databaseName, collName, indexName string, hidden bool) error
+	DropIndex(ctx context.Context, databaseName, collName, indexName string) error
+}
+
+/*
+StagedDrops carries indexes that were hidden instead of dropped across runs. Each
+hidden index is recorded with the query shapes that used it, and every later run
+compares those shapes against its own report: a regression unhides the index, and an
+index that stayed hidden for the whole observation period without one is dropped.
+*/
+type StagedDrops struct {
+	storage     storage.Storage
+	dropper     IndexDropper
+	observation time.Duration
+	threshold   float64
+	now         func() time.Time
+}
+
+/*
+StagedDropOptionFn is a function type for configuring a StagedDrops instance.
+*/
+type StagedDropOptionFn func(*StagedDrops)
+
+/*
+NewStagedDrops creates a new StagedDrops instance with the given options.
+*/
+func NewStagedDrops(opts ...StagedDropOptionFn) *StagedDrops {
+	drops := &StagedDrops{
+		observation: DefaultDropObservation,
+		threshold:   DefaultDropRegressionThreshold,
+		now:         time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(drops)
+	}
+
+	return drops
+}
+
+/*
+WithStagedDropStorage sets the storage the staged drops are recorded in.
+*/
+func WithStagedDropStorage(storage storage.Storage) StagedDropOptionFn {
+	return func(d *StagedDrops) {
+		d.storage = storage
+	}
+}
+
+/*
+WithIndexDropper sets what hides, unhides and drops the indexes.
+*/
+func WithIndexDropper(dropper IndexDropper) StagedDropOptionFn {
+	return func(d *StagedDrops) {
+		d.dropper = dropper
+	}
+}
+
+/*
+WithObservation sets how long an index stays hidden before it is dropped.
+*/
+func WithObservation(observation time.Duration) StagedDropOptionFn {
+	return func(d *StagedDrops) {
+		d.observation = observation
+	}
+}
+
+/*
+WithRegressionThreshold sets the increase in average latency or documents examined,
+in percent, that counts as a regression.
+*/
+func WithRegressionThreshold(threshold float64) StagedDropOptionFn {
+	return func(d *StagedDrops) {
+		d.threshold = threshold
+	}
+}
+
+/*
+Record saves a staged drop for every index the suggestion hid. The baseline is the set
+of query shapes in the report taken before the index was hidden that used it.
+*/
+func (d *StagedDrops) Record(ctx context.Context, databaseName string, suggestion *ai.OptimizationSuggestion, report *metrics.Report) error {
+	if d.storage == nil {
+		return fmt.Errorf("staged drops have no storage")
+	}
+
+	var pending []*storage.OptimizationRecord
+	for i, op := range suggestion.Solution.Operations {
+		if op.Action != "dropIndex" || op.Stage != optimizer.StageHidden {
+			continue
+		}
+
+		if pending == nil {
+			var err error
+			if pending, err = d.pending(ctx, databaseName); err != nil {
+				return err
+			}
+		}
+
+		if slices.ContainsFunc(pending, func(record *storage.OptimizationRecord) bool {
+			return record.StagedDrop.Collection == op.Collection && record.StagedDrop.Index == op.Name
+		}) {
+			logger.Debug("Index already has a staged drop", "db", databaseName, "coll", op.Collection, "name", op.Name)
+			continue
+		}
+
+		now := d.now()
+		record := &storage.OptimizationRecord{
+			ID:           fmt.Sprintf("staged-drop-%d-%d", now.UnixNano(), i),
+			Timestamp:    now,
+			DatabaseName: databaseName,
+			Suggestion:   suggestion,
+			Applied:      true,
+			StagedDrop: &storage.StagedDrop{
+				Collection: op.Collection,
+				Index:      op.Name,
+				Snapshot:   op.Snapshot,
+				HiddenAt:   now,
+				State:      storage.StagedDropHidden,
+				Baseline:   shapesUsingIndex(report, databaseName, op.Collection, op.Name),
+			},
+		}
+
+		if err := d.storage.SaveOptimizationRecord(ctx, record); err != nil {
+			return fmt.Errorf("failed to record staged drop of %s on %s: %w", op.Name, op.Collection, err)
+		}
+
+		pending = append(pending, record)
+		logger.Info("Recorded staged index drop",
+			"db", databaseName,
+			"coll", op.Collection,
+			"name", op.Name,
+			"baseline_shapes", len(record.StagedDrop.Baseline))
+	}
+
+	return nil
+}
+
+/*
+Process decides the pending staged drops of a database against a fresh report. An
+index whose baseline shapes regressed is unhidden, an index hidden for longer than the
+observation period without a regression is dropped, and the others are left hidden.
+*/
+func (d *StagedDrops) Process(ctx context.Context, databaseName string, report *metrics.Report) error {
+	if d.storage == nil || d.dropper == nil {
+		return fmt.Errorf("staged drops need storage and an index dropper")
+	}
+
+	pending, err := d.pending(ctx, databaseName)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range pending {
+		if err := d.decide(ctx, record, report); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decide moves a single staged drop on, if it is time to
+func (d *StagedDrops) decide(ctx context.Context, record *storage.OptimizationRecord, report *metrics.Report) error {
+	drop := record.StagedDrop
+	databaseName := record.DatabaseName
+	now := d.now()
+
+	// A partial report may have left the collection out, and without its indexes this run
+	// cannot confirm that the index is still hidden
+	indexes, ok := report.Indexes[drop.Collection]
+	if !ok || collectionFailed(report, drop.Collection) {
+		logger.Warn("Collection is missing from the report, leaving its staged drop for a later run",
+			"db", databaseName,
+			"coll", drop.Collection,
+			"name", drop.Index)
+		return nil
+	}
+
+	// The index may have been dropped or unhidden by someone else since it was hidden
+	i := slices.IndexFunc(indexes, func(index *metrics.IndexStats) bool { return index.Name == drop.Index })
+	switch {
+	case i < 0:
+		return d.settle(ctx, record, storage.StagedDropDropped, "index no longer exists")
+	case !indexes[i].Hidden:
+		return d.settle(ctx, record, storage.StagedDropRestored, "index is no longer hidden")
+	}
+
+	if regressions := Regressions(drop.Baseline, report.QueryPatterns, d.threshold); len(regressions) > 0 {
+		if err := d.dropper.SetIndexHidden(ctx, databaseName, drop.Collection, drop.Index, false); err != nil {
+			return fmt.Errorf("failed to unhide index %s on %s: %w", drop.Index, drop.Collection, err)
+		}
+
+		logger.Warn("Hiding the index regressed queries, unhid it",
+			"db", databaseName,
+			"coll", drop.Collection,
+			"name", drop.Index,
+			"regressions", len(regressions))
+		return d.settle(ctx, record, storage.StagedDropRestored, strings.Join(regressions, "; "))
+	}
+
+	if hiddenFor := now.Sub(drop.HiddenAt); hiddenFor < d.observation {
+		logger.Info("Index is still being observed before its drop",
+			"db", databaseName,
+			"coll", drop.Collection,
+			"name", drop.Index,
+			"remaining", (d.observation - hiddenFor).Round(time.Second))
+		return nil
+	}
+
+	if err := d.dropper.DropIndex(ctx, databaseName, drop.Collection, drop.Index); err != nil {
+		return fmt.Errorf("failed to drop hidden index %s on %s: %w", drop.Index, drop.Collection, err)
+	}
+
+	return d.settle(ctx, record, storage.StagedDropDropped,
+		fmt.Sprintf("no regression while hidden for %s", now.Sub(drop.HiddenAt).Round(time.Second)))
+}
+
+// settle records the final state of a staged drop
+func (d *StagedDrops) settle(ctx context.Context, record *storage.OptimizationRecord, state, reason string) error {
+	record.StagedDrop.State = state
+	record.StagedDrop.Reason = reason
+	record.StagedDrop.DecidedAt = d.now()
+	record.Success = state == storage.StagedDropDropped
+	record.RollbackRequired = state == storage.StagedDropRestored
+	record.RollbackSuccess = record.RollbackRequired
+
+	if err := d.storage.SaveOptimizationRecord(ctx, record); err != nil {
+		return fmt.Errorf("failed to update staged drop of %s on %s: %w", record.StagedDrop.Index, record.StagedDrop.Collection, err)
+	}
+
+	logger.Info("Staged index drop decided",
+		"db", record.DatabaseName,
+		"coll", record.StagedDrop.Collection,
+		"name", record.StagedDrop.Index,
+		"state", state,
+		"reason", reason)
+	return nil
+}
+
+// pending returns the staged drops of a database whose index is still hidden
+func (d *StagedDrops) pending(ctx context.Context, databaseName string) ([]*storage.OptimizationRecord, error) {
+	records, err := d.storage.ListOptimizationRecordsByDatabase(ctx, databaseName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list staged drops: %w", err)
+	}
+
+	// Storage backends list by key prefix, so records of other databases may be included
+	pending := make([]*storage.OptimizationRecord, 0)
+	for _, record := range records {
+		if record.DatabaseName == databaseName && record.StagedDrop != nil && record.StagedDrop.State == storage.StagedDropHidden {
+			pending = append(pending, record)
+		}
+	}
+
+	return pending, nil
+}
+
+// collectionFailed reports whether collecting a collection failed in a report
+func collectionFailed(report *metrics.Report, collName string) bool {
+	return slices.ContainsFunc(report.Errors, func(reportErr metrics.ReportError) bool {
+		return reportErr.Collection == collName
+	})
+}
+
+// shapesUsingIndex returns the query shapes of a report that used an index
+func shapesUsingIndex(report *metrics.Report, databaseName, collName, indexName string) []metrics.QueryPatternStats {
+	if report == nil {
+		return nil
+	}
+
+	namespace := databaseName + "." + collName
+	var shapes []metrics.QueryPatternStats
+	for _, shape := range report.QueryPatterns {
+		if shape.Namespace == namespace && slices.Contains(shape.IndexesUsed, indexName) {
+			shapes = append(shapes, shape)
+		}
+	}
+
+	return shapes
+}
+
+/*
+Regressions compares the baseline query shapes of a staged drop with the same shapes in
+a later report. A shape regressed when its average latency or documents examined grew
+by more than the threshold percentage, or when it started scanning the collection.
+Shapes that were not executed again are ignored.
+*/
+func Regressions(baseline, current []metrics.QueryPatternStats, threshold float64) []string {
+	byShape := make(map[string]metrics.QueryPatternStats, len(current))
+	for _, shape := range current {
+		byShape[shapeKey(shape)] = shape
+	}
+
+	var regressions []string
+	for _, before := range baseline {
+		after, ok := byShape[shapeKey(before)]
+		if !ok || after.ExecutionCount == 0 {
+			continue
+		}
+
+		if change := percentIncrease(float64(before.AverageLatency), float64(after.AverageLatency)); change > threshold {
+			regressions = append(regressions, fmt.Sprintf("%s %s: average latency %s -> %s (+%.0f%%)",
+				before.Operation, before.Pattern, before.AverageLatency, after.AverageLatency, change))
+		}
+		if change := percentIncrease(float64(before.AverageDocsScanned), float64(after.AverageDocsScanned)); change > threshold {
+			regressions = append(regressions, fmt.Sprintf("%s %s: documents examined %d -> %d (+%.0f%%)",
+				before.Operation, before.Pattern, before.AverageDocsScanned, after.AverageDocsScanned, change))
+		}
+		if before.CollectionScans == 0 && after.CollectionScans > 0 {
+			regressions = append(regressions, fmt.Sprintf("%s %s: now scans the collection", before.Operation, before.Pattern))
+		}
+	}
+
+	return regressions
+}
+
+// shapeKey identifies a query shape across reports
+func shapeKey(shape metrics.QueryPatternStats) string {
+	return shape.Namespace + "\x00" + shape.Operation + "\x00" + shape.Pattern
+}
+
+// percentIncrease returns how much after grew over before, in percent
+func percentIncrease(before, after float64) float64 {
+	if before <= 0 {
+		return 0
+	}
+	return (after - before) / before * 100
+}
@@ -0,0 +1,266 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/theapemachine/lookatthatmongo/ai"
+	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
+	"github.com/theapemachine/lookatthatmongo/mongodb/optimizer"
+	"github.com/theapemachine/lookatthatmongo/storage"
+)
+
+// mockDropper records the index changes made by staged drops
+type mockDropper struct {
+	unhidden  []string
+	dropped   []string
+	databases []string
+	err       error
+}
+
+func (m *mockDropper) SetIndexHidden(ctx context.Context, databaseName, collName, indexName string, hidden bool) error {
+	m.unhidden = append(m.unhidden, indexName)
+	m.databases = append(m.databases, databaseName)
+	return m.err
+}
+
+func (m *mockDropper) DropIndex(ctx context.Context, databaseName, collName, indexName string) error {
+	m.dropped = append(m.dropped, indexName)
+	m.databases = append(m.databases, databaseName)
+	return m.err
+}
+
+// recordStorage keeps saved records in memory, by ID
+func recordStorage(records map[string]*storage.OptimizationRecord) *mockStorage {
+	return &mockStorage{
+		saveFunc: func(ctx context.Context, record *storage.OptimizationRecord) error {
+			records[record.ID] = record
+			return nil
+		},
+		listByDBFunc: func(ctx context.Context, dbName string) ([]*storage.OptimizationRecord, error) {
+			var list []*storage.OptimizationRecord
+			for _, record := range records {
+				if record.DatabaseName == dbName {
+					list = append(list, record)
+				}
+			}
+			return list, nil
+		},
+	}
+}
+
+func TestStagedDrops(t *testing.T) {
+	Convey("Given an index hidden by a suggestion", t, func() {
+		records := map[string]*storage.OptimizationRecord{}
+		dropper := &mockDropper{}
+		now := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+
+		drops := NewStagedDrops(
+			WithStagedDropStorage(recordStorage(records)),
+			WithIndexDropper(dropper),
+			WithObservation(24*time.Hour),
+			WithRegressionThreshold(20),
+		)
+		drops.now = func() time.Time { return now }
+
+		suggestion := &ai.OptimizationSuggestion{Solution: ai.Solution{Operations: []ai.IndexOperation{
+			{Action: "dropIndex", Collection: "orders", Name: "status_1", Stage: optimizer.StageHidden},
+			{Action: "createIndex", Collection: "orders", Keys: ai.IndexKey{{Field: "total", Direction: 1}}},
+		}}}
+		shape := metrics.QueryPatternStats{
+			Namespace:          "app.orders",
+			Operation:          "find",
+			Pattern:            `{"status":1}`,
+			ExecutionCount:     100,
+			AverageLatency:     10 * time.Millisecond,
+			AverageDocsScanned: 50,
+			IndexesUsed:        []string{"status_1"},
+		}
+		before := &metrics.Report{QueryPatterns: []metrics.QueryPatternStats{
+			shape,
+			{Namespace: "app.orders", Operation: "find", Pattern: `{"total":1}`, IndexesUsed: []string{"total_1"}},
+		}}
+
+		So(drops.Record(context.Background(), "app", suggestion, before), ShouldBeNil)
+		So(records, ShouldHaveLength, 1)
+
+		var record *storage.OptimizationRecord
+		for _, r := range records {
+			record = r
+		}
+
+		Convey("Then the shapes that used the index should be its baseline", func() {
+			So(record.StagedDrop.Index, ShouldEqual, "status_1")
+			So(record.StagedDrop.State, ShouldEqual, storage.StagedDropHidden)
+			So(record.StagedDrop.Baseline, ShouldResemble, []metrics.QueryPatternStats{shape})
+		})
+
+		Convey("When the same drop is recorded again", func() {
+			So(drops.Record(context.Background(), "app", suggestion, before), ShouldBeNil)
+
+			Convey("Then it should not be tracked twice", func() {
+				So(records, ShouldHaveLength, 1)
+			})
+		})
+
+		hidden := map[string][]*metrics.IndexStats{"orders": {{Name: "_id_"}, {Name: "status_1", Hidden: true}}}
+
+		Convey("When a later run sees the shape slow down", func() {
+			slower := shape
+			slower.AverageLatency = 30 * time.Millisecond
+			report := &metrics.Report{Indexes: hidden, QueryPatterns: []metrics.QueryPatternStats{slower}}
+
+			So(drops.Process(context.Background(), "app", report), ShouldBeNil)
+
+			Convey("Then the index should be unhidden", func() {
+				So(dropper.unhidden, ShouldResemble, []string{"status_1"})
+				So(dropper.dropped, ShouldBeEmpty)
+				So(record.StagedDrop.State, ShouldEqual, storage.StagedDropRestored)
+				So(record.StagedDrop.Reason, ShouldContainSubstring, "average latency")
+			})
+		})
+
+		Convey("When a later run within the observation period sees no regression", func() {
+			now = now.Add(time.Hour)
+			report := &metrics.Report{Indexes: hidden, QueryPatterns: []metrics.QueryPatternStats{shape}}
+
+			So(drops.Process(context.Background(), "app", report), ShouldBeNil)
+
+			Convey("Then the index should stay hidden", func() {
+				So(dropper.unhidden, ShouldBeEmpty)
+				So(dropper.dropped, ShouldBeEmpty)
+				So(record.StagedDrop.State, ShouldEqual, storage.StagedDropHidden)
+			})
+		})
+
+		Convey("When a run after the observation period sees no regression", func() {
+			now = now.Add(25 * time.Hour)
+			report := &metrics.Report{Indexes: hidden, QueryPatterns: []metrics.QueryPatternStats{shape}}
+
+			So(drops.Process(context.Background(), "app", report), ShouldBeNil)
+
+			Convey("Then the index should be dropped", func() {
+				So(dropper.dropped, ShouldResemble, []string{"status_1"})
+				So(record.StagedDrop.State, ShouldEqual, storage.StagedDropDropped)
+				So(record.StagedDrop.DecidedAt, ShouldEqual, now)
+			})
+		})
+
+		Convey("When the index was unhidden by someone else", func() {
+			report := &metrics.Report{Indexes: map[string][]*metrics.IndexStats{"orders": {{Name: "status_1"}}}}
+
+			So(drops.Process(context.Background(), "app", report), ShouldBeNil)
+
+			Convey("Then the drop should be given up without touching the index", func() {
+				So(dropper.unhidden, ShouldBeEmpty)
+				So(record.StagedDrop.State, ShouldEqual, storage.StagedDropRestored)
+			})
+		})
+
+		Convey("When a run after the observation period has no indexes for the collection", func() {
+			now = now.Add(25 * time.Hour)
+			report := &metrics.Report{Indexes: map[string][]*metrics.IndexStats{}, QueryPatterns: []metrics.QueryPatternStats{shape}}
+
+			So(drops.Process(context.Background(), "app", report), ShouldBeNil)
+
+			Convey("Then the index should be left hidden for a later run", func() {
+				So(dropper.dropped, ShouldBeEmpty)
+				So(record.StagedDrop.State, ShouldEqual, storage.StagedDropHidden)
+			})
+		})
+
+		Convey("When a run after the observation period failed to collect the collection", func() {
+			now = now.Add(25 * time.Hour)
+			report := &metrics.Report{
+				Indexes:       hidden,
+				QueryPatterns: []metrics.QueryPatternStats{shape},
+				Errors:        []metrics.ReportError{{Collection: "orders", Stage: "collStats", Error: "context deadline exceeded"}},
+			}
+
+			So(drops.Process(context.Background(), "app", report), ShouldBeNil)
+
+			Convey("Then the index should be left hidden for a later run", func() {
+				So(dropper.dropped, ShouldBeEmpty)
+				So(record.StagedDrop.State, ShouldEqual, storage.StagedDropHidden)
+			})
+		})
+
+		Convey("When the storage lists the staged drops of a database sharing the name prefix", func() {
+			now = now.Add(25 * time.Hour)
+			other := *record
+			other.ID = "staged-drop-other"
+			other.DatabaseName = "application"
+			other.StagedDrop = &storage.StagedDrop{Collection: "orders", Index: "status_1", HiddenAt: record.StagedDrop.HiddenAt, State: storage.StagedDropHidden}
+			records[other.ID] = &other
+
+			prefixed := recordStorage(records)
+			prefixed.listByDBFunc = func(ctx context.Context, dbName string) ([]*storage.OptimizationRecord, error) {
+				var list []*storage.OptimizationRecord
+				for _, record := range records {
+					if strings.HasPrefix(record.DatabaseName, dbName) {
+						list = append(list, record)
+					}
+				}
+				return list, nil
+			}
+			drops.storage = prefixed
+
+			report := &metrics.Report{Indexes: hidden, QueryPatterns: []metrics.QueryPatternStats{shape}}
+			So(drops.Process(context.Background(), "app", report), ShouldBeNil)
+
+			Convey("Then only the drops of the database itself should be decided", func() {
+				So(dropper.dropped, ShouldResemble, []string{"status_1"})
+				So(dropper.databases, ShouldResemble, []string{"app"})
+				So(records["staged-drop-other"].StagedDrop.State, ShouldEqual, storage.StagedDropHidden)
+			})
+		})
+
+		Convey("When the index cannot be dropped", func() {
+			now = now.Add(25 * time.Hour)
+			dropper.err = errors.New("not authorized")
+
+			err := drops.Process(context.Background(), "app", &metrics.Report{Indexes: hidden})
+
+			Convey("Then the drop should stay pending", func() {
+				So(err, ShouldNotBeNil)
+				So(record.StagedDrop.State, ShouldEqual, storage.StagedDropHidden)
+			})
+		})
+	})
+}
+
+func TestRegressions(t *testing.T) {
+	Convey("Given the baseline shapes of a hidden index", t, func() {
+		baseline := []metrics.QueryPatternStats{
+			{Namespace: "app.orders", Operation: "find", Pattern: "a", ExecutionCount: 10, AverageLatency: 10 * time.Millisecond, AverageDocsScanned: 10},
+			{Namespace: "app.orders", Operation: "find", Pattern: "b", ExecutionCount: 10, AverageLatency: 10 * time.Millisecond},
+		}
+
+		Convey("When the shapes run about as fast", func() {
+			current := []metrics.QueryPatternStats{
+				{Namespace: "app.orders", Operation: "find", Pattern: "a", ExecutionCount: 10, AverageLatency: 11 * time.Millisecond, AverageDocsScanned: 11},
+			}
+
+			Convey("Then nothing should have regressed", func() {
+				So(Regressions(baseline, current, 20), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When a shape examines more documents and starts scanning the collection", func() {
+			current := []metrics.QueryPatternStats{
+				{Namespace: "app.orders", Operation: "find", Pattern: "a", ExecutionCount: 10, AverageLatency: 10 * time.Millisecond, AverageDocsScanned: 1000, CollectionScans: 10},
+			}
+
+			Convey("Then both should be reported", func() {
+				regressions := Regressions(baseline, current, 20)
+				So(regressions, ShouldHaveLength, 2)
+				So(regressions[0], ShouldContainSubstring, "documents examined 10 -> 1000")
+				So(regressions[1], ShouldContainSubstring, "scans the collection")
+			})
+		})
+	})
+}
@@ -0,0 +1,16 @@
+package storage
+
+import (
+	"fmt"
+)
+
+// auditDir is the directory under which the file storage keeps the audit log
+const auditDir = "_audit"
+
+// DefaultAuditPrefix is the default prefix for audit log entries in S3, kept apart from records
+const DefaultAuditPrefix = "audit-log/"
+
+// auditEntryName returns the file or object name of an audit entry, zero padded so names sort by sequence
+func auditEntryName(sequence uint64) string {
+	return fmt.Sprintf("%020d.json", sequence)
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/theapemachine/lookatthatmongo/audit"
+)
+
+func TestFileStorageAuditLog(t *testing.T) {
+	Convey("Given a file storage used as audit sink", t, func() {
+		tempDir, err := os.MkdirTemp("", "file_storage_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tempDir)
+
+		storage, err := NewFileStorage(tempDir)
+		So(err, ShouldBeNil)
+
+		ctx := context.Background()
+		log := audit.NewLog(storage)
+
+		Convey("When entries are appended", func() {
+			So(log.Append(ctx, &audit.Entry{Event: audit.EventIssued, Database: "test-db"}), ShouldBeNil)
+			So(log.Append(ctx, &audit.Entry{Event: audit.EventSucceeded, Database: "test-db"}), ShouldBeNil)
+
+			Convey("Then they should be listed in order and verify", func() {
+				entries, err := storage.ListAuditEntries(ctx)
+				So(err, ShouldBeNil)
+				So(entries, ShouldHaveLength, 2)
+				So(audit.Verify(entries), ShouldBeNil)
+			})
+
+			Convey("Then an existing entry should never be overwritten", func() {
+				err := storage.AppendAuditEntry(ctx, &audit.Entry{Sequence: 1})
+				So(err, ShouldEqual, audit.ErrEntryExists)
+			})
+
+			Convey("Then the audit log should not show up as records", func() {
+				records, err := storage.ListOptimizationRecords(ctx)
+				So(err, ShouldBeNil)
+				So(records, ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+func TestEncryptedFileStorageAuditLog(t *testing.T) {
+	Convey("Given an encrypted file storage used as audit sink", t, func() {
+		tempDir, err := os.MkdirTemp("", "file_storage_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tempDir)
+
+		enc, err := NewEncryptor(testKey(1))
+		So(err, ShouldBeNil)
+
+		storage, err := NewFileStorage(tempDir, WithFileEncryptor(enc))
+		So(err, ShouldBeNil)
+
+		ctx := context.Background()
+		log := audit.NewLog(storage)
+		So(log.Append(ctx, &audit.Entry{Event: audit.EventIssued, Database: "test-db"}), ShouldBeNil)
+		So(log.Append(ctx, &audit.Entry{Event: audit.EventSucceeded, Database: "test-db"}), ShouldBeNil)
+
+		Convey("Then the entries should be stored encrypted", func() {
+			data, err := os.ReadFile(filepath.Join(tempDir, auditDir, auditEntryName(1)))
+			So(err, ShouldBeNil)
+			So(IsEncrypted(data), ShouldBeTrue)
+			So(string(data), ShouldNotContainSubstring, "test-db")
+		})
+
+		Convey("Then they should be listed decrypted and verify", func() {
+			entries, err := storage.ListAuditEntries(ctx)
+			So(err, ShouldBeNil)
+			So(entries, ShouldHaveLength, 2)
+			So(entries[0].Database, ShouldEqual, "test-db")
+			So(audit.Verify(entries), ShouldBeNil)
+		})
+
+		Convey("When the entries are re-encrypted with a new key", func() {
+			rotated, err := NewEncryptor(testKey(2), testKey(1))
+			So(err, ShouldBeNil)
+			rotatedStorage, err := NewFileStorage(tempDir, WithFileEncryptor(rotated))
+			So(err, ShouldBeNil)
+
+			count, err := rotatedStorage.ReencryptAuditEntries(ctx)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 2)
+
+			Convey("Then they should verify with the new key alone", func() {
+				newOnly, err := NewEncryptor(testKey(2))
+				So(err, ShouldBeNil)
+				newStorage, err := NewFileStorage(tempDir, WithFileEncryptor(newOnly))
+				So(err, ShouldBeNil)
+
+				entries, err := newStorage.ListAuditEntries(ctx)
+				So(err, ShouldBeNil)
+				So(entries, ShouldHaveLength, 2)
+				So(audit.Verify(entries), ShouldBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,274 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	// envelopeVersion is the current version of the encrypted envelope format
+	envelopeVersion = 1
+	// keySize is the size in bytes of both master keys and data keys (AES-256)
+	keySize = 32
+)
+
+// ErrUnknownKey is returned when an envelope was sealed with a key that is not in the keyring
+var ErrUnknownKey = errors.New("envelope was encrypted with an unknown key")
+
+/*
+envelope is the on-disk representation of an encrypted payload.
+Each payload is encrypted with a fresh data key, and the data key is
+wrapped with the master key identified by KeyID.
+*/
+type envelope struct {
+	Version    int    `json:"lookatthatmongo_envelope"`
+	KeyID      string `json:"key_id"`
+	WrappedKey string `json:"wrapped_key"`
+	KeyNonce   string `json:"key_nonce"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+/*
+Encryptor performs envelope encryption using AES-GCM.
+It holds a keyring of master keys: the primary key is used to seal new payloads,
+while all keys in the ring can be used to open existing ones. This allows
+records written with an old key to be read during key rotation.
+*/
+type Encryptor struct {
+	primary string
+	keys    map[string][]byte
+}
+
+/*
+NewEncryptor creates a new Encryptor with the given primary master key.
+Additional keys are only used for decryption, which allows reading records
+that were written before a key rotation.
+*/
+func NewEncryptor(primary []byte, previous ...[]byte) (*Encryptor, error) {
+	enc := &Encryptor{keys: make(map[string][]byte)}
+
+	id, err := enc.addKey(primary)
+	if err != nil {
+		return nil, err
+	}
+	enc.primary = id
+
+	for _, key := range previous {
+		if _, err := enc.addKey(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return enc, nil
+}
+
+// addKey validates a master key and adds it to the keyring
+func (e *Encryptor) addKey(key []byte) (string, error) {
+	if len(key) != keySize {
+		return "", fmt.Errorf("encryption key must be %d bytes, got %d", keySize, len(key))
+	}
+	id := KeyID(key)
+	e.keys[id] = key
+	return id, nil
+}
+
+/*
+KeyID returns a short, non-reversible identifier for a master key.
+It is stored in each envelope so the correct key can be selected on read.
+*/
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+/*
+PrimaryKeyID returns the identifier of the key used to seal new payloads.
+*/
+func (e *Encryptor) PrimaryKeyID() string {
+	return e.primary
+}
+
+/*
+Seal encrypts the plaintext with a fresh data key and wraps the data key
+with the primary master key. The result is a JSON envelope.
+*/
+func (e *Encryptor) Seal(plaintext []byte) ([]byte, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	keyNonce, wrappedKey, err := gcmSeal(e.keys[e.primary], dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+
+	nonce, ciphertext, err := gcmSeal(dataKey, plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt payload: %w", err)
+	}
+
+	return json.Marshal(envelope{
+		Version:    envelopeVersion,
+		KeyID:      e.primary,
+		WrappedKey: base64.StdEncoding.EncodeToString(wrappedKey),
+		KeyNonce:   base64.StdEncoding.EncodeToString(keyNonce),
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
+	})
+}
+
+/*
+Open decrypts an envelope produced by Seal. Data that is not an envelope
+is returned unchanged, so that plaintext records written before encryption
+was enabled remain readable.
+*/
+func (e *Encryptor) Open(data []byte) ([]byte, error) {
+	env, ok := parseEnvelope(data)
+	if !ok {
+		return data, nil
+	}
+
+	if env.Version != envelopeVersion {
+		return nil, fmt.Errorf("unsupported envelope version: %d", env.Version)
+	}
+
+	masterKey, ok := e.keys[env.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, env.KeyID)
+	}
+
+	fields := make([][]byte, 0, 4)
+	for _, field := range []string{env.WrappedKey, env.KeyNonce, env.Nonce, env.Ciphertext} {
+		decoded, err := base64.StdEncoding.DecodeString(field)
+		if err != nil {
+			return nil, fmt.Errorf("malformed envelope: %w", err)
+		}
+		fields = append(fields, decoded)
+	}
+
+	dataKey, err := gcmOpen(masterKey, fields[1], fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+
+	plaintext, err := gcmOpen(dataKey, fields[2], fields[3])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt payload: %w", err)
+	}
+
+	return plaintext, nil
+}
+
+/*
+IsEncrypted reports whether the data is an encrypted envelope.
+*/
+func IsEncrypted(data []byte) bool {
+	_, ok := parseEnvelope(data)
+	return ok
+}
+
+// parseEnvelope attempts to decode data as an envelope
+func parseEnvelope(data []byte) (*envelope, bool) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return nil, false
+	}
+
+	var env envelope
+	if err := json.Unmarshal(trimmed, &env); err != nil || env.Version == 0 || env.Ciphertext == "" {
+		return nil, false
+	}
+
+	return &env, true
+}
+
+// gcmSeal encrypts plaintext with AES-GCM using a random nonce
+func gcmSeal(key, plaintext []byte) (nonce, ciphertext []byte, err error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce = make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, err
+	}
+
+	return nonce, aead.Seal(nil, nonce, plaintext, nil), nil
+}
+
+// gcmOpen decrypts ciphertext with AES-GCM
+func gcmOpen(key, nonce, ciphertext []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+// newGCM creates an AES-GCM cipher for the given key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+/*
+LoadKey loads a master key from a file or, if path is empty, from the named
+environment variable. Keys may be provided as raw 32 bytes, base64 or hex.
+It returns nil without error when neither source is configured.
+*/
+func LoadKey(path, envVar string) ([]byte, error) {
+	var raw []byte
+
+	switch {
+	case path != "":
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encryption key file: %w", err)
+		}
+		raw = data
+	case envVar != "" && os.Getenv(envVar) != "":
+		raw = []byte(os.Getenv(envVar))
+	default:
+		return nil, nil
+	}
+
+	return ParseKey(raw)
+}
+
+/*
+ParseKey decodes a master key given as raw 32 bytes, base64 or hex.
+*/
+func ParseKey(raw []byte) ([]byte, error) {
+	if len(raw) == keySize {
+		return raw, nil
+	}
+
+	text := strings.TrimSpace(string(raw))
+
+	if decoded, err := base64.StdEncoding.DecodeString(text); err == nil && len(decoded) == keySize {
+		return decoded, nil
+	}
+
+	if decoded, err := hex.DecodeString(text); err == nil && len(decoded) == keySize {
+		return decoded, nil
+	}
+
+	return nil, fmt.Errorf("encryption key must be %d raw bytes, or base64/hex encoded", keySize)
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testKey returns a deterministic 32 byte key for testing
+func testKey(seed byte) []byte {
+	return bytes.Repeat([]byte{seed}, keySize)
+}
+
+func TestEncryptor(t *testing.T) {
+	Convey("Given an encryptor", t, func() {
+		enc, err := NewEncryptor(testKey(1))
+		So(err, ShouldBeNil)
+
+		Convey("When sealing and opening a payload", func() {
+			plaintext := []byte(`{"id":"test-id"}`)
+			sealed, err := enc.Seal(plaintext)
+			So(err, ShouldBeNil)
+
+			Convey("Then the sealed payload should not contain the plaintext", func() {
+				So(IsEncrypted(sealed), ShouldBeTrue)
+				So(bytes.Contains(sealed, []byte("test-id")), ShouldBeFalse)
+			})
+
+			Convey("Then opening it should return the plaintext", func() {
+				opened, err := enc.Open(sealed)
+				So(err, ShouldBeNil)
+				So(opened, ShouldResemble, plaintext)
+			})
+		})
+
+		Convey("When opening plaintext data", func() {
+			opened, err := enc.Open([]byte(`{"id":"plain"}`))
+
+			Convey("Then it should be returned unchanged", func() {
+				So(err, ShouldBeNil)
+				So(string(opened), ShouldEqual, `{"id":"plain"}`)
+			})
+		})
+
+		Convey("When the key has been rotated", func() {
+			sealed, err := enc.Seal([]byte("secret"))
+			So(err, ShouldBeNil)
+
+			rotated, err := NewEncryptor(testKey(2), testKey(1))
+			So(err, ShouldBeNil)
+			newOnly, err := NewEncryptor(testKey(2))
+			So(err, ShouldBeNil)
+
+			Convey("Then a keyring containing the old key can still open it", func() {
+				opened, err := rotated.Open(sealed)
+				So(err, ShouldBeNil)
+				So(string(opened), ShouldEqual, "secret")
+			})
+
+			Convey("Then a keyring without the old key should fail", func() {
+				_, err := newOnly.Open(sealed)
+				So(err, ShouldWrap, ErrUnknownKey)
+			})
+		})
+	})
+
+	Convey("Given an invalid key", t, func() {
+		_, err := NewEncryptor([]byte("too-short"))
+
+		Convey("Then creating an encryptor should fail", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestParseKey(t *testing.T) {
+	Convey("Given keys in different encodings", t, func() {
+		key := testKey(7)
+
+		Convey("Then raw, base64 and hex keys should decode to the same bytes", func() {
+			raw, err := ParseKey(key)
+			So(err, ShouldBeNil)
+			So(raw, ShouldResemble, key)
+
+			b64, err := ParseKey([]byte(base64.StdEncoding.EncodeToString(key) + "\n"))
+			So(err, ShouldBeNil)
+			So(b64, ShouldResemble, key)
+
+			hexKey, err := ParseKey([]byte("0707070707070707070707070707070707070707070707070707070707070707"))
+			So(err, ShouldBeNil)
+			So(hexKey, ShouldResemble, key)
+		})
+
+		Convey("Then a malformed key should be rejected", func() {
+			_, err := ParseKey([]byte("not-a-key"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestEncryptedFileStorage(t *testing.T) {
+	Convey("Given a file storage with encryption enabled", t, func() {
+		tempDir, err := os.MkdirTemp("", "file_storage_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tempDir)
+
+		enc, err := NewEncryptor(testKey(3))
+		So(err, ShouldBeNil)
+
+		storage, err := NewFileStorage(tempDir, WithFileEncryptor(enc))
+		So(err, ShouldBeNil)
+
+		ctx := context.Background()
+		record := mockOptimizationRecord()
+		So(storage.SaveOptimizationRecord(ctx, record), ShouldBeNil)
+
+		Convey("When reading the raw file", func() {
+			data, err := os.ReadFile(filepath.Join(tempDir, record.DatabaseName, record.ID+".json"))
+			So(err, ShouldBeNil)
+
+			Convey("Then it should be encrypted", func() {
+				So(IsEncrypted(data), ShouldBeTrue)
+				So(bytes.Contains(data, []byte(record.DatabaseName)), ShouldBeFalse)
+			})
+		})
+
+		Convey("When reading the record through the storage", func() {
+			retrieved, err := storage.GetOptimizationRecord(ctx, record.ID, record.DatabaseName)
+
+			Convey("Then it should be decrypted transparently", func() {
+				So(err, ShouldBeNil)
+				So(retrieved.ID, ShouldEqual, record.ID)
+				So(retrieved.ImprovementPct, ShouldEqual, record.ImprovementPct)
+			})
+		})
+
+		Convey("When reading the record without a key", func() {
+			plain, err := NewFileStorage(tempDir)
+			So(err, ShouldBeNil)
+			_, err = plain.GetOptimizationRecord(ctx, record.ID, record.DatabaseName)
+
+			Convey("Then it should fail", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/theapemachine/lookatthatmongo/audit"
+)
+
+/*
+AppendAuditEntry stores an audit entry as its own file under the audit directory,
+encrypted if encryption is enabled. Entry files are created exclusively and are only
+rewritten to re-encrypt them. The hash chain covers the plaintext entries, so it
+verifies whether the entries are stored encrypted or not.
+*/
+func (fs *FileStorage) AppendAuditEntry(ctx context.Context, entry *audit.Entry) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	dir := filepath.Join(fs.basePath, auditDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create audit directory: %w", err)
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+
+	if fs.encryptor != nil {
+		if data, err = fs.encryptor.Seal(data); err != nil {
+			return fmt.Errorf("failed to encrypt audit entry: %w", err)
+		}
+	}
+
+	err = fs.createExclusive(filepath.Join(dir, auditEntryName(entry.Sequence)), data)
+	if os.IsExist(err) {
+		return audit.ErrEntryExists
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+
+	return nil
+}
+
+/*
+ListAuditEntries returns all audit entries ordered by sequence number.
+*/
+func (fs *FileStorage) ListAuditEntries(ctx context.Context) ([]*audit.Entry, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	dir := filepath.Join(fs.basePath, auditDir)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read audit directory: %w", err)
+	}
+
+	var entries []*audit.Entry
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audit entry %s: %w", file.Name(), err)
+		}
+		if data, err = fs.open(data); err != nil {
+			return nil, fmt.Errorf("failed to decrypt audit entry %s: %w", file.Name(), err)
+		}
+
+		var entry audit.Entry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal audit entry %s: %w", file.Name(), err)
+		}
+		entries = append(entries, &entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Sequence < entries[j].Sequence
+	})
+
+	return entries, nil
+}
+
+/*
+ReencryptAuditEntries rewrites every audit entry with the current primary encryption
+key. It is used during key rotation, since entries are never written again otherwise.
+*/
+func (fs *FileStorage) ReencryptAuditEntries(ctx context.Context) (int, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	dir := filepath.Join(fs.basePath, auditDir)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to read audit directory: %w", err)
+	}
+
+	var count int
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		path := filepath.Join(dir, file.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return count, fmt.Errorf("failed to read audit entry %s: %w", file.Name(), err)
+		}
+		if data, err = fs.open(data); err != nil {
+			return count, fmt.Errorf("failed to decrypt audit entry %s: %w", file.Name(), err)
+		}
+		if fs.encryptor != nil {
+			if data, err = fs.encryptor.Seal(data); err != nil {
+				return count, fmt.Errorf("failed to encrypt audit entry %s: %w", file.Name(), err)
+			}
+		}
+
+		if err := fs.writeFileAtomic(path, data); err != nil {
+			return count, fmt.Errorf("failed to rewrite audit entry %s: %w", file.Name(), err)
+		}
+		count++
+	}
+
+	return count, nil
+}
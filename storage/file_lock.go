@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/theapemachine/lookatthatmongo/logger"
+)
+
+/*
+AcquireLock takes a run lock backed by a lock file under the storage directory.
+The lock file is created atomically, so only one process can hold it. An expired
+lock is taken over by removing it, as long as it was not renewed in the meantime.
+*/
+func (fs *FileStorage) AcquireLock(ctx context.Context, key string, ttl time.Duration) (*Lease, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Join(fs.basePath, locksDir), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create locks directory: %w", err)
+	}
+
+	lease, err := newLease(key, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	path := fs.lockPath(key)
+
+	// A second attempt is made after taking over an expired or released lock
+	for attempt := 0; attempt < 2; attempt++ {
+		err := fs.createLockFile(path, lease)
+		if err == nil {
+			return lease, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to create lock file: %w", err)
+		}
+
+		current, err := fs.readLease(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if !current.Expired(time.Now()) {
+			return nil, &LockHeldError{Lease: current}
+		}
+
+		logger.Warn("Taking over expired lock",
+			"key", key,
+			"holder", current.Holder,
+			"expired_at", current.ExpiresAt)
+
+		if err := fs.removeExpiredLock(path, current); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, ErrLockHeld
+}
+
+/*
+removeExpiredLock removes a lock file only if it still holds the expired lease that was
+read. A holder renewing in the meantime keeps its owner but moves its expiry, and its
+lock is left alone.
+*/
+func (fs *FileStorage) removeExpiredLock(path string, expired *Lease) error {
+	again, err := fs.readLease(path)
+	if err != nil {
+		return nil
+	}
+	if again.Owner != expired.Owner || !again.ExpiresAt.Equal(expired.ExpiresAt) || !again.Expired(time.Now()) {
+		return nil
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove expired lock: %w", err)
+	}
+	return nil
+}
+
+/*
+RenewLock extends a lease held through the file storage.
+*/
+func (fs *FileStorage) RenewLock(ctx context.Context, lease *Lease, ttl time.Duration) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	path := fs.lockPath(lease.Key)
+
+	current, err := fs.readLease(path)
+	if os.IsNotExist(err) {
+		return ErrLockLost
+	}
+	if err != nil {
+		return err
+	}
+	if current.Owner != lease.Owner {
+		return ErrLockLost
+	}
+
+	renewed := *lease
+	renewed.ExpiresAt = time.Now().UTC().Add(ttl)
+
+	data, err := json.Marshal(&renewed)
+	if err != nil {
+		return fmt.Errorf("failed to marshal lease: %w", err)
+	}
+
+	if err := fs.writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to renew lock: %w", err)
+	}
+
+	lease.ExpiresAt = renewed.ExpiresAt
+	return nil
+}
+
+/*
+ReleaseLock removes the lock file if it is still owned by the lease.
+*/
+func (fs *FileStorage) ReleaseLock(ctx context.Context, lease *Lease) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	path := fs.lockPath(lease.Key)
+
+	current, err := fs.readLease(path)
+	if os.IsNotExist(err) {
+		return ErrLockLost
+	}
+	if err != nil {
+		return err
+	}
+	if current.Owner != lease.Owner {
+		return ErrLockLost
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove lock file: %w", err)
+	}
+
+	return nil
+}
+
+// lockPath returns the path of the lock file for a key
+func (fs *FileStorage) lockPath(key string) string {
+	return filepath.Join(fs.basePath, locksDir, sanitizeKey(key)+".lock")
+}
+
+// createLockFile writes the lease to the lock file, failing if it already exists
+func (fs *FileStorage) createLockFile(path string, lease *Lease) error {
+	data, err := json.Marshal(lease)
+	if err != nil {
+		return fmt.Errorf("failed to marshal lease: %w", err)
+	}
+
+	return fs.createExclusive(path, data)
+}
+
+// readLease reads the lease stored in a lock file
+func (fs *FileStorage) readLease(path string) (*Lease, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var lease Lease
+	if err := json.Unmarshal(data, &lease); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal lock file %s: %w", path, err)
+	}
+
+	return &lease, nil
+}
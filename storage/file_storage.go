@@ -13,6 +13,7 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/theapemachine/lookatthatmongo/logger"
+	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
 )
 
 /*
@@ -20,23 +21,53 @@ FileStorage implements the Storage interface using the local filesystem.
 It stores optimization records as JSON files in a directory structure.
 */
 type FileStorage struct {
-	basePath string
-	mu       sync.RWMutex
+	basePath    string
+	encryptor   *Encryptor
+	deduplicate bool
+	compression Compression
+	mu          sync.RWMutex
+}
+
+// FileStorageOption defines options for the FileStorage
+type FileStorageOption func(*FileStorage)
+
+// WithFileEncryptor enables envelope encryption of records written to disk
+func WithFileEncryptor(encryptor *Encryptor) FileStorageOption {
+	return func(fs *FileStorage) {
+		fs.encryptor = encryptor
+	}
+}
+
+/*
+WithFileReportDeduplication stores reports once as compressed, content-addressed
+blobs that records reference by hash, instead of embedding them in every record.
+*/
+func WithFileReportDeduplication(compression Compression) FileStorageOption {
+	return func(fs *FileStorage) {
+		fs.deduplicate = true
+		fs.compression = compression
+	}
 }
 
 /*
 NewFileStorage creates a new file storage instance.
 It initializes the storage directory if it doesn't exist.
 */
-func NewFileStorage(basePath string) (*FileStorage, error) {
+func NewFileStorage(basePath string, opts ...FileStorageOption) (*FileStorage, error) {
 	// Create the base directory if it doesn't exist
 	if err := os.MkdirAll(basePath, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create storage directory: %w", err)
 	}
 
-	return &FileStorage{
+	fs := &FileStorage{
 		basePath: basePath,
-	}, nil
+	}
+
+	for _, opt := range opts {
+		opt(fs)
+	}
+
+	return fs, nil
 }
 
 /*
@@ -63,15 +94,31 @@ func (fs *FileStorage) SaveOptimizationRecord(ctx context.Context, record *Optim
 		return fmt.Errorf("failed to create database directory: %w", err)
 	}
 
+	// Store reports separately if deduplication is enabled
+	stored := record
+	if fs.deduplicate {
+		var err error
+		if stored, err = externalizeReports(ctx, record, fs.compression, fs); err != nil {
+			return fmt.Errorf("failed to store reports: %w", err)
+		}
+	}
+
 	// Marshal the record to JSON
-	data, err := json.Marshal(record)
+	data, err := json.Marshal(stored)
 	if err != nil {
 		return fmt.Errorf("failed to marshal optimization record: %w", err)
 	}
 
-	// Save to a file named after the record ID
+	// Encrypt the record if encryption is enabled
+	if fs.encryptor != nil {
+		if data, err = fs.encryptor.Seal(data); err != nil {
+			return fmt.Errorf("failed to encrypt optimization record: %w", err)
+		}
+	}
+
+	// Save to a file named after the record ID, replacing any previous version in one step
 	filePath := filepath.Join(dbDir, record.ID+".json")
-	if err := os.WriteFile(filePath, data, 0644); err != nil {
+	if err := fs.writeFileAtomic(filePath, data); err != nil {
 		return fmt.Errorf("failed to write optimization record: %w", err)
 	}
 
@@ -97,7 +144,7 @@ func (fs *FileStorage) GetOptimizationRecord(ctx context.Context, id string, dbN
 		// Look in the specified database directory
 		dbDir := filepath.Join(fs.basePath, dbName[0])
 		filePath := filepath.Join(dbDir, id+".json")
-		return fs.readRecordFromFile(filePath)
+		return fs.readRecordFromFile(ctx, filePath, nil)
 	}
 
 	// Search in all database directories
@@ -107,9 +154,9 @@ func (fs *FileStorage) GetOptimizationRecord(ctx context.Context, id string, dbN
 	}
 
 	for _, entry := range entries {
-		if entry.IsDir() {
+		if entry.IsDir() && !isReservedDir(entry.Name()) {
 			filePath := filepath.Join(fs.basePath, entry.Name(), id+".json")
-			if record, err := fs.readRecordFromFile(filePath); err == nil {
+			if record, err := fs.readRecordFromFile(ctx, filePath, nil); err == nil {
 				return record, nil
 			}
 		}
@@ -119,29 +166,62 @@ func (fs *FileStorage) GetOptimizationRecord(ctx context.Context, id string, dbN
 }
 
 // Helper function to read a record from a file
-func (fs *FileStorage) readRecordFromFile(filePath string) (*OptimizationRecord, error) {
+func (fs *FileStorage) readRecordFromFile(ctx context.Context, filePath string, cache map[string]*metrics.Report) (*OptimizationRecord, error) {
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read record file: %w", err)
 	}
 
+	if data, err = fs.open(data); err != nil {
+		return nil, err
+	}
+
 	var record OptimizationRecord
 	if err := json.Unmarshal(data, &record); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal record: %w", err)
 	}
 
+	if err := resolveReports(ctx, &record, fs, cache); err != nil {
+		return nil, err
+	}
+
 	return &record, nil
 }
 
+// open decrypts data read from disk if it is an encrypted envelope
+func (fs *FileStorage) open(data []byte) ([]byte, error) {
+	if fs.encryptor != nil {
+		return fs.encryptor.Open(data)
+	}
+	if IsEncrypted(data) {
+		return nil, fmt.Errorf("record is encrypted but no encryption key is configured")
+	}
+	return data, nil
+}
+
 /*
 ListOptimizationRecords lists all optimization records.
 It returns all records across all databases sorted by timestamp (newest first).
 */
 func (fs *FileStorage) ListOptimizationRecords(ctx context.Context) ([]*OptimizationRecord, error) {
+	return fs.listRecords(ctx, false)
+}
+
+/*
+ListOptimizationRecordsStrict lists all optimization records like ListOptimizationRecords,
+but fails on the first record that cannot be read instead of skipping it.
+*/
+func (fs *FileStorage) ListOptimizationRecordsStrict(ctx context.Context) ([]*OptimizationRecord, error) {
+	return fs.listRecords(ctx, true)
+}
+
+// listRecords reads the records of every database, skipping unreadable ones unless strict
+func (fs *FileStorage) listRecords(ctx context.Context, strict bool) ([]*OptimizationRecord, error) {
 	fs.mu.RLock()
 	defer fs.mu.RUnlock()
 
 	var records []*OptimizationRecord
+	cache := make(map[string]*metrics.Report)
 
 	// Read all database directories
 	dbDirs, err := os.ReadDir(fs.basePath)
@@ -151,7 +231,7 @@ func (fs *FileStorage) ListOptimizationRecords(ctx context.Context) ([]*Optimiza
 
 	// Iterate through each database directory
 	for _, dbDir := range dbDirs {
-		if !dbDir.IsDir() {
+		if !dbDir.IsDir() || isReservedDir(dbDir.Name()) {
 			continue
 		}
 
@@ -159,6 +239,9 @@ func (fs *FileStorage) ListOptimizationRecords(ctx context.Context) ([]*Optimiza
 		dbDirPath := filepath.Join(fs.basePath, dbDir.Name())
 		files, err := os.ReadDir(dbDirPath)
 		if err != nil {
+			if strict {
+				return nil, fmt.Errorf("failed to read database directory %s: %w", dbDir.Name(), err)
+			}
 			// Skip directories we can't read
 			continue
 		}
@@ -170,8 +253,11 @@ func (fs *FileStorage) ListOptimizationRecords(ctx context.Context) ([]*Optimiza
 			}
 
 			filePath := filepath.Join(dbDirPath, file.Name())
-			record, err := fs.readRecordFromFile(filePath)
+			record, err := fs.readRecordFromFile(ctx, filePath, cache)
 			if err != nil {
+				if strict {
+					return nil, fmt.Errorf("failed to read record %s: %w", filePath, err)
+				}
 				// Skip files we can't read
 				continue
 			}
@@ -202,6 +288,7 @@ func (fs *FileStorage) ListOptimizationRecordsByDatabase(ctx context.Context, db
 	defer fs.mu.RUnlock()
 
 	var records []*OptimizationRecord
+	cache := make(map[string]*metrics.Report)
 
 	// Check if the database directory exists
 	dbDirPath := filepath.Join(fs.basePath, dbName)
@@ -221,7 +308,7 @@ func (fs *FileStorage) ListOptimizationRecordsByDatabase(ctx context.Context, db
 		}
 
 		filePath := filepath.Join(dbDirPath, file.Name())
-		record, err := fs.readRecordFromFile(filePath)
+		record, err := fs.readRecordFromFile(ctx, filePath, cache)
 		if err != nil {
 			// Skip files we can't read
 			continue
@@ -243,6 +330,86 @@ func (fs *FileStorage) ListOptimizationRecordsByDatabase(ctx context.Context, db
 	return records, nil
 }
 
+// blobPath returns the path of a report blob
+func (fs *FileStorage) blobPath(hash string) string {
+	return filepath.Join(fs.basePath, reportsDir, hash)
+}
+
+// refreshBlob checks whether a report blob exists and updates its modification time if it does
+func (fs *FileStorage) refreshBlob(ctx context.Context, hash string) (bool, error) {
+	now := time.Now()
+	err := os.Chtimes(fs.blobPath(hash), now, now)
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// putBlob writes a report blob, encrypting it if encryption is enabled
+func (fs *FileStorage) putBlob(ctx context.Context, hash string, data []byte) error {
+	if err := os.MkdirAll(filepath.Join(fs.basePath, reportsDir), 0755); err != nil {
+		return fmt.Errorf("failed to create reports directory: %w", err)
+	}
+
+	if fs.encryptor != nil {
+		var err error
+		if data, err = fs.encryptor.Seal(data); err != nil {
+			return fmt.Errorf("failed to encrypt report blob: %w", err)
+		}
+	}
+
+	// Write to a temporary file first so a partially written blob is never visible
+	return fs.writeFileAtomic(fs.blobPath(hash), data)
+}
+
+// getBlob reads a report blob, decrypting it if needed
+func (fs *FileStorage) getBlob(ctx context.Context, hash string) ([]byte, error) {
+	data, err := os.ReadFile(fs.blobPath(hash))
+	if err != nil {
+		return nil, err
+	}
+	return fs.open(data)
+}
+
+/*
+ReencryptReports rewrites every report blob with the current primary encryption key.
+It is used during key rotation, since blobs are shared between records and are
+not rewritten when a record is saved again.
+*/
+func (fs *FileStorage) ReencryptReports(ctx context.Context) (int, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	entries, err := os.ReadDir(filepath.Join(fs.basePath, reportsDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to read reports directory: %w", err)
+	}
+
+	var count int
+	for _, entry := range entries {
+		if entry.IsDir() || strings.Contains(entry.Name(), ".tmp-") {
+			continue
+		}
+
+		data, err := fs.getBlob(ctx, entry.Name())
+		if err != nil {
+			return count, fmt.Errorf("failed to read report blob %s: %w", entry.Name(), err)
+		}
+		if err := fs.putBlob(ctx, entry.Name(), data); err != nil {
+			return count, fmt.Errorf("failed to rewrite report blob %s: %w", entry.Name(), err)
+		}
+		count++
+	}
+
+	return count, nil
+}
+
 /*
 GetLatestOptimizationRecord retrieves the most recent optimization record.
 It returns the record with the latest timestamp.
@@ -260,3 +427,58 @@ func (fs *FileStorage) GetLatestOptimizationRecord(ctx context.Context) (*Optimi
 	// Records are already sorted by timestamp (newest first)
 	return records[0], nil
 }
+
+// writeFileAtomic replaces a file by writing a temporary file and renaming it into place
+func (fs *FileStorage) writeFileAtomic(path string, data []byte) error {
+	tmp, err := fs.writeTempFile(filepath.Dir(path), filepath.Base(path), data)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	return os.Rename(tmp, path)
+}
+
+// writeTempFile writes data to a new temporary file in dir and returns its path
+func (fs *FileStorage) writeTempFile(dir, name string, data []byte) (string, error) {
+	tmp, err := os.CreateTemp(dir, name+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	return tmp.Name(), nil
+}
+
+// createExclusive writes data to a temporary file and links it into place, which fails
+// with os.ErrExist if the file already exists and never exposes a partially written file
+func (fs *FileStorage) createExclusive(path string, data []byte) error {
+	tmp, err := fs.writeTempFile(filepath.Dir(path), filepath.Base(path), data)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	if err := os.Link(tmp, path); err != nil {
+		if le, ok := err.(*os.LinkError); ok && os.IsExist(le.Err) {
+			return os.ErrExist
+		}
+		return err
+	}
+
+	return nil
+}
+
+// isReservedDir reports whether a directory under the base path holds internal data rather than records
+func isReservedDir(name string) bool {
+	return name == reportsDir || name == locksDir || name == auditDir
+}
@@ -122,6 +122,21 @@ func TestGetOptimizationRecord(t *testing.T) {
 			})
 		})
 
+		Convey("When retrieving a record written with index keys as an object", func() {
+			legacy := `{"id": "legacy-id", "database_name": "test-db", "suggestion": {"category": "index",
+				"solution": {"operations": [{"action": "createIndex", "collection": "orders",
+				"keys": {"status": 1, "createdAt": -1, "customer": 1}}]}}}`
+			err := os.WriteFile(filepath.Join(tempDir, "test-db", "legacy-id.json"), []byte(legacy), 0644)
+			So(err, ShouldBeNil)
+
+			retrievedRecord, err := storage.GetOptimizationRecord(ctx, "legacy-id")
+
+			Convey("Then the keys should keep their order", func() {
+				So(err, ShouldBeNil)
+				So(retrievedRecord.Suggestion.Solution.Operations[0].Keys.Fields(), ShouldResemble, []string{"status", "createdAt", "customer"})
+			})
+		})
+
 		Convey("When retrieving a non-existent record", func() {
 			retrievedRecord, err := storage.GetOptimizationRecord(ctx, "non-existent-id")
 
@@ -177,6 +192,44 @@ func TestListOptimizationRecords(t *testing.T) {
 	})
 }
 
+func TestListOptimizationRecordsStrict(t *testing.T) {
+	Convey("Given a file storage instance with an unreadable record", t, func() {
+		// Create a temporary directory for testing
+		tempDir, err := os.MkdirTemp("", "file_storage_test")
+		So(err, ShouldBeNil)
+
+		// Clean up after the test
+		defer os.RemoveAll(tempDir)
+
+		storage, err := NewFileStorage(tempDir)
+		So(err, ShouldBeNil)
+
+		ctx := context.Background()
+		record := mockOptimizationRecord()
+		So(storage.SaveOptimizationRecord(ctx, record), ShouldBeNil)
+		So(os.WriteFile(filepath.Join(tempDir, record.DatabaseName, "corrupt.json"), []byte("{"), 0644), ShouldBeNil)
+
+		Convey("When listing all records", func() {
+			records, err := storage.ListOptimizationRecords(ctx)
+
+			Convey("Then the unreadable record should be skipped", func() {
+				So(err, ShouldBeNil)
+				So(records, ShouldHaveLength, 1)
+			})
+		})
+
+		Convey("When listing all records strictly", func() {
+			records, err := storage.ListOptimizationRecordsStrict(ctx)
+
+			Convey("Then it should fail", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "corrupt.json")
+				So(records, ShouldBeNil)
+			})
+		})
+	})
+}
+
 func TestListOptimizationRecordsByDatabase(t *testing.T) {
 	Convey("Given a file storage instance with records from different databases", t, func() {
 		// Create a temporary directory for testing
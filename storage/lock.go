@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// locksDir is the directory (or key segment) under which run locks are stored
+const locksDir = "_locks"
+
+// ErrLockHeld is returned when a lock is held by another run and its lease has not expired
+var ErrLockHeld = errors.New("lock is held by another run")
+
+// ErrLockLost is returned when renewing or releasing a lease that is no longer held
+var ErrLockLost = errors.New("lock lease is no longer held")
+
+/*
+Lease represents a held run lock.
+The lease expires at ExpiresAt unless it is renewed, after which another run may take the lock over.
+*/
+type Lease struct {
+	Key        string    `json:"key" bson:"_id"`
+	Owner      string    `json:"owner" bson:"owner"`
+	Holder     string    `json:"holder" bson:"holder"`
+	AcquiredAt time.Time `json:"acquired_at" bson:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at" bson:"expires_at"`
+
+	// version identifies the stored lock for conditional updates (e.g. an S3 ETag)
+	version string
+}
+
+/*
+Expired reports whether the lease has expired at the given time.
+*/
+func (l *Lease) Expired(now time.Time) bool {
+	return !now.Before(l.ExpiresAt)
+}
+
+/*
+Locker is implemented by backends that can hold distributed run locks.
+Locks are keyed by cluster and database (see LockKey) so that two runs never
+optimize the same database at the same time.
+*/
+type Locker interface {
+	// AcquireLock takes the lock for the key, returning ErrLockHeld if another run holds it
+	AcquireLock(ctx context.Context, key string, ttl time.Duration) (*Lease, error)
+
+	// RenewLock extends a held lease, returning ErrLockLost if it was taken over
+	RenewLock(ctx context.Context, lease *Lease, ttl time.Duration) error
+
+	// ReleaseLock releases a held lease
+	ReleaseLock(ctx context.Context, lease *Lease) error
+}
+
+/*
+LockKey returns the lock key for a database on a cluster.
+The result only contains characters that are safe in file names and object keys.
+*/
+func LockKey(cluster, dbName string) string {
+	return sanitizeKey(cluster) + "." + sanitizeKey(dbName)
+}
+
+// sanitizeKey replaces characters that are not safe in file names and object keys.
+// Dots are kept, since LockKey uses them to separate the cluster from the database.
+func sanitizeKey(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, value)
+}
+
+// newLease creates a lease for the key owned by this process
+func newLease(key string, ttl time.Duration) (*Lease, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("failed to generate lock owner: %w", err)
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	now := time.Now().UTC()
+	return &Lease{
+		Key:        key,
+		Owner:      hex.EncodeToString(token),
+		Holder:     fmt.Sprintf("%s:%d", host, os.Getpid()),
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(ttl),
+	}, nil
+}
+
+/*
+LockHeldError describes the lease that prevented a lock from being acquired.
+It wraps ErrLockHeld.
+*/
+type LockHeldError struct {
+	Lease *Lease
+}
+
+// Error returns the error message
+func (e *LockHeldError) Error() string {
+	return fmt.Sprintf("%v: %s is held by %s until %s",
+		ErrLockHeld, e.Lease.Key, e.Lease.Holder, e.Lease.ExpiresAt.Format(time.RFC3339))
+}
+
+// Unwrap returns ErrLockHeld
+func (e *LockHeldError) Unwrap() error {
+	return ErrLockHeld
+}
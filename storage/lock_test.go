@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLockKey(t *testing.T) {
+	Convey("Given a cluster and database name", t, func() {
+		key := LockKey("host1:27017,host2:27017", "my/db")
+
+		Convey("Then the key should only contain safe characters", func() {
+			So(key, ShouldEqual, "host1_27017_host2_27017.my_db")
+		})
+	})
+}
+
+func TestFileStorageLock(t *testing.T) {
+	Convey("Given a file storage", t, func() {
+		tempDir, err := os.MkdirTemp("", "file_storage_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tempDir)
+
+		storage, err := NewFileStorage(tempDir)
+		So(err, ShouldBeNil)
+
+		ctx := context.Background()
+		key := LockKey("localhost:27017", "test-db")
+
+		Convey("When a run acquires the lock", func() {
+			lease, err := storage.AcquireLock(ctx, key, time.Minute)
+			So(err, ShouldBeNil)
+			So(lease.Owner, ShouldNotBeEmpty)
+
+			Convey("Then a second run should be refused", func() {
+				_, err := storage.AcquireLock(ctx, key, time.Minute)
+				So(err, ShouldWrap, ErrLockHeld)
+			})
+
+			Convey("Then a lock on another database should be independent", func() {
+				other, err := storage.AcquireLock(ctx, LockKey("localhost:27017", "other-db"), time.Minute)
+				So(err, ShouldBeNil)
+				So(other.Key, ShouldNotEqual, lease.Key)
+			})
+
+			Convey("Then the lock should not show up as a record", func() {
+				records, err := storage.ListOptimizationRecords(ctx)
+				So(err, ShouldBeNil)
+				So(records, ShouldBeEmpty)
+			})
+
+			Convey("Then renewing should extend the lease", func() {
+				before := lease.ExpiresAt
+				So(storage.RenewLock(ctx, lease, time.Hour), ShouldBeNil)
+				So(lease.ExpiresAt.After(before), ShouldBeTrue)
+			})
+
+			Convey("Then after releasing it the lock can be acquired again", func() {
+				So(storage.ReleaseLock(ctx, lease), ShouldBeNil)
+
+				again, err := storage.AcquireLock(ctx, key, time.Minute)
+				So(err, ShouldBeNil)
+				So(again.Owner, ShouldNotEqual, lease.Owner)
+
+				Convey("And the old lease should be lost", func() {
+					So(storage.RenewLock(ctx, lease, time.Minute), ShouldEqual, ErrLockLost)
+					So(storage.ReleaseLock(ctx, lease), ShouldEqual, ErrLockLost)
+				})
+			})
+		})
+
+		Convey("When the lease of the holder has expired", func() {
+			stale, err := storage.AcquireLock(ctx, key, time.Minute)
+			So(err, ShouldBeNil)
+
+			stale.ExpiresAt = time.Now().Add(-time.Second)
+			data, err := json.Marshal(stale)
+			So(err, ShouldBeNil)
+			So(os.WriteFile(storage.lockPath(key), data, 0644), ShouldBeNil)
+
+			Convey("Then another run should take the lock over", func() {
+				lease, err := storage.AcquireLock(ctx, key, time.Minute)
+				So(err, ShouldBeNil)
+				So(lease.Owner, ShouldNotEqual, stale.Owner)
+
+				So(storage.RenewLock(ctx, stale, time.Minute), ShouldEqual, ErrLockLost)
+			})
+
+			Convey("Then a renewal landing before the takeover should keep the lock", func() {
+				expired := *stale
+				renewed := *stale
+				renewed.ExpiresAt = time.Now().Add(time.Minute)
+				data, err := json.Marshal(&renewed)
+				So(err, ShouldBeNil)
+				So(os.WriteFile(storage.lockPath(key), data, 0644), ShouldBeNil)
+
+				So(storage.removeExpiredLock(storage.lockPath(key), &expired), ShouldBeNil)
+
+				current, err := storage.readLease(storage.lockPath(key))
+				So(err, ShouldBeNil)
+				So(current.Owner, ShouldEqual, stale.Owner)
+				So(current.ExpiresAt.Equal(renewed.ExpiresAt), ShouldBeTrue)
+
+				_, err = storage.AcquireLock(ctx, key, time.Minute)
+				So(err, ShouldWrap, ErrLockHeld)
+			})
+		})
+	})
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/theapemachine/lookatthatmongo/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultLockCollection is the collection that holds run locks in MongoDB
+const DefaultLockCollection = "lookatthatmongo_locks"
+
+/*
+MongoLocker implements Locker with one lock document per key in a MongoDB collection.
+It can be used when the storage backend cannot provide locks, or when several
+runs share a cluster but not a storage location.
+*/
+type MongoLocker struct {
+	collection *mongo.Collection
+}
+
+/*
+NewMongoLocker creates a locker that stores lock documents in the given collection.
+*/
+func NewMongoLocker(collection *mongo.Collection) *MongoLocker {
+	return &MongoLocker{collection: collection}
+}
+
+/*
+AcquireLock takes a run lock by upserting the lock document for the key.
+The upsert only matches an expired lock, so when a live lock exists it fails
+with a duplicate key error on _id and the lock is reported as held.
+*/
+func (ml *MongoLocker) AcquireLock(ctx context.Context, key string, ttl time.Duration) (*Lease, error) {
+	lease, err := newLease(key, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{
+		"_id":        key,
+		"expires_at": bson.M{"$lte": lease.AcquiredAt},
+	}
+
+	result, err := ml.collection.ReplaceOne(ctx, filter, lease, options.Replace().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		var current Lease
+		if err := ml.collection.FindOne(ctx, bson.M{"_id": key}).Decode(&current); err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return nil, ErrLockHeld
+			}
+			return nil, fmt.Errorf("failed to read lock document: %w", err)
+		}
+		return nil, &LockHeldError{Lease: &current}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire lock: %w", err)
+	}
+
+	if result.MatchedCount > 0 {
+		logger.Warn("Took over expired lock", "key", key)
+	}
+
+	return lease, nil
+}
+
+/*
+RenewLock extends a lease if the lock document is still owned by it.
+*/
+func (ml *MongoLocker) RenewLock(ctx context.Context, lease *Lease, ttl time.Duration) error {
+	expiresAt := time.Now().UTC().Add(ttl)
+
+	result, err := ml.collection.UpdateOne(ctx,
+		bson.M{"_id": lease.Key, "owner": lease.Owner},
+		bson.M{"$set": bson.M{"expires_at": expiresAt}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to renew lock: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrLockLost
+	}
+
+	lease.ExpiresAt = expiresAt
+	return nil
+}
+
+/*
+ReleaseLock deletes the lock document if it is still owned by the lease.
+*/
+func (ml *MongoLocker) ReleaseLock(ctx context.Context, lease *Lease) error {
+	result, err := ml.collection.DeleteOne(ctx, bson.M{"_id": lease.Key, "owner": lease.Owner})
+	if err != nil {
+		return fmt.Errorf("failed to release lock: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrLockLost
+	}
+
+	return nil
+}
@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
+)
+
+// Compression represents the codec used for stored report blobs
+type Compression string
+
+const (
+	// CompressionNone stores report blobs uncompressed
+	CompressionNone Compression = "none"
+	// CompressionGzip compresses report blobs with gzip
+	CompressionGzip Compression = "gzip"
+	// CompressionZstd compresses report blobs with zstd
+	CompressionZstd Compression = "zstd"
+)
+
+// reportsDir is the directory (or key segment) under which report blobs are stored
+const reportsDir = "_reports"
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+	// zstd encoders and decoders are safe for concurrent use with EncodeAll/DecodeAll
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+/*
+ParseCompression converts a string to a Compression value.
+*/
+func ParseCompression(value string) (Compression, error) {
+	switch Compression(strings.ToLower(value)) {
+	case "", CompressionNone:
+		return CompressionNone, nil
+	case CompressionGzip:
+		return CompressionGzip, nil
+	case CompressionZstd:
+		return CompressionZstd, nil
+	default:
+		return "", fmt.Errorf("invalid compression: %s (valid values: none, gzip, zstd)", value)
+	}
+}
+
+/*
+blobStore is implemented by storage backends that can hold content-addressed report blobs.
+Blobs are immutable, so writing an existing hash is a no-op. Reusing a blob refreshes
+it, so backends that collect unreferenced blobs can tell it is still in use.
+*/
+type blobStore interface {
+	refreshBlob(ctx context.Context, hash string) (bool, error)
+	putBlob(ctx context.Context, hash string, data []byte) error
+	getBlob(ctx context.Context, hash string) ([]byte, error)
+}
+
+/*
+externalizeReports returns a shallow copy of the record in which the before and after
+reports are replaced by references to compressed, content-addressed blobs.
+Reports that are already stored are refreshed rather than written again.
+*/
+func externalizeReports(ctx context.Context, record *OptimizationRecord, compression Compression, blobs blobStore) (*OptimizationRecord, error) {
+	stored := *record
+
+	for _, ref := range []struct {
+		report *metrics.Report
+		hash   *string
+		clear  func()
+	}{
+		{record.BeforeReport, &stored.BeforeReportRef, func() { stored.BeforeReport = nil }},
+		{record.AfterReport, &stored.AfterReportRef, func() { stored.AfterReport = nil }},
+	} {
+		if ref.report == nil {
+			continue
+		}
+
+		data, err := json.Marshal(ref.report)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal report: %w", err)
+		}
+
+		hash := ContentHash(data)
+
+		exists, err := blobs.refreshBlob(ctx, hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check report blob: %w", err)
+		}
+
+		if !exists {
+			compressed, err := compress(compression, data)
+			if err != nil {
+				return nil, fmt.Errorf("failed to compress report: %w", err)
+			}
+			if err := blobs.putBlob(ctx, hash, compressed); err != nil {
+				return nil, fmt.Errorf("failed to store report blob: %w", err)
+			}
+		}
+
+		*ref.hash = hash
+		ref.clear()
+	}
+
+	return &stored, nil
+}
+
+/*
+resolveReports loads the reports referenced by a record back into it.
+The cache avoids reading and decompressing the same blob more than once
+when many records share a report.
+*/
+func resolveReports(ctx context.Context, record *OptimizationRecord, blobs blobStore, cache map[string]*metrics.Report) error {
+	for _, ref := range []struct {
+		hash   string
+		report **metrics.Report
+	}{
+		{record.BeforeReportRef, &record.BeforeReport},
+		{record.AfterReportRef, &record.AfterReport},
+	} {
+		if ref.hash == "" || *ref.report != nil {
+			continue
+		}
+
+		if cached, ok := cache[ref.hash]; ok {
+			*ref.report = cached
+			continue
+		}
+
+		data, err := blobs.getBlob(ctx, ref.hash)
+		if err != nil {
+			return fmt.Errorf("failed to load report %s: %w", ref.hash, err)
+		}
+
+		if data, err = decompress(data); err != nil {
+			return fmt.Errorf("failed to decompress report %s: %w", ref.hash, err)
+		}
+
+		if ContentHash(data) != ref.hash {
+			return fmt.Errorf("report %s failed integrity check", ref.hash)
+		}
+
+		var report metrics.Report
+		if err := json.Unmarshal(data, &report); err != nil {
+			return fmt.Errorf("failed to unmarshal report %s: %w", ref.hash, err)
+		}
+
+		if cache != nil {
+			cache[ref.hash] = &report
+		}
+		*ref.report = &report
+	}
+
+	return nil
+}
+
+/*
+ContentHash returns the hex encoded SHA-256 of the data, used to address report blobs.
+*/
+func ContentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// compress encodes data with the given compression
+func compress(compression Compression, data []byte) ([]byte, error) {
+	switch compression {
+	case CompressionGzip:
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionZstd:
+		return zstdEncoder.EncodeAll(data, nil), nil
+	default:
+		return data, nil
+	}
+}
+
+// decompress decodes data based on its magic bytes, returning uncompressed data unchanged
+func decompress(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, gzipMagic):
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return io.ReadAll(reader)
+	case bytes.HasPrefix(data, zstdMagic):
+		return zstdDecoder.DecodeAll(data, nil)
+	default:
+		return data, nil
+	}
+}
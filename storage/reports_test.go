@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
+)
+
+func TestCompression(t *testing.T) {
+	Convey("Given a payload", t, func() {
+		data := []byte(`{"timestamp":"2025-01-01T00:00:00Z","collections":{"users":[]}}`)
+
+		for _, compression := range []Compression{CompressionNone, CompressionGzip, CompressionZstd} {
+			Convey("When compressing with "+string(compression), func() {
+				compressed, err := compress(compression, data)
+				So(err, ShouldBeNil)
+
+				Convey("Then decompressing should return the original payload", func() {
+					decompressed, err := decompress(compressed)
+					So(err, ShouldBeNil)
+					So(decompressed, ShouldResemble, data)
+				})
+			})
+		}
+	})
+
+	Convey("Given compression names", t, func() {
+		Convey("Then valid names should parse and invalid names should fail", func() {
+			c, err := ParseCompression("ZSTD")
+			So(err, ShouldBeNil)
+			So(c, ShouldEqual, CompressionZstd)
+
+			_, err = ParseCompression("lz4")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestFileStorageReportDeduplication(t *testing.T) {
+	Convey("Given a file storage with report deduplication", t, func() {
+		tempDir, err := os.MkdirTemp("", "file_storage_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tempDir)
+
+		storage, err := NewFileStorage(tempDir, WithFileReportDeduplication(CompressionGzip))
+		So(err, ShouldBeNil)
+
+		ctx := context.Background()
+		report := &metrics.Report{
+			Timestamp:     time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+			DatabaseStats: map[string]*metrics.DatabaseStats{"test-db": {Name: "test-db", Objects: 42}},
+		}
+
+		Convey("When saving two records that share the same reports", func() {
+			first := mockOptimizationRecord()
+			first.ID = "first"
+			first.BeforeReport = report
+			first.AfterReport = report
+
+			second := mockOptimizationRecord()
+			second.ID = "second"
+			second.BeforeReport = report
+			second.AfterReport = report
+
+			So(storage.SaveOptimizationRecord(ctx, first), ShouldBeNil)
+			So(storage.SaveOptimizationRecord(ctx, second), ShouldBeNil)
+
+			Convey("Then the report should be stored once", func() {
+				blobs, err := os.ReadDir(filepath.Join(tempDir, reportsDir))
+				So(err, ShouldBeNil)
+				So(blobs, ShouldHaveLength, 1)
+			})
+
+			Convey("Then the caller's record should keep its reports", func() {
+				So(first.BeforeReport, ShouldEqual, report)
+			})
+
+			Convey("Then listing should resolve the referenced reports", func() {
+				records, err := storage.ListOptimizationRecords(ctx)
+				So(err, ShouldBeNil)
+				So(records, ShouldHaveLength, 2)
+
+				for _, record := range records {
+					So(record.BeforeReportRef, ShouldNotBeEmpty)
+					So(record.BeforeReport, ShouldNotBeNil)
+					So(record.BeforeReport.DatabaseStats["test-db"].Objects, ShouldEqual, 42)
+				}
+			})
+
+			Convey("Then a storage without deduplication should still read them", func() {
+				plain, err := NewFileStorage(tempDir)
+				So(err, ShouldBeNil)
+
+				record, err := plain.GetOptimizationRecord(ctx, "first", "test-db")
+				So(err, ShouldBeNil)
+				So(record.AfterReport, ShouldNotBeNil)
+				So(record.AfterReport.DatabaseStats["test-db"].Objects, ShouldEqual, 42)
+			})
+		})
+	})
+}
+
+func TestFileStorageReencryptReports(t *testing.T) {
+	Convey("Given an encrypted file storage with report deduplication", t, func() {
+		tempDir, err := os.MkdirTemp("", "file_storage_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tempDir)
+
+		oldKey, err := NewEncryptor(testKey(4))
+		So(err, ShouldBeNil)
+
+		storage, err := NewFileStorage(tempDir, WithFileEncryptor(oldKey), WithFileReportDeduplication(CompressionZstd))
+		So(err, ShouldBeNil)
+
+		ctx := context.Background()
+		record := mockOptimizationRecord()
+		record.BeforeReport = &metrics.Report{Timestamp: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+		So(storage.SaveOptimizationRecord(ctx, record), ShouldBeNil)
+
+		Convey("When the reports are re-encrypted with a new key", func() {
+			rotated, err := NewEncryptor(testKey(5), testKey(4))
+			So(err, ShouldBeNil)
+
+			rekeyed, err := NewFileStorage(tempDir, WithFileEncryptor(rotated), WithFileReportDeduplication(CompressionZstd))
+			So(err, ShouldBeNil)
+			So(rekeyed.SaveOptimizationRecord(ctx, record), ShouldBeNil)
+
+			count, err := rekeyed.ReencryptReports(ctx)
+			So(err, ShouldBeNil)
+
+			blobs, err := os.ReadDir(filepath.Join(tempDir, reportsDir))
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, len(blobs))
+
+			Convey("Then the record should be readable with only the new key", func() {
+				newKey, err := NewEncryptor(testKey(5))
+				So(err, ShouldBeNil)
+
+				reader, err := NewFileStorage(tempDir, WithFileEncryptor(newKey))
+				So(err, ShouldBeNil)
+
+				retrieved, err := reader.GetOptimizationRecord(ctx, record.ID, record.DatabaseName)
+				So(err, ShouldBeNil)
+				So(retrieved.BeforeReport, ShouldNotBeNil)
+			})
+		})
+	})
+}
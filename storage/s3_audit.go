@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/theapemachine/lookatthatmongo/audit"
+)
+
+/*
+AppendAuditEntry stores an audit entry as its own object under the audit prefix,
+encrypted if encryption is enabled. The object is created with a conditional put, so
+existing entries are never overwritten. The hash chain covers the plaintext entries,
+so it verifies whether the entries are stored encrypted or not.
+*/
+func (s *S3Storage) AppendAuditEntry(ctx context.Context, entry *audit.Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return &S3StorageError{Message: "failed to marshal audit entry", Err: err}
+	}
+
+	if s.encryptor != nil {
+		if data, err = s.encryptor.Seal(data); err != nil {
+			return &S3StorageError{Message: "failed to encrypt audit entry", Err: err}
+		}
+	}
+
+	input := s.putObjectInput(s.auditPrefix+auditEntryName(entry.Sequence), data, "application/json")
+	input.IfNoneMatch = aws.String("*")
+
+	_, err = s.client.PutObject(ctx, input)
+	if isConditionFailed(err) {
+		return audit.ErrEntryExists
+	}
+	if err != nil {
+		return &S3StorageError{Message: "failed to upload audit entry to S3", Err: err}
+	}
+
+	return nil
+}
+
+/*
+ListAuditEntries returns all audit entries ordered by sequence number.
+*/
+func (s *S3Storage) ListAuditEntries(ctx context.Context) ([]*audit.Entry, error) {
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.auditPrefix),
+	})
+
+	var entries []*audit.Entry
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, &S3StorageError{Message: "failed to list audit entries in S3", Err: err}
+		}
+
+		for _, obj := range page.Contents {
+			if !strings.HasSuffix(*obj.Key, ".json") {
+				continue
+			}
+
+			data, err := s.getAuditEntry(ctx, *obj.Key)
+			if err != nil {
+				return nil, err
+			}
+
+			var entry audit.Entry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return nil, &S3StorageError{Message: "failed to unmarshal audit entry", Err: err}
+			}
+			entries = append(entries, &entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Sequence < entries[j].Sequence
+	})
+
+	return entries, nil
+}
+
+/*
+ReencryptAuditEntries rewrites every audit entry with the current primary encryption
+key. It is used during key rotation, since entries are never written again otherwise.
+*/
+func (s *S3Storage) ReencryptAuditEntries(ctx context.Context) (int, error) {
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.auditPrefix),
+	})
+
+	var count int
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return count, &S3StorageError{Message: "failed to list audit entries in S3", Err: err}
+		}
+
+		for _, obj := range page.Contents {
+			if !strings.HasSuffix(*obj.Key, ".json") {
+				continue
+			}
+
+			data, err := s.getAuditEntry(ctx, *obj.Key)
+			if err != nil {
+				return count, err
+			}
+			if s.encryptor != nil {
+				if data, err = s.encryptor.Seal(data); err != nil {
+					return count, &S3StorageError{Message: "failed to encrypt audit entry", Err: err}
+				}
+			}
+
+			if _, err := s.client.PutObject(ctx, s.putObjectInput(*obj.Key, data, "application/json")); err != nil {
+				return count, &S3StorageError{Message: "failed to rewrite audit entry " + *obj.Key, Err: err}
+			}
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// getAuditEntry downloads a single audit entry and returns it decrypted
+func (s *S3Storage) getAuditEntry(ctx context.Context, key string) ([]byte, error) {
+	output, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, &S3StorageError{Message: "failed to get audit entry from S3", Err: err}
+	}
+	defer output.Body.Close()
+
+	data, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, &S3StorageError{Message: "failed to read audit entry", Err: err}
+	}
+
+	if s.encryptor != nil {
+		if data, err = s.encryptor.Open(data); err != nil {
+			return nil, &S3StorageError{Message: "failed to decrypt audit entry", Err: err}
+		}
+	} else if IsEncrypted(data) {
+		return nil, &S3StorageError{Message: "audit entry is encrypted but no encryption key is configured"}
+	}
+
+	return data, nil
+}
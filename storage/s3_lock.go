@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/theapemachine/lookatthatmongo/logger"
+)
+
+/*
+AcquireLock takes a run lock backed by an S3 object.
+The lock object is created with a conditional put (If-None-Match), so only one
+run can create it. An expired lock is taken over with a put conditioned on its ETag.
+*/
+func (s *S3Storage) AcquireLock(ctx context.Context, key string, ttl time.Duration) (*Lease, error) {
+	lease, err := newLease(key, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	// A second attempt is made if the lock was released between the put and the read
+	for attempt := 0; attempt < 2; attempt++ {
+		err := s.putLease(ctx, lease, func(input *s3.PutObjectInput) {
+			input.IfNoneMatch = aws.String("*")
+		})
+		if err == nil {
+			return lease, nil
+		}
+		if !isConditionFailed(err) {
+			return nil, &S3StorageError{Message: "failed to create lock object", Err: err}
+		}
+
+		current, err := s.getLease(ctx, key)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, &S3StorageError{Message: "failed to read lock object", Err: err}
+		}
+
+		if !current.Expired(time.Now()) {
+			return nil, &LockHeldError{Lease: current}
+		}
+
+		logger.Warn("Taking over expired lock",
+			"key", key,
+			"holder", current.Holder,
+			"expired_at", current.ExpiresAt)
+
+		// Replace the expired lease only if nobody renewed or took it over in the meantime
+		err = s.putLease(ctx, lease, func(input *s3.PutObjectInput) {
+			input.IfMatch = aws.String(current.version)
+		})
+		if err == nil {
+			return lease, nil
+		}
+		if isConditionFailed(err) || isNotFound(err) {
+			return nil, ErrLockHeld
+		}
+		return nil, &S3StorageError{Message: "failed to take over expired lock", Err: err}
+	}
+
+	return nil, ErrLockHeld
+}
+
+/*
+RenewLock extends a lease held through S3, conditioned on the lock object
+not having changed since it was last written by this lease.
+*/
+func (s *S3Storage) RenewLock(ctx context.Context, lease *Lease, ttl time.Duration) error {
+	renewed := *lease
+	renewed.ExpiresAt = time.Now().UTC().Add(ttl)
+
+	err := s.putLease(ctx, &renewed, func(input *s3.PutObjectInput) {
+		input.IfMatch = aws.String(lease.version)
+	})
+	if isConditionFailed(err) || isNotFound(err) {
+		return ErrLockLost
+	}
+	if err != nil {
+		return &S3StorageError{Message: "failed to renew lock", Err: err}
+	}
+
+	lease.ExpiresAt = renewed.ExpiresAt
+	lease.version = renewed.version
+	return nil
+}
+
+/*
+ReleaseLock deletes the lock object if it was not taken over by another run.
+*/
+func (s *S3Storage) ReleaseLock(ctx context.Context, lease *Lease) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket:  aws.String(s.bucket),
+		Key:     aws.String(s.lockKey(lease.Key)),
+		IfMatch: aws.String(lease.version),
+	})
+	if isConditionFailed(err) || isNotFound(err) {
+		return ErrLockLost
+	}
+	if err != nil {
+		return &S3StorageError{Message: "failed to delete lock object", Err: err}
+	}
+
+	return nil
+}
+
+// lockKey returns the object key of the lock for a key
+func (s *S3Storage) lockKey(key string) string {
+	return filepath.Join(s.prefix, locksDir, sanitizeKey(key)+".lock")
+}
+
+// putLease writes a lease to its lock object and records the resulting ETag
+func (s *S3Storage) putLease(ctx context.Context, lease *Lease, condition func(*s3.PutObjectInput)) error {
+	data, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+
+	input := s.putObjectInput(s.lockKey(lease.Key), data, "application/json")
+	condition(input)
+
+	output, err := s.client.PutObject(ctx, input)
+	if err != nil {
+		return err
+	}
+
+	lease.version = aws.ToString(output.ETag)
+	return nil
+}
+
+// getLease reads the lease stored in a lock object, including its ETag
+func (s *S3Storage) getLease(ctx context.Context, key string) (*Lease, error) {
+	output, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.lockKey(key)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer output.Body.Close()
+
+	data, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var lease Lease
+	if err := json.Unmarshal(data, &lease); err != nil {
+		return nil, err
+	}
+
+	lease.version = aws.ToString(output.ETag)
+	return &lease, nil
+}
+
+// isConditionFailed reports whether a conditional S3 request failed because the object changed
+func isConditionFailed(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	switch apiErr.ErrorCode() {
+	case "PreconditionFailed", "ConditionalRequestConflict":
+		return true
+	}
+	return false
+}
+
+// isNotFound reports whether an S3 request failed because the object does not exist
+func isNotFound(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	if errors.As(err, &noSuchKey) || errors.As(err, &notFound) {
+		return true
+	}
+
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "NotFound"
+}
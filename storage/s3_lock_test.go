@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// newLockTestStorage creates an S3Storage backed by a mock client for lock tests
+func newLockTestStorage() (*S3Storage, *mockS3Client) {
+	client := new(mockS3Client)
+	return &S3Storage{bucket: "test-bucket", prefix: "test-prefix/", client: client}, client
+}
+
+func TestS3Storage_AcquireLock(t *testing.T) {
+	storage, client := newLockTestStorage()
+	ctx := context.Background()
+
+	client.On("PutObject", ctx, mock.MatchedBy(func(input *s3.PutObjectInput) bool {
+		return *input.Key == "test-prefix/_locks/cluster.db.lock" && aws.ToString(input.IfNoneMatch) == "*"
+	})).Return(&s3.PutObjectOutput{ETag: aws.String(`"etag-1"`)}, nil)
+
+	lease, err := storage.AcquireLock(ctx, "cluster.db", time.Minute)
+
+	assert.NoError(t, err)
+	assert.Equal(t, `"etag-1"`, lease.version)
+	client.AssertExpectations(t)
+}
+
+func TestS3Storage_AcquireLockHeld(t *testing.T) {
+	storage, client := newLockTestStorage()
+	ctx := context.Background()
+
+	current := &Lease{Key: "cluster.db", Owner: "other", Holder: "host:1", ExpiresAt: time.Now().Add(time.Minute)}
+	data, _ := json.Marshal(current)
+
+	client.On("PutObject", ctx, mock.Anything).
+		Return(nil, &smithy.GenericAPIError{Code: "PreconditionFailed"})
+	client.On("GetObject", ctx, mock.Anything).
+		Return(&s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(string(data))), ETag: aws.String(`"etag-1"`)}, nil)
+
+	_, err := storage.AcquireLock(ctx, "cluster.db", time.Minute)
+
+	assert.ErrorIs(t, err, ErrLockHeld)
+	client.AssertNumberOfCalls(t, "PutObject", 1)
+}
+
+func TestS3Storage_AcquireLockExpired(t *testing.T) {
+	storage, client := newLockTestStorage()
+	ctx := context.Background()
+
+	current := &Lease{Key: "cluster.db", Owner: "other", Holder: "host:1", ExpiresAt: time.Now().Add(-time.Minute)}
+	data, _ := json.Marshal(current)
+
+	client.On("PutObject", ctx, mock.MatchedBy(func(input *s3.PutObjectInput) bool {
+		return input.IfNoneMatch != nil
+	})).Return(nil, &smithy.GenericAPIError{Code: "PreconditionFailed"})
+	client.On("GetObject", ctx, mock.Anything).
+		Return(&s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(string(data))), ETag: aws.String(`"etag-1"`)}, nil)
+	client.On("PutObject", ctx, mock.MatchedBy(func(input *s3.PutObjectInput) bool {
+		return aws.ToString(input.IfMatch) == `"etag-1"`
+	})).Return(&s3.PutObjectOutput{ETag: aws.String(`"etag-2"`)}, nil)
+
+	lease, err := storage.AcquireLock(ctx, "cluster.db", time.Minute)
+
+	assert.NoError(t, err)
+	assert.NotEqual(t, "other", lease.Owner)
+	assert.Equal(t, `"etag-2"`, lease.version)
+	client.AssertExpectations(t)
+}
+
+func TestS3Storage_RenewAndReleaseLock(t *testing.T) {
+	storage, client := newLockTestStorage()
+	ctx := context.Background()
+	lease := &Lease{Key: "cluster.db", Owner: "me", version: `"etag-1"`}
+
+	client.On("PutObject", ctx, mock.MatchedBy(func(input *s3.PutObjectInput) bool {
+		return aws.ToString(input.IfMatch) == `"etag-1"`
+	})).Return(&s3.PutObjectOutput{ETag: aws.String(`"etag-2"`)}, nil)
+	client.On("DeleteObject", ctx, mock.MatchedBy(func(input *s3.DeleteObjectInput) bool {
+		return aws.ToString(input.IfMatch) == `"etag-2"`
+	})).Return(&s3.DeleteObjectOutput{}, nil)
+
+	assert.NoError(t, storage.RenewLock(ctx, lease, time.Minute))
+	assert.Equal(t, `"etag-2"`, lease.version)
+	assert.NoError(t, storage.ReleaseLock(ctx, lease))
+	client.AssertExpectations(t)
+}
+
+func TestS3Storage_RenewLockLost(t *testing.T) {
+	storage, client := newLockTestStorage()
+	ctx := context.Background()
+	lease := &Lease{Key: "cluster.db", Owner: "me", version: `"etag-1"`}
+
+	client.On("PutObject", ctx, mock.Anything).
+		Return(nil, &smithy.GenericAPIError{Code: "PreconditionFailed"})
+
+	assert.ErrorIs(t, storage.RenewLock(ctx, lease, time.Minute), ErrLockLost)
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeS3Server records the requests made against an S3-compatible endpoint
+type fakeS3Server struct {
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+func (f *fakeS3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, r)
+	f.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestNewS3Storage_CustomEndpoint(t *testing.T) {
+	fake := &fakeS3Server{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	storage, err := NewS3Storage(context.Background(),
+		WithBucket("test-bucket"),
+		WithEndpoint(server.URL),
+		WithPathStyle(true),
+		WithStaticCredentials("test-access-key", "test-secret-key", ""),
+	)
+
+	assert.NoError(t, err)
+	assert.NotNil(t, storage)
+	if assert.Len(t, fake.requests, 1) {
+		req := fake.requests[0]
+		assert.Equal(t, http.MethodHead, req.Method)
+		assert.Equal(t, "/test-bucket", req.URL.Path)
+		assert.Contains(t, req.Header.Get("Authorization"), "Credential=test-access-key/")
+	}
+}
+
+func TestNewS3Storage_CredentialsFile(t *testing.T) {
+	fake := &fakeS3Server{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	credentialsFile := filepath.Join(t.TempDir(), "credentials")
+	content := "[default]\naws_access_key_id = file-access-key\naws_secret_access_key = file-secret-key\n"
+	assert.NoError(t, os.WriteFile(credentialsFile, []byte(content), 0600))
+
+	// Keep environment credentials from taking precedence over the file
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_PROFILE", "")
+
+	_, err := NewS3Storage(context.Background(),
+		WithBucket("test-bucket"),
+		WithEndpoint(server.URL),
+		WithPathStyle(true),
+		WithCredentialsFile(credentialsFile),
+	)
+
+	assert.NoError(t, err)
+	if assert.Len(t, fake.requests, 1) {
+		authorization := fake.requests[0].Header.Get("Authorization")
+		assert.True(t, strings.Contains(authorization, "Credential=file-access-key/"), authorization)
+	}
+}
+
+func TestS3Storage_PutObjectInputSSEKMS(t *testing.T) {
+	storage := &S3Storage{bucket: "test-bucket"}
+
+	input := storage.putObjectInput("key.json", []byte("{}"), "application/json")
+	assert.Empty(t, input.ServerSideEncryption)
+	assert.Nil(t, input.SSEKMSKeyId)
+
+	WithSSEKMS("test-kms-key")(storage)
+	input = storage.putObjectInput("key.json", []byte("{}"), "application/json")
+	assert.Equal(t, types.ServerSideEncryptionAwsKms, input.ServerSideEncryption)
+	assert.Equal(t, "test-kms-key", aws.ToString(input.SSEKMSKeyId))
+	assert.Equal(t, "test-bucket", aws.ToString(input.Bucket))
+}
@@ -14,15 +14,20 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/charmbracelet/log"
 	"github.com/theapemachine/lookatthatmongo/logger"
+	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
 )
 
 const (
 	// DefaultRecordsPrefix is the default prefix for optimization records in S3
 	DefaultRecordsPrefix = "optimization-records/"
+
+	// blobGracePeriod is how long an unreferenced report blob is kept after it was last written or reused
+	blobGracePeriod = 24 * time.Hour
 )
 
 // S3StorageError defines custom errors for S3Storage
@@ -70,19 +75,97 @@ func WithClient(client *s3.Client) S3StorageOption {
 	}
 }
 
+/*
+WithEndpoint sets a custom S3 endpoint URL, for S3-compatible services
+such as MinIO, Ceph or LocalStack.
+*/
+func WithEndpoint(endpoint string) S3StorageOption {
+	return func(s *S3Storage) {
+		s.endpoint = endpoint
+	}
+}
+
+// WithPathStyle enables path-style addressing (endpoint/bucket/key) instead of virtual-hosted buckets
+func WithPathStyle(pathStyle bool) S3StorageOption {
+	return func(s *S3Storage) {
+		s.pathStyle = pathStyle
+	}
+}
+
+// WithStaticCredentials sets explicit credentials instead of the default AWS credential chain
+func WithStaticCredentials(accessKeyID, secretAccessKey, sessionToken string) S3StorageOption {
+	return func(s *S3Storage) {
+		s.credentials = credentials.NewStaticCredentialsProvider(accessKeyID, secretAccessKey, sessionToken)
+	}
+}
+
+// WithCredentialsFile loads credentials from the given shared AWS credentials file
+func WithCredentialsFile(path string) S3StorageOption {
+	return func(s *S3Storage) {
+		s.credentialsFile = path
+	}
+}
+
+/*
+WithSSEKMS enables server-side encryption with AWS KMS for uploaded objects.
+An empty key ID uses the bucket's default (AWS managed) KMS key.
+*/
+func WithSSEKMS(keyID string) S3StorageOption {
+	return func(s *S3Storage) {
+		s.sseKMS = true
+		s.kmsKeyID = keyID
+	}
+}
+
+// WithEncryptor enables envelope encryption of records before upload
+func WithEncryptor(encryptor *Encryptor) S3StorageOption {
+	return func(s *S3Storage) {
+		s.encryptor = encryptor
+	}
+}
+
+// WithAuditPrefix sets the S3 prefix of the audit log, which should not overlap with the records prefix
+func WithAuditPrefix(prefix string) S3StorageOption {
+	return func(s *S3Storage) {
+		s.auditPrefix = prefix
+	}
+}
+
+/*
+WithReportDeduplication stores reports once as compressed, content-addressed
+objects that records reference by hash, instead of embedding them in every record.
+*/
+func WithReportDeduplication(compression Compression) S3StorageOption {
+	return func(s *S3Storage) {
+		s.deduplicate = true
+		s.compression = compression
+	}
+}
+
 // S3Storage implements the Storage interface using AWS S3
 type S3Storage struct {
-	bucket string
-	prefix string
-	region string
-	client s3ClientAPI
+	bucket          string
+	prefix          string
+	auditPrefix     string
+	region          string
+	endpoint        string
+	pathStyle       bool
+	credentials     aws.CredentialsProvider
+	credentialsFile string
+	sseKMS          bool
+	kmsKeyID        string
+	client          s3ClientAPI
+	encryptor       *Encryptor
+	deduplicate     bool
+	compression     Compression
 }
 
 // NewS3Storage creates a new S3Storage instance
 func NewS3Storage(ctx context.Context, opts ...S3StorageOption) (*S3Storage, error) {
 	storage := &S3Storage{
-		prefix: DefaultRecordsPrefix,
-		region: "us-east-1", // Default region
+		prefix:      DefaultRecordsPrefix,
+		auditPrefix: DefaultAuditPrefix,
+		region:      "us-east-1", // Default region
 	}
 
 	// Apply options
@@ -97,11 +180,27 @@ func NewS3Storage(ctx context.Context, opts ...S3StorageOption) (*S3Storage, err
 
 	// If client is not provided, create one
 	if storage.client == nil {
-		cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(storage.region))
+		loadOpts := []func(*config.LoadOptions) error{
+			config.WithRegion(storage.region),
+		}
+		if storage.credentialsFile != "" {
+			loadOpts = append(loadOpts, config.WithSharedCredentialsFiles([]string{storage.credentialsFile}))
+		}
+		if storage.credentials != nil {
+			loadOpts = append(loadOpts, config.WithCredentialsProvider(storage.credentials))
+		}
+
+		cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
 		if err != nil {
 			return nil, &S3StorageError{Message: "failed to load AWS config", Err: err}
 		}
-		storage.client = s3.NewFromConfig(cfg)
+
+		storage.client = s3.NewFromConfig(cfg, func(o *s3.Options) {
+			if storage.endpoint != "" {
+				o.BaseEndpoint = aws.String(storage.endpoint)
+			}
+			o.UsePathStyle = storage.pathStyle
+		})
 	}
 
 	// Ensure the bucket exists
@@ -112,7 +211,11 @@ func NewS3Storage(ctx context.Context, opts ...S3StorageOption) (*S3Storage, err
 		return nil, &S3StorageError{Message: "failed to access S3 bucket", Err: err}
 	}
 
-	logger.Info("S3 storage initialized", "bucket", storage.bucket, "prefix", storage.prefix)
+	logger.Info("S3 storage initialized",
+		"bucket", storage.bucket,
+		"prefix", storage.prefix,
+		"endpoint", storage.endpoint,
+		"sse_kms", storage.sseKMS)
 	return storage, nil
 }
 
@@ -152,19 +255,33 @@ func (s *S3Storage) SaveOptimizationRecord(ctx context.Context, record *Optimiza
 	// Generate the object key
 	key := s.getObjectKey(record)
 
+	// Store reports separately if deduplication is enabled
+	stored := record
+	if s.deduplicate {
+		var err error
+		if stored, err = externalizeReports(ctx, record, s.compression, s); err != nil {
+			return &S3StorageError{Message: "failed to store reports", Err: err}
+		}
+	}
+
 	// Convert record to JSON
-	data, err := json.Marshal(record)
+	data, err := json.Marshal(stored)
 	if err != nil {
 		return &S3StorageError{Message: "failed to marshal record to JSON", Err: err}
 	}
 
+	contentType := "application/json"
+
+	// Encrypt the record if encryption is enabled
+	if s.encryptor != nil {
+		if data, err = s.encryptor.Seal(data); err != nil {
+			return &S3StorageError{Message: "failed to encrypt record", Err: err}
+		}
+		contentType = "application/vnd.lookatthatmongo.envelope+json"
+	}
+
 	// Upload to S3
-	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket:      aws.String(s.bucket),
-		Key:         aws.String(key),
-		Body:        bytes.NewReader(data),
-		ContentType: aws.String("application/json"),
-	})
+	_, err = s.client.PutObject(ctx, s.putObjectInput(key, data, contentType))
 	if err != nil {
 		return &S3StorageError{Message: "failed to upload record to S3", Err: err}
 	}
@@ -201,7 +318,7 @@ func (s *S3Storage) GetOptimizationRecord(ctx context.Context, id string, dbName
 	for _, obj := range resp.Contents {
 		objID, _ := s.parseObjectKey(*obj.Key)
 		if objID == id {
-			return s.getRecord(ctx, *obj.Key)
+			return s.getRecord(ctx, *obj.Key, nil)
 		}
 	}
 
@@ -209,7 +326,7 @@ func (s *S3Storage) GetOptimizationRecord(ctx context.Context, id string, dbName
 }
 
 // getRecord retrieves and parses a record from S3
-func (s *S3Storage) getRecord(ctx context.Context, key string) (*OptimizationRecord, error) {
+func (s *S3Storage) getRecord(ctx context.Context, key string, cache map[string]*metrics.Report) (*OptimizationRecord, error) {
 	// Get the object from S3
 	resp, err := s.client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
@@ -230,18 +347,31 @@ func (s *S3Storage) getRecord(ctx context.Context, key string) (*OptimizationRec
 		return nil, &S3StorageError{Message: "failed to read object body", Err: err}
 	}
 
+	// Decrypt the object if it is an encrypted envelope
+	if s.encryptor != nil {
+		if data, err = s.encryptor.Open(data); err != nil {
+			return nil, &S3StorageError{Message: "failed to decrypt record", Err: err}
+		}
+	} else if IsEncrypted(data) {
+		return nil, &S3StorageError{Message: "record is encrypted but no encryption key is configured"}
+	}
+
 	// Parse the JSON data
 	var record OptimizationRecord
 	if err := json.Unmarshal(data, &record); err != nil {
 		return nil, &S3StorageError{Message: "failed to unmarshal record from JSON", Err: err}
 	}
 
+	if err := resolveReports(ctx, &record, s, cache); err != nil {
+		return nil, &S3StorageError{Message: "failed to resolve record reports", Err: err}
+	}
+
 	return &record, nil
 }
 
 // ListOptimizationRecords lists all optimization records
 func (s *S3Storage) ListOptimizationRecords(ctx context.Context) ([]*OptimizationRecord, error) {
-	records, err := s.listRecordsByPrefix(ctx, s.prefix)
+	records, _, err := s.listRecordsByPrefix(ctx, s.prefix)
 	if err != nil {
 		return nil, err
 	}
@@ -254,14 +384,36 @@ func (s *S3Storage) ListOptimizationRecords(ctx context.Context) ([]*Optimizatio
 	return records, nil
 }
 
+/*
+ListOptimizationRecordsStrict lists all optimization records like ListOptimizationRecords,
+but fails when any record cannot be read instead of skipping it.
+*/
+func (s *S3Storage) ListOptimizationRecordsStrict(ctx context.Context) ([]*OptimizationRecord, error) {
+	records, unreadable, err := s.listRecordsByPrefix(ctx, s.prefix)
+	if err != nil {
+		return nil, err
+	}
+	if len(unreadable) > 0 {
+		return nil, &S3StorageError{Message: fmt.Sprintf("failed to read %d records: %s", len(unreadable), strings.Join(unreadable, ", "))}
+	}
+
+	// Sort records by timestamp (newest first)
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Timestamp.After(records[j].Timestamp)
+	})
+
+	return records, nil
+}
+
 // ListOptimizationRecordsByDatabase lists optimization records for a specific database
 func (s *S3Storage) ListOptimizationRecordsByDatabase(ctx context.Context, dbName string) ([]*OptimizationRecord, error) {
 	if dbName == "" {
 		return nil, &S3StorageError{Message: "database name cannot be empty"}
 	}
 
-	prefix := filepath.Join(s.prefix, dbName)
-	records, err := s.listRecordsByPrefix(ctx, prefix)
+	// The trailing slash keeps databases sharing a name prefix apart
+	prefix := filepath.Join(s.prefix, dbName) + "/"
+	records, _, err := s.listRecordsByPrefix(ctx, prefix)
 	if err != nil {
 		return nil, err
 	}
@@ -274,9 +426,11 @@ func (s *S3Storage) ListOptimizationRecordsByDatabase(ctx context.Context, dbNam
 	return records, nil
 }
 
-// listRecordsByPrefix lists all records with a specific prefix
-func (s *S3Storage) listRecordsByPrefix(ctx context.Context, prefix string) ([]*OptimizationRecord, error) {
+// listRecordsByPrefix lists all records with a specific prefix, along with the keys of records it could not read
+func (s *S3Storage) listRecordsByPrefix(ctx context.Context, prefix string) ([]*OptimizationRecord, []string, error) {
 	var records []*OptimizationRecord
+	var unreadable []string
+	cache := make(map[string]*metrics.Report)
 
 	// Use pagination to handle large numbers of objects
 	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
@@ -287,21 +441,27 @@ func (s *S3Storage) listRecordsByPrefix(ctx context.Context, prefix string) ([]*
 	for paginator.HasMorePages() {
 		page, err := paginator.NextPage(ctx)
 		if err != nil {
-			return nil, &S3StorageError{Message: "failed to list objects in S3", Err: err}
+			return nil, nil, &S3StorageError{Message: "failed to list objects in S3", Err: err}
 		}
 
 		// Process each object in the page
 		for _, obj := range page.Contents {
-			record, err := s.getRecord(ctx, *obj.Key)
+			// Skip report blobs, audit entries and anything else that is not a record
+			if !strings.HasSuffix(*obj.Key, ".json") || s.isAuditKey(*obj.Key) {
+				continue
+			}
+
+			record, err := s.getRecord(ctx, *obj.Key, cache)
 			if err != nil {
 				log.Warn("Failed to get record", "key", *obj.Key, "error", err)
+				unreadable = append(unreadable, *obj.Key)
 				continue
 			}
 			records = append(records, record)
 		}
 	}
 
-	return records, nil
+	return records, unreadable, nil
 }
 
 // GetLatestOptimizationRecord gets the most recent optimization record
@@ -323,7 +483,7 @@ func (s *S3Storage) GetLatestOptimizationRecord(ctx context.Context) (*Optimizat
 // DeleteOldRecords deletes records older than the specified duration
 func (s *S3Storage) DeleteOldRecords(ctx context.Context, age time.Duration) (int, error) {
 	// List all records
-	records, err := s.ListOptimizationRecords(ctx)
+	records, unreadable, err := s.listRecordsByPrefix(ctx, s.prefix)
 	if err != nil {
 		return 0, err
 	}
@@ -331,16 +491,25 @@ func (s *S3Storage) DeleteOldRecords(ctx context.Context, age time.Duration) (in
 	cutoff := time.Now().Add(-age)
 	var objectsToDelete []types.ObjectIdentifier
 	var count int
+	referenced := make(map[string]bool)
+	var usesBlobs bool
 
 	// Identify records to delete
 	for _, record := range records {
+		usesBlobs = usesBlobs || record.BeforeReportRef != "" || record.AfterReportRef != ""
+
 		if record.Timestamp.Before(cutoff) {
 			key := s.getObjectKey(record)
 			objectsToDelete = append(objectsToDelete, types.ObjectIdentifier{
 				Key: aws.String(key),
 			})
 			count++
+			continue
 		}
+
+		// Remember which report blobs are still in use
+		referenced[record.BeforeReportRef] = true
+		referenced[record.AfterReportRef] = true
 	}
 
 	// If no objects to delete, return early
@@ -348,7 +517,74 @@ func (s *S3Storage) DeleteOldRecords(ctx context.Context, age time.Duration) (in
 		return 0, nil
 	}
 
-	// Delete objects in batches (S3 allows up to 1000 objects per request)
+	if deleted, err := s.deleteObjects(ctx, objectsToDelete); err != nil {
+		return deleted, err
+	}
+
+	// Remove report blobs that are no longer referenced by any record. A record that
+	// could not be read may reference any blob, so none are removed in that case.
+	switch {
+	case usesBlobs && len(unreadable) > 0:
+		logger.Warn("Skipping deletion of unreferenced report blobs, some records could not be read", "unreadable", len(unreadable))
+	case usesBlobs:
+		if err := s.deleteUnreferencedBlobs(ctx, referenced); err != nil {
+			logger.Warn("Failed to delete unreferenced report blobs", "error", err)
+		}
+	}
+
+	logger.Info("Deleted old optimization records", "count", count, "age", age.String())
+	return count, nil
+}
+
+/*
+deleteUnreferencedBlobs deletes report blobs whose hash is not in the referenced set.
+Blobs written or reused within the grace period are kept, since a record saved while
+the records were being listed may reference them; each one is checked again right
+before it is deleted.
+*/
+func (s *S3Storage) deleteUnreferencedBlobs(ctx context.Context, referenced map[string]bool) error {
+	var orphans []types.ObjectIdentifier
+	cutoff := time.Now().Add(-blobGracePeriod)
+
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(filepath.Join(s.prefix, reportsDir) + "/"),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return &S3StorageError{Message: "failed to list report blobs in S3", Err: err}
+		}
+
+		for _, obj := range page.Contents {
+			if referenced[filepath.Base(*obj.Key)] || !modifiedBefore(obj.LastModified, cutoff) {
+				continue
+			}
+
+			head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+				Bucket: aws.String(s.bucket),
+				Key:    obj.Key,
+			})
+			if err != nil || !modifiedBefore(head.LastModified, cutoff) {
+				continue
+			}
+
+			orphans = append(orphans, types.ObjectIdentifier{Key: obj.Key})
+		}
+	}
+
+	if len(orphans) == 0 {
+		return nil
+	}
+
+	_, err := s.deleteObjects(ctx, orphans)
+	return err
+}
+
+// deleteObjects deletes objects in batches (S3 allows up to 1000 objects per request)
+// and returns the number of objects deleted before any failure
+func (s *S3Storage) deleteObjects(ctx context.Context, objectsToDelete []types.ObjectIdentifier) (int, error) {
 	const batchSize = 1000
 	for i := 0; i < len(objectsToDelete); i += batchSize {
 		end := i + batchSize
@@ -365,13 +601,155 @@ func (s *S3Storage) DeleteOldRecords(ctx context.Context, age time.Duration) (in
 			},
 		})
 		if err != nil {
-			return count - (len(objectsToDelete) - i), &S3StorageError{
+			return i, &S3StorageError{
 				Message: "failed to delete objects from S3",
 				Err:     err,
 			}
 		}
 	}
 
-	logger.Info("Deleted old optimization records", "count", count, "age", age.String())
+	return len(objectsToDelete), nil
+}
+
+/*
+ReencryptReports rewrites every report blob with the current primary encryption key.
+It is used during key rotation, since blobs are shared between records and are
+not rewritten when a record is saved again.
+*/
+func (s *S3Storage) ReencryptReports(ctx context.Context) (int, error) {
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(filepath.Join(s.prefix, reportsDir) + "/"),
+	})
+
+	var count int
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return count, &S3StorageError{Message: "failed to list report blobs in S3", Err: err}
+		}
+
+		for _, obj := range page.Contents {
+			hash := filepath.Base(*obj.Key)
+
+			data, err := s.getBlob(ctx, hash)
+			if err != nil {
+				return count, &S3StorageError{Message: "failed to read report blob " + hash, Err: err}
+			}
+			if err := s.putBlob(ctx, hash, data); err != nil {
+				return count, &S3StorageError{Message: "failed to rewrite report blob " + hash, Err: err}
+			}
+			count++
+		}
+	}
+
 	return count, nil
 }
+
+// modifiedBefore reports whether an object was last modified before the cutoff
+func modifiedBefore(lastModified *time.Time, cutoff time.Time) bool {
+	return lastModified != nil && lastModified.Before(cutoff)
+}
+
+// isAuditKey reports whether an object key belongs to the audit log
+func (s *S3Storage) isAuditKey(key string) bool {
+	return s.auditPrefix != "" && strings.HasPrefix(key, s.auditPrefix)
+}
+
+// blobKey returns the object key of a report blob
+func (s *S3Storage) blobKey(hash string) string {
+	return filepath.Join(s.prefix, reportsDir, hash)
+}
+
+/*
+refreshBlob checks whether a report blob exists and, if it does, copies it onto itself
+so its modification time shows it is still in use and it is kept by DeleteOldRecords.
+*/
+func (s *S3Storage) refreshBlob(ctx context.Context, hash string) (bool, error) {
+	key := s.blobKey(hash)
+
+	_, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	input := &s3.CopyObjectInput{
+		Bucket:            aws.String(s.bucket),
+		Key:               aws.String(key),
+		CopySource:        aws.String(s.bucket + "/" + key),
+		ContentType:       aws.String("application/octet-stream"),
+		MetadataDirective: types.MetadataDirectiveReplace,
+	}
+	if s.sseKMS {
+		input.ServerSideEncryption = types.ServerSideEncryptionAwsKms
+		if s.kmsKeyID != "" {
+			input.SSEKMSKeyId = aws.String(s.kmsKeyID)
+		}
+	}
+
+	if _, err := s.client.CopyObject(ctx, input); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// putBlob uploads a report blob, encrypting it if encryption is enabled
+func (s *S3Storage) putBlob(ctx context.Context, hash string, data []byte) error {
+	if s.encryptor != nil {
+		var err error
+		if data, err = s.encryptor.Seal(data); err != nil {
+			return err
+		}
+	}
+
+	_, err := s.client.PutObject(ctx, s.putObjectInput(s.blobKey(hash), data, "application/octet-stream"))
+	return err
+}
+
+// putObjectInput builds an upload request, applying server-side encryption when configured
+func (s *S3Storage) putObjectInput(key string, data []byte, contentType string) *s3.PutObjectInput {
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String(contentType),
+	}
+
+	if s.sseKMS {
+		input.ServerSideEncryption = types.ServerSideEncryptionAwsKms
+		if s.kmsKeyID != "" {
+			input.SSEKMSKeyId = aws.String(s.kmsKeyID)
+		}
+	}
+
+	return input
+}
+
+// getBlob downloads a report blob, decrypting it if needed
+func (s *S3Storage) getBlob(ctx context.Context, hash string) ([]byte, error) {
+	resp, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.blobKey(hash)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.encryptor != nil {
+		return s.encryptor.Open(data)
+	}
+	return data, nil
+}
@@ -30,6 +30,15 @@ func (m *mockS3Client) HeadBucket(ctx context.Context, params *s3.HeadBucketInpu
 	return args.Get(0).(*s3.HeadBucketOutput), args.Error(1)
 }
 
+// HeadObject mocks the HeadObject operation
+func (m *mockS3Client) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	args := m.Called(ctx, params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*s3.HeadObjectOutput), args.Error(1)
+}
+
 // PutObject mocks the PutObject operation
 func (m *mockS3Client) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
 	args := m.Called(ctx, params)
@@ -39,6 +48,15 @@ func (m *mockS3Client) PutObject(ctx context.Context, params *s3.PutObjectInput,
 	return args.Get(0).(*s3.PutObjectOutput), args.Error(1)
 }
 
+// CopyObject mocks the CopyObject operation
+func (m *mockS3Client) CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	args := m.Called(ctx, params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*s3.CopyObjectOutput), args.Error(1)
+}
+
 // GetObject mocks the GetObject operation
 func (m *mockS3Client) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
 	args := m.Called(ctx, params)
@@ -57,6 +75,15 @@ func (m *mockS3Client) ListObjectsV2(ctx context.Context, params *s3.ListObjects
 	return args.Get(0).(*s3.ListObjectsV2Output), args.Error(1)
 }
 
+// DeleteObject mocks the DeleteObject operation
+func (m *mockS3Client) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	args := m.Called(ctx, params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*s3.DeleteObjectOutput), args.Error(1)
+}
+
 // DeleteObjects mocks the DeleteObjects operation
 func (m *mockS3Client) DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
 	args := m.Called(ctx, params)
@@ -343,3 +370,111 @@ func TestS3Storage_DeleteOldRecords(t *testing.T) {
 
 	mockClient.AssertExpectations(t)
 }
+
+func TestS3Storage_DeleteOldRecordsReportBlobs(t *testing.T) {
+	ctx := context.Background()
+	stale := time.Now().Add(-48 * time.Hour)
+	recent := time.Now().Add(-time.Minute)
+
+	// Report blobs are addressed by the hash of their content
+	dead := ContentHash([]byte(`{"database":"dead"}`))
+	live := ContentHash([]byte(`{"database":"live"}`))
+	blobs := map[string]string{dead: `{"database":"dead"}`, live: `{"database":"live"}`}
+
+	oldJSON := `{"id":"old","timestamp":"` + stale.Format(time.RFC3339) + `","database_name":"test-db","before_report_ref":"` + dead + `"}`
+	newJSON := `{"id":"new","timestamp":"` + recent.Format(time.RFC3339) + `","database_name":"test-db","before_report_ref":"` + live + `"}`
+
+	// newStorage returns a storage whose records list holds the given record keys
+	newStorage := func(keys ...string) (*S3Storage, *mockS3Client) {
+		mockClient := new(mockS3Client)
+		mockClient.On("HeadBucket", ctx, mock.Anything).Return(&s3.HeadBucketOutput{}, nil)
+
+		var contents []types.Object
+		for _, key := range keys {
+			contents = append(contents, types.Object{Key: aws.String(key)})
+		}
+		mockClient.On("ListObjectsV2", ctx, mock.MatchedBy(func(input *s3.ListObjectsV2Input) bool {
+			return *input.Prefix == "optimization-records/"
+		})).Return(&s3.ListObjectsV2Output{Contents: contents}, nil)
+
+		mockClient.On("GetObject", ctx, &s3.GetObjectInput{
+			Bucket: aws.String("test-bucket"),
+			Key:    aws.String("optimization-records/test-db/old.json"),
+		}).Return(newMockS3Output(oldJSON), nil)
+		mockClient.On("GetObject", ctx, &s3.GetObjectInput{
+			Bucket: aws.String("test-bucket"),
+			Key:    aws.String("optimization-records/test-db/new.json"),
+		}).Return(newMockS3Output(newJSON), nil)
+		for hash, content := range blobs {
+			mockClient.On("GetObject", ctx, &s3.GetObjectInput{
+				Bucket: aws.String("test-bucket"),
+				Key:    aws.String("optimization-records/_reports/" + hash),
+			}).Return(newMockS3Output(content), nil)
+		}
+
+		mockClient.On("DeleteObjects", ctx, mock.MatchedBy(func(input *s3.DeleteObjectsInput) bool {
+			return len(input.Delete.Objects) == 1 && *input.Delete.Objects[0].Key == "optimization-records/test-db/old.json"
+		})).Return(&s3.DeleteObjectsOutput{}, nil).Once()
+
+		storage, err := NewS3Storage(ctx, WithBucket("test-bucket"), mockS3Option(mockClient))
+		assert.NoError(t, err)
+		return storage, mockClient
+	}
+
+	t.Run("deletes only unreferenced blobs older than the grace period", func(t *testing.T) {
+		storage, mockClient := newStorage("optimization-records/test-db/old.json", "optimization-records/test-db/new.json")
+
+		mockClient.On("ListObjectsV2", ctx, mock.MatchedBy(func(input *s3.ListObjectsV2Input) bool {
+			return *input.Prefix == "optimization-records/_reports/"
+		})).Return(&s3.ListObjectsV2Output{
+			Contents: []types.Object{
+				{Key: aws.String("optimization-records/_reports/" + live), LastModified: aws.Time(stale)},
+				{Key: aws.String("optimization-records/_reports/" + dead), LastModified: aws.Time(stale)},
+				{Key: aws.String("optimization-records/_reports/fresh"), LastModified: aws.Time(recent)},
+				{Key: aws.String("optimization-records/_reports/reused"), LastModified: aws.Time(stale)},
+			},
+		}, nil)
+
+		// The reused blob was refreshed by a save after the blobs were listed
+		mockClient.On("HeadObject", ctx, &s3.HeadObjectInput{
+			Bucket: aws.String("test-bucket"),
+			Key:    aws.String("optimization-records/_reports/" + dead),
+		}).Return(&s3.HeadObjectOutput{LastModified: aws.Time(stale)}, nil)
+		mockClient.On("HeadObject", ctx, &s3.HeadObjectInput{
+			Bucket: aws.String("test-bucket"),
+			Key:    aws.String("optimization-records/_reports/reused"),
+		}).Return(&s3.HeadObjectOutput{LastModified: aws.Time(recent)}, nil)
+
+		mockClient.On("DeleteObjects", ctx, mock.MatchedBy(func(input *s3.DeleteObjectsInput) bool {
+			return len(input.Delete.Objects) == 1 && *input.Delete.Objects[0].Key == "optimization-records/_reports/"+dead
+		})).Return(&s3.DeleteObjectsOutput{}, nil).Once()
+
+		count, err := storage.DeleteOldRecords(ctx, 24*time.Hour)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, count)
+
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("keeps every blob when a record cannot be read", func(t *testing.T) {
+		storage, mockClient := newStorage(
+			"optimization-records/test-db/old.json",
+			"optimization-records/test-db/new.json",
+			"optimization-records/test-db/broken.json",
+		)
+
+		mockClient.On("GetObject", ctx, &s3.GetObjectInput{
+			Bucket: aws.String("test-bucket"),
+			Key:    aws.String("optimization-records/test-db/broken.json"),
+		}).Return(nil, assert.AnError)
+
+		count, err := storage.DeleteOldRecords(ctx, 24*time.Hour)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, count)
+
+		mockClient.AssertExpectations(t)
+		mockClient.AssertNotCalled(t, "ListObjectsV2", ctx, mock.MatchedBy(func(input *s3.ListObjectsV2Input) bool {
+			return *input.Prefix == "optimization-records/_reports/"
+		}))
+	})
+}
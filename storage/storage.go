@@ -6,6 +6,7 @@ import (
 
 	"github.com/theapemachine/lookatthatmongo/ai"
 	"github.com/theapemachine/lookatthatmongo/mongodb/metrics"
+	"github.com/theapemachine/lookatthatmongo/mongodb/optimizer"
 )
 
 /*
@@ -25,6 +26,43 @@ type OptimizationRecord struct {
 	ImprovementPct   float64                    `json:"improvement_pct"`
 	RollbackRequired bool                       `json:"rollback_required"`
 	RollbackSuccess  bool                       `json:"rollback_success"`
+
+	// BeforeReportRef and AfterReportRef hold the content hashes of the reports when
+	// they are stored separately from the record (see report deduplication)
+	BeforeReportRef string `json:"before_report_ref,omitempty"`
+	AfterReportRef  string `json:"after_report_ref,omitempty"`
+
+	// StagedDrop is set on the records that track an index hidden ahead of its drop
+	StagedDrop *StagedDrop `json:"staged_drop,omitempty"`
+
+	// WhatIf holds the estimated benefit of the indexes the run created, when the
+	// what-if analysis is enabled
+	WhatIf []optimizer.IndexEstimate `json:"what_if,omitempty"`
+}
+
+const (
+	// StagedDropHidden is the state of an index that is hidden and being observed
+	StagedDropHidden = "hidden"
+	// StagedDropDropped is the state of an index that was dropped after its observation
+	StagedDropDropped = "dropped"
+	// StagedDropRestored is the state of an index that was unhidden after a regression
+	StagedDropRestored = "restored"
+)
+
+/*
+StagedDrop tracks an index that was hidden instead of dropped. The query shapes that
+used the index are kept as a baseline, so a later run can tell whether hiding it made
+them slower before the index is dropped for good.
+*/
+type StagedDrop struct {
+	Collection string                      `json:"collection"`
+	Index      string                      `json:"index"`
+	Snapshot   ai.Document                 `json:"snapshot,omitempty"`
+	HiddenAt   time.Time                   `json:"hidden_at"`
+	State      string                      `json:"state"`
+	Baseline   []metrics.QueryPatternStats `json:"baseline,omitempty"`
+	DecidedAt  time.Time                   `json:"decided_at,omitempty"`
+	Reason     string                      `json:"reason,omitempty"`
 }
 
 /*